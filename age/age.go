@@ -0,0 +1,310 @@
+// Package age reads and writes files in the age format
+// (https://age-encryption.org/v1), so that data can be exchanged with the
+// age command-line tool and other age implementations.
+//
+// Sealer's native format remains the default and the better choice for
+// data that only sealer reads: it compresses, supports more kinds of key
+// slots and lets you inspect key IDs before opening. Use this package at
+// the boundary with age users.
+//
+// X25519 recipients (age1...) and passphrases (scrypt) are supported, and
+// other recipient types can be plugged in by implementing Recipient and
+// Identity. X25519 keys can be converted to and from sealer's HPKE
+// identities, so the same key pair works with both formats.
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	fileKeySize = 16
+	nonceSize   = 16
+
+	intro        = "age-encryption.org/v1\n"
+	stanzaPrefix = "-> "
+	footerPrefix = "---"
+
+	columnsPerLine = 64
+	bytesPerLine   = columnsPerLine / 4 * 3
+
+	// maxHeaderSize limits the header read from untrusted input.
+	maxHeaderSize = 1024 * 1024
+)
+
+var (
+	// ErrIncorrectIdentity is returned by Identity.Unwrap for stanzas it
+	// cannot open.
+	ErrIncorrectIdentity = errors.New("age: incorrect identity for recipient block")
+
+	// ErrNoIdentityMatch is returned by Decrypt if no identity can open
+	// the file.
+	ErrNoIdentityMatch = errors.New("age: no identity matched any of the recipients")
+
+	errInvalidHeader = errors.New("age: invalid header")
+	errHeaderMAC     = errors.New("age: bad header MAC")
+)
+
+// Stanza is a recipient block of the age header.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// Recipient wraps a file key into one or more stanzas.
+type Recipient interface {
+	Wrap(fileKey []byte) ([]*Stanza, error)
+}
+
+// Identity unwraps a file key from the stanzas of a file, returning
+// ErrIncorrectIdentity if none of them is addressed to it.
+type Identity interface {
+	Unwrap(stanzas []*Stanza) ([]byte, error)
+}
+
+// Encrypt starts writing an age file encrypted to the given recipients.
+// The returned writer must be closed to write the final chunk.
+func Encrypt(dst io.Writer, recipients ...Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("age: no recipients")
+	}
+	fileKey := make([]byte, fileKeySize)
+	rand.Read(fileKey)
+
+	var stanzas []*Stanza
+	for _, r := range recipients {
+		ss, err := r.Wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			if s.Type == scryptType && len(recipients) > 1 {
+				return nil, errors.New("age: a passphrase cannot be combined with other recipients")
+			}
+		}
+		stanzas = append(stanzas, ss...)
+	}
+
+	hdr, err := marshalHeader(stanzas, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	hdr = append(hdr, nonce...)
+	if _, err := dst.Write(hdr); err != nil {
+		return nil, err
+	}
+	w, err := newStreamWriter(payloadKey(fileKey, nonce), dst)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Decrypt reads the header of an age file and returns a reader of the
+// plaintext, using the first identity that can open the file.
+func Decrypt(src io.Reader, identities ...Identity) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	stanzas, macced, mac, err := parseHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, id := range identities {
+		fileKey, err = id.Unwrap(stanzas)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if fileKey == nil {
+		return nil, ErrNoIdentityMatch
+	}
+	if !hmac.Equal(headerMAC(fileKey, macced), mac) {
+		return nil, errHeaderMAC
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, fmt.Errorf("age: reading payload nonce: %w", err)
+	}
+	r, err := newStreamReader(payloadKey(fileKey, nonce), br)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func payloadKey(fileKey, nonce []byte) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", streamKeySize)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func headerMAC(fileKey, macced []byte) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		panic(err)
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(macced)
+	return m.Sum(nil)
+}
+
+var b64 = base64.RawStdEncoding.Strict()
+
+func marshalHeader(stanzas []*Stanza, fileKey []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(intro)
+	for _, s := range stanzas {
+		if err := writeStanza(&buf, s); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString(footerPrefix)
+	mac := headerMAC(fileKey, buf.Bytes())
+	buf.WriteByte(' ')
+	buf.WriteString(b64.EncodeToString(mac))
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeStanza(buf *bytes.Buffer, s *Stanza) error {
+	if !validArg(s.Type) {
+		return errInvalidHeader
+	}
+	buf.WriteString(stanzaPrefix)
+	buf.WriteString(s.Type)
+	for _, arg := range s.Args {
+		if !validArg(arg) {
+			return errInvalidHeader
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(arg)
+	}
+	buf.WriteByte('\n')
+	body := b64.EncodeToString(s.Body)
+	for len(body) >= columnsPerLine {
+		buf.WriteString(body[:columnsPerLine])
+		buf.WriteByte('\n')
+		body = body[columnsPerLine:]
+	}
+	buf.WriteString(body)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func validArg(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if s[i] < 33 || s[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeader returns the stanzas, the bytes covered by the MAC and the MAC.
+func parseHeader(br *bufio.Reader) (stanzas []*Stanza, macced, mac []byte, err error) {
+	var raw []byte
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		raw = append(raw, line...)
+		if len(raw) > maxHeaderSize {
+			return "", errInvalidHeader
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	line, err := readLine()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if line+"\n" != intro {
+		return nil, nil, nil, fmt.Errorf("age: not an age file or unsupported version")
+	}
+
+	for {
+		start := len(raw)
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, footerPrefix); ok {
+			macStr, ok := strings.CutPrefix(rest, " ")
+			if !ok {
+				return nil, nil, nil, errInvalidHeader
+			}
+			mac, err := b64.DecodeString(macStr)
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, errInvalidHeader
+			}
+			return stanzas, raw[:start+len(footerPrefix)], mac, nil
+		}
+
+		s, err := readStanza(line, readLine)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+// readStanza parses a stanza given its first line, reading the body lines
+// with readLine.
+func readStanza(line string, readLine func() (string, error)) (*Stanza, error) {
+	rest, ok := strings.CutPrefix(line, stanzaPrefix)
+	if !ok {
+		return nil, errInvalidHeader
+	}
+	args := strings.Split(rest, " ")
+	for _, arg := range args {
+		if !validArg(arg) {
+			return nil, errInvalidHeader
+		}
+	}
+	s := &Stanza{Type: args[0], Args: args[1:]}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > columnsPerLine {
+			return nil, errInvalidHeader
+		}
+		b, err := b64.DecodeString(line)
+		if err != nil {
+			return nil, errInvalidHeader
+		}
+		s.Body = append(s.Body, b...)
+		if len(line) < columnsPerLine {
+			return s, nil
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package age_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/age"
+)
+
+// Generated with the reference age implementation.
+const (
+	testIdentity  = "AGE-SECRET-KEY-18C2L55DLLS76GXNP7YKVEXQ4AKF3D9CFP440PGHEJD5Q8C3CLMVQ0JHY6J"
+	testRecipient = "age1de3xm3eny9tyncp5kvf2t42zcwnylekq0shqfjpdqwd85mx9gywqztt725"
+	testFile      = "age-encryption.org/v1\n-> X25519 c/EbzgtZZnDRLtra4Vyttbg6r3qfj3Y8F+CUPqU0DRs\n3tRq4TrEwTD+6qdpCrkgF+/Oc4gYYh/g5cHzyzDw3ZU\n--- YzZNfJBni2rkVwVNnuKGuUSVCMV4Xai+jsNWFq+3qyk\n[\xa5\xb6|}F\xaa\x0fT\xcf\xd0\x06\xf7\x1d\x9fd\xa6\xa5y\x1cg~6\x1b\x16=\xaf?\xc9?\x0f\x7f\xd6\xf9\x82\x84'v\xed\xfe\xd59\x0f\\\xad\xec\x99"
+)
+
+func TestKeys(t *testing.T) {
+	id, err := age.ParseX25519Identity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := id.String(), testIdentity; a != e {
+		t.Errorf("String = %q, wanted %q", a, e)
+	}
+	if a, e := id.Recipient().String(), testRecipient; a != e {
+		t.Errorf("Recipient = %q, wanted %q", a, e)
+	}
+	r, err := age.ParseX25519Recipient(testRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := r.String(), testRecipient; a != e {
+		t.Errorf("String = %q, wanted %q", a, e)
+	}
+	if _, err := age.ParseX25519Recipient(testRecipient[:len(testRecipient)-1] + "q"); err == nil {
+		t.Errorf("ParseX25519Recipient accepted a bad checksum")
+	}
+}
+
+func TestDecryptAgeFile(t *testing.T) {
+	id, err := age.ParseX25519Identity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(decrypt(t, []byte(testFile), id)), "hello from age\n"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := age.GenerateX25519Identity()
+	for _, size := range []int{0, 1, 64*1024 - 1, 64 * 1024, 64*1024 + 1, 3 * 64 * 1024} {
+		data := bytes.Repeat([]byte("abcdefg"), size/7+1)[:size]
+		sealed := encrypt(t, data, other.Recipient(), id.Recipient())
+
+		if !strings.HasPrefix(string(sealed), "age-encryption.org/v1\n-> X25519 ") {
+			t.Errorf("unexpected header: %q", sealed[:40])
+		}
+		if a := decrypt(t, sealed, id); !bytes.Equal(a, data) {
+			t.Errorf("size %d: got %d bytes", size, len(a))
+		}
+
+		if size > 0 {
+			_, err := readAll(sealed[:len(sealed)-1], id)
+			if err == nil {
+				t.Errorf("size %d: truncated file decrypted", size)
+			}
+		}
+	}
+}
+
+func TestScrypt(t *testing.T) {
+	r := age.NewScryptRecipient("correct horse")
+	r.SetWorkFactor(10)
+	sealed := encrypt(t, []byte("hello"), r)
+
+	if a := decrypt(t, sealed, age.NewScryptIdentity("correct horse")); string(a) != "hello" {
+		t.Errorf("got %q", a)
+	}
+	if _, err := readAll(sealed, age.NewScryptIdentity("wrong")); !errors.Is(err, age.ErrNoIdentityMatch) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+	strict := age.NewScryptIdentity("correct horse")
+	strict.SetMaxWorkFactor(9)
+	if _, err := readAll(sealed, strict); err == nil {
+		t.Errorf("work factor limit not enforced")
+	}
+}
+
+func TestSealerIdentity(t *testing.T) {
+	sid, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := age.FromSealerIdentity(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := encrypt(t, []byte("hello"), id.Recipient())
+	if a := decrypt(t, sealed, id); string(a) != "hello" {
+		t.Errorf("got %q", a)
+	}
+	sid2, err := id.SealerIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid2.ID != sid.ID {
+		t.Errorf("SealerIdentity ID = %x, wanted %x", sid2.ID, sid.ID)
+	}
+}
+
+func TestTamperedHeader(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	sealed := encrypt(t, []byte("hello"), id.Recipient())
+	sealed = bytes.Replace(sealed, []byte("-> X25519"), []byte("-> X25519 extra"), 1)
+	if _, err := readAll(sealed, id); err == nil {
+		t.Errorf("tampered header accepted")
+	}
+}
+
+func encrypt(t *testing.T, data []byte, recipients ...age.Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(t *testing.T, sealed []byte, ids ...age.Identity) []byte {
+	t.Helper()
+	data, err := readAll(sealed, ids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readAll(sealed []byte, ids ...age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(sealed), ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
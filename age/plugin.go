@@ -0,0 +1,375 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/andreyvit/sealer/internal/bech32"
+)
+
+// Plugins implement additional recipient types (YubiKey, TPM, cloud KMS and
+// others) as separate age-plugin-NAME binaries speaking the age-plugin
+// protocol (https://c2sp.org/age-plugin) over stdin and stdout.
+
+// ClientUI lets plugins interact with the user. Any of the fields can be
+// nil, in which case the corresponding requests fail.
+type ClientUI struct {
+	// DisplayMessage shows a message from the plugin, e.g. "touch your
+	// YubiKey".
+	DisplayMessage func(plugin, message string) error
+
+	// RequestValue asks the user for a value, e.g. a PIN if secret is true.
+	RequestValue func(plugin, prompt string, secret bool) (string, error)
+
+	// Confirm asks the user to choose between yes and no (no may be empty
+	// for a single-choice prompt).
+	Confirm func(plugin, prompt, yes, no string) (bool, error)
+}
+
+// PluginError is an error reported by a plugin.
+type PluginError struct {
+	Plugin  string
+	Message string
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("age-plugin-%s: %s", e.Plugin, e.Message)
+}
+
+// PluginRecipient is a recipient handled by a plugin, age1NAME1...
+type PluginRecipient struct {
+	name      string
+	recipient string
+	ui        *ClientUI
+}
+
+// NewPluginRecipient returns a recipient for an age1NAME1... string, which
+// will be handled by the age-plugin-NAME binary found in $PATH.
+func NewPluginRecipient(s string, ui *ClientUI) (*PluginRecipient, error) {
+	hrp, _, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	name, ok := strings.CutPrefix(hrp, recipientHRP+"1")
+	if !ok || !validPluginName(name) {
+		return nil, fmt.Errorf("age: not a plugin recipient: %q", s)
+	}
+	return &PluginRecipient{name: name, recipient: s, ui: ui}, nil
+}
+
+// Name returns the plugin name.
+func (r *PluginRecipient) Name() string {
+	return r.name
+}
+
+// String returns the recipient string.
+func (r *PluginRecipient) String() string {
+	return r.recipient
+}
+
+// Wrap implements Recipient.
+func (r *PluginRecipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	c, err := startPlugin(r.name, "recipient-v1", r.ui)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	c.send("add-recipient", nil, r.recipient)
+	c.send("wrap-file-key", fileKey)
+	c.send("done", nil)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	var stanzas []*Stanza
+	var perr error
+	err = c.serve(func(s *Stanza) (bool, error) {
+		switch s.Type {
+		case "recipient-stanza":
+			if len(s.Args) < 2 || s.Args[0] != "0" {
+				return false, errInvalidPluginResponse
+			}
+			stanzas = append(stanzas, &Stanza{Type: s.Args[1], Args: s.Args[2:], Body: s.Body})
+			return true, nil
+		case "error":
+			perr = &PluginError{Plugin: r.name, Message: string(s.Body)}
+			return true, nil
+		}
+		return false, nil
+	})
+	// plugins may exit right after reporting an error
+	if perr != nil {
+		return nil, perr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(stanzas) == 0 {
+		return nil, errInvalidPluginResponse
+	}
+	return stanzas, nil
+}
+
+// PluginIdentity is an identity handled by a plugin, AGE-PLUGIN-NAME-1...
+type PluginIdentity struct {
+	name     string
+	identity string
+	ui       *ClientUI
+}
+
+// NewPluginIdentity returns an identity for an AGE-PLUGIN-NAME-1... string,
+// which will be handled by the age-plugin-NAME binary found in $PATH.
+func NewPluginIdentity(s string, ui *ClientUI) (*PluginIdentity, error) {
+	hrp, _, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	name, ok := strings.CutPrefix(hrp, "age-plugin-")
+	if ok {
+		name, ok = strings.CutSuffix(name, "-")
+	}
+	if !ok || !validPluginName(name) {
+		return nil, fmt.Errorf("age: not a plugin identity")
+	}
+	return &PluginIdentity{name: name, identity: s, ui: ui}, nil
+}
+
+// Name returns the plugin name.
+func (id *PluginIdentity) Name() string {
+	return id.name
+}
+
+// Unwrap implements Identity.
+func (id *PluginIdentity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	c, err := startPlugin(id.name, "identity-v1", id.ui)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	c.send("add-identity", nil, id.identity)
+	for _, s := range stanzas {
+		c.send("recipient-stanza", s.Body, append([]string{"0", s.Type}, s.Args...)...)
+	}
+	c.send("done", nil)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	var perr error
+	err = c.serve(func(s *Stanza) (bool, error) {
+		switch s.Type {
+		case "file-key":
+			if len(s.Args) != 1 || s.Args[0] != "0" || len(s.Body) != fileKeySize {
+				return false, errInvalidPluginResponse
+			}
+			fileKey = s.Body
+			return true, nil
+		case "error":
+			perr = &PluginError{Plugin: id.name, Message: string(s.Body)}
+			return true, nil
+		}
+		return false, nil
+	})
+	if fileKey != nil {
+		return fileKey, nil
+	}
+	// plugins may exit right after reporting an error
+	if perr != nil {
+		return nil, perr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrIncorrectIdentity
+}
+
+var errInvalidPluginResponse = errors.New("age: invalid response from plugin")
+
+func validPluginName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_' || r == '+') {
+			return false
+		}
+	}
+	return true
+}
+
+// pluginConn is a running plugin process.
+type pluginConn struct {
+	name   string
+	ui     *ClientUI
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	br     *bufio.Reader
+	stderr bytes.Buffer
+	out    bytes.Buffer
+	exited bool
+}
+
+func startPlugin(name, stateMachine string, ui *ClientUI) (*pluginConn, error) {
+	if ui == nil {
+		ui = &ClientUI{}
+	}
+	c := &pluginConn{name: name, ui: ui}
+	c.cmd = exec.Command("age-plugin-"+name, "--age-plugin="+stateMachine)
+	c.cmd.Stderr = &c.stderr
+	var err error
+	c.stdin, err = c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("age: starting plugin: %w", err)
+	}
+	c.br = bufio.NewReader(stdout)
+	return c, nil
+}
+
+func (c *pluginConn) send(typ string, body []byte, args ...string) {
+	writeStanza(&c.out, &Stanza{Type: typ, Args: args, Body: body})
+}
+
+func (c *pluginConn) flush() error {
+	_, err := c.stdin.Write(c.out.Bytes())
+	c.out.Reset()
+	if err != nil {
+		return c.wrapErr(err)
+	}
+	return nil
+}
+
+func (c *pluginConn) read() (*Stanza, error) {
+	readLine := func() (string, error) {
+		line, err := c.br.ReadString('\n')
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", c.wrapErr(err)
+		}
+		if len(line) > maxHeaderSize {
+			return "", errInvalidPluginResponse
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	return readStanza(line, readLine)
+}
+
+// serve runs phase 2 of the protocol, answering the plugin's requests until
+// it's done. handle processes state machine specific commands, returning
+// false for unknown ones.
+func (c *pluginConn) serve(handle func(s *Stanza) (bool, error)) error {
+	for {
+		s, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch s.Type {
+		case "done":
+			return nil
+		case "msg":
+			if c.ui.DisplayMessage == nil {
+				c.send("fail", nil)
+			} else if err := c.ui.DisplayMessage(c.name, string(s.Body)); err != nil {
+				c.send("fail", nil)
+			} else {
+				c.send("ok", nil)
+			}
+		case "request-public", "request-secret":
+			var v string
+			if c.ui.RequestValue != nil {
+				v, err = c.ui.RequestValue(c.name, string(s.Body), s.Type == "request-secret")
+			}
+			if c.ui.RequestValue == nil || err != nil {
+				c.send("fail", nil)
+			} else {
+				c.send("ok", []byte(v))
+			}
+		case "confirm":
+			var yes, no string
+			if len(s.Args) > 0 {
+				yes = decodeArg(s.Args[0])
+			}
+			if len(s.Args) > 1 {
+				no = decodeArg(s.Args[1])
+			}
+			var ok bool
+			if c.ui.Confirm != nil {
+				ok, err = c.ui.Confirm(c.name, string(s.Body), yes, no)
+			}
+			if c.ui.Confirm == nil || err != nil {
+				c.send("fail", nil)
+			} else if ok {
+				c.send("ok", nil, "yes")
+			} else {
+				c.send("ok", nil, "no")
+			}
+		default:
+			handled, err := handle(s)
+			if err != nil {
+				return err
+			}
+			if handled {
+				c.send("ok", nil)
+			} else {
+				c.send("unsupported", nil)
+			}
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeArg(arg string) string {
+	b, err := b64.DecodeString(arg)
+	if err != nil {
+		return arg
+	}
+	return string(b)
+}
+
+// wrapErr adds what the plugin has written to stderr to an error talking
+// to it, which means that it has failed, so it's waited for first: until
+// then, stderr is still being copied.
+func (c *pluginConn) wrapErr(err error) error {
+	c.wait()
+	if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+		return fmt.Errorf("age-plugin-%s: %w: %s", c.name, err, msg)
+	}
+	return fmt.Errorf("age-plugin-%s: %w", c.name, err)
+}
+
+func (c *pluginConn) close() {
+	// the plugin has already given its answer, so its exit status does not
+	// matter
+	c.wait()
+}
+
+func (c *pluginConn) wait() {
+	if c.exited {
+		return
+	}
+	c.exited = true
+	c.stdin.Close()
+	c.cmd.Wait()
+}
@@ -0,0 +1,226 @@
+package age_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/age"
+)
+
+// The test binary doubles as age-plugin-test: a plugin that "wraps" file
+// keys by XORing them with a byte, and asks for a PIN to unwrap.
+
+const (
+	testPluginRecipient = "age1test1qypqzqspqgqsyqgzqypqzqspqgqsyqgzqypqzqspqgqsyqgzqypq6nm760"
+	testPluginIdentity  = "AGE-PLUGIN-TEST-1QYPQZQSPQGQSYQGZQYPQZQSPQGQSYQGZQYPQZQSPQGQSYQGZQYPQRQHPL7"
+	testPluginPIN       = "1234"
+)
+
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "age-plugin-test" {
+		os.Exit(runTestPlugin(os.Args[1]))
+	}
+	os.Exit(m.Run())
+}
+
+func TestPlugin(t *testing.T) {
+	installTestPlugin(t)
+
+	r, err := age.NewPluginRecipient(testPluginRecipient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := r.Name(), "test"; a != e {
+		t.Errorf("Name = %q, wanted %q", a, e)
+	}
+	var messages []string
+	ui := &age.ClientUI{
+		DisplayMessage: func(plugin, message string) error {
+			messages = append(messages, plugin+": "+message)
+			return nil
+		},
+		RequestValue: func(plugin, prompt string, secret bool) (string, error) {
+			if !secret {
+				return "", fmt.Errorf("expected a secret request")
+			}
+			return testPluginPIN, nil
+		},
+	}
+	id, err := age.NewPluginIdentity(testPluginIdentity, ui)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// age format
+	sealed := encrypt(t, []byte("hello"), r)
+	if a := decrypt(t, sealed, id); string(a) != "hello" {
+		t.Errorf("got %q", a)
+	}
+	if a, e := strings.Join(messages, "\n"), "test: touch the token"; a != e {
+		t.Errorf("messages = %q, wanted %q", a, e)
+	}
+
+	// sealer slots
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{age.NewEncapsulator(r)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hello, world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	opn, err := sealer.Prepare(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := opn.KeyID, age.KeyID(testPluginRecipient); a != e {
+		t.Errorf("KeyID = %x, wanted %x", a, e)
+	}
+	stanzas, err := age.SlotStanzas(opn.Slots[0])
+	if err != nil || len(stanzas) != 1 || stanzas[0].Type != "test" {
+		t.Errorf("SlotStanzas = %v, %v", stanzas, err)
+	}
+	sr, err := opn.OpenWith(age.NewDecapsulator(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "hello, world"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+	// age recipients are X25519 or whatever the plugin does
+	_, err = sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{age.NewEncapsulator(r)}, PostQuantum: true})
+	if err != sealer.ErrNotPostQuantum {
+		t.Errorf("Seal with PostQuantum = %v, wanted ErrNotPostQuantum", err)
+	}
+
+	// wrong PIN
+	bad, _ := age.NewPluginIdentity(testPluginIdentity, &age.ClientUI{
+		RequestValue: func(plugin, prompt string, secret bool) (string, error) { return "0000", nil },
+	})
+	_, err = age.Decrypt(bytes.NewReader(sealed), bad)
+	if err == nil || !strings.Contains(err.Error(), "wrong PIN") {
+		t.Errorf("Decrypt with wrong PIN = %v", err)
+	}
+}
+
+func TestPlugin_failure(t *testing.T) {
+	installTestPlugin(t)
+	t.Setenv("AGE_PLUGIN_TEST_FAIL", "1")
+
+	r, err := age.NewPluginRecipient(testPluginRecipient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = age.Encrypt(io.Discard, r)
+	if err == nil || !strings.Contains(err.Error(), "token not found") {
+		t.Errorf("Encrypt = %v, wanted the plugin's message", err)
+	}
+}
+
+func installTestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test relies on symlinks")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "age-plugin-test")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+type testStanza struct {
+	args []string
+	body []byte
+}
+
+func runTestPlugin(stateMachine string) int {
+	br := bufio.NewReader(os.Stdin)
+	b64 := base64.RawStdEncoding
+	read := func() testStanza {
+		line, _ := br.ReadString('\n')
+		s := testStanza{args: strings.Fields(strings.TrimPrefix(line, "-> "))}
+		for {
+			line, _ := br.ReadString('\n')
+			line = strings.TrimSuffix(line, "\n")
+			b, _ := b64.DecodeString(line)
+			s.body = append(s.body, b...)
+			if len(line) < 64 {
+				return s
+			}
+		}
+	}
+	write := func(body []byte, args ...string) {
+		fmt.Printf("-> %s\n%s\n", strings.Join(args, " "), b64.EncodeToString(body))
+	}
+	xor := func(b []byte) []byte {
+		out := bytes.Clone(b)
+		for i := range out {
+			out[i] ^= 0x5a
+		}
+		return out
+	}
+
+	var phase1 []testStanza
+	for {
+		s := read()
+		if len(s.args) == 0 {
+			return 1
+		}
+		if s.args[0] == "done" {
+			break
+		}
+		phase1 = append(phase1, s)
+	}
+	if os.Getenv("AGE_PLUGIN_TEST_FAIL") != "" {
+		fmt.Fprintln(os.Stderr, "token not found")
+		return 1
+	}
+
+	switch stateMachine {
+	case "--age-plugin=recipient-v1":
+		for _, s := range phase1 {
+			if s.args[0] == "wrap-file-key" {
+				write(xor(s.body), "recipient-stanza", "0", "test", "arg")
+				read()
+			}
+		}
+	case "--age-plugin=identity-v1":
+		for _, s := range phase1 {
+			if s.args[0] != "recipient-stanza" || s.args[2] != "test" {
+				continue
+			}
+			write([]byte("touch the token"), "msg")
+			read()
+			write([]byte("PIN:"), "request-secret")
+			if resp := read(); string(resp.body) != testPluginPIN {
+				write([]byte("wrong PIN"), "error", "internal")
+				read()
+				break
+			}
+			write(xor(s.body), "file-key", "0")
+			read()
+		}
+	default:
+		return 1
+	}
+	write(nil, "done")
+	return 0
+}
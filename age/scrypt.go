@@ -0,0 +1,108 @@
+package age
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptType       = "scrypt"
+	scryptLabel      = "age-encryption.org/v1/scrypt"
+	scryptSaltSize   = 16
+	scryptWorkLog    = 18
+	maxScryptWorkLog = 22
+)
+
+// ScryptRecipient encrypts a file with a passphrase. It cannot be combined
+// with other recipients.
+type ScryptRecipient struct {
+	passphrase []byte
+	workFactor int
+}
+
+// NewScryptRecipient returns a passphrase recipient with the same default
+// work factor as the age CLI.
+func NewScryptRecipient(passphrase string) *ScryptRecipient {
+	return &ScryptRecipient{passphrase: []byte(passphrase), workFactor: scryptWorkLog}
+}
+
+// SetWorkFactor sets the scrypt work factor to 2^logN.
+func (r *ScryptRecipient) SetWorkFactor(logN int) {
+	r.workFactor = logN
+}
+
+// Wrap implements Recipient.
+func (r *ScryptRecipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	salt := make([]byte, scryptSaltSize)
+	rand.Read(salt)
+	key, err := scrypt.Key(r.passphrase, append([]byte(scryptLabel), salt...), 1<<r.workFactor, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	body, err := aeadEncrypt(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*Stanza{{
+		Type: scryptType,
+		Args: []string{b64.EncodeToString(salt), strconv.Itoa(r.workFactor)},
+		Body: body,
+	}}, nil
+}
+
+// ScryptIdentity decrypts a passphrase-encrypted file.
+type ScryptIdentity struct {
+	passphrase    []byte
+	maxWorkFactor int
+}
+
+// NewScryptIdentity returns a passphrase identity, which refuses work
+// factors above 2^22 to protect against malicious files.
+func NewScryptIdentity(passphrase string) *ScryptIdentity {
+	return &ScryptIdentity{passphrase: []byte(passphrase), maxWorkFactor: maxScryptWorkLog}
+}
+
+// SetMaxWorkFactor sets the maximum accepted work factor to 2^logN.
+func (id *ScryptIdentity) SetMaxWorkFactor(logN int) {
+	id.maxWorkFactor = logN
+}
+
+// Unwrap implements Identity.
+func (id *ScryptIdentity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type == scryptType && len(stanzas) != 1 {
+			return nil, errors.New("age: scrypt recipient block mixed with other recipients")
+		}
+	}
+	if len(stanzas) != 1 || stanzas[0].Type != scryptType {
+		return nil, ErrIncorrectIdentity
+	}
+	s := stanzas[0]
+	if len(s.Args) != 2 {
+		return nil, errInvalidHeader
+	}
+	salt, err := b64.DecodeString(s.Args[0])
+	if err != nil || len(salt) != scryptSaltSize {
+		return nil, errInvalidHeader
+	}
+	logN, err := strconv.Atoi(s.Args[1])
+	if err != nil || logN <= 0 || strconv.Itoa(logN) != s.Args[1] {
+		return nil, errInvalidHeader
+	}
+	if logN > id.maxWorkFactor {
+		return nil, errors.New("age: scrypt work factor too large")
+	}
+	key, err := scrypt.Key(id.passphrase, append([]byte(scryptLabel), salt...), 1<<logN, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aeadDecrypt(key, s.Body)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
@@ -0,0 +1,158 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Any age recipient, including plugins, can be used for sealer key slots.
+// As age file keys are 16 bytes, the recipient wraps a fresh age file key
+// from which the key encapsulating sealer's file key is derived.
+//
+// Age slot data is slotdata.Pack(stanzas, encapsulated), where stanzas are
+// the recipient stanzas in the age header format and encapsulated is the
+// XChaCha20-Poly1305 nonce and ciphertext of the file key, with stanzas as
+// additional data.
+
+const slotKeyInfo = "github.com/andreyvit/sealer/age file key"
+
+type encapsulator struct {
+	r Recipient
+}
+
+// NewEncapsulator returns a sealer.Encapsulator wrapping file keys to the
+// age recipient. The slot's key ID is derived from the recipient string
+// for recipients that implement fmt.Stringer (see KeyID), and is zero
+// otherwise.
+func NewEncapsulator(r Recipient) sealer.Encapsulator {
+	return encapsulator{r}
+}
+
+// KeyID returns the sealer key ID of slots wrapped to the recipient with
+// the given string encoding.
+func KeyID(recipient string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("age:" + recipient))
+}
+
+func (e encapsulator) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	ageKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(random, ageKey); err != nil {
+		return sealer.Slot{}, fmt.Errorf("generating age file key: %w", err)
+	}
+	defer clear(ageKey)
+	stanzas, err := e.r.Wrap(ageKey)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	var buf bytes.Buffer
+	for _, s := range stanzas {
+		if err := writeStanza(&buf, s); err != nil {
+			return sealer.Slot{}, err
+		}
+	}
+	text := buf.Bytes()
+
+	aead, err := slotAEAD(ageKey)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	encapsulated := make([]byte, chacha20poly1305.NonceSizeX, chacha20poly1305.NonceSizeX+len(fileKey)+aead.Overhead())
+	if _, err := io.ReadFull(random, encapsulated); err != nil {
+		return sealer.Slot{}, fmt.Errorf("generating nonce: %w", err)
+	}
+	encapsulated = aead.Seal(encapsulated, encapsulated[:chacha20poly1305.NonceSizeX], fileKey, text)
+
+	var id [sealer.IDSize]byte
+	if str, ok := e.r.(fmt.Stringer); ok {
+		id = KeyID(str.String())
+	}
+	return sealer.Slot{Type: sealer.SlotTypeAge, KeyID: id, Data: slotdata.Pack(string(text), encapsulated)}, nil
+}
+
+type decapsulator struct {
+	id Identity
+}
+
+// NewDecapsulator returns a sealer.Decapsulator unwrapping file keys with
+// the age identity. Like age itself, it tries every age slot regardless of
+// the key ID.
+func NewDecapsulator(id Identity) sealer.Decapsulator {
+	return decapsulator{id}
+}
+
+func (d decapsulator) Decapsulate(s sealer.Slot) ([]byte, error) {
+	if s.Type != sealer.SlotTypeAge {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	text, encapsulated, ok := slotdata.Unpack(s.Data)
+	if !ok || len(encapsulated) < chacha20poly1305.NonceSizeX {
+		return nil, sealer.ErrUnsupportedVersion
+	}
+	stanzas, err := SlotStanzas(s)
+	if err != nil {
+		return nil, err
+	}
+	ageKey, err := d.id.Unwrap(stanzas)
+	if errors.Is(err, ErrIncorrectIdentity) {
+		return nil, sealer.ErrNoMatchingKey
+	} else if err != nil {
+		return nil, err
+	}
+	defer clear(ageKey)
+	aead, err := slotAEAD(ageKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := encapsulated[:chacha20poly1305.NonceSizeX]
+	return aead.Open(nil, nonce, encapsulated[chacha20poly1305.NonceSizeX:], []byte(text))
+}
+
+// SlotStanzas returns the age recipient stanzas of an age slot, e.g. to
+// find out which plugin is needed to open it.
+func SlotStanzas(s sealer.Slot) ([]*Stanza, error) {
+	text, _, ok := slotdata.Unpack(s.Data)
+	if s.Type != sealer.SlotTypeAge || !ok {
+		return nil, sealer.ErrUnsupportedVersion
+	}
+	br := bufio.NewReader(strings.NewReader(text))
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", errInvalidHeader
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+	var stanzas []*Stanza
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return stanzas, nil
+		} else if err != nil {
+			return nil, errInvalidHeader
+		}
+		s, err := readStanza(strings.TrimSuffix(line, "\n"), readLine)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+func slotAEAD(ageKey []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, ageKey, nil, slotKeyInfo, chacha20poly1305.KeySize)
+	if err != nil {
+		panic(err)
+	}
+	return chacha20poly1305.NewX(key)
+}
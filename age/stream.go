@@ -0,0 +1,158 @@
+package age
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The payload is encrypted using the STREAM construction, in 64 KiB chunks
+// of ChaCha20-Poly1305 with a nonce of an 11-byte big-endian counter and
+// a final chunk flag.
+
+const (
+	streamKeySize  = chacha20poly1305.KeySize
+	chunkSize      = 64 * 1024
+	encChunkSize   = chunkSize + chacha20poly1305.Overhead
+	lastChunkFlag  = 0x01
+	streamNonceLen = chacha20poly1305.NonceSize
+)
+
+var errPayload = errors.New("age: payload corrupted or truncated")
+
+type streamNonce [streamNonceLen]byte
+
+func (n *streamNonce) set(counter uint64, last bool) {
+	clear(n[:])
+	for i := 10; i >= 3; i-- {
+		n[i] = byte(counter)
+		counter >>= 8
+	}
+	if last {
+		n[11] = lastChunkFlag
+	}
+}
+
+type streamWriter struct {
+	aead    cipher.AEAD
+	dst     io.Writer
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newStreamWriter(key []byte, dst io.Writer) (*streamWriter, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{aead: aead, dst: dst, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("age: write to closed writer")
+	}
+	total := len(p)
+	for len(p) > 0 {
+		// a full chunk is only flushed once more data arrives, because the
+		// final chunk must be flagged as such
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *streamWriter) flush(last bool) error {
+	var nonce streamNonce
+	nonce.set(w.counter, last)
+	w.counter++
+	out := w.aead.Seal(w.buf[:0], nonce[:], w.buf, nil)
+	_, err := w.dst.Write(out)
+	w.buf = w.buf[:0]
+	return err
+}
+
+type streamReader struct {
+	aead    cipher.AEAD
+	src     io.Reader
+	encBuf  []byte
+	pending int // bytes of the next chunk already in encBuf
+	decBuf  []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+func newStreamReader(key []byte, src io.Reader) (*streamReader, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{aead: aead, src: src, encBuf: make([]byte, encChunkSize+1), decBuf: make([]byte, chunkSize)}, nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) readChunk() error {
+	// read one byte past the chunk to find out whether it is the last one
+	n, err := io.ReadFull(r.src, r.encBuf[r.pending:])
+	n += r.pending
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	} else if err != nil {
+		return err
+	}
+	last := n <= encChunkSize
+	if last && n < chacha20poly1305.Overhead {
+		return errPayload
+	}
+	size := min(n, encChunkSize)
+
+	var nonce streamNonce
+	nonce.set(r.counter, last)
+	r.counter++
+	buf, err := r.aead.Open(r.decBuf[:0], nonce[:], r.encBuf[:size], nil)
+	if err != nil {
+		return errPayload
+	}
+	if last && len(buf) == 0 && r.counter > 1 {
+		// only an empty payload may have an empty final chunk
+		return errPayload
+	}
+	r.buf = buf
+	if !last {
+		r.encBuf[0] = r.encBuf[encChunkSize]
+		r.pending = 1
+	}
+	r.done = last
+	return nil
+}
@@ -0,0 +1,194 @@
+package age
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/bech32"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	x25519Type  = "X25519"
+	x25519Label = "age-encryption.org/v1/X25519"
+
+	recipientHRP = "age"
+	identityHRP  = "AGE-SECRET-KEY-"
+)
+
+// X25519Recipient is the standard age public key, age1...
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+}
+
+// X25519Identity is the standard age private key, AGE-SECRET-KEY-1...
+type X25519Identity struct {
+	priv *ecdh.PrivateKey
+}
+
+// GenerateX25519Identity generates a new random identity.
+func GenerateX25519Identity() (*X25519Identity, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// ParseX25519Recipient parses an age1... public key.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	if hrp != recipientHRP {
+		return nil, fmt.Errorf("age: not an X25519 recipient: %q", s)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("age: invalid X25519 recipient: %w", err)
+	}
+	return &X25519Recipient{pub: pub}, nil
+}
+
+// ParseX25519Identity parses an AGE-SECRET-KEY-1... private key.
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	if hrp != strings.ToLower(identityHRP) {
+		return nil, errors.New("age: not an X25519 identity")
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("age: invalid X25519 identity: %w", err)
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// FromSealerIdentity converts a sealer X25519 identity (KEMX25519), so that
+// the same key pair can open both sealer and age files.
+func FromSealerIdentity(id *sealer.Identity) (*X25519Identity, error) {
+	if id.KEM() != sealer.KEMX25519 {
+		return nil, sealer.ErrUnsupportedKEM
+	}
+	b, err := id.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// SealerIdentity converts the identity into a sealer HPKE identity.
+func (id *X25519Identity) SealerIdentity() (*sealer.Identity, error) {
+	return sealer.NewIdentity(sealer.KEMX25519, id.priv.Bytes())
+}
+
+// Recipient returns the public key of the identity.
+func (id *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{pub: id.priv.PublicKey()}
+}
+
+// String returns the AGE-SECRET-KEY-1... encoding of the identity.
+func (id *X25519Identity) String() string {
+	return bech32.Encode(identityHRP, id.priv.Bytes())
+}
+
+// String returns the age1... encoding of the recipient.
+func (r *X25519Recipient) String() string {
+	return bech32.Encode(recipientHRP, r.pub.Bytes())
+}
+
+// Wrap implements Recipient.
+func (r *X25519Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(r.pub)
+	if err != nil {
+		return nil, err
+	}
+	ephPub := eph.PublicKey().Bytes()
+	wrappingKey, err := hkdf.Key(sha256.New, shared, slices.Concat(ephPub, r.pub.Bytes()), x25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	body, err := aeadEncrypt(wrappingKey, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*Stanza{{
+		Type: x25519Type,
+		Args: []string{b64.EncodeToString(ephPub)},
+		Body: body,
+	}}, nil
+}
+
+// Unwrap implements Identity.
+func (id *X25519Identity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != x25519Type {
+			continue
+		}
+		if len(s.Args) != 1 {
+			return nil, errInvalidHeader
+		}
+		ephBytes, err := b64.DecodeString(s.Args[0])
+		if err != nil {
+			return nil, errInvalidHeader
+		}
+		eph, err := ecdh.X25519().NewPublicKey(ephBytes)
+		if err != nil {
+			return nil, errInvalidHeader
+		}
+		shared, err := id.priv.ECDH(eph)
+		if err != nil {
+			return nil, errInvalidHeader
+		}
+		wrappingKey, err := hkdf.Key(sha256.New, shared, slices.Concat(ephBytes, id.priv.PublicKey().Bytes()), x25519Label, chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := aeadDecrypt(wrappingKey, s.Body)
+		if err == nil {
+			return fileKey, nil
+		}
+	}
+	return nil, ErrIncorrectIdentity
+}
+
+// aeadEncrypt encrypts a file key with a zero nonce, which is safe because
+// every wrapping key is used only once.
+func aeadEncrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func aeadDecrypt(key, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) != fileKeySize+chacha20poly1305.Overhead {
+		return nil, errInvalidHeader
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,67 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_anonymous(t *testing.T) {
+	alice, bob := generateKeyWithID("alice@example.com"), generateKeyWithID("bob@example.com")
+	carol, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := []byte("hello, world")
+
+	for _, keys := range [][]*sealer.Key{{alice}, {alice, bob}} {
+		sealed := seal(t, keys, nil, sealer.SealOptions{
+			Anonymous:  true,
+			Recipients: []*sealer.Recipient{carol.Recipient()},
+		}, original)
+		for _, id := range [][]byte{alice.ID[:], bob.ID[:], carol.ID[:]} {
+			if bytes.Contains(sealed, id) {
+				t.Errorf("sealed file contains key ID %q", id)
+			}
+		}
+
+		opens := map[string]func(opn *sealer.Openable) (*sealer.Reader, error){
+			"Open": func(opn *sealer.Openable) (*sealer.Reader, error) {
+				return opn.Open(keys[len(keys)-1])
+			},
+			"OpenWithKeyring": func(opn *sealer.Openable) (*sealer.Reader, error) {
+				return opn.OpenWithKeyring(sealer.NewKeyring(generateKeyWithID("other"), keys[len(keys)-1]))
+			},
+			"OpenIdentity": func(opn *sealer.Openable) (*sealer.Reader, error) {
+				return opn.OpenIdentity(carol)
+			},
+		}
+		for name, open := range opens {
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := open(opn)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("%s: got %q, wanted %q", name, actual, original)
+			}
+		}
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := opn.OpenWithKeyring(sealer.NewKeyring(generateKeyWithID("other"))); err == nil {
+			t.Errorf("opened with a foreign key")
+		}
+	}
+}
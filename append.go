@@ -0,0 +1,68 @@
+package sealer
+
+import (
+	"bytes"
+	"io"
+	"slices"
+)
+
+// AppendSeal seals plaintext to key, like Seal with no outer prefix, and
+// appends the sealed file to dst, returning the extended slice. It suits
+// small in-memory payloads such as tokens and database fields.
+func AppendSeal(dst []byte, key *Key, plaintext []byte, opt SealOptions) ([]byte, error) {
+	out := &appendWriter{b: dst}
+	w, err := Seal(out, []*Key{key}, nil, opt)
+	if err != nil {
+		return nil, err
+	}
+	// the estimate is a few bytes short with the stream codecs
+	out.b = slices.Grow(out.b, int(w.SealedSizeEstimate(int64(len(plaintext))))+64)
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.b, nil
+}
+
+// AppendOpen opens sealed, which has to hold a single sealed file and
+// nothing more (see OpenOptions.StrictEOF), using the slot matching
+// key.ID, and appends its plaintext to dst, returning the extended slice.
+// Nothing is returned unless the whole file has been verified.
+func AppendOpen(dst []byte, key *Key, sealed []byte, opts OpenOptions) ([]byte, error) {
+	opts.StrictEOF = true
+	opn, err := PrepareWithOptions(bytes.NewReader(sealed), nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	dst = slices.Grow(dst, len(sealed))
+	for {
+		if len(dst) == cap(dst) {
+			dst = slices.Grow(dst, max(cap(dst), 512))
+		}
+		n, err := r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err == io.EOF {
+			return dst, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// appendWriter appends what is written to b.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
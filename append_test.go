@@ -0,0 +1,45 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestAppendSeal(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, PlaintextDigest: true},
+		{Compression: sealer.CompressionBrotli, Padding: sealer.PaddingPadme},
+	} {
+		for _, original := range [][]byte{nil, []byte("token"), bytes.Repeat([]byte("field "), 20000)} {
+			sealed, err := sealer.AppendSeal([]byte("id:"), key, original, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(sealed[:3]) != "id:" {
+				t.Fatalf("prefix overwritten: %q", sealed[:3])
+			}
+			opened, err := sealer.AppendOpen([]byte("plain:"), key, sealed[3:], sealer.OpenOptions{})
+			if err != nil {
+				t.Fatalf("%+v, %d bytes: %v", opt, len(original), err)
+			}
+			if string(opened[:6]) != "plain:" || !bytes.Equal(opened[6:], original) {
+				t.Errorf("%+v: got %d bytes, wanted %d", opt, len(opened)-6, len(original))
+			}
+
+			// legacy files, unframed, fail to authenticate instead
+			if _, err := sealer.AppendOpen(nil, key, append(sealed[3:], 0), sealer.OpenOptions{}); err == nil || opt.PlaintextDigest && !errors.Is(err, sealer.ErrTrailingData) {
+				t.Errorf("%+v, %d bytes: trailing data: err = %v, wanted ErrTrailingData", opt, len(original), err)
+			}
+			damaged := bytes.Clone(sealed[3:])
+			damaged[len(damaged)-1] ^= 1
+			if opened, err := sealer.AppendOpen(nil, key, damaged, sealer.OpenOptions{}); err == nil || opened != nil {
+				t.Errorf("damaged: %d bytes, err = %v", len(opened), err)
+			}
+		}
+	}
+}
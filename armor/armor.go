@@ -0,0 +1,197 @@
+// Package armor wraps sealed files in ASCII armor: base64 between BEGIN and
+// END markers, like age and PGP do, so that they can be pasted into tickets,
+// YAML files or emails. Both directions stream.
+//
+//	-----BEGIN SEALED FILE-----
+//	AQAAAAAAgAA...
+//	-----END SEALED FILE-----
+//
+// Armored files are about a third larger, so only armor what has to travel
+// as text.
+package armor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+const (
+	Header = "-----BEGIN SEALED FILE-----"
+	Footer = "-----END SEALED FILE-----"
+
+	// ColumnsPerLine is the length of all base64 lines but the last one.
+	ColumnsPerLine = 64
+
+	bytesPerLine = ColumnsPerLine / 4 * 3
+)
+
+// ErrInvalid is returned when reading malformed armor.
+var ErrInvalid = errors.New("armor: invalid armor")
+
+// NewWriter returns a writer that armors the data written to it into w.
+// Close writes the footer, and does not close w.
+func NewWriter(w io.Writer) io.WriteCloser {
+	lw := &lineWriter{w: w}
+	return &writer{lw: lw, enc: base64.NewEncoder(base64.StdEncoding, lw)}
+}
+
+type writer struct {
+	lw      *lineWriter
+	enc     io.WriteCloser
+	started bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		if _, err := io.WriteString(w.lw.w, Header+"\n"); err != nil {
+			return 0, err
+		}
+	}
+	return w.enc.Write(p)
+}
+
+func (w *writer) Close() error {
+	_, err := w.Write(nil)
+	if err != nil {
+		return err
+	}
+	err = w.enc.Close()
+	if err != nil {
+		return err
+	}
+	if w.lw.col > 0 {
+		if _, err := io.WriteString(w.lw.w, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w.lw.w, Footer+"\n")
+	return err
+}
+
+// lineWriter breaks base64 into lines of ColumnsPerLine.
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), ColumnsPerLine-lw.col)
+		if _, err := lw.w.Write(p[:k]); err != nil {
+			return 0, err
+		}
+		p = p[k:]
+		lw.col += k
+		if lw.col == ColumnsPerLine {
+			if _, err := io.WriteString(lw.w, "\n"); err != nil {
+				return 0, err
+			}
+			lw.col = 0
+		}
+	}
+	return n, nil
+}
+
+// NewReader returns a reader of the data armored in r. Whitespace before
+// the header is skipped, and lines can end with CR LF. The reader returns
+// io.EOF at the footer line; note that it buffers r, so it may have read
+// a little past the footer by then.
+func NewReader(r io.Reader) io.Reader {
+	return &reader{r: bufio.NewReaderSize(r, ColumnsPerLine+2)}
+}
+
+type reader struct {
+	r       *bufio.Reader
+	started bool
+	last    bool
+	done    bool
+	buf     []byte
+	decoded [bytesPerLine]byte
+	err     error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads the next line into buf.
+func (r *reader) next() error {
+	if !r.started {
+		r.started = true
+		if err := r.skipSpace(); err != nil {
+			return err
+		}
+		line, err := r.line()
+		if err != nil {
+			return err
+		}
+		if line != Header {
+			return ErrInvalid
+		}
+	}
+	line, err := r.line()
+	if err != nil {
+		return err
+	}
+	if line == Footer {
+		r.done = true
+		return nil
+	}
+	if r.last || len(line) > ColumnsPerLine || len(line)%4 != 0 {
+		return ErrInvalid
+	}
+	r.last = len(line) < ColumnsPerLine || line[len(line)-1] == '='
+	n, err := base64.StdEncoding.Decode(r.decoded[:], []byte(line))
+	if err != nil {
+		return ErrInvalid
+	}
+	r.buf = r.decoded[:n]
+	return nil
+}
+
+func (r *reader) skipSpace() error {
+	for {
+		b, err := r.r.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return r.r.UnreadByte()
+		}
+	}
+}
+
+// line reads a line of at most ColumnsPerLine characters, with the line
+// ending removed.
+func (r *reader) line() (string, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	} else if err == bufio.ErrBufferFull {
+		return "", ErrInvalid
+	} else if err != nil {
+		return "", err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return string(line), nil
+}
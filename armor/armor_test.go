@@ -0,0 +1,109 @@
+package armor_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/armor"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 47, 48, 49, 96, 1000} {
+		original := make([]byte, n)
+		rand.Read(original)
+
+		var buf bytes.Buffer
+		w := armor.NewWriter(&buf)
+		// write in odd pieces to exercise line breaking
+		for p := original; len(p) > 0; {
+			k := min(len(p), 7)
+			if _, err := w.Write(p[:k]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[k:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		armored := buf.String()
+		if !strings.HasPrefix(armored, armor.Header+"\n") || !strings.HasSuffix(armored, "\n"+armor.Footer+"\n") {
+			t.Errorf("%d bytes: malformed armor %q", n, armored)
+		}
+		for _, line := range strings.Split(armored, "\n") {
+			if len(line) > armor.ColumnsPerLine {
+				t.Errorf("%d bytes: line too long: %q", n, line)
+			}
+		}
+
+		for _, input := range []string{armored, "\n  " + strings.ReplaceAll(armored, "\n", "\r\n")} {
+			actual, err := io.ReadAll(armor.NewReader(strings.NewReader(input)))
+			if err != nil {
+				t.Fatalf("%d bytes: %v", n, err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("%d bytes: got %x, wanted %x", n, actual, original)
+			}
+		}
+	}
+}
+
+func TestReader_invalid(t *testing.T) {
+	full := strings.Repeat("A", armor.ColumnsPerLine)
+	for _, input := range []string{
+		"",
+		"hello\n",
+		armor.Header + "\n",
+		armor.Header + "\nAAAA\n",
+		armor.Header + "\nAAA\n" + armor.Footer + "\n",
+		armor.Header + "\n" + full + "A\n" + armor.Footer + "\n",
+		armor.Header + "\nAAAA\n" + full + "\n" + armor.Footer + "\n",
+		armor.Header + "\n!!!!\n" + armor.Footer + "\n",
+	} {
+		if _, err := io.ReadAll(armor.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("read invalid armor %q", input)
+		}
+	}
+}
+
+func TestSealed(t *testing.T) {
+	key := &sealer.Key{ID: [sealer.IDSize]byte{1}}
+	rand.Read(key.Key[:])
+	original := []byte("hello, world")
+
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := sealer.Seal(aw, []*sealer.Key{key}, nil, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(original); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opn, err := sealer.Prepare(armor.NewReader(&buf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("got %q, wanted %q", actual, original)
+	}
+}
@@ -0,0 +1,256 @@
+// Package awskms wraps sealer file keys using AWS KMS Encrypt and Decrypt,
+// so that sealing and opening never need local key material.
+//
+// The key ARN is stored in the slot, so after sealer.Prepare you can call
+// KeyARN on each of Openable.Slots to find out which KMS key is required.
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+)
+
+// KMS is the subset of AWS KMS API used by this package. Client implements
+// it, or you can adapt the official AWS SDK client.
+type KMS interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// encryptionContext is passed to KMS with every request, binding the
+// ciphertexts to this use.
+var encryptionContext = map[string]string{
+	"purpose": "github.com/andreyvit/sealer file key",
+}
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// a KMS key.
+type Wrapper struct {
+	kms    KMS
+	keyARN string
+	ctx    context.Context
+}
+
+// New returns a Wrapper that uses the given KMS key. keyARN should be a full
+// key ARN (or alias ARN), because it's stored in the slot to find the key
+// when opening.
+func New(kms KMS, keyARN string) *Wrapper {
+	return &Wrapper{
+		kms:    kms,
+		keyARN: keyARN,
+		ctx:    context.Background(),
+	}
+}
+
+// WithContext returns a copy of w that uses ctx for KMS requests.
+func (w *Wrapper) WithContext(ctx context.Context) *Wrapper {
+	w2 := *w
+	w2.ctx = ctx
+	return &w2
+}
+
+// KeyARN returns the ARN of the KMS key.
+func (w *Wrapper) KeyARN() string {
+	return w.keyARN
+}
+
+// AWS KMS slot data is the key ARN and CiphertextBlob returned by KMS
+// Encrypt, packed by slotdata.Pack.
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	ciphertext, err := w.kms.Encrypt(w.ctx, w.keyARN, fileKey, encryptionContext)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("awskms: %w", err)
+	}
+	data := slotdata.Pack(w.keyARN, ciphertext)
+	return sealer.Slot{Type: sealer.SlotTypeAWSKMS, KeyID: KeyID(w.keyARN), Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter. Encrypt only takes an
+// encryption context with symmetric KMS keys, so the key is AES-256.
+func (w *Wrapper) PostQuantum() bool {
+	return true
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	arn, ciphertext, ok := parseSlot(s)
+	if !ok || arn != w.keyARN {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.kms.Decrypt(w.ctx, w.keyARN, ciphertext, encryptionContext)
+	if err != nil {
+		return nil, fmt.Errorf("awskms: %w", err)
+	}
+	return fileKey, nil
+}
+
+// KeyID returns the sealer key ID used for slots wrapped by the given key.
+func KeyID(keyARN string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("awskms:" + keyARN))
+}
+
+// KeyARN returns the ARN of the KMS key required to open the slot, or false
+// if it's not an AWS KMS slot.
+func KeyARN(s sealer.Slot) (string, bool) {
+	arn, _, ok := parseSlot(s)
+	return arn, ok
+}
+
+func parseSlot(s sealer.Slot) (keyARN string, ciphertext []byte, ok bool) {
+	if s.Type != sealer.SlotTypeAWSKMS {
+		return "", nil, false
+	}
+	return slotdata.Unpack(s.Data)
+}
+
+// Credentials are AWS access credentials used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv reads credentials from the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func CredentialsFromEnv() (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// Client is a minimal AWS KMS client speaking the JSON API over HTTPS.
+type Client struct {
+	Region      string
+	Credentials func(ctx context.Context) (Credentials, error)
+
+	// Endpoint defaults to https://kms.<region>.amazonaws.com/.
+	Endpoint string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the given region using static credentials.
+func NewClient(region string, creds Credentials) *Client {
+	return &Client{
+		Region: region,
+		Credentials: func(ctx context.Context) (Credentials, error) {
+			return creds, nil
+		},
+	}
+}
+
+type encryptRequest struct {
+	KeyId             string
+	Plaintext         []byte
+	EncryptionContext map[string]string `json:",omitempty"`
+}
+
+type encryptResponse struct {
+	CiphertextBlob []byte
+}
+
+type decryptRequest struct {
+	KeyId             string
+	CiphertextBlob    []byte
+	EncryptionContext map[string]string `json:",omitempty"`
+}
+
+type decryptResponse struct {
+	Plaintext []byte
+}
+
+// Error is an error returned by the AWS KMS API.
+type Error struct {
+	StatusCode int
+	Type       string `json:"__type"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("KMS HTTP %d: %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+// Encrypt calls the KMS Encrypt API.
+func (c *Client) Encrypt(ctx context.Context, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error) {
+	var resp encryptResponse
+	err := c.call(ctx, "Encrypt", &encryptRequest{keyID, plaintext, encryptionContext}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Decrypt calls the KMS Decrypt API.
+func (c *Client) Decrypt(ctx context.Context, keyID string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error) {
+	var resp decryptResponse
+	err := c.call(ctx, "Decrypt", &decryptRequest{keyID, ciphertext, encryptionContext}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (c *Client) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + c.Region + ".amazonaws.com/"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	creds, err := c.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	signV4(req, body, creds, c.Region, "kms", time.Now())
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(respBody, e)
+		return e
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,106 @@
+package awskms_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/awskms"
+)
+
+const testARN = "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+// fakeKMS "encrypts" by reversing the bytes, and checks the request shape.
+func fakeKMS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("missing or invalid Authorization: %q", r.Header.Get("Authorization"))
+		}
+		var req struct {
+			KeyId             string
+			Plaintext         []byte
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.KeyId != testARN {
+			t.Errorf("KeyId = %q", req.KeyId)
+		}
+		if len(req.EncryptionContext) == 0 {
+			t.Errorf("missing EncryptionContext")
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(map[string]any{"CiphertextBlob": reversed(req.Plaintext)})
+		case "TrentService.Decrypt":
+			json.NewEncoder(w).Encode(map[string]any{"Plaintext": reversed(req.CiphertextBlob)})
+		default:
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]any{"__type": "UnknownOperationException"})
+		}
+	}))
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func TestWrapper(t *testing.T) {
+	srv := fakeKMS(t)
+	defer srv.Close()
+
+	client := awskms.NewClient("us-east-1", awskms.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	client.Endpoint = srv.URL
+	wrapper := awskms.New(client, testARN)
+
+	original := make([]byte, 1000)
+	rand.Read(original)
+
+	var sealed bytes.Buffer
+	w, err := sealer.Seal(&sealed, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{wrapper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(original)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opn, err := sealer.Prepare(&sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arn, ok := awskms.KeyARN(opn.Slots[0])
+	if !ok || arn != testARN {
+		t.Fatalf("KeyARN = %q, %v", arn, ok)
+	}
+	if opn.KeyIDs[0] != awskms.KeyID(testARN) {
+		t.Fatalf("KeyIDs[0] = %x", opn.KeyIDs[0])
+	}
+
+	r, err := opn.OpenWith(wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatal("data mismatch")
+	}
+}
@@ -0,0 +1,30 @@
+package awskms
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Example from the AWS Signature Version 4 documentation.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	signV4(req, nil, creds, "us-east-1", "iam", now)
+
+	auth := req.Header.Get("Authorization")
+	const sig = "Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if !strings.HasSuffix(auth, sig) {
+		t.Fatalf("Authorization = %q, wanted it to end with %q", auth, sig)
+	}
+}
@@ -0,0 +1,257 @@
+// Package azurekv wraps sealer file keys using Azure Key Vault wrapkey and
+// unwrapkey operations, so that sealing and opening never need local key
+// material.
+//
+// The versioned key identifier is stored in the slot, so after
+// sealer.Prepare you can call KeyIdentifier on each of Openable.Slots to find
+// out which key (and key version) is required.
+package azurekv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+)
+
+// Key wrapping algorithms supported by Key Vault.
+const (
+	RSAOAEP256 = "RSA-OAEP-256"
+	RSAOAEP    = "RSA-OAEP"
+	A256KW     = "A256KW" // Managed HSM only
+)
+
+// APIVersion is the Key Vault REST API version used by Client.
+const APIVersion = "7.4"
+
+// KeyVault is the subset of Key Vault API used by this package. Client
+// implements it, or you can adapt the official Azure SDK client.
+type KeyVault interface {
+	// WrapKey wraps key using the given key, returning the versioned
+	// identifier of the key that was used.
+	WrapKey(ctx context.Context, keyID, alg string, key []byte) (kid string, wrapped []byte, err error)
+	UnwrapKey(ctx context.Context, kid, alg string, wrapped []byte) ([]byte, error)
+}
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// a Key Vault key.
+type Wrapper struct {
+	kv    KeyVault
+	keyID string
+	alg   string
+	ctx   context.Context
+}
+
+// New returns a Wrapper that uses the given key, identified by a URL like
+// https://myvault.vault.azure.net/keys/mykey (the latest version is used
+// for sealing), or a versioned URL. The algorithm defaults to RSA-OAEP-256.
+func New(kv KeyVault, keyID string) *Wrapper {
+	return &Wrapper{
+		kv:    kv,
+		keyID: strings.TrimSuffix(keyID, "/"),
+		alg:   RSAOAEP256,
+		ctx:   context.Background(),
+	}
+}
+
+// WithAlgorithm returns a copy of w that wraps keys using alg. Of these,
+// only A256KW is accepted with sealer.SealOptions.PostQuantum.
+func (w *Wrapper) WithAlgorithm(alg string) *Wrapper {
+	w2 := *w
+	w2.alg = alg
+	return &w2
+}
+
+// WithContext returns a copy of w that uses ctx for Key Vault requests.
+func (w *Wrapper) WithContext(ctx context.Context) *Wrapper {
+	w2 := *w
+	w2.ctx = ctx
+	return &w2
+}
+
+// Key Vault slot data is the versioned key identifier and, packed within,
+// the algorithm and the wrapped key, both packed by slotdata.Pack.
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	kid, wrapped, err := w.kv.WrapKey(w.ctx, w.keyID, w.alg, fileKey)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("azurekv: %w", err)
+	}
+	data := slotdata.Pack(kid, slotdata.Pack(w.alg, wrapped))
+	return sealer.Slot{Type: sealer.SlotTypeAzureKeyVault, KeyID: KeyID(kid), Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter: only A256KW is
+// symmetric.
+func (w *Wrapper) PostQuantum() bool {
+	return w.alg == A256KW
+}
+
+// Decapsulate implements sealer.Decapsulator. It handles slots wrapped by
+// any version of the key, unless the wrapper was created for a specific
+// version.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	kid, alg, wrapped, ok := parseSlot(s)
+	if !ok || (kid != w.keyID && unversioned(kid) != w.keyID) {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.kv.UnwrapKey(w.ctx, kid, alg, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("azurekv: %w", err)
+	}
+	return fileKey, nil
+}
+
+// KeyID returns the sealer key ID used for slots wrapped by the given key.
+// All versions of a key share the same ID.
+func KeyID(keyID string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("azurekv:" + unversioned(keyID)))
+}
+
+// KeyIdentifier returns the versioned key identifier required to open the
+// slot, or false if it's not a Key Vault slot.
+func KeyIdentifier(s sealer.Slot) (string, bool) {
+	kid, _, _, ok := parseSlot(s)
+	return kid, ok
+}
+
+func parseSlot(s sealer.Slot) (kid, alg string, wrapped []byte, ok bool) {
+	if s.Type != sealer.SlotTypeAzureKeyVault {
+		return "", "", nil, false
+	}
+	kid, rest, ok := slotdata.Unpack(s.Data)
+	if !ok {
+		return "", "", nil, false
+	}
+	alg, wrapped, ok = slotdata.Unpack(rest)
+	return kid, alg, wrapped, ok
+}
+
+// unversioned strips the version from a key identifier like
+// https://myvault.vault.azure.net/keys/mykey/0123abcd.
+func unversioned(kid string) string {
+	scheme, rest, ok := strings.Cut(kid, "://")
+	if !ok {
+		return kid
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) == 4 && parts[1] == "keys" {
+		return scheme + "://" + strings.Join(parts[:3], "/")
+	}
+	return kid
+}
+
+// Client is a minimal Key Vault client speaking the REST API over HTTPS.
+type Client struct {
+	// Token returns an OAuth2 access token for the
+	// https://vault.azure.net/.default scope.
+	Token func(ctx context.Context) (string, error)
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client that authenticates using the given token
+// function.
+func NewClient(token func(ctx context.Context) (string, error)) *Client {
+	return &Client{Token: token}
+}
+
+type keyOperation struct {
+	Alg   string `json:"alg,omitempty"`
+	Value string `json:"value"`
+}
+
+type keyOperationResult struct {
+	Kid   string `json:"kid"`
+	Value string `json:"value"`
+}
+
+// Error is an error returned by the Key Vault API.
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Key Vault HTTP %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// WrapKey calls the wrapkey operation.
+func (c *Client) WrapKey(ctx context.Context, keyID, alg string, key []byte) (string, []byte, error) {
+	return c.call(ctx, keyID+"/wrapkey", alg, key)
+}
+
+// UnwrapKey calls the unwrapkey operation.
+func (c *Client) UnwrapKey(ctx context.Context, kid, alg string, wrapped []byte) ([]byte, error) {
+	_, key, err := c.call(ctx, kid+"/unwrapkey", alg, wrapped)
+	return key, err
+}
+
+func (c *Client) call(ctx context.Context, url, alg string, value []byte) (kid string, result []byte, err error) {
+	body, err := json.Marshal(&keyOperation{
+		Alg:   alg,
+		Value: base64.RawURLEncoding.EncodeToString(value),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"?api-version="+APIVersion, bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	token, err := c.Token(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var wrapper struct {
+			Error *Error `json:"error"`
+		}
+		_ = json.Unmarshal(respBody, &wrapper)
+		e := wrapper.Error
+		if e == nil {
+			e = &Error{}
+		}
+		e.StatusCode = resp.StatusCode
+		return "", nil, e
+	}
+
+	var res keyOperationResult
+	if err := json.Unmarshal(respBody, &res); err != nil {
+		return "", nil, err
+	}
+	result, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(res.Value, "="))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid value in Key Vault response: %w", err)
+	}
+	return res.Kid, result, nil
+}
@@ -0,0 +1,133 @@
+package azurekv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/azurekv"
+)
+
+// fakeVault "wraps" by reversing the bytes, and rotates key versions on
+// every wrap.
+func fakeVault(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	version := 0
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != azurekv.APIVersion {
+			t.Errorf("api-version = %q", r.URL.Query().Get("api-version"))
+		}
+		var req struct {
+			Alg   string `json:"alg"`
+			Value string `json:"value"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Alg != azurekv.RSAOAEP256 {
+			t.Errorf("alg = %q", req.Alg)
+		}
+		value, err := base64.RawURLEncoding.DecodeString(req.Value)
+		if err != nil {
+			t.Error(err)
+		}
+		switch {
+		case r.URL.Path == "/keys/k/wrapkey":
+			version++
+			kid := srv.URL + "/keys/k/v" + string(rune('0'+version))
+			json.NewEncoder(w).Encode(map[string]any{"kid": kid, "value": base64.RawURLEncoding.EncodeToString(reversed(value))})
+		case strings.HasPrefix(r.URL.Path, "/keys/k/v") && strings.HasSuffix(r.URL.Path, "/unwrapkey"):
+			json.NewEncoder(w).Encode(map[string]any{"kid": srv.URL + r.URL.Path, "value": base64.RawURLEncoding.EncodeToString(reversed(value))})
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": {"code": "KeyNotFound", "message": "no such key"}}`))
+		}
+	}))
+	return srv
+}
+
+// reversingVault is a KeyVault that "wraps" by reversing the bytes.
+type reversingVault struct{}
+
+func (reversingVault) WrapKey(ctx context.Context, keyID, alg string, key []byte) (string, []byte, error) {
+	return keyID + "/v1", reversed(key), nil
+}
+
+func (reversingVault) UnwrapKey(ctx context.Context, kid, alg string, wrapped []byte) ([]byte, error) {
+	return reversed(wrapped), nil
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func TestWrapper(t *testing.T) {
+	srv := fakeVault(t)
+	defer srv.Close()
+
+	client := azurekv.NewClient(func(ctx context.Context) (string, error) { return "TOKEN", nil })
+	client.HTTPClient = srv.Client()
+	wrapper := azurekv.New(client, srv.URL+"/keys/k")
+
+	original := []byte("hello, world")
+	var sealed bytes.Buffer
+	w, err := sealer.Seal(&sealed, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{wrapper, wrapper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(original)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opn, err := sealer.Prepare(&sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, ok := azurekv.KeyIdentifier(opn.Slots[1])
+	if !ok || kid != srv.URL+"/keys/k/v2" {
+		t.Fatalf("KeyIdentifier = %q, %v", kid, ok)
+	}
+	if opn.KeyIDs[0] != opn.KeyIDs[1] || opn.KeyIDs[0] != azurekv.KeyID(srv.URL+"/keys/k") {
+		t.Fatalf("expected all key versions to share a key ID")
+	}
+
+	r, err := opn.OpenWith(wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+}
+
+func TestWrapper_postQuantum(t *testing.T) {
+	wrapper := azurekv.New(reversingVault{}, "https://vault.example.com/keys/k")
+	for _, tc := range []struct {
+		alg string
+		err error
+	}{{azurekv.RSAOAEP256, sealer.ErrNotPostQuantum}, {azurekv.A256KW, nil}} {
+		_, err := sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+			Encapsulators: []sealer.Encapsulator{wrapper.WithAlgorithm(tc.alg)},
+			PostQuantum:   true,
+		})
+		if err != tc.err {
+			t.Errorf("Seal with %s and PostQuantum = %v, wanted %v", tc.alg, err, tc.err)
+		}
+	}
+}
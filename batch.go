@@ -0,0 +1,204 @@
+package sealer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// BatchOptions configure SealMany and OpenMany.
+type BatchOptions struct {
+	// Workers is the number of items processed at once, GOMAXPROCS if
+	// zero. Each worker keeps a Writer or Reader, resetting it for every
+	// item, so that their buffers and codecs are reused.
+	Workers int
+
+	// Progress, if not nil, is called after every item with the totals so
+	// far. Calls don't overlap.
+	Progress func(BatchProgress)
+}
+
+// BatchProgress reports the progress of SealMany and OpenMany.
+type BatchProgress struct {
+	// Items is the number of items done, and Failed the number of those
+	// that failed.
+	Items  int
+	Failed int
+
+	// Plaintext is the number of plaintext bytes sealed or opened.
+	Plaintext int64
+}
+
+// ItemError is the error of an item of SealMany or OpenMany.
+type ItemError struct {
+	// Index is the position of the item in the sequence, from 0.
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by SealMany and OpenMany when some items fail.
+type BatchError struct {
+	// Errors are the errors of the failed items, by index.
+	Errors []*ItemError
+}
+
+func (e *BatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sealer: %d items failed", len(e.Errors))
+	for i, err := range e.Errors {
+		if i == 3 {
+			b.WriteString("; ...")
+			break
+		}
+		b.WriteString("; ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// SealMany seals every source of items to its destination, like Seal with
+// no outer prefix followed by io.Copy, on up to bopt.Workers goroutines at
+// once. Sources and destinations that are io.Closers are closed once done
+// with, which lets items open files lazily. A failed item doesn't stop the
+// others; their errors are returned together as a *BatchError.
+//
+// opt can't have a Manifest or Buffers, which are per stream.
+func SealMany(items iter.Seq2[io.Reader, io.Writer], keys []*Key, opt SealOptions, bopt BatchOptions) error {
+	if opt.Manifest != nil || opt.Buffers != nil {
+		return fmt.Errorf("%w: SealMany cannot use a Manifest or Buffers", ErrInvalidOptions)
+	}
+	return runBatch(items, bopt, func() func(in io.Reader, out io.Writer) (int64, error) {
+		var w Writer
+		return func(in io.Reader, out io.Writer) (int64, error) {
+			err := w.Reset(out, keys, nil, opt)
+			if err != nil {
+				return 0, err
+			}
+			n, err := io.Copy(&w, in)
+			if err != nil {
+				return n, err
+			}
+			return n, w.Close()
+		}
+	})
+}
+
+// OpenMany opens every sealed source of items (see PrepareWithOptions),
+// using the first of keys that can decrypt its file key, and writes its plaintext
+// to the destination, on up to bopt.Workers goroutines at once. Sources
+// and destinations are closed like by SealMany, and errors are returned
+// the same way. An item whose plaintext fails to verify may have written
+// some of it before the failure.
+//
+// opts can't have Buffers, which are per stream.
+func OpenMany(items iter.Seq2[io.Reader, io.Writer], keys []*Key, opts OpenOptions, bopt BatchOptions) error {
+	if opts.Buffers != nil {
+		return fmt.Errorf("%w: OpenMany cannot use Buffers", ErrInvalidOptions)
+	}
+	return runBatch(items, bopt, func() func(in io.Reader, out io.Writer) (int64, error) {
+		var r Reader
+		return func(in io.Reader, out io.Writer) (int64, error) {
+			opn, err := PrepareWithOptions(in, nil, opts)
+			if err != nil {
+				return 0, err
+			}
+			err = ErrNoMatchingKey
+			for _, key := range keys {
+				err = r.Reset(opn, key)
+				if !errors.Is(err, ErrNoMatchingKey) && !errors.Is(err, ErrWrongKey) {
+					break
+				}
+			}
+			if err != nil {
+				return 0, err
+			}
+			return io.Copy(out, &r)
+		}
+	})
+}
+
+type batchItem struct {
+	index int
+	in    io.Reader
+	out   io.Writer
+}
+
+// runBatch processes items on the workers, each of which calls newWorker
+// for the function processing its items.
+func runBatch(items iter.Seq2[io.Reader, io.Writer], bopt BatchOptions, newWorker func() func(in io.Reader, out io.Writer) (int64, error)) error {
+	workers := bopt.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var mu sync.Mutex
+	var progress BatchProgress
+	var errs []*ItemError
+	done := func(index int, n int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Items++
+		progress.Plaintext += n
+		if err != nil {
+			progress.Failed++
+			errs = append(errs, &ItemError{Index: index, Err: err})
+		}
+		if bopt.Progress != nil {
+			bopt.Progress(progress)
+		}
+	}
+
+	queue := make(chan batchItem)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			process := newWorker()
+			for item := range queue {
+				n, err := process(item.in, item.out)
+				if c, ok := item.in.(io.Closer); ok {
+					c.Close()
+				}
+				if c, ok := item.out.(io.Closer); ok {
+					if cerr := c.Close(); err == nil {
+						err = cerr
+					}
+				}
+				done(item.index, n, err)
+			}
+		})
+	}
+	index := 0
+	for in, out := range items {
+		queue <- batchItem{index, in, out}
+		index++
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	slices.SortFunc(errs, func(a, b *ItemError) int { return a.Index - b.Index })
+	return &BatchError{Errors: errs}
+}
@@ -0,0 +1,86 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+// closingBuffer records whether it has been closed.
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSealMany(t *testing.T) {
+	key, other := generateKeyWithID("key"), generateKeyWithID("other")
+	var originals [][]byte
+	var total int64
+	for i := range 50 {
+		originals = append(originals, bytes.Repeat([]byte(fmt.Sprint(i)), i*100))
+		total += int64(len(originals[i]))
+	}
+	sealed := make([]*closingBuffer, len(originals))
+	var last sealer.BatchProgress
+	err := sealer.SealMany(func(yield func(io.Reader, io.Writer) bool) {
+		for i, original := range originals {
+			sealed[i] = new(closingBuffer)
+			if !yield(bytes.NewReader(original), sealed[i]) {
+				return
+			}
+		}
+	}, []*sealer.Key{key}, sealer.SealOptions{Compression: sealer.CompressionZstdChunks}, sealer.BatchOptions{
+		Workers:  4,
+		Progress: func(p sealer.BatchProgress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Items != len(originals) || last.Failed != 0 || last.Plaintext != total {
+		t.Errorf("progress = %+v", last)
+	}
+
+	// damage some of them
+	sealed[7].Bytes()[sealed[7].Len()-1] ^= 1
+	sealed[30].Truncate(10)
+	opened := make([]*closingBuffer, len(originals))
+	err = sealer.OpenMany(func(yield func(io.Reader, io.Writer) bool) {
+		for i, s := range sealed {
+			opened[i] = new(closingBuffer)
+			if !yield(bytes.NewReader(s.Bytes()), opened[i]) {
+				return
+			}
+		}
+	}, []*sealer.Key{other, key}, sealer.OpenOptions{}, sealer.BatchOptions{
+		Workers:  3,
+		Progress: func(p sealer.BatchProgress) { last = p },
+	})
+	var berr *sealer.BatchError
+	if !errors.As(err, &berr) || len(berr.Errors) != 2 || berr.Errors[0].Index != 7 || berr.Errors[1].Index != 30 {
+		t.Fatalf("err = %v, wanted errors of items 7 and 30", err)
+	}
+	if last.Items != len(originals) || last.Failed != 2 {
+		t.Errorf("progress = %+v", last)
+	}
+	for i, original := range originals {
+		if !sealed[i].closed || !opened[i].closed {
+			t.Errorf("item %d not closed", i)
+		}
+		if i != 7 && i != 30 && !bytes.Equal(opened[i].Bytes(), original) {
+			t.Errorf("item %d: got %d bytes, wanted %d", i, opened[i].Len(), len(original))
+		}
+	}
+
+	if err := sealer.SealMany(nil, []*sealer.Key{key}, sealer.SealOptions{Manifest: new(sealer.Manifest)}, sealer.BatchOptions{}); !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("SealMany with a Manifest: err = %v, wanted ErrInvalidOptions", err)
+	}
+}
@@ -0,0 +1,44 @@
+package sealer
+
+import "fmt"
+
+// Buffers are chunk buffers supplied by the caller (see SealOptions.Buffers
+// and OpenOptions.Buffers), so that a service sealing or opening many
+// streams can keep a set per goroutine, and streams don't allocate their
+// own. A Buffers can only be used by one stream at a time, until the
+// Writer is closed or the Reader is done with.
+type Buffers struct {
+	// Plaintext holds a chunk of plaintext, and needs a capacity of at
+	// least the chunk size.
+	Plaintext []byte
+
+	// Sealed holds a sealed chunk, and needs a capacity of at least
+	// SealedChunkSize(chunkSize).
+	Sealed []byte
+}
+
+// NewBuffers returns Buffers for streams with chunks of up to chunkSize
+// bytes (DefaultChunkSize if zero).
+func NewBuffers(chunkSize int) *Buffers {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Buffers{
+		Plaintext: make([]byte, 0, chunkSize),
+		Sealed:    make([]byte, 0, SealedChunkSize(chunkSize)),
+	}
+}
+
+// SealedChunkSize returns the largest size of a sealed chunk holding up to
+// chunkSize bytes of plaintext, headers included.
+func SealedChunkSize(chunkSize int) int {
+	return chunkHeaderSize + chunkSizeFieldSize + nonceSizeX + chunkSize + overhead
+}
+
+// check returns an error unless the buffers fit chunks of the given sizes.
+func (b *Buffers) check(plaintext, sealed int) error {
+	if cap(b.Plaintext) < plaintext || cap(b.Sealed) < sealed {
+		return fmt.Errorf("sealer: Buffers too small for chunks of %d bytes", plaintext)
+	}
+	return nil
+}
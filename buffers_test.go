@@ -0,0 +1,86 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestBuffers(t *testing.T) {
+	key := generateKeyWithID("key")
+	const chunkSize = 256 * 1024
+	original := randomBytes(t, 3*chunkSize+1000)
+	bufs := sealer.NewBuffers(chunkSize)
+
+	var sealed, opened bytes.Buffer
+	sealAndOpen := func(opt sealer.SealOptions) {
+		opt.ChunkSize = chunkSize
+		opt.Buffers = bufs
+		sealed.Reset()
+		w, err := sealer.Seal(&sealed, []*sealer.Key{key}, nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed.Bytes()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.Buffers = bufs
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened.Reset()
+		if _, err := opened.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened.Bytes(), original) {
+			t.Fatalf("%+v: got %d bytes, wanted %d", opt, opened.Len(), len(original))
+		}
+	}
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, RandomNonces: true, PlaintextDigest: true},
+		{Compression: sealer.CompressionZstdChunks, MerkleRoot: true},
+		{},
+	} {
+		sealAndOpen(opt)
+	}
+
+	// the chunk buffers are no longer allocated per stream
+	sealed.Grow(2 * len(original))
+	opened.Grow(2 * len(original))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for range 10 {
+		sealAndOpen(sealer.SealOptions{Compression: sealer.CompressionNone})
+	}
+	runtime.ReadMemStats(&after)
+	if n := (after.TotalAlloc - before.TotalAlloc) / 10; n > chunkSize {
+		t.Errorf("allocated %d bytes per stream", n)
+	}
+
+	small := sealer.NewBuffers(1024)
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Buffers: small}); !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("Seal with small Buffers: err = %v, wanted ErrInvalidOptions", err)
+	}
+	opn, err := sealer.Prepare(bytes.NewReader(sealed.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn.Buffers = small
+	if _, err := opn.Open(key); err == nil {
+		t.Errorf("Open with small Buffers: no error")
+	}
+}
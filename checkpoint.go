@@ -0,0 +1,207 @@
+package sealer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxCheckpointLabelLen is the longest label of a checkpoint (see
+// Writer.Checkpoint).
+const MaxCheckpointLabelLen = 32
+
+var (
+	// ErrTooManyCheckpoints is returned by Writer.Checkpoint once the room
+	// reserved by SealOptions.Checkpoints is used up.
+	ErrTooManyCheckpoints = errors.New("too many checkpoints")
+
+	// ErrCheckpointNotFound is returned by Reader.SeekCheckpoint for
+	// a label that the file has no checkpoint for.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+)
+
+// Files sealed with SealOptions.Checkpoints record the checkpoints in the
+// trailer, with the position of the chunk holding each, so that readers
+// can jump to them without an index. The field has room for a fixed number
+// of checkpoints, since the size of the trailer is set in the header.
+//
+// Checkpoints field value:
+//  - count           uint32, number of checkpoints
+//  - checkpoints     [capacity]checkpoint, the unused ones zeroed
+//
+// Checkpoint:
+//  - labelLen        uint8
+//  - label           [MaxCheckpointLabelLen]byte, zero-padded
+//  - plaintext       uint64, offset in the plaintext
+//  - chunk           uint32, index of the chunk holding the offset
+//  - ciphertext      uint64, offset of the chunk from the first chunk
+
+const checkpointLen = 1 + MaxCheckpointLabelLen + 8 + 4 + 8
+
+// maxCheckpoints is the most checkpoints that fit into a field.
+const maxCheckpoints = (maxFieldSize - 4) / checkpointLen
+
+// Checkpoint is a named position in the plaintext (see Writer.Checkpoint).
+type Checkpoint struct {
+	Label  string
+	Offset int64
+}
+
+type checkpoint struct {
+	label      string
+	plaintext  int64
+	chunk      uint32
+	ciphertext int64
+}
+
+type checkpoints struct {
+	list     []checkpoint
+	capacity int
+}
+
+func (c *checkpoints) append(buf []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.list)))
+	for _, cp := range c.list {
+		buf = append(buf, byte(len(cp.label)))
+		buf = append(buf, cp.label...)
+		buf = append(buf, make([]byte, MaxCheckpointLabelLen-len(cp.label))...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(cp.plaintext))
+		buf = binary.LittleEndian.AppendUint32(buf, cp.chunk)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(cp.ciphertext))
+	}
+	return append(buf, make([]byte, (c.capacity-len(c.list))*checkpointLen)...)
+}
+
+func (c *checkpoints) parse(value []byte) error {
+	if len(value) < 4 || (len(value)-4)%checkpointLen != 0 {
+		return errBadTrailer
+	}
+	count := int(binary.LittleEndian.Uint32(value))
+	c.capacity = (len(value) - 4) / checkpointLen
+	if count > c.capacity {
+		return errBadTrailer
+	}
+	value = value[4:]
+	c.list = make([]checkpoint, count)
+	for i := range c.list {
+		n := int(value[0])
+		if n > MaxCheckpointLabelLen {
+			return errBadTrailer
+		}
+		e := value[1+MaxCheckpointLabelLen:]
+		c.list[i] = checkpoint{
+			label:      string(value[1 : 1+n]),
+			plaintext:  int64(binary.LittleEndian.Uint64(e)),
+			chunk:      binary.LittleEndian.Uint32(e[8:]),
+			ciphertext: int64(binary.LittleEndian.Uint64(e[12:])),
+		}
+		if c.list[i].plaintext < 0 || c.list[i].ciphertext < 0 {
+			return errBadTrailer
+		}
+		value = value[checkpointLen:]
+	}
+	return nil
+}
+
+// Checkpoint records a named checkpoint at the current position of the
+// plaintext, e.g. at the start of every section of an export, or every
+// flush of a log, so that readers can jump to it (see
+// Reader.SeekCheckpoint). The label must be at most MaxCheckpointLabelLen
+// bytes long, and checkpoints need room reserved with
+// SealOptions.Checkpoints; once it is used up, Checkpoint returns
+// ErrTooManyCheckpoints.
+func (w *Writer) Checkpoint(label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := w.enc.checkpoints
+	if c == nil || len(c.list) == c.capacity {
+		return ErrTooManyCheckpoints
+	}
+	if len(label) > MaxCheckpointLabelLen {
+		return fmt.Errorf("checkpoint label %q is longer than %d bytes", label, MaxCheckpointLabelLen)
+	}
+	perChunk := int64(w.enc.chunkSize)
+	if w.layout.compression == CompressionZstdChunks {
+		perChunk--
+	}
+	// the chunk's offset is filled in when it's written
+	c.list = append(c.list, checkpoint{label: label, plaintext: w.total, chunk: uint32(w.total / perChunk), ciphertext: -1})
+	return nil
+}
+
+// placeCheckpoints fills in off as the offset of the chunk about to be
+// written in the checkpoints it holds.
+func (e *encryptor) placeCheckpoints(off int64) {
+	if e.checkpoints == nil {
+		return
+	}
+	for i := range e.checkpoints.list {
+		cp := &e.checkpoints.list[i]
+		if cp.ciphertext < 0 && cp.chunk == e.chunkIndex {
+			cp.ciphertext = off
+		}
+	}
+}
+
+// Checkpoints returns the checkpoints recorded in the trailer (see
+// Writer.Checkpoint) in the order they were made. Before the end of the
+// file, the trailer is read like Size does, and ErrNotSeekable is returned
+// if it can't be.
+func (r *Reader) Checkpoints() ([]Checkpoint, error) {
+	t, err := r.peekTrailer()
+	if err == errTrailerUnavailable {
+		return nil, ErrNotSeekable
+	} else if err != nil {
+		return nil, err
+	}
+	if t.checkpoints == nil {
+		return nil, nil
+	}
+	result := make([]Checkpoint, len(t.checkpoints.list))
+	for i, cp := range t.checkpoints.list {
+		result[i] = Checkpoint{Label: cp.label, Offset: cp.plaintext}
+	}
+	return result, nil
+}
+
+// SeekCheckpoint moves the Reader to the first checkpoint with the given
+// label (see Writer.Checkpoint), and returns its offset in the plaintext.
+// The Reader has to read from an io.ReadSeeker that ends with the file,
+// and the trailer has to be readable before the end (see Size); for
+// CompressionZstdChunks, this takes SealOptions.SeekIndex. Like Seek, it
+// stops the plaintext as a whole from being checked against the trailer.
+func (r *Reader) SeekCheckpoint(label string) (int64, error) {
+	in, ok := r.dec.in.(io.ReadSeeker)
+	if !ok || r.dec.salvage != nil || r.bodyOff < 0 {
+		return 0, ErrNotSeekable
+	}
+	t, err := r.peekTrailer()
+	if err == errTrailerUnavailable {
+		return 0, ErrNotSeekable
+	} else if err != nil {
+		return 0, err
+	}
+	if t.checkpoints == nil {
+		return 0, ErrCheckpointNotFound
+	}
+	for _, cp := range t.checkpoints.list {
+		if cp.label != label {
+			continue
+		}
+		perChunk := int64(r.dec.chunkSize)
+		if r.dec.variableChunks {
+			perChunk--
+		}
+		within := cp.plaintext - int64(cp.chunk)*perChunk
+		if within < 0 || within >= perChunk {
+			return 0, errBadTrailer
+		}
+		err = r.seekChunk(in, int64(cp.chunk), cp.ciphertext, within, cp.plaintext)
+		if err != nil {
+			return 0, err
+		}
+		return cp.plaintext, nil
+	}
+	return 0, ErrCheckpointNotFound
+}
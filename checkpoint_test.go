@@ -0,0 +1,119 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestCheckpoint(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 20000)
+	for i := 0; i < len(original); i += 200 {
+		clear(original[i : i+100])
+	}
+	labels := []string{"start", "a", "b", "b", "c", "end"}
+	offsets := []int64{0, 1023, 4000, 4000, 10240, 20000}
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, RandomNonces: true, PlaintextDigest: true},
+		{Compression: sealer.CompressionZstdChunks, SeekIndex: true},
+	} {
+		opt.ChunkSize = 1024
+		opt.Checkpoints = 8
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.RandomNonces), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pos int64
+			for i, label := range labels {
+				if _, err := w.Write(original[pos:offsets[i]]); err != nil {
+					t.Fatal(err)
+				}
+				pos = offsets[i]
+				if err := w.Checkpoint(label); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			sealed := buf.Bytes()
+
+			r := openReader(t, sealed, key)
+			cps, err := r.Checkpoints()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cps) != len(labels) {
+				t.Fatalf("Checkpoints() = %v", cps)
+			}
+			for i, cp := range cps {
+				if cp.Label != labels[i] || cp.Offset != offsets[i] {
+					t.Errorf("Checkpoints()[%d] = %+v", i, cp)
+				}
+			}
+			for _, label := range []string{"c", "a", "b", "end", "start"} {
+				off, err := r.SeekCheckpoint(label)
+				if err != nil {
+					t.Fatalf("SeekCheckpoint(%q): %v", label, err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("reading from %q: %v", label, err)
+				}
+				if !bytes.Equal(actual, original[off:]) {
+					t.Fatalf("got %d bytes from %q at %d", len(actual), label, off)
+				}
+			}
+			if _, err := r.SeekCheckpoint("none"); !errors.Is(err, sealer.ErrCheckpointNotFound) {
+				t.Errorf("SeekCheckpoint of a missing label: err = %v, wanted ErrCheckpointNotFound", err)
+			}
+
+			// the file reads normally from the start
+			actual, err := io.ReadAll(openReader(t, sealed, key))
+			if err != nil || !bytes.Equal(actual, original) {
+				t.Errorf("got %d bytes: %v", len(actual), err)
+			}
+		})
+	}
+}
+
+func TestCheckpoint_limits(t *testing.T) {
+	key := generateKeyWithID("key")
+	w, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, Checkpoints: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Checkpoint(string(make([]byte, sealer.MaxCheckpointLabelLen+1))); err == nil {
+		t.Errorf("Checkpoint with a long label: no error")
+	}
+	if err := w.Checkpoint("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Checkpoint("b"); !errors.Is(err, sealer.ErrTooManyCheckpoints) {
+		t.Errorf("err = %v, wanted ErrTooManyCheckpoints", err)
+	}
+
+	w, err = sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Checkpoint("a"); !errors.Is(err, sealer.ErrTooManyCheckpoints) {
+		t.Errorf("Checkpoint without room: err = %v, wanted ErrTooManyCheckpoints", err)
+	}
+
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionZstd, Checkpoints: 1}); !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("Checkpoints with zstd: err = %v, wanted ErrInvalidOptions", err)
+	}
+	// more checkpoints than the trailer field holds, however large the chunks
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 1 << 20, Checkpoints: 2000}); !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("2000 Checkpoints: err = %v, wanted ErrInvalidOptions", err)
+	}
+}
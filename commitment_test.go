@@ -0,0 +1,80 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_keyCommitment(t *testing.T) {
+	a, b := generateKeyWithID("A"), generateKeyWithID("B")
+	original := []byte("hello, world")
+
+	open := func(sealed []byte, key *sealer.Key) ([]byte, error) {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			return nil, err
+		}
+		opn.RequireKeyCommitment = true
+		r, err := opn.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	single := seal(t, []*sealer.Key{a}, nil, sealer.SealOptions{}, original)
+	if _, err := open(single, a); err != sealer.ErrNoKeyCommitment {
+		t.Errorf("opening a single-key file: err = %v, wanted ErrNoKeyCommitment", err)
+	}
+
+	for _, sealed := range [][]byte{
+		seal(t, []*sealer.Key{a}, nil, sealer.SealOptions{CommitKey: true}, original),
+		seal(t, []*sealer.Key{a, b}, nil, sealer.SealOptions{}, original),
+	} {
+		actual, err := open(sealed, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+
+		// commitment field: type 0x8006, size 32
+		i := bytes.Index(sealed, []byte{0x06, 0x80, 0x20, 0x00})
+		if i < 0 {
+			t.Fatal("no key commitment in the header")
+		}
+		tampered := bytes.Clone(sealed)
+		tampered[i+4] ^= 1
+		if _, err := open(tampered, a); !errors.Is(err, sealer.ErrCorrupted) {
+			t.Errorf("opening a file with a tampered key commitment: err = %v, wanted ErrCorrupted", err)
+		}
+		// the header MAC field follows: type 2, size 32
+		if !bytes.Equal(sealed[i+36:i+40], []byte{0x02, 0x00, 0x20, 0x00}) {
+			t.Fatal("no header MAC after the key commitment")
+		}
+		tampered = bytes.Clone(sealed)
+		tampered[i+40] ^= 1
+		if _, err := open(tampered, a); !errors.Is(err, sealer.ErrCorrupted) {
+			t.Errorf("opening a file with a tampered header MAC: err = %v, wanted ErrCorrupted", err)
+		}
+
+		// resealing keeps the file committed
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = opn.Reseal(&buf, a, []*sealer.Key{b}, sealer.SealOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := open(buf.Bytes(), b); err != nil {
+			t.Errorf("opening a resealed file: %v", err)
+		}
+	}
+}
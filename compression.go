@@ -0,0 +1,445 @@
+package sealer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how Seal compresses the data before encrypting it
+// (see SealOptions.Compression).
+type Compression int
+
+const (
+	// CompressionZstd compresses the data with zstd. This is the default.
+	CompressionZstd Compression = iota
+
+	// CompressionNone stores the data as is, for payloads that don't
+	// compress anyway (video, archives, encrypted blobs), making throughput
+	// and the size of the output predictable.
+	CompressionNone
+
+	// CompressionZstdChunks compresses each chunk's worth of data
+	// separately with zstd, and stores the chunks that don't get any
+	// smaller as is, like zip does for incompressible files. This saves
+	// the time and the bytes otherwise wasted on media and archives inside
+	// otherwise compressible streams, at some cost in compression ratio
+	// on the rest. Cannot be combined with Padding.
+	//
+	// Since every chunk is its own zstd frame, chunks can be decrypted and
+	// decompressed out of order and in parallel, which makes this the mode
+	// for random access.
+	CompressionZstdChunks
+
+	// CompressionBrotli compresses the data with brotli at
+	// SealOptions.BrotliLevel. It's slower than zstd, but pays off for
+	// web assets sealed once and served many times, and is the codec
+	// browsers and CDNs speak natively.
+	CompressionBrotli
+)
+
+// String returns the name of the compression, as shown by tools.
+func (c Compression) String() string {
+	switch c {
+	case CompressionZstd:
+		return "zstd"
+	case CompressionNone:
+		return "none"
+	case CompressionZstdChunks:
+		return "zstd-chunks"
+	case CompressionBrotli:
+		return "brotli"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// isZstd returns whether the compression uses zstd, and so its options.
+func (c Compression) isZstd() bool {
+	return c == CompressionZstd || c == CompressionZstdChunks
+}
+
+// Chunk format of CompressionZstdChunks (chunks are framed, and all but the
+// final one can be shorter than chunkSize):
+//  - flag            uint8 (chunkStored or chunkCompressed)
+//  - data            the data as is, or a zstd frame holding at most
+//                    chunkSize-1 bytes of it
+
+const (
+	chunkStored     byte = 0
+	chunkCompressed byte = 1
+)
+
+// DefaultLongRangeWindowSize is the zstd window size used by
+// SealOptions.ZstdLongRange, same as that of `zstd --long`.
+const DefaultLongRangeWindowSize = 128 << 20
+
+// ZstdLiterals selects how the zstd encoder compresses literals (see
+// SealOptions.ZstdLiterals).
+type ZstdLiterals int
+
+const (
+	// ZstdLiteralsDefault lets the level decide.
+	ZstdLiteralsDefault ZstdLiterals = iota
+
+	// ZstdLiteralsEntropy entropy-codes the literals of blocks without any
+	// matches too, which shrinks text-like data with no repetitions.
+	ZstdLiteralsEntropy
+
+	// ZstdLiteralsSkipUnmatched stores the literals of blocks without any
+	// matches as they are, which skips incompressible data faster.
+	ZstdLiteralsSkipUnmatched
+
+	// ZstdLiteralsRaw never entropy-codes literals, which is a bit faster
+	// for data that only compresses through matches.
+	ZstdLiteralsRaw
+)
+
+// maxZstdLevel is the highest value of SealOptions.ZstdLevel.
+const maxZstdLevel = 22
+
+// zstdOptions returns the zstd encoder options configured by opt, which
+// has been validated.
+func (opt *SealOptions) zstdOptions() []zstd.EOption {
+	var zopts []zstd.EOption
+	if opt.ZstdLevel != 0 {
+		zopts = append(zopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opt.ZstdLevel)))
+	}
+	if opt.Dictionary != nil {
+		zopts = append(zopts, opt.Dictionary.encoderOption())
+	}
+	window := opt.ZstdWindowSize
+	if window == 0 && opt.ZstdLongRange {
+		window = DefaultLongRangeWindowSize
+	}
+	if window != 0 {
+		zopts = append(zopts, zstd.WithWindowSize(window))
+	}
+	if opt.ZstdConcurrency != 0 && opt.Compression == CompressionZstd {
+		zopts = append(zopts, zstd.WithEncoderConcurrency(opt.ZstdConcurrency))
+	}
+	switch opt.ZstdLiterals {
+	case ZstdLiteralsEntropy:
+		zopts = append(zopts, zstd.WithAllLitEntropyCompression(true))
+	case ZstdLiteralsSkipUnmatched:
+		zopts = append(zopts, zstd.WithAllLitEntropyCompression(false))
+	case ZstdLiteralsRaw:
+		zopts = append(zopts, zstd.WithNoEntropyCompression(true))
+	}
+	if opt.ZstdLowMemory {
+		zopts = append(zopts, zstd.WithLowerEncoderMem(true))
+	}
+	return zopts
+}
+
+// zstdOptions returns the zstd decoder options configured by opn.
+func (opn *Openable) zstdOptions() ([]zstd.DOption, error) {
+	dopts, err := opn.decompressorOptions()
+	if err != nil {
+		return nil, err
+	}
+	return dopts.zstdOptions(), nil
+}
+
+// decompressorOptions are the options a decompressor is created with. A
+// decompressor can be reset to decompress another stream with the same
+// ones.
+type decompressorOptions struct {
+	compression Compression
+	dictionary  *Dictionary
+	maxWindow   int
+	maxMemory   uint64
+	lowMemory   bool
+}
+
+func (opn *Openable) decompressorOptions() (decompressorOptions, error) {
+	dopts := decompressorOptions{
+		compression: opn.hdr.compression,
+		maxWindow:   opn.MaxWindowSize,
+		maxMemory:   opn.MaxDecoderMemory,
+		lowMemory:   opn.LowMemory,
+	}
+	if opn.hdr.dictID != 0 {
+		dopts.dictionary = opn.dictionary(opn.hdr.dictID)
+		if dopts.dictionary == nil {
+			return dopts, ErrNoDictionary
+		}
+	}
+	if opn.hdr.compression == CompressionZstdChunks {
+		// a chunk never decompresses to more than chunkSize
+		limit := uint64(max(opn.chunkSize, zstd.MinWindowSize))
+		if dopts.maxMemory == 0 || dopts.maxMemory > limit {
+			dopts.maxMemory = limit
+		}
+	}
+	return dopts, nil
+}
+
+func (dopts *decompressorOptions) zstdOptions() []zstd.DOption {
+	var zopts []zstd.DOption
+	if dopts.dictionary != nil {
+		zopts = append(zopts, dopts.dictionary.decoderOption())
+	}
+	if dopts.maxWindow != 0 {
+		zopts = append(zopts, zstd.WithDecoderMaxWindow(uint64(dopts.maxWindow)))
+	}
+	if dopts.maxMemory != 0 {
+		zopts = append(zopts, zstd.WithDecoderMaxMemory(dopts.maxMemory))
+	}
+	if dopts.lowMemory {
+		zopts = append(zopts, zstd.WithDecoderLowmem(true))
+	}
+	return zopts
+}
+
+// newWriter returns a compressor writing to enc, or for the stream codecs,
+// to out, which writes to enc in turn.
+func (c Compression) newWriter(enc *encryptor, out io.Writer, opt *SealOptions, zopts []zstd.EOption) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{enc}, nil
+	case CompressionBrotli:
+		level := opt.BrotliLevel
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(out, level), nil
+	case CompressionZstdChunks:
+		return newChunkWriter(enc, zopts)
+	default:
+		return zstd.NewWriter(out, zopts...)
+	}
+}
+
+func (c Compression) newReader(dec *decryptor, zopts []zstd.DOption) (io.Reader, error) {
+	switch c {
+	case CompressionNone:
+		return dec, nil
+	case CompressionBrotli:
+		return brotli.NewReader(dec), nil
+	case CompressionZstdChunks:
+		return newChunkReader(dec, zopts)
+	default:
+		return zstd.NewReader(dec, append([]zstd.DOption{zstd.WithDecoderConcurrency(1)}, zopts...)...)
+	}
+}
+
+// compressorOptions are the options a compressor is created with. A
+// compressor can be reset to compress another stream with the same ones.
+type compressorOptions struct {
+	compression Compression
+	zstdLevel   int
+	zstdWindow  int
+	longRange   bool
+	concurrency int
+	literals    ZstdLiterals
+	lowMemory   bool
+	dictionary  *Dictionary
+	brotliLevel int
+	chunkSize   int
+}
+
+func (opt *SealOptions) compressorOptions() compressorOptions {
+	return compressorOptions{
+		compression: opt.Compression,
+		zstdLevel:   opt.ZstdLevel,
+		zstdWindow:  opt.ZstdWindowSize,
+		longRange:   opt.ZstdLongRange,
+		concurrency: opt.ZstdConcurrency,
+		literals:    opt.ZstdLiterals,
+		lowMemory:   opt.ZstdLowMemory,
+		dictionary:  opt.Dictionary,
+		brotliLevel: opt.BrotliLevel,
+		chunkSize:   opt.ChunkSize,
+	}
+}
+
+// resetWriter makes a compressor returned by newWriter write a new stream
+// to enc or out.
+func resetWriter(w io.WriteCloser, enc *encryptor, out io.Writer) {
+	switch w := w.(type) {
+	case *brotli.Writer:
+		w.Reset(out)
+	case *chunkWriter:
+		w.enc = enc
+		w.block = w.block[:0]
+		w.pending = w.pending[:0]
+		w.hasPending = false
+	case *zstd.Encoder:
+		w.Reset(out)
+	}
+}
+
+// resetReader makes a decompressor returned by newReader read a new stream
+// from dec.
+func resetReader(r io.Reader, dec *decryptor) error {
+	switch r := r.(type) {
+	case *brotli.Reader:
+		return r.Reset(dec)
+	case *chunkReader:
+		r.dec = dec
+		r.out = nil
+		r.buf = r.buf[:0]
+		r.next = false
+	case *zstd.Decoder:
+		return r.Reset(dec)
+	}
+	return nil
+}
+
+// closeReader releases the decompressor, wiping what it can of the
+// plaintext it holds.
+func closeReader(r io.Reader) {
+	switch r := r.(type) {
+	case *chunkReader:
+		r.zstd.Close()
+		clear(r.buf[:cap(r.buf)])
+	case *zstd.Decoder:
+		r.Close()
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// chunkWriter compresses each chunk separately. The encoded chunk is held
+// back in pending until it's known whether it's the final one.
+type chunkWriter struct {
+	enc        *encryptor
+	zstd       *zstd.Encoder
+	block      []byte
+	pending    []byte
+	hasPending bool
+}
+
+func newChunkWriter(enc *encryptor, zopts []zstd.EOption) (*chunkWriter, error) {
+	zopts = append([]zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithSingleSegment(true)}, zopts...)
+	z, err := zstd.NewWriter(nil, zopts...)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{
+		enc:     enc,
+		zstd:    z,
+		block:   make([]byte, 0, enc.chunkSize-1),
+		pending: make([]byte, 0, enc.chunkSize),
+	}, nil
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), cap(w.block)-len(w.block))
+		w.block = append(w.block, p[:k]...)
+		p = p[k:]
+		if len(w.block) == cap(w.block) {
+			err := w.next()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) Close() error {
+	if len(w.block) > 0 || !w.hasPending {
+		err := w.next()
+		if err != nil {
+			return err
+		}
+	}
+	// Writer.Close flushes the final chunk
+	w.enc.buf = append(w.enc.buf[:0], w.pending...)
+	return nil
+}
+
+// next writes out the pending chunk and encodes the current block as the
+// new pending chunk.
+func (w *chunkWriter) next() error {
+	if w.hasPending {
+		err := w.enc.flush(w.pending, false)
+		if err != nil {
+			return err
+		}
+	}
+	w.pending = w.zstd.EncodeAll(w.block, append(w.pending[:0], chunkCompressed))
+	if len(w.pending)-1 >= len(w.block) {
+		w.pending = append(append(w.pending[:0], chunkStored), w.block...)
+	}
+	w.hasPending = true
+	w.block = w.block[:0]
+	return nil
+}
+
+// decodeChunk appends the data of a decrypted CompressionZstdChunks chunk
+// to dst. Chunks are compressed independently, so they can be decoded in
+// any order, given a decoder each if in parallel.
+func decodeChunk(z *zstd.Decoder, dst, c []byte, chunkSize int) ([]byte, error) {
+	if len(c) == 0 {
+		return nil, errors.New("empty chunk")
+	}
+	switch c[0] {
+	case chunkStored:
+		return append(dst, c[1:]...), nil
+	case chunkCompressed:
+		out, err := z.DecodeAll(c[1:], dst)
+		if err == nil && len(out)-len(dst) >= chunkSize {
+			err = zstd.ErrDecoderSizeExceeded
+		}
+		return out, err
+	default:
+		return nil, fmt.Errorf("unknown chunk flag %d", c[0])
+	}
+}
+
+// chunkReader decompresses each chunk separately. The first chunk has
+// already been read into dec.buf by the time the reader is created.
+type chunkReader struct {
+	dec  *decryptor
+	zstd *zstd.Decoder
+	out  []byte
+	buf  []byte
+	next bool
+}
+
+func newChunkReader(dec *decryptor, zopts []zstd.DOption) (*chunkReader, error) {
+	zopts = append([]zstd.DOption{zstd.WithDecoderConcurrency(1)}, zopts...)
+	z, err := zstd.NewReader(nil, zopts...)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{dec: dec, zstd: z}, nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.next {
+			err := r.dec.read(nil)
+			if err != nil {
+				return 0, err
+			}
+		}
+		r.next = true
+		if r.dec.eof && r.dec.trailerSize != 0 {
+			return 0, io.EOF
+		}
+		var err error
+		r.buf, err = decodeChunk(r.zstd, r.buf[:0], r.dec.buf, r.dec.chunkSize)
+		r.dec.buf = nil
+		if err != nil {
+			return 0, chunkError(fmt.Errorf("%w: %w", ErrCorrupted, err), int64(r.dec.chunkIndex-1), r.dec.lastPos)
+		}
+		r.out = r.buf
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
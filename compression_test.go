@@ -0,0 +1,292 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_compressionNone(t *testing.T) {
+	key := generateKey()
+	const chunkSize = 100
+	for _, original := range [][]byte{
+		nil,
+		bytes.Repeat([]byte("compressible "), 100),
+		randomBytes(t, 1000),
+	} {
+		sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ChunkSize: chunkSize, Compression: sealer.CompressionNone}, original)
+		empty := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ChunkSize: chunkSize, Compression: sealer.CompressionNone}, nil)
+		chunks := max(1, (len(original)+chunkSize-1)/chunkSize)
+		if e := len(empty) + len(original) + (chunks-1)*(4+4+16); len(sealed) != e {
+			t.Errorf("%d bytes sealed to %d, wanted %d", len(original), len(sealed), e)
+		}
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+}
+
+func TestSealer_compressionZstdChunks(t *testing.T) {
+	key := generateKey()
+	master := generateKeyWithID("master")
+	const chunkSize = 1000
+	random := randomBytes(t, 5000)
+	text := bytes.Repeat([]byte("compressible "), 1000)
+	for _, original := range [][]byte{
+		nil,
+		text,
+		random,
+		slices.Concat(text, random, text),
+	} {
+		opt := sealer.SealOptions{ChunkSize: chunkSize, Compression: sealer.CompressionZstdChunks}
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+		opened, err := openSealed(sealed, nil, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, original) {
+			t.Fatalf("got %d bytes, wanted %d", len(opened), len(original))
+		}
+
+		// random data is stored, costing one flag byte per chunk
+		chunks := len(original)/(chunkSize-1) + 1
+		if body, limit := bodySize(t, sealed, nil), len(original)+chunks*(1+4+4+16); body > limit {
+			t.Errorf("%d bytes sealed to a body of %d, wanted at most %d", len(original), body, limit)
+		}
+		if bytes.HasPrefix(original, text) && bodySize(t, sealed, nil) > len(random)+len(original)/10 {
+			t.Errorf("%d bytes sealed to a body of %d, compressible part wasn't compressed", len(original), bodySize(t, sealed, nil))
+		}
+
+		// re-encrypting the chunks under a master key retains their boundaries
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = opn.Reseal(&buf, key, nil, sealer.SealOptions{MasterKey: master})
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err = openSealed(buf.Bytes(), nil, master)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, original) {
+			t.Fatalf("resealed: got %d bytes, wanted %d", len(opened), len(original))
+		}
+	}
+
+	_, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionZstdChunks, Padding: sealer.PaddingPadme})
+	if err == nil {
+		t.Errorf("sealed with both CompressionZstdChunks and padding")
+	}
+}
+
+func TestSealer_zstdWindowSize(t *testing.T) {
+	key := generateKey()
+	block := randomBytes(t, 256*1024)
+	original := slices.Concat(block, block)
+
+	// the repetition is out of reach of a small window
+	small := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ZstdWindowSize: 64 * 1024}, original)
+	large := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ZstdLongRange: true}, original)
+	if len(small) < len(original) || len(large) > len(block)+len(block)/10 {
+		t.Errorf("sealed to %d bytes with a small window, %d with a large one", len(small), len(large))
+	}
+
+	for _, tc := range []struct {
+		maxWindowSize int
+		ok            bool
+	}{
+		{0, true},
+		{sealer.DefaultLongRangeWindowSize, true},
+		{1 << 20, false},
+	} {
+		opn, err := sealer.Prepare(bytes.NewReader(large), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.MaxWindowSize = tc.maxWindowSize
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("MaxWindowSize %d: opened a file with a larger window", tc.maxWindowSize)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("MaxWindowSize %d: %v", tc.maxWindowSize, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("MaxWindowSize %d: got %d bytes, wanted %d", tc.maxWindowSize, len(actual), len(original))
+		}
+	}
+
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{ZstdWindowSize: 1000}); err == nil {
+		t.Errorf("sealed with an invalid window size")
+	}
+}
+
+func TestSealer_zstdTuning(t *testing.T) {
+	key := generateKey()
+	// short repetitions only, and few distinct bytes
+	original := randomBytes(t, 300000)
+	for i := range original {
+		original[i] = 'a' + original[i]%4
+	}
+	sizes := make(map[sealer.ZstdLiterals]int)
+	for _, opt := range []sealer.SealOptions{
+		{ZstdConcurrency: 1},
+		{ZstdConcurrency: 4, ZstdLowMemory: true},
+		{ZstdLiterals: sealer.ZstdLiteralsEntropy},
+		{ZstdLiterals: sealer.ZstdLiteralsSkipUnmatched, ZstdLevel: 1},
+		{ZstdLiterals: sealer.ZstdLiteralsRaw},
+		{Compression: sealer.CompressionZstdChunks, ZstdConcurrency: 4, ZstdLiterals: sealer.ZstdLiteralsEntropy, ZstdLowMemory: true},
+	} {
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+		actual, err := io.ReadAll(openReader(t, sealed, key))
+		if err != nil {
+			t.Fatalf("%+v: %v", opt, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("%+v: got %d bytes, wanted %d", opt, len(actual), len(original))
+		}
+		if opt.Compression == sealer.CompressionZstd {
+			sizes[opt.ZstdLiterals] = len(sealed)
+		}
+	}
+	if sizes[sealer.ZstdLiteralsEntropy] >= sizes[sealer.ZstdLiteralsRaw] {
+		t.Errorf("sealed to %d bytes with entropy-coded literals, %d with raw ones", sizes[sealer.ZstdLiteralsEntropy], sizes[sealer.ZstdLiteralsRaw])
+	}
+}
+
+func TestOpenable_openOptions(t *testing.T) {
+	key := generateKey()
+	original := bytes.Repeat([]byte("compressible "), 100000)
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ZstdWindowSize: 4 << 20}, original)
+
+	for _, tc := range []struct {
+		opts sealer.OpenOptions
+		ok   bool
+	}{
+		{sealer.OpenOptions{LowMemory: true}, true},
+		{sealer.OpenOptions{MaxDecoderMemory: 8 << 20, LowMemory: true}, true},
+		{sealer.OpenOptions{MaxDecoderMemory: 1 << 20}, false},
+		{sealer.OpenOptions{MaxWindowSize: 1 << 20}, false},
+	} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.OpenOptions = tc.opts
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%+v: opened a file with a larger window", tc.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.opts, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("%+v: got %d bytes, wanted %d", tc.opts, len(actual), len(original))
+		}
+	}
+}
+
+func TestOpenable_maxPlaintextSize(t *testing.T) {
+	key := generateKey()
+	original := bytes.Repeat([]byte("compressible "), 1000)
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, original)
+
+	for _, limit := range []int64{0, int64(len(original)), int64(len(original)) + 1, int64(len(original)) - 1, 1} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.MaxPlaintextSize = limit
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if limit != 0 && limit < int64(len(original)) {
+			if !errors.Is(err, sealer.ErrPlaintextTooLarge) {
+				t.Errorf("limit %d: got %v, wanted ErrPlaintextTooLarge", limit, err)
+			}
+			if int64(len(actual)) != limit {
+				t.Errorf("limit %d: read %d bytes", limit, len(actual))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("limit %d: got %d bytes, wanted %d", limit, len(actual), len(original))
+		}
+	}
+}
+
+func TestSealer_compressionBrotli(t *testing.T) {
+	key := generateKey()
+	original := bytes.Repeat([]byte("<p>compressible</p>\n"), 1000)
+	for _, level := range []int{0, 1, 11} {
+		opt := sealer.SealOptions{Compression: sealer.CompressionBrotli, BrotliLevel: level}
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+		if len(sealed) > len(original)/10 {
+			t.Errorf("level %d: %d bytes sealed to %d", level, len(original), len(sealed))
+		}
+		actual, err := openSealed(sealed, nil, key)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("level %d: got %d bytes, wanted %d", level, len(actual), len(original))
+		}
+	}
+
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionBrotli, BrotliLevel: 12},
+		{Compression: sealer.CompressionBrotli, Dictionary: &sealer.Dictionary{ID: 1, Data: original}},
+	} {
+		if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, opt); err == nil {
+			t.Errorf("sealed with %+v", opt)
+		}
+	}
+}
+
+func randomBytes(t testing.TB, n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_context(t *testing.T) {
+	key := generateKey()
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{Context: "tenant-42/invoices"}, original)
+	plain := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, original)
+
+	open := func(sealed []byte, context string) ([]byte, error) {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			return nil, err
+		}
+		opn.Context = context
+		r, err := opn.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	actual, err := open(sealed, "tenant-42/invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("got %q, wanted %q", actual, original)
+	}
+
+	for _, context := range []string{"", "tenant-43/invoices", "tenant-42/invoices "} {
+		if _, err := open(sealed, context); err == nil {
+			t.Errorf("opened with context %q", context)
+		}
+	}
+	if _, err := open(plain, "tenant-42/invoices"); err != sealer.ErrContextMismatch {
+		t.Errorf("opening a file without context: err = %v, wanted ErrContextMismatch", err)
+	}
+
+	// resealing keeps the context
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = opn.Reseal(&buf, key, []*sealer.Key{key}, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(buf.Bytes(), ""); err == nil {
+		t.Errorf("opened resealed file without context")
+	}
+	actual, err = open(buf.Bytes(), "tenant-42/invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("resealed: got %q, wanted %q", actual, original)
+	}
+}
@@ -0,0 +1,83 @@
+package sealer
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrNoDictionary is returned when opening a file compressed with a zstd
+// dictionary that is not in Openable.Dictionaries.
+var ErrNoDictionary = errors.New("sealed file needs a zstd dictionary that has not been provided")
+
+// Dictionary is a zstd dictionary. Compressing many small similar records
+// (say, JSON documents of the same schema) with a dictionary trained on
+// samples of them gives a much better ratio than compressing each on its
+// own, since the records no longer have to carry their own vocabulary.
+//
+// The ID of the dictionary is stored in the header, so that the opener can
+// pick the matching one; the dictionary itself is not. Assign a new ID to
+// every dictionary you train, and keep the old ones around for as long as
+// files compressed with them exist.
+type Dictionary struct {
+	// ID identifies the dictionary and cannot be zero.
+	ID uint32
+
+	// Data is either a dictionary in the zstd format, as produced by
+	// `zstd --train` or zstd.BuildDict, whose own ID must match ID, or
+	// raw content, i.e. a sample of typical data.
+	Data []byte
+}
+
+var errInvalidDictionary = errors.New("invalid zstd dictionary")
+
+// zstdDictMagic starts dictionaries in the zstd format.
+var zstdDictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// NewDictionary returns a dictionary in the zstd format, taking its ID from
+// the data.
+func NewDictionary(data []byte) (*Dictionary, error) {
+	info, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return nil, err
+	}
+	if info.ID() == 0 {
+		return nil, errInvalidDictionary
+	}
+	return &Dictionary{ID: info.ID(), Data: data}, nil
+}
+
+func (d *Dictionary) formatted() bool {
+	return bytes.HasPrefix(d.Data, zstdDictMagic)
+}
+
+func (d *Dictionary) validate() error {
+	if d.ID == 0 {
+		return errInvalidDictionary
+	}
+	if d.formatted() {
+		info, err := zstd.InspectDictionary(d.Data)
+		if err != nil {
+			return err
+		}
+		if info.ID() != d.ID {
+			return errInvalidDictionary
+		}
+	}
+	return nil
+}
+
+func (d *Dictionary) encoderOption() zstd.EOption {
+	if d.formatted() {
+		return zstd.WithEncoderDict(d.Data)
+	}
+	return zstd.WithEncoderDictRaw(d.ID, d.Data)
+}
+
+func (d *Dictionary) decoderOption() zstd.DOption {
+	if d.formatted() {
+		return zstd.WithDecoderDicts(d.Data)
+	}
+	return zstd.WithDecoderDictRaw(d.ID, d.Data)
+}
@@ -0,0 +1,64 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_dictionary(t *testing.T) {
+	key := generateKey()
+	record := func(i int) []byte {
+		return fmt.Appendf(nil, `{"id":%d,"type":"invoice","customer":{"name":"Customer %d","country":"NL"},"status":"paid","currency":"EUR"}`, i, i*7)
+	}
+	var sample []byte
+	for i := range 20 {
+		sample = append(sample, record(1000+i)...)
+	}
+	dict := &sealer.Dictionary{ID: 42, Data: sample}
+	original := record(1)
+
+	for _, compression := range []sealer.Compression{sealer.CompressionZstd, sealer.CompressionZstdChunks} {
+		plain := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: compression, CommitKey: true}, original)
+		sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: compression, Dictionary: dict}, original)
+		if len(sealed) >= len(plain)-len(original)/2 {
+			t.Errorf("compression %d: sealed to %d bytes with a dictionary, %d without", compression, len(sealed), len(plain))
+		}
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opn.DictionaryID != dict.ID {
+			t.Errorf("DictionaryID = %d, wanted %d", opn.DictionaryID, dict.ID)
+		}
+		opn.Dictionaries = []*sealer.Dictionary{{ID: 43, Data: sample}}
+		if _, err := opn.Open(key); !errors.Is(err, sealer.ErrNoDictionary) {
+			t.Errorf("opened with a wrong dictionary: %v", err)
+		}
+
+		opn.Dictionaries = append(opn.Dictionaries, dict)
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	if _, err := sealer.NewDictionary(sample); err == nil {
+		t.Errorf("raw content accepted as a zstd format dictionary")
+	}
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Dictionary: &sealer.Dictionary{Data: sample}}); err == nil {
+		t.Errorf("sealed with dictionary ID 0")
+	}
+}
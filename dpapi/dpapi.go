@@ -0,0 +1,86 @@
+// Package dpapi protects sealer file keys with the Windows Data Protection
+// API, tying them to the current Windows user account or to the machine.
+//
+// A file sealed in CurrentUser scope can only be opened by the same user
+// (including on other machines if the user has a roaming profile), and one
+// sealed in LocalMachine scope by any process on the same machine. On other
+// platforms, encapsulation and decapsulation fail with ErrUnsupported.
+package dpapi
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andreyvit/sealer"
+)
+
+// ErrUnsupported is returned on platforms other than Windows.
+var ErrUnsupported = errors.New("dpapi: only supported on Windows")
+
+// Scope determines who can unprotect the data.
+type Scope uint8
+
+const (
+	CurrentUser  Scope = 0
+	LocalMachine Scope = 1
+)
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// DPAPI.
+type Wrapper struct {
+	name    string
+	scope   Scope
+	entropy []byte
+}
+
+// New returns a Wrapper protecting file keys in the given scope. The name
+// only determines the key ID, letting an app tell its own DPAPI slots apart
+// from other apps'. Entropy is optional secondary secret that must be
+// supplied again to open the file; it is not stored in the header.
+func New(name string, scope Scope, entropy []byte) *Wrapper {
+	return &Wrapper{name: name, scope: scope, entropy: entropy}
+}
+
+// KeyID returns the sealer key ID used for slots of the given name and
+// scope.
+func KeyID(name string, scope Scope) [sealer.IDSize]byte {
+	return sha256.Sum256(fmt.Appendf(nil, "dpapi:%d:%s", scope, name))
+}
+
+// DPAPI slot data:
+//  - scope  uint8
+//  - blob   (rest, output of CryptProtectData)
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	blob, err := protect(fileKey, w.entropy, w.scope == LocalMachine)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("dpapi: %w", err)
+	}
+	return sealer.Slot{
+		Type:  sealer.SlotTypeDPAPI,
+		KeyID: KeyID(w.name, w.scope),
+		Data:  append([]byte{byte(w.scope)}, blob...),
+	}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter. DPAPI wraps data with
+// symmetric keys derived from the user's or machine's master key.
+func (w *Wrapper) PostQuantum() bool {
+	return true
+}
+
+// Decapsulate implements sealer.Decapsulator for DPAPI slots with the same
+// name and scope.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	if s.Type != sealer.SlotTypeDPAPI || s.KeyID != KeyID(w.name, w.scope) || len(s.Data) < 1 || Scope(s.Data[0]) != w.scope {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := unprotect(s.Data[1:], w.entropy)
+	if err != nil {
+		return nil, fmt.Errorf("dpapi: %w", err)
+	}
+	return fileKey, nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package dpapi
+
+func protect(data, entropy []byte, machine bool) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func unprotect(blob, entropy []byte) ([]byte, error) {
+	return nil, ErrUnsupported
+}
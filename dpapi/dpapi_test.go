@@ -0,0 +1,61 @@
+package dpapi_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/dpapi"
+)
+
+func TestWrapper(t *testing.T) {
+	w := dpapi.New("example", dpapi.CurrentUser, []byte("entropy"))
+
+	var buf bytes.Buffer
+	sw, err := sealer.Seal(&buf, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{w}})
+	if runtime.GOOS != "windows" {
+		if !errors.Is(err, dpapi.ErrUnsupported) {
+			t.Fatalf("Seal = %v, wanted ErrUnsupported", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(sw, "hello, world")
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.Bytes()
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := opn.KeyID, dpapi.KeyID("example", dpapi.CurrentUser); a != e {
+		t.Errorf("KeyID = %x, wanted %x", a, e)
+	}
+	r, err := opn.OpenWith(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "hello, world"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+
+	_, err = opn.OpenWith(dpapi.New("example", dpapi.CurrentUser, []byte("wrong")))
+	if err == nil {
+		t.Errorf("OpenWith succeeded with wrong entropy")
+	}
+	_, err = opn.OpenWith(dpapi.New("other", dpapi.CurrentUser, nil))
+	if err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenWith(other) = %v, wanted ErrNoMatchingKey", err)
+	}
+}
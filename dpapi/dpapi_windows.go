@@ -0,0 +1,73 @@
+//go:build windows
+
+package dpapi
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+const (
+	cryptprotectUIForbidden  = 0x1
+	cryptprotectLocalMachine = 0x4
+)
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// bytes copies the blob out of LocalAlloc'ed memory and frees it.
+func (b *dataBlob) bytes() []byte {
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(b.data)))
+	mem := unsafe.Slice(b.data, b.size)
+	out := make([]byte, len(mem))
+	copy(out, mem)
+	clear(mem)
+	return out
+}
+
+func protect(data, entropy []byte, machine bool) ([]byte, error) {
+	flags := uintptr(cryptprotectUIForbidden)
+	if machine {
+		flags |= cryptprotectLocalMachine
+	}
+	var out dataBlob
+	var entropyBlob uintptr
+	if len(entropy) > 0 {
+		entropyBlob = uintptr(unsafe.Pointer(newBlob(entropy)))
+	}
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newBlob(data))), 0, entropyBlob, 0, 0, flags, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+func unprotect(blob, entropy []byte) ([]byte, error) {
+	var out dataBlob
+	var entropyBlob uintptr
+	if len(entropy) > 0 {
+		entropyBlob = uintptr(unsafe.Pointer(newBlob(entropy)))
+	}
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newBlob(blob))), 0, entropyBlob, 0, 0, cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
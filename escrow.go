@@ -0,0 +1,57 @@
+package sealer
+
+import "io"
+
+// Escrow slots hold the file key wrapped to an organizational recovery key
+// (see SealOptions.Escrow). Their data is the same as of HPKE slots, but
+// they have a separate type, so that the regular ways of opening a file
+// never touch them and recovery is always a deliberate act.
+
+type escrowEncapsulator struct {
+	r *Recipient
+}
+
+func (e escrowEncapsulator) Encapsulate(fileKey []byte, random io.Reader) (Slot, error) {
+	s, err := e.r.Encapsulate(fileKey, random)
+	s.Type = SlotTypeEscrow
+	return s, err
+}
+
+func (e escrowEncapsulator) PostQuantum() bool {
+	return e.r.PostQuantum()
+}
+
+func (e escrowEncapsulator) SlotDataSize() int {
+	return e.r.SlotDataSize()
+}
+
+// EscrowKeyIDs returns the IDs of the escrow keys the file has been sealed
+// to, which can be used to check that a file is recoverable.
+func (opn *Openable) EscrowKeyIDs() [][IDSize]byte {
+	var ids [][IDSize]byte
+	for _, s := range opn.hdr.slots {
+		if s.Type == SlotTypeEscrow {
+			ids = append(ids, s.KeyID)
+		}
+	}
+	return ids
+}
+
+// OpenEscrow opens the file using the escrow slot matching id.ID, for
+// incident recovery.
+func (opn *Openable) OpenEscrow(id *Identity) (*Reader, error) {
+	err := ErrNoMatchingKey
+	for _, s := range opn.hdr.slots {
+		if s.Type != SlotTypeEscrow || s.KeyID != id.ID {
+			continue
+		}
+		s.Type = SlotTypeHPKE
+		fileKey, derr := id.Decapsulate(s)
+		if derr != nil {
+			err = derr
+			continue
+		}
+		return opn.open(fileKey)
+	}
+	return nil, err
+}
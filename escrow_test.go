@@ -0,0 +1,63 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestEscrow(t *testing.T) {
+	key := generateKey()
+	escrow, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{
+		Escrow: []*sealer.Recipient{escrow.Recipient()},
+	}, original)
+
+	prepare := func() *sealer.Openable {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return opn
+	}
+
+	ids := prepare().EscrowKeyIDs()
+	if len(ids) != 1 || ids[0] != escrow.ID {
+		t.Errorf("EscrowKeyIDs = %x, wanted [%x]", ids, escrow.ID)
+	}
+
+	// the regular key still works, and the escrow key only works via
+	// OpenEscrow
+	for _, open := range []func(opn *sealer.Openable) (*sealer.Reader, error){
+		func(opn *sealer.Openable) (*sealer.Reader, error) { return opn.Open(key) },
+		func(opn *sealer.Openable) (*sealer.Reader, error) { return opn.OpenEscrow(escrow) },
+	} {
+		r, err := open(prepare())
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+	}
+	if _, err := prepare().OpenIdentity(escrow); err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenIdentity(escrow) = %v, wanted ErrNoMatchingKey", err)
+	}
+
+	// escrow alone is not enough to seal
+	var buf bytes.Buffer
+	_, err = sealer.Seal(&buf, nil, nil, sealer.SealOptions{Escrow: []*sealer.Recipient{escrow.Recipient()}})
+	if err != sealer.ErrNoKeys {
+		t.Errorf("Seal(escrow only) = %v, wanted ErrNoKeys", err)
+	}
+}
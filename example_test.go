@@ -31,7 +31,7 @@ func Example() {
 	var sealed bytes.Buffer
 	var expectedData bytes.Buffer
 	{ // Sealing
-		w, err := sealer.Seal(&sealed, key, prefix, sealer.SealOptions{})
+		w, err := sealer.Seal(&sealed, []*sealer.Key{key}, prefix, sealer.SealOptions{})
 		if err != nil {
 			panic(err)
 		}
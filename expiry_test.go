@@ -0,0 +1,77 @@
+package sealer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_notAfter(t *testing.T) {
+	key := generateKey()
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{NotAfter: notAfter}, original)
+
+	open := func(sealed []byte, now time.Time) ([]byte, error) {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			return nil, err
+		}
+		opn.Clock = func() time.Time { return now }
+		r, err := opn.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opn.NotAfter.Equal(notAfter) {
+		t.Errorf("NotAfter = %v, wanted %v", opn.NotAfter, notAfter)
+	}
+
+	actual, err := open(sealed, notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("got %q, wanted %q", actual, original)
+	}
+	if _, err := open(sealed, notAfter.Add(time.Second)); err != sealer.ErrExpired {
+		t.Errorf("open after expiry: err = %v, wanted ErrExpired", err)
+	}
+
+	// extending the expiry invalidates the header
+	var enc [8]byte
+	binary.LittleEndian.PutUint64(enc[:], uint64(notAfter.Unix()))
+	i := bytes.Index(sealed, enc[:])
+	if i < 0 {
+		t.Fatal("expiry not found in the header")
+	}
+	tampered := bytes.Clone(sealed)
+	binary.LittleEndian.PutUint64(tampered[i:], uint64(notAfter.AddDate(1, 0, 0).Unix()))
+	if _, err := open(tampered, notAfter.Add(time.Second)); err == nil || err == sealer.ErrExpired {
+		t.Errorf("open with extended expiry: err = %v, wanted an authentication error", err)
+	}
+
+	// reseal keeps the expiry
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resealed bytes.Buffer
+	err = opn.Reseal(&resealed, key, []*sealer.Key{key}, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(resealed.Bytes(), notAfter.Add(time.Second)); err != sealer.ErrExpired {
+		t.Errorf("open resealed after expiry: err = %v, wanted ErrExpired", err)
+	}
+}
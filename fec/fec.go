@@ -0,0 +1,343 @@
+// Package fec adds Reed-Solomon forward error correction to sealed files, so
+// that archives on flaky media survive a bounded number of damaged blocks
+// instead of failing authentication outright. Both directions stream.
+//
+// The stream is cut into groups of DataShards blocks of ShardSize bytes,
+// each followed by ParityShards parity blocks. Every block carries a CRC,
+// so damaged blocks are treated as erasures: the Reader recovers any group
+// with at most ParityShards damaged or missing blocks. Damage spanning more
+// than ParityShards blocks of one group is unrecoverable, so larger blocks
+// tolerate longer bursts, and more parity tolerates more scattered damage.
+//
+// Correction happens before the sealed file is opened, so it is applied
+// to the ciphertext and needs no key:
+//
+//	fw, err := fec.NewWriter(file, fec.Options{})
+//	w, err := sealer.Seal(fw, keys, nil, opt)
+//	... // write, then close w and fw
+//
+//	opn, err := sealer.Prepare(fec.NewReader(file), nil)
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	// Magic starts every error-corrected stream.
+	Magic = "SLRFEC\x00\x01"
+
+	DefaultDataShards   = 10
+	DefaultParityShards = 2
+	DefaultShardSize    = 64 * 1024
+
+	// MaxShardSize is the largest ShardSize that Reader accepts.
+	MaxShardSize = 16 * 1024 * 1024
+
+	headerSize = len(Magic) + 1 + 1 + 2 + 4
+
+	// blockInfoSize is the size of the info and the CRC following the data
+	// of every block.
+	blockInfoSize = 4 + 4
+
+	finalBit = 1 << 31
+)
+
+// Stream format:
+//  - magic           [8]byte (Magic)
+//  - dataShards      uint8
+//  - parityShards    uint8
+//  - reserved        uint16
+//  - shardSize       uint32
+//  - groups          [...]group, the last one with the final bit set
+//
+// Group:
+//  - blocks          [dataShards+parityShards]block
+//
+// Block:
+//  - data            [shardSize]byte, zero-padded in the final group
+//  - info            uint32, the number of bytes of data in the group, with
+//                    the high bit set in the final group
+//  - crc             uint32, CRC-32C of the group index (uint64), the block
+//                    index (uint8), data and info
+
+var (
+	// ErrInvalid is returned when reading something that is not an
+	// error-corrected stream.
+	ErrInvalid = errors.New("fec: invalid stream")
+
+	// ErrUnrecoverable is returned when a group has more damaged blocks
+	// than there are parity blocks.
+	ErrUnrecoverable = errors.New("fec: too many damaged blocks")
+
+	// ErrInvalidOptions is returned by NewWriter for invalid Options.
+	ErrInvalidOptions = errors.New("fec: invalid options")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configure the error correction. Zero values select the defaults.
+type Options struct {
+	// DataShards is the number of data blocks per group, 10 by default.
+	DataShards int
+
+	// ParityShards is the number of parity blocks per group, and thus the
+	// number of damaged blocks a group can recover from, 2 by default.
+	ParityShards int
+
+	// ShardSize is the size of the blocks, 64 KiB by default.
+	ShardSize int
+}
+
+func (opt *Options) setDefaults() error {
+	if opt.DataShards == 0 {
+		opt.DataShards = DefaultDataShards
+	}
+	if opt.ParityShards == 0 {
+		opt.ParityShards = DefaultParityShards
+	}
+	if opt.ShardSize == 0 {
+		opt.ShardSize = DefaultShardSize
+	}
+	if opt.DataShards < 1 || opt.ParityShards < 1 || opt.DataShards+opt.ParityShards > 256 {
+		return fmt.Errorf("%w: %d data and %d parity shards", ErrInvalidOptions, opt.DataShards, opt.ParityShards)
+	}
+	if opt.ShardSize < 1 || opt.ShardSize > MaxShardSize {
+		return fmt.Errorf("%w: shard size %d", ErrInvalidOptions, opt.ShardSize)
+	}
+	return nil
+}
+
+// Writer adds error correction to the data written to it.
+type Writer struct {
+	w      io.Writer
+	opt    Options
+	enc    reedsolomon.Encoder
+	shards [][]byte
+	buf    []byte
+	n      int
+	group  uint64
+	err    error
+}
+
+// NewWriter returns a Writer that writes the data written to it to w with
+// error correction, writing the header right away. Close writes the final
+// group, and does not close w.
+func NewWriter(w io.Writer, opt Options) (*Writer, error) {
+	err := opt.setDefaults()
+	if err != nil {
+		return nil, err
+	}
+	enc, err := reedsolomon.New(opt.DataShards, opt.ParityShards)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+
+	total := opt.DataShards + opt.ParityShards
+	buf := make([]byte, total*(opt.ShardSize+blockInfoSize))
+	shards := make([][]byte, total)
+	for i := range shards {
+		off := i * (opt.ShardSize + blockInfoSize)
+		shards[i] = buf[off : off+opt.ShardSize]
+	}
+	fw := &Writer{w: w, opt: opt, enc: enc, shards: shards, buf: buf}
+
+	var hdr [headerSize]byte
+	copy(hdr[:], Magic)
+	hdr[len(Magic)] = byte(opt.DataShards)
+	hdr[len(Magic)+1] = byte(opt.ParityShards)
+	binary.LittleEndian.PutUint32(hdr[len(Magic)+4:], uint32(opt.ShardSize))
+	_, fw.err = w.Write(hdr[:])
+	return fw, fw.err
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 && w.err == nil {
+		c := w.copyIn(p)
+		written += c
+		p = p[c:]
+		if w.n == w.opt.DataShards*w.opt.ShardSize {
+			w.err = w.flush(false)
+		}
+	}
+	return written, w.err
+}
+
+// copyIn copies data into the data shards of the current group.
+func (w *Writer) copyIn(p []byte) int {
+	i, off := w.n/w.opt.ShardSize, w.n%w.opt.ShardSize
+	c := copy(w.shards[i][off:], p)
+	w.n += c
+	return c
+}
+
+// Close writes the final group, which may be empty.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flush(true)
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("fec: write after Close")
+	return nil
+}
+
+func (w *Writer) flush(final bool) error {
+	dataLen := w.n
+	for i := w.n / w.opt.ShardSize; i < w.opt.DataShards; i++ {
+		clear(w.shards[i][w.n%w.opt.ShardSize:])
+		w.n = (i + 1) * w.opt.ShardSize
+	}
+	err := w.enc.Encode(w.shards)
+	if err != nil {
+		return err
+	}
+	info := uint32(dataLen)
+	if final {
+		info |= finalBit
+	}
+	for i, shard := range w.shards {
+		block := shard[:w.opt.ShardSize+blockInfoSize]
+		binary.LittleEndian.PutUint32(block[w.opt.ShardSize:], info)
+		binary.LittleEndian.PutUint32(block[w.opt.ShardSize+4:], blockCRC(w.group, i, block[:w.opt.ShardSize+4]))
+	}
+	_, err = w.w.Write(w.buf)
+	w.n = 0
+	w.group++
+	return err
+}
+
+func blockCRC(group uint64, index int, block []byte) uint32 {
+	var prefix [9]byte
+	binary.LittleEndian.PutUint64(prefix[:], group)
+	prefix[8] = byte(index)
+	crc := crc32.Update(0, crcTable, prefix[:])
+	return crc32.Update(crc, crcTable, block)
+}
+
+// Reader reads an error-corrected stream, repairing damaged blocks.
+type Reader struct {
+	r      io.Reader
+	opt    Options
+	enc    reedsolomon.Encoder
+	buf    []byte
+	shards [][]byte
+	out    []byte
+	group  uint64
+	final  bool
+	err    error
+
+	// Repaired is the number of damaged or missing blocks repaired so far.
+	Repaired int
+}
+
+// NewReader returns a Reader of the data in the error-corrected stream read
+// from r. The header is read by the first Read.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 && r.err == nil {
+		if r.enc == nil {
+			r.err = r.readHeader()
+		} else if r.final {
+			r.err = io.EOF
+		} else {
+			r.err = r.readGroup()
+		}
+	}
+	if len(r.out) > 0 {
+		n := copy(p, r.out)
+		r.out = r.out[n:]
+		return n, nil
+	}
+	return 0, r.err
+}
+
+func (r *Reader) readHeader() error {
+	var hdr [headerSize]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalid
+	} else if err != nil {
+		return err
+	}
+	if string(hdr[:len(Magic)]) != Magic {
+		return ErrInvalid
+	}
+	r.opt = Options{
+		DataShards:   int(hdr[len(Magic)]),
+		ParityShards: int(hdr[len(Magic)+1]),
+		ShardSize:    int(binary.LittleEndian.Uint32(hdr[len(Magic)+4:])),
+	}
+	if r.opt.DataShards == 0 || r.opt.ParityShards == 0 || r.opt.ShardSize == 0 || r.opt.setDefaults() != nil {
+		return ErrInvalid
+	}
+	enc, err := reedsolomon.New(r.opt.DataShards, r.opt.ParityShards)
+	if err != nil {
+		return ErrInvalid
+	}
+	r.enc = enc
+	total := r.opt.DataShards + r.opt.ParityShards
+	r.buf = make([]byte, total*(r.opt.ShardSize+blockInfoSize))
+	r.shards = make([][]byte, total)
+	return nil
+}
+
+func (r *Reader) readGroup() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// blocks cut short by a truncation are missing, like damaged ones
+	blockSize := r.opt.ShardSize + blockInfoSize
+	var info uint32
+	var missing int
+	for i := range r.shards {
+		block := r.buf[i*blockSize : (i+1)*blockSize]
+		if (i+1)*blockSize > n || binary.LittleEndian.Uint32(block[r.opt.ShardSize+4:]) != blockCRC(r.group, i, block[:r.opt.ShardSize+4]) {
+			r.shards[i] = nil
+			missing++
+			continue
+		}
+		r.shards[i] = block[:r.opt.ShardSize]
+		info = binary.LittleEndian.Uint32(block[r.opt.ShardSize:])
+	}
+	if missing > r.opt.ParityShards {
+		return fmt.Errorf("%w: group %d has %d damaged blocks", ErrUnrecoverable, r.group, missing)
+	}
+	if missing > 0 {
+		err := r.enc.ReconstructData(r.shards)
+		if err != nil {
+			return fmt.Errorf("%w: group %d: %w", ErrUnrecoverable, r.group, err)
+		}
+		r.Repaired += missing
+	}
+	r.group++
+
+	r.final = info&finalBit != 0
+	dataLen := int(info &^ finalBit)
+	if dataLen > r.opt.DataShards*r.opt.ShardSize || !r.final && dataLen != r.opt.DataShards*r.opt.ShardSize {
+		return ErrInvalid
+	}
+	r.out = r.out[:0]
+	for i := 0; dataLen > 0; i++ {
+		c := min(dataLen, r.opt.ShardSize)
+		r.out = append(r.out, r.shards[i][:c]...)
+		dataLen -= c
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package fec_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/fec"
+)
+
+var testOptions = fec.Options{DataShards: 4, ParityShards: 2, ShardSize: 16}
+
+const (
+	blockSize = 16 + 8
+	groupSize = 6 * blockSize
+	hdrSize   = 16
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 15, 16, 63, 64, 65, 1000} {
+		original := make([]byte, n)
+		rand.Read(original)
+		encoded := encode(t, original)
+
+		actual, err := io.ReadAll(fec.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("%d bytes: got %x, wanted %x", n, actual, original)
+		}
+	}
+}
+
+func TestReader_repair(t *testing.T) {
+	original := make([]byte, 1000)
+	rand.Read(original)
+	encoded := encode(t, original)
+	groups := (len(encoded) - hdrSize) / groupSize
+
+	// damage two blocks of every group: a data block and a parity one
+	damaged := bytes.Clone(encoded)
+	for g := range groups {
+		damaged[hdrSize+g*groupSize+1*blockSize+3] ^= 1
+		damaged[hdrSize+g*groupSize+5*blockSize+20] ^= 1
+	}
+	r := fec.NewReader(bytes.NewReader(damaged))
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("repaired data doesn't match")
+	}
+	if r.Repaired != 2*groups {
+		t.Errorf("Repaired = %d, wanted %d", r.Repaired, 2*groups)
+	}
+
+	// the parity blocks of the final group can be lost
+	actual, err = io.ReadAll(fec.NewReader(bytes.NewReader(encoded[:len(encoded)-2*blockSize])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("data of a truncated stream doesn't match")
+	}
+
+	// three damaged blocks in a group are too many
+	damaged = bytes.Clone(encoded)
+	for i := range 3 {
+		damaged[hdrSize+groupSize+i*blockSize] ^= 1
+	}
+	_, err = io.ReadAll(fec.NewReader(bytes.NewReader(damaged)))
+	if !errors.Is(err, fec.ErrUnrecoverable) {
+		t.Errorf("err = %v, wanted ErrUnrecoverable", err)
+	}
+
+	// swapped blocks are detected
+	damaged = bytes.Clone(encoded)
+	copy(damaged[hdrSize:], encoded[hdrSize+groupSize:hdrSize+2*groupSize])
+	copy(damaged[hdrSize+groupSize:], encoded[hdrSize:hdrSize+groupSize])
+	_, err = io.ReadAll(fec.NewReader(bytes.NewReader(damaged)))
+	if !errors.Is(err, fec.ErrUnrecoverable) {
+		t.Errorf("err = %v, wanted ErrUnrecoverable for swapped groups", err)
+	}
+}
+
+func TestReader_invalid(t *testing.T) {
+	for _, input := range [][]byte{
+		nil,
+		[]byte("hello, world, this is not it"),
+		[]byte(fec.Magic + "\x00\x02\x00\x00\x10\x00\x00\x00"),
+	} {
+		_, err := io.ReadAll(fec.NewReader(bytes.NewReader(input)))
+		if err != fec.ErrInvalid {
+			t.Errorf("%q: err = %v, wanted ErrInvalid", input, err)
+		}
+	}
+	if _, err := fec.NewWriter(io.Discard, fec.Options{DataShards: 200, ParityShards: 100}); !errors.Is(err, fec.ErrInvalidOptions) {
+		t.Errorf("NewWriter = %v, wanted ErrInvalidOptions", err)
+	}
+}
+
+func TestSealed(t *testing.T) {
+	key := &sealer.Key{ID: [sealer.IDSize]byte{1}}
+	rand.Read(key.Key[:])
+	original := make([]byte, 10000)
+	rand.Read(original)
+
+	var buf bytes.Buffer
+	fw, err := fec.NewWriter(&buf, fec.Options{ShardSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := sealer.Seal(fw, []*sealer.Key{key}, nil, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(original); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	damaged := buf.Bytes()
+	for i := 100; i < 600; i++ {
+		damaged[i] = 0
+	}
+	opn, err := sealer.Prepare(fec.NewReader(bytes.NewReader(damaged)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("got %d bytes, wanted %d", len(actual), len(original))
+	}
+}
+
+func encode(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := fec.NewWriter(&buf, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in odd pieces to exercise grouping
+	for p := data; len(p) > 0; {
+		k := min(len(p), 7)
+		if _, err := w.Write(p[:k]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[k:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
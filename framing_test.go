@@ -0,0 +1,130 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_trailingData(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	original := bytes.Repeat([]byte("hello, world "), 1000)
+	trailer := []byte("TRAILING DATA")
+
+	for _, opt := range []sealer.SealOptions{
+		{ChunkSize: 64},
+		{ChunkSize: 64, RandomNonces: true},
+		{},
+	} {
+		sealed := seal(t, keys, nil, opt, original)
+		in := bytes.NewReader(append(bytes.Clone(sealed), trailer...))
+
+		opn, err := sealer.Prepare(in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.Open(keys[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %d bytes, wanted %d", len(actual), len(original))
+		}
+		rest, err := io.ReadAll(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, trailer) {
+			t.Errorf("left %q unread, wanted %q", rest, trailer)
+		}
+
+		for _, cut := range []int{1, 16, 17} {
+			opn, err := sealer.Prepare(bytes.NewReader(sealed[:len(sealed)-cut]), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := opn.Open(keys[0])
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if err == nil {
+				t.Errorf("opened a file with %d bytes cut off", cut)
+			}
+		}
+	}
+}
+
+func TestOpenOptions_strictEOF(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	original := bytes.Repeat([]byte("hello, world "), 1000)
+	sealed := seal(t, keys, nil, sealer.SealOptions{ChunkSize: 64}, original)
+
+	for _, trailing := range []int{0, 1, 13, 100000} {
+		in := bytes.NewReader(append(bytes.Clone(sealed), make([]byte, trailing)...))
+		opn, err := sealer.PrepareWithOptions(in, nil, sealer.OpenOptions{StrictEOF: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.Open(keys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if trailing == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if !errors.Is(err, sealer.ErrTrailingData) {
+			t.Errorf("%d trailing bytes: err = %v, wanted ErrTrailingData", trailing, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %d bytes, wanted %d", len(actual), len(original))
+		}
+		n, err := r.TrailingBytes()
+		if err != nil || n != int64(trailing) {
+			t.Errorf("TrailingBytes = %d, %v, wanted %d", n, err, trailing)
+		}
+	}
+}
+
+func TestOpenOptions_version(t *testing.T) {
+	key := generateKeyWithID("A")
+	original := []byte("hello, world")
+	legacy := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, original)
+	current := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{CommitKey: true}, original)
+
+	for _, tc := range []struct {
+		sealed  []byte
+		opt     sealer.OpenOptions
+		version int
+		allowed bool
+	}{
+		{legacy, sealer.OpenOptions{}, 0, true},
+		{current, sealer.OpenOptions{}, 1, true},
+		{legacy, sealer.OpenOptions{MinVersion: 1}, 0, false},
+		{current, sealer.OpenOptions{MinVersion: 1}, 1, true},
+		{current, sealer.OpenOptions{MaxVersion: 1}, 1, true},
+		{current, sealer.OpenOptions{MinVersion: 2}, 1, false},
+	} {
+		opn, err := sealer.PrepareWithOptions(bytes.NewReader(tc.sealed), nil, tc.opt)
+		if !tc.allowed {
+			if !errors.Is(err, sealer.ErrVersionNotAllowed) {
+				t.Errorf("v%d with %+v: err = %v, wanted ErrVersionNotAllowed", tc.version, tc.opt, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opn.Version != tc.version {
+			t.Errorf("Version = %d, wanted %d", opn.Version, tc.version)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Package gcpkms wraps sealer file keys using Google Cloud KMS encrypt and
+// decrypt, so that sealing and opening never need local key material.
+//
+// The CryptoKey resource name is stored in the slot, so after sealer.Prepare
+// you can call KeyName on each of Openable.Slots to find out which key is
+// required.
+package gcpkms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+)
+
+// KMS is the subset of Cloud KMS API used by this package. Client implements
+// it, or you can adapt the official Cloud KMS client.
+type KMS interface {
+	Encrypt(ctx context.Context, keyName string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyName string, ciphertext, aad []byte) ([]byte, error)
+}
+
+// aad is passed to Cloud KMS with every request, binding the ciphertexts to
+// this use.
+var aad = []byte("github.com/andreyvit/sealer file key")
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// a Cloud KMS key.
+type Wrapper struct {
+	kms     KMS
+	keyName string
+	ctx     context.Context
+}
+
+// New returns a Wrapper that uses the given CryptoKey, named like
+// projects/*/locations/*/keyRings/*/cryptoKeys/*.
+func New(kms KMS, keyName string) *Wrapper {
+	return &Wrapper{
+		kms:     kms,
+		keyName: keyName,
+		ctx:     context.Background(),
+	}
+}
+
+// WithContext returns a copy of w that uses ctx for KMS requests.
+func (w *Wrapper) WithContext(ctx context.Context) *Wrapper {
+	w2 := *w
+	w2.ctx = ctx
+	return &w2
+}
+
+// KeyName returns the resource name of the CryptoKey.
+func (w *Wrapper) KeyName() string {
+	return w.keyName
+}
+
+// Cloud KMS slot data is the CryptoKey name and the ciphertext returned by
+// Cloud KMS encrypt, packed by slotdata.Pack.
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	ciphertext, err := w.kms.Encrypt(w.ctx, w.keyName, fileKey, aad)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("gcpkms: %w", err)
+	}
+	data := slotdata.Pack(w.keyName, ciphertext)
+	return sealer.Slot{Type: sealer.SlotTypeGCPKMS, KeyID: KeyID(w.keyName), Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter. Cloud KMS only
+// encrypts with symmetric keys (AES-256).
+func (w *Wrapper) PostQuantum() bool {
+	return true
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	name, ciphertext, ok := parseSlot(s)
+	if !ok || name != w.keyName {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.kms.Decrypt(w.ctx, w.keyName, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("gcpkms: %w", err)
+	}
+	return fileKey, nil
+}
+
+// KeyID returns the sealer key ID used for slots wrapped by the given key.
+func KeyID(keyName string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("gcpkms:" + keyName))
+}
+
+// KeyName returns the CryptoKey name required to open the slot, or false if
+// it's not a Cloud KMS slot.
+func KeyName(s sealer.Slot) (string, bool) {
+	name, _, ok := parseSlot(s)
+	return name, ok
+}
+
+func parseSlot(s sealer.Slot) (keyName string, ciphertext []byte, ok bool) {
+	if s.Type != sealer.SlotTypeGCPKMS {
+		return "", nil, false
+	}
+	return slotdata.Unpack(s.Data)
+}
+
+// Default retry settings of Client.
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+)
+
+// Client is a minimal Cloud KMS client speaking the REST API over HTTPS.
+// It retries failed requests that are safe to retry (network errors,
+// HTTP 429 and 5xx) with exponential backoff and jitter, and stops as soon
+// as the context is done.
+type Client struct {
+	// Token returns an OAuth2 access token, e.g. from an
+	// oauth2.TokenSource or the metadata server.
+	Token func(ctx context.Context) (string, error)
+
+	// Endpoint defaults to https://cloudkms.googleapis.com/.
+	Endpoint string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewClient returns a client that authenticates using the given token
+// function.
+func NewClient(token func(ctx context.Context) (string, error)) *Client {
+	return &Client{Token: token}
+}
+
+type encryptRequest struct {
+	Plaintext                   []byte `json:"plaintext"`
+	AdditionalAuthenticatedData []byte `json:"additionalAuthenticatedData,omitempty"`
+}
+
+type encryptResponse struct {
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type decryptRequest struct {
+	Ciphertext                  []byte `json:"ciphertext"`
+	AdditionalAuthenticatedData []byte `json:"additionalAuthenticatedData,omitempty"`
+}
+
+type decryptResponse struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+// Error is an error returned by the Cloud KMS API.
+type Error struct {
+	StatusCode int
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Cloud KMS HTTP %d: %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+func (e *Error) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Encrypt calls the cryptoKeys.encrypt API.
+func (c *Client) Encrypt(ctx context.Context, keyName string, plaintext, aad []byte) ([]byte, error) {
+	var resp encryptResponse
+	err := c.call(ctx, keyName+":encrypt", &encryptRequest{plaintext, aad}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+// Decrypt calls the cryptoKeys.decrypt API.
+func (c *Client) Decrypt(ctx context.Context, keyName string, ciphertext, aad []byte) ([]byte, error) {
+	var resp decryptResponse
+	err := c.call(ctx, keyName+":decrypt", &decryptRequest{ciphertext, aad}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (c *Client) call(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+	backoff := c.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultInitialBackoff
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		var temporary bool
+		temporary, err = c.do(ctx, path, body, out)
+		if err == nil || !temporary || attempt >= attempts {
+			return err
+		}
+
+		// full jitter, see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+		delay := rand.N(backoff) + 1
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string, body []byte, out any) (temporary bool, err error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com/"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	token, err := c.Token(ctx)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var wrapper struct {
+			Error *Error `json:"error"`
+		}
+		wrapper.Error = &Error{}
+		_ = json.Unmarshal(respBody, &wrapper)
+		e := wrapper.Error
+		if e == nil {
+			e = &Error{}
+		}
+		e.StatusCode = resp.StatusCode
+		return e.temporary(), e
+	}
+	return false, json.Unmarshal(respBody, out)
+}
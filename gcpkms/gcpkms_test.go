@@ -0,0 +1,141 @@
+package gcpkms_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/gcpkms"
+)
+
+const testKey = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+func token(ctx context.Context) (string, error) {
+	return "TOKEN", nil
+}
+
+// fakeKMS "encrypts" by reversing the bytes, failing every other request
+// with HTTP 503 to exercise retries.
+func fakeKMS(t *testing.T, failures *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer TOKEN" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if failures.Add(1)%2 == 1 {
+			w.WriteHeader(503)
+			w.Write([]byte(`{"error": {"status": "UNAVAILABLE", "message": "try again"}}`))
+			return
+		}
+		var req struct {
+			Plaintext  []byte
+			Ciphertext []byte
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v1/" + testKey + ":encrypt":
+			json.NewEncoder(w).Encode(map[string]any{"ciphertext": reversed(req.Plaintext)})
+		case "/v1/" + testKey + ":decrypt":
+			json.NewEncoder(w).Encode(map[string]any{"plaintext": reversed(req.Ciphertext)})
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": {"status": "NOT_FOUND", "message": "no such key"}}`))
+		}
+	}))
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func TestWrapper(t *testing.T) {
+	var calls atomic.Int32
+	srv := fakeKMS(t, &calls)
+	defer srv.Close()
+
+	client := gcpkms.NewClient(token)
+	client.Endpoint = srv.URL + "/"
+	client.InitialBackoff = time.Millisecond
+	wrapper := gcpkms.New(client, testKey)
+
+	original := []byte("hello, world")
+	var sealed bytes.Buffer
+	w, err := sealer.Seal(&sealed, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{wrapper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(original)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opn, err := sealer.Prepare(&sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := gcpkms.KeyName(opn.Slots[0]); !ok || name != testKey {
+		t.Fatalf("KeyName = %q, %v", name, ok)
+	}
+	r, err := opn.OpenWith(wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("made %d requests, wanted 4", n)
+	}
+}
+
+func TestClient_permanentErrors(t *testing.T) {
+	var calls atomic.Int32
+	calls.Store(1) // succeed on the first attempt
+	srv := fakeKMS(t, &calls)
+	defer srv.Close()
+
+	client := gcpkms.NewClient(token)
+	client.Endpoint = srv.URL + "/"
+	_, err := client.Encrypt(context.Background(), "projects/p/locations/global/keyRings/r/cryptoKeys/other", []byte("x"), nil)
+	var kerr *gcpkms.Error
+	if !errors.As(err, &kerr) || kerr.StatusCode != 404 || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Fatalf("got %v, wanted a 404 error", err)
+	}
+}
+
+func TestClient_contextCancellation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+
+	client := gcpkms.NewClient(token)
+	client.Endpoint = srv.URL + "/"
+	client.MaxAttempts = 1000
+	client.InitialBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.Encrypt(ctx, testKey, []byte("x"), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+}
@@ -1,10 +1,16 @@
 module github.com/andreyvit/sealer
 
-go 1.22
+go 1.26
 
 require (
+	filippo.io/edwards25519 v1.2.0
+	github.com/andybalholm/brotli v1.2.0
 	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/reedsolomon v1.10.0
 	golang.org/x/crypto v0.33.0
 )
 
-require golang.org/x/sys v0.30.0 // indirect
+require (
+	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,503 @@
+package sealer
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type header struct {
+	version   int
+	chunkSize int
+	slots     []Slot
+
+	// magic is set if the header has been read after Magic, which is then
+	// treated as part of the outer prefix.
+	magic bool
+
+	// notAfter is the expiry time in Unix seconds, or 0 if none.
+	notAfter int64
+
+	// context is set if the chunk key is derived using a context string.
+	context bool
+
+	// boundChunks is set if every chunk is authenticated with a digest of
+	// the header (see chunkAD).
+	boundChunks bool
+
+	// framed is set if chunk headers hold the size of the chunk.
+	framed bool
+
+	// padded is set if the compressed stream is padded.
+	padded bool
+
+	// compression is the compression algorithm of the stream.
+	compression Compression
+
+	// dictID is the ID of the zstd dictionary, or 0 if none.
+	dictID uint32
+
+	// randomNonces is set if chunks are encrypted with XChaCha20-Poly1305
+	// under random nonces.
+	randomNonces bool
+
+	// trailerSize is the size of the trailer held by the final chunk, or 0
+	// if none; trailerFields are the types of its fields.
+	trailerSize   int
+	trailerFields []uint16
+
+	// syncInterval is the number of chunks between sync markers, or 0 if
+	// the file has none.
+	syncInterval uint32
+
+	// commitment is the key commitment, or nil if none.
+	commitment []byte
+
+	// mac is the header MAC, or nil for headers without one; macOff is its
+	// offset from the start of the header.
+	mac    []byte
+	macOff int
+}
+
+// append appends the header to buf. It fails with ErrHeaderTooLarge if
+// a field doesn't fit.
+func (h *header) append(buf []byte) ([]byte, error) {
+	if h.version == 0 {
+		s := &h.slots[0]
+		buf = binary.LittleEndian.AppendUint32(buf, 0)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(h.chunkSize))
+		buf = append(buf, s.KeyID[:]...)
+		buf = append(buf, s.Data...)
+		return buf, nil
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(h.version))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(h.chunkSize))
+	sizeOff := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	start := len(buf)
+	var err error
+	field := func(typ uint16, f func(buf []byte) []byte) {
+		if err == nil {
+			buf, err = appendField(buf, typ, f)
+		}
+	}
+	for _, s := range h.slots {
+		field(fieldKeySlot, func(buf []byte) []byte {
+			buf = binary.LittleEndian.AppendUint16(buf, s.Type)
+			buf = append(buf, s.KeyID[:]...)
+			return append(buf, s.Data...)
+		})
+	}
+	if h.notAfter != 0 {
+		field(fieldNotAfter, func(buf []byte) []byte {
+			return binary.LittleEndian.AppendUint64(buf, uint64(h.notAfter))
+		})
+	}
+	if h.context {
+		field(fieldContext, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	if h.randomNonces {
+		field(fieldNonces, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	if h.boundChunks {
+		field(fieldChunkBinding, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	if h.framed {
+		field(fieldFramed, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	if h.compression != CompressionZstd {
+		field(fieldCompression, func(buf []byte) []byte {
+			return append(buf, byte(h.compression))
+		})
+	}
+	if h.dictID != 0 {
+		field(fieldDictionary, func(buf []byte) []byte {
+			return binary.LittleEndian.AppendUint32(buf, h.dictID)
+		})
+	}
+	if h.padded {
+		field(fieldPadded, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	if h.trailerSize != 0 {
+		field(fieldTrailer, func(buf []byte) []byte {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(h.trailerSize))
+			for _, typ := range h.trailerFields {
+				buf = binary.LittleEndian.AppendUint16(buf, typ)
+			}
+			return buf
+		})
+	}
+	if h.syncInterval != 0 {
+		field(fieldSync, func(buf []byte) []byte {
+			return binary.LittleEndian.AppendUint32(buf, h.syncInterval)
+		})
+	}
+	if h.commitment != nil {
+		field(fieldKeyCommitment, func(buf []byte) []byte {
+			return append(buf, h.commitment...)
+		})
+	}
+	if h.mac != nil {
+		field(fieldHeaderMAC, func(buf []byte) []byte {
+			return append(buf, h.mac...)
+		})
+		h.macOff = len(buf) - headerMACSize - (start - headerSizeV1Fixed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHeaderTooLarge, err)
+	}
+	binary.LittleEndian.PutUint32(buf[sizeOff:], uint32(len(buf)-start))
+	return buf, nil
+}
+
+// appendField appends a field of the given type, whose value f appends, or
+// fails if the value is longer than a field can hold.
+func appendField(buf []byte, typ uint16, f func(buf []byte) []byte) ([]byte, error) {
+	buf = binary.LittleEndian.AppendUint16(buf, typ)
+	sizeOff := len(buf)
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	start := len(buf)
+	buf = f(buf)
+	size := len(buf) - start
+	if size > maxFieldSize {
+		return nil, fmt.Errorf("field %#x of %d bytes exceeds %d bytes", typ, size, maxFieldSize)
+	}
+	binary.LittleEndian.PutUint16(buf[sizeOff:], uint16(size))
+	return buf, nil
+}
+
+// readHeader reads the envelope header from in and appends its raw bytes
+// to prefix, which is used as additional data for the first chunk.
+func readHeader(in io.Reader, prefix []byte) (*header, []byte, error) {
+	start := len(prefix)
+	prefix, err := readAppend(in, prefix, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+	magic := string(prefix[start:]) == Magic[:4]
+	if magic {
+		prefix, err = readAppend(in, prefix, len(Magic))
+		if err != nil {
+			return nil, nil, err
+		}
+		if string(prefix[start:start+len(Magic)]) != Magic {
+			return nil, nil, ErrUnsupportedVersion
+		}
+		start += len(Magic)
+	}
+	version := int(binary.LittleEndian.Uint32(prefix[start+offVersion:]))
+
+	switch version {
+	case 0:
+		prefix, err = readAppend(in, prefix, headerSize-4)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := prefix[start:]
+		h := &header{
+			version:   0,
+			chunkSize: int(binary.LittleEndian.Uint32(raw[offChunkSize:])),
+			magic:     magic,
+		}
+		s := Slot{Type: SlotTypeKey, Data: raw[offEncKey:headerSize]}
+		copy(s.KeyID[:], raw[offKeyID:offKeyID+IDSize])
+		h.slots = []Slot{s}
+		return h, prefix, h.validate()
+
+	case 1, 2:
+		prefix, err = readAppend(in, prefix, headerSizeV1Fixed-4)
+		if err != nil {
+			return nil, nil, err
+		}
+		fieldsSize := int(binary.LittleEndian.Uint32(prefix[start+offFieldsSize:]))
+		if fieldsSize > MaxHeaderSize {
+			return nil, nil, ErrHeaderTooLarge
+		}
+		prefix, err = readAppend(in, prefix, fieldsSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := prefix[start:]
+		h := &header{
+			version:   version,
+			chunkSize: int(binary.LittleEndian.Uint32(raw[offChunkSize:])),
+			magic:     magic,
+		}
+		err = h.parseFields(raw[headerSizeV1Fixed:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return h, prefix, h.validate()
+
+	default:
+		return nil, nil, ErrUnsupportedVersion
+	}
+}
+
+func (h *header) parseFields(data []byte) error {
+	fieldsSize := len(data)
+	for len(data) > 0 {
+		if len(data) < fieldHeaderSize {
+			return ErrUnsupportedVersion
+		}
+		typ := binary.LittleEndian.Uint16(data)
+		size := int(binary.LittleEndian.Uint16(data[2:]))
+		data = data[fieldHeaderSize:]
+		if size > len(data) {
+			return ErrUnsupportedVersion
+		}
+		value := data[:size:size]
+		data = data[size:]
+
+		switch typ {
+		case fieldKeySlot:
+			if len(value) < 2+IDSize {
+				return ErrUnsupportedVersion
+			}
+			s := Slot{
+				Type: binary.LittleEndian.Uint16(value),
+				Data: value[2+IDSize:],
+			}
+			copy(s.KeyID[:], value[2:2+IDSize])
+			h.slots = append(h.slots, s)
+		case fieldHeaderMAC:
+			if len(value) != headerMACSize || h.mac != nil {
+				return ErrUnsupportedVersion
+			}
+			h.mac = value
+			h.macOff = headerSizeV1Fixed + fieldsSize - len(data) - size
+		case fieldNotAfter:
+			if len(value) != 8 || h.notAfter != 0 {
+				return ErrUnsupportedVersion
+			}
+			h.notAfter = int64(binary.LittleEndian.Uint64(value))
+		case fieldContext:
+			if len(value) != 0 || h.context {
+				return ErrUnsupportedVersion
+			}
+			h.context = true
+		case fieldCompression:
+			if len(value) != 1 || h.compression != CompressionZstd || value[0] == 0 || Compression(value[0]) > CompressionBrotli {
+				return ErrUnsupportedVersion
+			}
+			h.compression = Compression(value[0])
+		case fieldDictionary:
+			if len(value) != 4 || h.dictID != 0 {
+				return ErrUnsupportedVersion
+			}
+			h.dictID = binary.LittleEndian.Uint32(value)
+			if h.dictID == 0 {
+				return ErrUnsupportedVersion
+			}
+		case fieldTrailer:
+			if len(value) < 2 || len(value)%2 != 0 || h.trailerSize != 0 {
+				return ErrUnsupportedVersion
+			}
+			h.trailerSize = int(binary.LittleEndian.Uint16(value))
+			if h.trailerSize == 0 {
+				return ErrUnsupportedVersion
+			}
+			for i := 2; i < len(value); i += 2 {
+				h.trailerFields = append(h.trailerFields, binary.LittleEndian.Uint16(value[i:]))
+			}
+		case fieldSync:
+			if len(value) != 4 || h.syncInterval != 0 {
+				return ErrUnsupportedVersion
+			}
+			h.syncInterval = binary.LittleEndian.Uint32(value)
+			if h.syncInterval == 0 {
+				return ErrUnsupportedVersion
+			}
+		case fieldPadded:
+			if len(value) != 0 || h.padded {
+				return ErrUnsupportedVersion
+			}
+			h.padded = true
+		case fieldFramed:
+			if len(value) != 0 || h.framed {
+				return ErrUnsupportedVersion
+			}
+			h.framed = true
+		case fieldChunkBinding:
+			if len(value) != 0 || h.boundChunks {
+				return ErrUnsupportedVersion
+			}
+			h.boundChunks = true
+		case fieldKeyCommitment:
+			if len(value) != keyCommitmentSize || h.commitment != nil {
+				return ErrUnsupportedVersion
+			}
+			h.commitment = value
+		case fieldNonces:
+			if len(value) != 0 || h.randomNonces {
+				return ErrUnsupportedVersion
+			}
+			h.randomNonces = true
+		default:
+			if typ&fieldOptional == 0 {
+				return ErrUnsupportedVersion
+			}
+		}
+	}
+	return nil
+}
+
+// hasTrailerField returns whether the trailer has a field of the given type.
+func (h *header) hasTrailerField(typ uint16) bool {
+	return slices.Contains(h.trailerFields, typ)
+}
+
+func (h *header) validate() error {
+	if h.chunkSize == 0 || h.chunkSize > MaxChunkSize {
+		return ErrChunkSizeTooLarge
+	}
+	if len(h.slots) == 0 {
+		return ErrUnsupportedVersion
+	}
+	if (h.compression == CompressionZstdChunks || h.trailerSize != 0 || h.syncInterval != 0) && !h.framed {
+		return ErrUnsupportedVersion
+	}
+	if h.trailerSize > h.chunkSize {
+		return ErrUnsupportedVersion
+	}
+	if h.version == 2 {
+		for i := range h.slots {
+			if !h.slots[i].isPostQuantum() {
+				return ErrNotPostQuantum
+			}
+		}
+	}
+	return nil
+}
+
+// headerMAC computes the MAC of an envelope prefix (the outer prefix and
+// the header) whose MAC value has been zeroed.
+func headerMAC(fileKey, prefix []byte) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "github.com/andreyvit/sealer header", sha256.Size)
+	if err != nil {
+		panic(err)
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(prefix)
+	return m.Sum(nil)
+}
+
+// keyCommitment returns the commitment to a file key. Unlike the AEADs used
+// here, which can be made to decrypt the same ciphertext under different
+// keys, it makes the header (and thus the file) valid for one file key only.
+func keyCommitment(fileKey []byte) []byte {
+	c, err := hkdf.Key(sha256.New, fileKey, nil, "github.com/andreyvit/sealer key commitment", keyCommitmentSize)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// chunkKey returns the key that encrypts the chunks of a file with the given
+// file key, which is the file key itself unless the header has a context.
+func (h *header) chunkKey(fileKey []byte, context string) []byte {
+	if !h.context {
+		return fileKey
+	}
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "github.com/andreyvit/sealer context\x00"+context, KeySize)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// chunkHeaderLen returns the size of the chunk headers of the file.
+func (h *header) chunkHeaderLen() int {
+	n := chunkHeaderSize
+	if h.framed {
+		n += chunkSizeFieldSize
+	}
+	if h.randomNonces {
+		n += nonceSizeX
+	}
+	return n
+}
+
+// chunkAEAD returns the cipher that encrypts the chunks of a file with the
+// given file key.
+func (h *header) chunkAEAD(fileKey []byte, context string) (cipher.AEAD, error) {
+	key := h.chunkKey(fileKey, context)
+	var aead cipher.AEAD
+	var err error
+	if h.randomNonces {
+		aead, err = chacha20poly1305.NewX(key)
+	} else {
+		aead, err = chacha20poly1305.New(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return aead, nil
+}
+
+// firstChunkAD returns the additional data of the first chunk for the given
+// envelope prefix, which starts with an outer prefix of outerLen bytes.
+func (h *header) firstChunkAD(prefix []byte, outerLen int) []byte {
+	if h.boundChunks {
+		return h.chunkAD(prefix, outerLen)
+	}
+	if h.mac == nil {
+		return prefix
+	}
+	ad := make([]byte, 0, outerLen+4)
+	ad = append(ad, prefix[:outerLen]...)
+	return append(ad, prefix[outerLen+offChunkSize:outerLen+offChunkSize+4]...)
+}
+
+// chunkAD returns the additional data of every chunk of a file with bound
+// chunks, or nil for other files (whose chunks after the first have none).
+// It is a digest of the parts of the envelope prefix that Reseal keeps: the
+// outer prefix, the chunk size and the flags that affect chunk encryption.
+// Slots, the expiry time and the version can change, and are authenticated
+// by the header MAC instead.
+func (h *header) chunkAD(prefix []byte, outerLen int) []byte {
+	if !h.boundChunks {
+		return nil
+	}
+	d := sha256.New()
+	d.Write([]byte("github.com/andreyvit/sealer chunk AD\x00"))
+	d.Write(binary.LittleEndian.AppendUint32(nil, uint32(outerLen)))
+	d.Write(prefix[:outerLen])
+	d.Write(binary.LittleEndian.AppendUint32(nil, uint32(h.chunkSize)))
+	var flags byte
+	if h.context {
+		flags |= 1
+	}
+	if h.randomNonces {
+		flags |= 2
+	}
+	d.Write([]byte{flags})
+	return d.Sum(nil)
+}
+
+func readAppend(in io.Reader, buf []byte, n int) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, n)...)
+	if _, err := io.ReadFull(in, buf[start:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
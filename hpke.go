@@ -0,0 +1,251 @@
+package sealer
+
+import (
+	"crypto/ecdh"
+	"crypto/hpke"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// HPKE suite identifiers (RFC 9180) used for public key recipients.
+const (
+	KEMX25519         uint16 = 0x0020 // DHKEM(X25519, HKDF-SHA256)
+	KEMMLKEM768X25519 uint16 = 0x647a // MLKEM768-X25519 (X-Wing), post-quantum hybrid
+
+	kdfHKDFSHA256        uint16 = 0x0001
+	aeadChaCha20Poly1305 uint16 = 0x0003
+)
+
+// hpkeInfo is the HPKE info parameter, binding wrapped keys to this format.
+var hpkeInfo = []byte("github.com/andreyvit/sealer file key")
+
+// Recipient is a public key that a file can be sealed to, using HPKE
+// (RFC 9180) to encapsulate the ephemeral file key. Only the holder of
+// the corresponding Identity can open the file.
+//
+// ID defaults to a hash of the public key, but can be replaced with any
+// user-defined value.
+type Recipient struct {
+	ID  [IDSize]byte
+	pub hpke.PublicKey
+}
+
+// Identity is a private key corresponding to a Recipient.
+type Identity struct {
+	ID   [IDSize]byte
+	priv hpke.PrivateKey
+}
+
+// GenerateIdentity generates a new X25519 identity.
+func GenerateIdentity() (*Identity, error) {
+	return GenerateIdentityKEM(KEMX25519)
+}
+
+// GenerateIdentityKEM generates a new identity using the given HPKE KEM.
+// Use KEMMLKEM768X25519 for identities that need to withstand a future
+// quantum adversary.
+func GenerateIdentityKEM(kemID uint16) (*Identity, error) {
+	kem, err := newKEM(kemID)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := kem.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(priv), nil
+}
+
+// NewIdentity loads an identity from the KEM ID and private key bytes
+// returned by Identity.KEM and Identity.Bytes.
+func NewIdentity(kemID uint16, privateKey []byte) (*Identity, error) {
+	kem, err := newKEM(kemID)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := kem.NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(priv), nil
+}
+
+func newIdentity(priv hpke.PrivateKey) *Identity {
+	return &Identity{
+		ID:   recipientID(priv.PublicKey()),
+		priv: priv,
+	}
+}
+
+// NewRecipient loads a recipient from the KEM ID and public key bytes
+// returned by Recipient.KEM and Recipient.Bytes.
+func NewRecipient(kemID uint16, publicKey []byte) (*Recipient, error) {
+	kem, err := newKEM(kemID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := kem.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{
+		ID:  recipientID(pub),
+		pub: pub,
+	}, nil
+}
+
+// Recipient returns the public key of the identity, with the same ID.
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{
+		ID:  id.ID,
+		pub: id.priv.PublicKey(),
+	}
+}
+
+// KEM returns the HPKE KEM ID of the identity.
+func (id *Identity) KEM() uint16 {
+	return id.priv.KEM().ID()
+}
+
+// Bytes returns the serialized private key.
+func (id *Identity) Bytes() ([]byte, error) {
+	return id.priv.Bytes()
+}
+
+// KEM returns the HPKE KEM ID of the recipient.
+func (r *Recipient) KEM() uint16 {
+	return r.pub.KEM().ID()
+}
+
+// Bytes returns the serialized public key.
+func (r *Recipient) Bytes() []byte {
+	return r.pub.Bytes()
+}
+
+func newKEM(kemID uint16) (hpke.KEM, error) {
+	switch kemID {
+	case KEMX25519:
+		return hpke.DHKEM(ecdh.X25519()), nil
+	case KEMMLKEM768X25519:
+		return hpke.MLKEM768X25519(), nil
+	default:
+		return nil, ErrUnsupportedKEM
+	}
+}
+
+// kemEncSize returns the size of the encapsulated key of the KEM.
+func kemEncSize(kemID uint16) int {
+	if kemID == KEMMLKEM768X25519 {
+		return 1120
+	}
+	return 32
+}
+
+func isPostQuantumKEM(kemID uint16) bool {
+	return kemID == KEMMLKEM768X25519
+}
+
+// isPostQuantum reports whether a slot is acceptable in a v2 header. Slots
+// wrapping the file key with a symmetric cipher are, and so are HPKE slots
+// with hybrid KEMs. The algorithms of the slot types of other packages can't
+// be told from the slot, so their Encapsulators vouch for them when sealing
+// instead (see PostQuantumReporter), and the slots are accepted.
+func (s *Slot) isPostQuantum() bool {
+	switch s.Type {
+	case SlotTypeKey, SlotTypePassphrase, SlotTypeMasterKey:
+		return true
+	case SlotTypeThreshold:
+		return false
+	case SlotTypeHPKE, SlotTypeEscrow:
+		return len(s.Data) >= 2 && isPostQuantumKEM(binary.LittleEndian.Uint16(s.Data))
+	case SlotTypeShamir:
+		_, shares, _, ok := parseShamir(s)
+		for i := range shares {
+			if !shares[i].isPostQuantum() {
+				return false
+			}
+		}
+		return ok
+	default:
+		return true
+	}
+}
+
+func recipientID(pub hpke.PublicKey) [IDSize]byte {
+	h := sha256.New()
+	h.Write(binary.LittleEndian.AppendUint16(nil, pub.KEM().ID()))
+	h.Write(pub.Bytes())
+	return [IDSize]byte(h.Sum(nil))
+}
+
+// HPKE slot data:
+//  - kemID           uint16
+//  - kdfID           uint16
+//  - aeadID          uint16
+//  - enc || ciphertext of the ephemeral key (HPKE single-shot Seal output)
+
+const hpkeSlotFixedSize = 6
+
+// Encapsulate implements Encapsulator using HPKE. The randomness comes from
+// crypto/rand regardless of the random argument.
+func (r *Recipient) Encapsulate(ephemeralKey []byte, random io.Reader) (Slot, error) {
+	sealed, err := hpke.Seal(r.pub, hpke.HKDFSHA256(), hpke.ChaCha20Poly1305(), hpkeInfo, ephemeralKey)
+	if err != nil {
+		return Slot{}, fmt.Errorf("HPKE seal: %w", err)
+	}
+	data := make([]byte, 0, hpkeSlotFixedSize+len(sealed))
+	data = binary.LittleEndian.AppendUint16(data, r.KEM())
+	data = binary.LittleEndian.AppendUint16(data, kdfHKDFSHA256)
+	data = binary.LittleEndian.AppendUint16(data, aeadChaCha20Poly1305)
+	data = append(data, sealed...)
+	return Slot{Type: SlotTypeHPKE, KeyID: r.ID, Data: data}, nil
+}
+
+// PostQuantum implements PostQuantumReporter.
+func (r *Recipient) PostQuantum() bool {
+	return isPostQuantumKEM(r.KEM())
+}
+
+// SlotDataSize implements SlotSizer.
+func (r *Recipient) SlotDataSize() int {
+	return hpkeSlotFixedSize + kemEncSize(r.KEM()) + KeySize + overhead
+}
+
+// Decapsulate implements Decapsulator for HPKE slots with the same ID, or
+// anonymous ones.
+func (id *Identity) Decapsulate(s Slot) ([]byte, error) {
+	if s.Type != SlotTypeHPKE || !idMatches(s.KeyID, id.ID) {
+		return nil, ErrNoMatchingKey
+	}
+	if len(s.Data) < hpkeSlotFixedSize {
+		return nil, ErrUnsupportedVersion
+	}
+	kemID := binary.LittleEndian.Uint16(s.Data[0:])
+	if kemID != id.KEM() {
+		return nil, ErrUnsupportedKEM
+	}
+	kdf, err := hpke.NewKDF(binary.LittleEndian.Uint16(s.Data[2:]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := hpke.NewAEAD(binary.LittleEndian.Uint16(s.Data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := hpke.Open(id.priv, kdf, aead, hpkeInfo, s.Data[hpkeSlotFixedSize:])
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	if len(ephemeralKey) != KeySize {
+		return nil, ErrUnsupportedVersion
+	}
+	return ephemeralKey, nil
+}
+
+// OpenIdentity decrypts the file key using the HPKE slot matching id.ID and
+// returns a Reader of the plaintext.
+func (opn *Openable) OpenIdentity(id *Identity) (*Reader, error) {
+	return opn.OpenWith(id)
+}
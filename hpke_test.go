@@ -0,0 +1,147 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_recipients(t *testing.T) {
+	key := generateKey()
+	alice, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// round-trip Bob's recipient through its serialized form
+	bobRecipient, err := sealer.NewRecipient(bob.Recipient().KEM(), bob.Recipient().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bobRecipient.ID != bob.ID {
+		t.Fatalf("recipient ID %x != identity ID %x", bobRecipient.ID, bob.ID)
+	}
+
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{
+		Recipients: []*sealer.Recipient{alice.Recipient(), bobRecipient},
+	}, original)
+
+	for _, id := range []*sealer.Identity{alice, bob} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(opn.KeyIDs) != 3 || opn.KeyIDs[0] != key.ID {
+			t.Fatalf("unexpected key IDs: %x", opn.KeyIDs)
+		}
+		r, err := opn.OpenIdentity(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	priv, err := alice.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice2, err := sealer.NewIdentity(alice.KEM(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.OpenIdentity(alice2); err != nil {
+		t.Fatal(err)
+	}
+
+	carol, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.OpenIdentity(carol); err != sealer.ErrNoMatchingKey {
+		t.Fatalf("OpenIdentity with a foreign identity: got %v, wanted %v", err, sealer.ErrNoMatchingKey)
+	}
+}
+
+func TestSealer_postQuantum(t *testing.T) {
+	pq, err := sealer.GenerateIdentityKEM(sealer.KEMMLKEM768X25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classic, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+		Recipients:  []*sealer.Recipient{pq.Recipient(), classic.Recipient()},
+		PostQuantum: true,
+	})
+	if err != sealer.ErrNotPostQuantum {
+		t.Fatalf("Seal to a classic recipient: got %v, wanted %v", err, sealer.ErrNotPostQuantum)
+	}
+
+	for _, enc := range []sealer.Encapsulator{
+		opaqueEncapsulator{},
+		&sealer.Shamir{Threshold: 1, Holders: []sealer.Encapsulator{pq.Recipient(), classic.Recipient()}},
+	} {
+		_, err = sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+			Encapsulators: []sealer.Encapsulator{enc},
+			PostQuantum:   true,
+		})
+		if err != sealer.ErrNotPostQuantum {
+			t.Errorf("Seal to %T: got %v, wanted %v", enc, err, sealer.ErrNotPostQuantum)
+		}
+	}
+
+	original := []byte("hello, future")
+	sealed := seal(t, []*sealer.Key{generateKey()}, nil, sealer.SealOptions{
+		Recipients:    []*sealer.Recipient{pq.Recipient()},
+		Encapsulators: []sealer.Encapsulator{customPQEncapsulator{}, &sealer.Shamir{Threshold: 1, Holders: []sealer.Encapsulator{pq.Recipient()}}},
+		PostQuantum:   true,
+	}, original)
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.OpenIdentity(pq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+}
+
+// customPQEncapsulator vouches for the slots of a type this package doesn't
+// know the algorithm of.
+type customPQEncapsulator struct{}
+
+func (customPQEncapsulator) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	return sealer.Slot{Type: sealer.SlotTypeCustom, Data: []byte("wrapped")}, nil
+}
+
+func (customPQEncapsulator) PostQuantum() bool {
+	return true
+}
@@ -0,0 +1,298 @@
+// Package httprange implements io.ReaderAt over an HTTP(S) URL using Range
+// requests, so that sealed files kept in object storage can be opened and
+// read at random positions without downloading them:
+//
+//	ra, err := httprange.Open(ctx, url, httprange.Options{})
+//	opn, err := sealer.PrepareAt(ra, 0, 0)
+//	r, err := opn.NewReaderAt(key)
+//
+// The file is read in blocks, and reads of neighbouring bytes are coalesced
+// into a single request for the blocks they need: a few recently read
+// blocks are kept, and concurrent reads of the same block share a request.
+// The file is pinned to the ETag returned by the first request, and reads
+// fail with ErrChanged once the file has been replaced.
+package httprange
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultBlockSize   = 256 * 1024
+	DefaultCacheBlocks = 16
+)
+
+var (
+	// ErrChanged is returned when the file has changed since it was opened.
+	ErrChanged = errors.New("httprange: file has changed")
+
+	// ErrRangeUnsupported is returned by Open when the server ignores Range
+	// requests.
+	ErrRangeUnsupported = errors.New("httprange: server does not support range requests")
+)
+
+// Options configure a ReaderAt. The zero value is ready to use.
+type Options struct {
+	// Client makes the requests; http.DefaultClient if nil.
+	Client *http.Client
+
+	// Header is added to every request, e.g. for authorization.
+	Header http.Header
+
+	// BlockSize is the unit the file is requested in, DefaultBlockSize if
+	// 0. Reads are rounded out to whole blocks, so a larger block means
+	// fewer requests for scattered small reads, at the cost of transferring
+	// more data.
+	BlockSize int
+
+	// CacheBlocks is the number of recently read blocks kept in memory,
+	// DefaultCacheBlocks if 0.
+	CacheBlocks int
+}
+
+// ReaderAt reads a remote file with Range requests. It is safe for
+// concurrent use.
+type ReaderAt struct {
+	ctx    context.Context
+	url    string
+	opt    Options
+	size   int64
+	etag   string
+	weak   bool
+	bsize  int64
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[int64]*list.Element
+	lru   list.List
+}
+
+// block is a cached block, whose data is ready once done is closed.
+type block struct {
+	index int64
+	done  chan struct{}
+	data  []byte
+	err   error
+}
+
+// Open requests the first block of the file at url, which gives its size
+// and ETag, and returns a ReaderAt of it. ctx applies to every request made
+// by the ReaderAt.
+func Open(ctx context.Context, url string, opt Options) (*ReaderAt, error) {
+	if opt.Client == nil {
+		opt.Client = http.DefaultClient
+	}
+	if opt.BlockSize <= 0 {
+		opt.BlockSize = DefaultBlockSize
+	}
+	if opt.CacheBlocks <= 0 {
+		opt.CacheBlocks = DefaultCacheBlocks
+	}
+	r := &ReaderAt{
+		ctx:    ctx,
+		url:    url,
+		opt:    opt,
+		bsize:  int64(opt.BlockSize),
+		client: opt.Client,
+		cache:  make(map[int64]*list.Element),
+	}
+
+	resp, err := r.get(0, r.bsize-1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// an empty file has no bytes to request
+		if resp.Header.Get("Content-Range") != "bytes */0" {
+			return nil, fmt.Errorf("httprange: %s: unexpected %s", url, resp.Status)
+		}
+		r.etag = resp.Header.Get("ETag")
+		return r, nil
+	case http.StatusOK:
+		return nil, ErrRangeUnsupported
+	default:
+		return nil, fmt.Errorf("httprange: %s: %s", url, resp.Status)
+	}
+	r.etag = resp.Header.Get("ETag")
+	r.weak = strings.HasPrefix(r.etag, "W/")
+
+	start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if start != 0 || end != min(r.bsize, size)-1 {
+		return nil, fmt.Errorf("httprange: %s: unexpected range %d-%d", url, start, end)
+	}
+	r.size = size
+	data := make([]byte, end+1)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	b := &block{index: 0, done: make(chan struct{}), data: data}
+	close(b.done)
+	r.cache[0] = r.lru.PushFront(b)
+	return r, nil
+}
+
+// Size returns the size of the file.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ETag returns the ETag the file is pinned to, or "" if the server doesn't
+// return one, in which case changes to the file go unnoticed.
+func (r *ReaderAt) ETag() string {
+	return r.etag
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("httprange: negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	p = p[:min(int64(len(p)), r.size-off)]
+
+	first, last := off/r.bsize, (off+int64(len(p))-1)/r.bsize
+	var n int
+	for i, b := range r.acquire(first, last) {
+		<-b.done
+		if b.err != nil {
+			return n, b.err
+		}
+		n += copy(p[n:], b.data[off+int64(n)-(first+int64(i))*r.bsize:])
+	}
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// acquire returns the blocks from first to last, cached or being fetched,
+// fetching each run of the missing ones with a single request.
+func (r *ReaderAt) acquire(first, last int64) []*block {
+	blocks := make([]*block, 0, last-first+1)
+	var runs [][]*block
+	r.mu.Lock()
+	for i := first; i <= last; i++ {
+		if e, ok := r.cache[i]; ok {
+			r.lru.MoveToFront(e)
+			blocks = append(blocks, e.Value.(*block))
+			continue
+		}
+		b := &block{index: i, done: make(chan struct{})}
+		r.cache[i] = r.lru.PushFront(b)
+		blocks = append(blocks, b)
+		if n := len(runs); n > 0 && runs[n-1][len(runs[n-1])-1].index == i-1 {
+			runs[n-1] = append(runs[n-1], b)
+		} else {
+			runs = append(runs, []*block{b})
+		}
+	}
+	for r.lru.Len() > max(r.opt.CacheBlocks, len(blocks)) {
+		e := r.lru.Back()
+		delete(r.cache, e.Value.(*block).index)
+		r.lru.Remove(e)
+	}
+	r.mu.Unlock()
+
+	for _, run := range runs {
+		err := r.fetch(run)
+		if err != nil {
+			r.mu.Lock()
+			for _, b := range run {
+				b.err = err
+				if e, ok := r.cache[b.index]; ok && e.Value == b {
+					delete(r.cache, b.index)
+					r.lru.Remove(e)
+				}
+			}
+			r.mu.Unlock()
+		}
+		for _, b := range run {
+			close(b.done)
+		}
+	}
+	return blocks
+}
+
+// fetch requests the data of consecutive blocks.
+func (r *ReaderAt) fetch(run []*block) error {
+	start := run[0].index * r.bsize
+	end := min((run[len(run)-1].index+1)*r.bsize, r.size) - 1
+	resp, err := r.get(start, end)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusPreconditionFailed:
+		return ErrChanged
+	default:
+		return fmt.Errorf("httprange: %s: %s", r.url, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != r.etag {
+		return ErrChanged
+	}
+	s, e, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if size != r.size {
+		return ErrChanged
+	}
+	if s != start || e != end {
+		return fmt.Errorf("httprange: %s: got range %d-%d, wanted %d-%d", r.url, s, e, start, end)
+	}
+
+	data := make([]byte, end-start+1)
+	_, err = io.ReadFull(resp.Body, data)
+	if err != nil {
+		return err
+	}
+	for _, b := range run {
+		b.data = data[:min(r.bsize, int64(len(data))):min(r.bsize, int64(len(data)))]
+		data = data[len(b.data):]
+	}
+	return nil
+}
+
+func (r *ReaderAt) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.opt.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	// If-Match needs a strong ETag; weak ones are only compared on return
+	if r.etag != "" && !r.weak {
+		req.Header.Set("If-Match", r.etag)
+	}
+	return r.client.Do(req)
+}
+
+// parseContentRange parses a Content-Range header like "bytes 0-99/1000".
+func parseContentRange(s string) (start, end, size int64, err error) {
+	_, err = fmt.Sscanf(s, "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil || start < 0 || end < start || size <= end {
+		return 0, 0, 0, fmt.Errorf("httprange: invalid Content-Range %q", s)
+	}
+	return start, end, size, nil
+}
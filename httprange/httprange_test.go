@@ -0,0 +1,152 @@
+package httprange_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/httprange"
+)
+
+// server serves content with Range support, counting the requests.
+type server struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	requests atomic.Int32
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mu.Lock()
+	content, etag := s.content, s.etag
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *server) replace(content []byte, etag string) {
+	s.mu.Lock()
+	s.content, s.etag = content, etag
+	s.mu.Unlock()
+}
+
+func TestReaderAt(t *testing.T) {
+	original := make([]byte, 10000)
+	rand.Read(original)
+	s := &server{content: original, etag: `"v1"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ra, err := httprange.Open(context.Background(), ts.URL, httprange.Options{BlockSize: 1000, CacheBlocks: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.Size() != int64(len(original)) || ra.ETag() != `"v1"` {
+		t.Fatalf("Size() = %d, ETag() = %q", ra.Size(), ra.ETag())
+	}
+
+	for _, c := range []struct{ off, n int }{{0, 10}, {990, 20}, {2500, 3000}, {9990, 10}, {9995, 10}} {
+		buf := make([]byte, c.n)
+		n, err := ra.ReadAt(buf, int64(c.off))
+		end := min(c.off+c.n, len(original))
+		if n != end-c.off || (end < c.off+c.n) != (err == io.EOF) || err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d, %d) = %d, %v", c.n, c.off, n, err)
+		}
+		if !bytes.Equal(buf[:n], original[c.off:end]) {
+			t.Fatalf("ReadAt(%d, %d) returned wrong data", c.n, c.off)
+		}
+	}
+
+	// neighbouring reads of a cached block don't make requests
+	before := s.requests.Load()
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf [10]byte
+			if _, err := ra.ReadAt(buf[:], int64(9000+i*10)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.requests.Load() - before; n != 0 {
+		t.Errorf("%d requests for cached data", n)
+	}
+
+	// a replaced file is detected
+	s.replace(bytes.Clone(original), `"v2"`)
+	_, err = ra.ReadAt(make([]byte, 10), 6000)
+	if !errors.Is(err, httprange.ErrChanged) {
+		t.Errorf("err = %v, wanted ErrChanged", err)
+	}
+}
+
+func TestReaderAt_sealed(t *testing.T) {
+	key := &sealer.Key{ID: [sealer.IDSize]byte{1}}
+	rand.Read(key.Key[:])
+	original := make([]byte, 100000)
+	rand.Read(original)
+
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 4096, SeekIndex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(original); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{content: buf.Bytes(), etag: `"sealed"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ra, err := httprange.Open(context.Background(), ts.URL, httprange.Options{BlockSize: 8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn, err := sealer.PrepareAt(ra, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.NewReaderAt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := s.requests.Load()
+	actual := make([]byte, 1000)
+	if _, err := r.ReadAt(actual, 50000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original[50000:51000]) {
+		t.Errorf("got wrong data")
+	}
+	if n := s.requests.Load() - before; n > 1 {
+		t.Errorf("%d requests to read a single chunk", n)
+	}
+}
+
+func TestOpen_noRanges(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+	_, err := httprange.Open(context.Background(), ts.URL, httprange.Options{})
+	if err != httprange.ErrRangeUnsupported {
+		t.Errorf("err = %v, wanted ErrRangeUnsupported", err)
+	}
+}
@@ -0,0 +1,221 @@
+package sealer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrIndexMismatch is returned by Openable.SetIndex for an index built for
+// another file.
+var ErrIndexMismatch = errors.New("index does not belong to the sealed file")
+
+// Index is a sidecar index of a sealed file, which makes files sealed
+// without SealOptions.SeekIndex seekable without rewriting them. Build it
+// once with Openable.BuildIndex, and keep it next to the file, sealed with
+// Index.Seal (conventionally as a .idx file); then load it with OpenIndex,
+// and pass it to Openable.SetIndex before opening the file.
+//
+// Only files whose chunks can be decompressed on their own, i.e. sealed
+// with CompressionNone or CompressionZstdChunks, can be indexed, and files
+// with sync markers (see SealOptions.SyncInterval) can't.
+type Index struct {
+	x seekIndex
+
+	// fileID is the SHA-256 of the first chunk of the file, which is
+	// unique to it, and doesn't change when the file is resealed.
+	fileID [sha256.Size]byte
+}
+
+// Sidecar index format (the plaintext of the sealed index file):
+//  - magic           [8]byte (indexMagic)
+//  - fileID          [32]byte, SHA-256 of the first chunk, header included
+//  - index           seek index field value (see seek.go) of all chunks,
+//                    the final one included, without unused entries
+
+const indexMagic = "SLRINDEX"
+
+// maxSidecarEntries is the largest number of entries in a sidecar index,
+// which keeps it within 16 MiB.
+const maxSidecarEntries = 1 << 20
+
+// indexer collects a sidecar index of the chunks being read.
+type indexer struct {
+	seekIndexBuilder
+	off    int64
+	fileID [sha256.Size]byte
+}
+
+// chunk is called for every chunk read, header included.
+func (ix *indexer) chunk(index uint32, chunk []byte) {
+	if index == 0 {
+		ix.fileID = sha256.Sum256(chunk)
+	}
+	ix.add(index, ix.off)
+	ix.off += int64(len(chunk))
+}
+
+// BuildIndex decrypts the file key using the slot matching key.ID, reads
+// and verifies the whole file, and returns its sidecar index. Like Open, it
+// consumes the input of an Openable returned by Prepare.
+func (opn *Openable) BuildIndex(key *Key) (*Index, error) {
+	c := opn.hdr.compression
+	if c != CompressionNone && c != CompressionZstdChunks || opn.hdr.syncInterval != 0 {
+		return nil, ErrNotSeekable
+	}
+	var fileKey [KeySize]byte
+	defer clear(fileKey[:])
+	err := opn.decapsulateKey(fileKey[:], key)
+	if err != nil {
+		return nil, err
+	}
+	err = opn.checkFileKey(fileKey[:])
+	if err != nil {
+		return nil, err
+	}
+	zopts, err := opn.zstdOptions()
+	if err != nil {
+		return nil, err
+	}
+	r, err := opn.newReader(opn.body(), fileKey[:])
+	if err != nil {
+		return nil, err
+	}
+	ix := &indexer{seekIndexBuilder: *newSeekIndexBuilder(opn.hdr)}
+	ix.capacity = maxSidecarEntries
+	r.dec.indexer = ix
+
+	err = r.dec.read(opn.ad)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the first chunk: %w", withPlaintext(err, 0))
+	}
+	r.decompr, err = c.newReader(&r.dec, zopts)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Index{fileID: ix.fileID}
+	x.x = ix.seekIndex
+	x.x.plaintext = r.total
+	x.x.chunks = int(r.dec.chunkIndex)
+	x.x.capacity = len(x.x.entries)
+	x.x.sidecar = true
+	return x, nil
+}
+
+// Size returns the size of the plaintext of the indexed file.
+func (x *Index) Size() int64 {
+	return x.x.plaintext
+}
+
+// Seal seals the index to out, like Seal would with the given arguments.
+func (x *Index) Seal(out io.Writer, keys []*Key, opt SealOptions) error {
+	w, err := Seal(out, keys, nil, opt)
+	if err != nil {
+		return err
+	}
+	buf := append([]byte(indexMagic), x.fileID[:]...)
+	_, err = w.Write(x.x.append(buf))
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// OpenIndex opens a sidecar index sealed with Index.Seal.
+func OpenIndex(in io.Reader, key *Key) (*Index, error) {
+	opn, err := Prepare(in, nil)
+	if err != nil {
+		return nil, err
+	}
+	opn.MaxPlaintextSize = int64(len(indexMagic) + sha256.Size + seekIndexHeaderLen + maxSidecarEntries*seekEntryLen)
+	r, err := opn.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(indexMagic)+sha256.Size || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, errors.New("not a sealed file index")
+	}
+	x := &Index{x: seekIndex{sidecar: true}}
+	copy(x.fileID[:], data[len(indexMagic):])
+	err = x.x.parse(data[len(indexMagic)+sha256.Size:])
+	if err != nil || len(x.x.entries) == 0 {
+		return nil, errors.New("malformed sealed file index")
+	}
+	return x, nil
+}
+
+// SetIndex makes the Readers and ReaderAts opened from now on use x to seek
+// (see Reader.Seek and Openable.NewReaderAt), after checking that x has
+// been built for this file, which needs reading its first chunk: the
+// Openable has to come from PrepareAt, or from Prepare given an
+// io.ReadSeeker, and SetIndex returns ErrNotSeekable otherwise.
+func (opn *Openable) SetIndex(x *Index) error {
+	chunk, err := opn.readFirstChunk()
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(chunk) != x.fileID {
+		return ErrIndexMismatch
+	}
+	opn.index = &x.x
+	return nil
+}
+
+// readFirstChunk reads the first chunk of a framed file from a seekable
+// input without consuming it.
+func (opn *Openable) readFirstChunk() ([]byte, error) {
+	var readAt func(p []byte, off int64) error
+	if opn.ra != nil {
+		readAt = func(p []byte, off int64) error {
+			_, err := opn.ra.ReadAt(p, opn.bodyStart+off)
+			return err
+		}
+	} else if in, ok := opn.in.(io.ReadSeeker); ok && opn.bodyOff >= 0 {
+		readAt = func(p []byte, off int64) error {
+			pos, err := in.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			_, err = in.Seek(opn.bodyOff+off, io.SeekStart)
+			if err == nil {
+				_, err = io.ReadFull(in, p)
+			}
+			if _, serr := in.Seek(pos, io.SeekStart); err == nil {
+				err = serr
+			}
+			return err
+		}
+	} else {
+		return nil, ErrNotSeekable
+	}
+	if !opn.hdr.framed {
+		return nil, ErrNotSeekable
+	}
+
+	hdrLen := opn.hdr.chunkHeaderLen()
+	chunk := make([]byte, hdrLen)
+	err := readAt(chunk, 0)
+	if err == nil {
+		size := int(binary.LittleEndian.Uint32(chunk[chunkHeaderSize:]))
+		if size > opn.hdr.chunkSize {
+			return nil, fmt.Errorf("%w: chunk 0 has size %d", ErrCorrupted, size)
+		}
+		chunk = append(chunk, make([]byte, size+overhead)...)
+		err = readAt(chunk[hdrLen:], int64(hdrLen))
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return chunk, err
+}
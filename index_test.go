@@ -0,0 +1,134 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestOpenable_buildIndex(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, Padding: sealer.PaddingPadme, RecordSize: true},
+		{Compression: sealer.CompressionZstdChunks},
+		{Compression: sealer.CompressionZstdChunks, PlaintextDigest: true, RandomNonces: true},
+	} {
+		opt.ChunkSize = 256
+		for _, size := range []int{0, 100, 255 * 4, 256 * 4, 30000} {
+			t.Run(fmt.Sprintf("%v/%v/%d", opt.Compression, opt.Padding, size), func(t *testing.T) {
+				original := randomBytes(t, size)
+				for i := 0; i < size; i += 1000 {
+					clear(original[i:min(size, i+500)])
+				}
+				sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+				opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				x, err := opn.BuildIndex(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if x.Size() != int64(size) {
+					t.Errorf("Size() = %d, wanted %d", x.Size(), size)
+				}
+				var idx bytes.Buffer
+				if err := x.Seal(&idx, []*sealer.Key{key}, sealer.SealOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				x, err = sealer.OpenIndex(&idx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := opn.SetIndex(x); err != nil {
+					t.Fatal(err)
+				}
+				r, err := opn.Open(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil || end != int64(size) {
+					t.Fatalf("Seek(0, io.SeekEnd) = %d, %v, wanted %d", end, err, size)
+				}
+				for range 20 {
+					pos := rand.IntN(size + 2)
+					if _, err := r.Seek(int64(pos), io.SeekStart); err != nil {
+						t.Fatalf("Seek(%d): %v", pos, err)
+					}
+					actual, err := io.ReadAll(io.LimitReader(r, 600))
+					if err != nil {
+						t.Fatalf("reading at %d: %v", pos, err)
+					}
+					if expected := original[min(pos, size):min(pos+600, size)]; !bytes.Equal(actual, expected) {
+						t.Fatalf("at %d: got %d bytes, wanted %d", pos, len(actual), len(expected))
+					}
+				}
+				if _, err := r.Seek(int64(size/2), io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				if actual, err := io.ReadAll(r); err != nil || !bytes.Equal(actual, original[size/2:]) {
+					t.Errorf("got %d bytes after seeking to the middle: %v", len(actual), err)
+				}
+
+				opn, err = sealer.PrepareAt(bytes.NewReader(sealed), 0, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := opn.SetIndex(x); err != nil {
+					t.Fatal(err)
+				}
+				ra, err := opn.NewReaderAt(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+				if err != nil || !bytes.Equal(actual, original) {
+					t.Errorf("ReaderAt returned %d bytes: %v", len(actual), err)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenable_setIndexMismatch(t *testing.T) {
+	key := generateKeyWithID("key")
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone}
+	data := randomBytes(t, 1000)
+	opn, err := sealer.Prepare(bytes.NewReader(seal(t, []*sealer.Key{key}, nil, opt, data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := opn.BuildIndex(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opn, err = sealer.Prepare(bytes.NewReader(seal(t, []*sealer.Key{key}, nil, opt, data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opn.SetIndex(x); err != sealer.ErrIndexMismatch {
+		t.Errorf("err = %v, wanted ErrIndexMismatch", err)
+	}
+
+	opn, err = sealer.Prepare(bytes.NewReader(seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.BuildIndex(key); !errors.Is(err, sealer.ErrNotSeekable) {
+		t.Errorf("BuildIndex with zstd: err = %v, wanted ErrNotSeekable", err)
+	}
+}
@@ -0,0 +1,129 @@
+package sealer
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// HeaderInfo describes the envelope header of a sealed file, for tooling
+// that displays file properties. Nothing in it is authenticated until the
+// file is opened.
+type HeaderInfo struct {
+	// Version is the envelope format version (see Openable.Version).
+	Version int
+
+	// ChunkSize is the size of the plaintext held by a chunk.
+	ChunkSize int
+
+	// KeyID is the ID of the first key the file has been sealed to, and
+	// KeyIDs are the IDs of all of them.
+	KeyID  [IDSize]byte
+	KeyIDs [][IDSize]byte
+
+	// Slots are the raw header slots. Do not modify.
+	Slots []Slot
+
+	// Cipher is the AEAD the chunks are encrypted with: ChaCha20-Poly1305,
+	// or XChaCha20-Poly1305 for files sealed with SealOptions.RandomNonces.
+	Cipher string
+
+	// Compression is the compression algorithm of the data, and
+	// DictionaryID is the ID of its zstd dictionary, or 0 if none.
+	Compression  Compression
+	DictionaryID uint32
+
+	// Padded is set if the compressed data is padded (see
+	// SealOptions.Padding).
+	Padded bool
+
+	// Framed is set if chunk headers hold the size of the chunk, which
+	// allows short chunks.
+	Framed bool
+
+	// Magic is set if the header follows Magic (see SealOptions.Magic).
+	Magic bool
+
+	// NotAfter is the expiry time of the file, or zero if it doesn't
+	// expire.
+	NotAfter time.Time
+
+	// Context is set if the file has been sealed with a context string,
+	// which isn't recorded.
+	Context bool
+
+	// KeyCommitment and HeaderMAC are set if the header has a key
+	// commitment and a MAC.
+	KeyCommitment bool
+	HeaderMAC     bool
+
+	// PlaintextDigest, RecordSize and MerkleRoot are set if the file has
+	// been sealed with the same SealOptions, and so has a trailer holding
+	// them.
+	PlaintextDigest bool
+	RecordSize      bool
+	MerkleRoot      bool
+
+	// SyncInterval is the number of chunks between sync markers (see
+	// SealOptions.SyncInterval), or 0 if the file has none.
+	SyncInterval int
+}
+
+// Header returns the properties of the file read from its header.
+func (opn *Openable) Header() HeaderInfo {
+	return opn.hdr.info()
+}
+
+func (h *header) info() HeaderInfo {
+	info := HeaderInfo{
+		Version:         h.version,
+		ChunkSize:       h.chunkSize,
+		KeyIDs:          make([][IDSize]byte, len(h.slots)),
+		Slots:           h.slots,
+		Cipher:          "ChaCha20-Poly1305",
+		Compression:     h.compression,
+		DictionaryID:    h.dictID,
+		Padded:          h.padded,
+		Framed:          h.framed,
+		Magic:           h.magic,
+		Context:         h.context,
+		KeyCommitment:   h.commitment != nil,
+		HeaderMAC:       h.mac != nil,
+		PlaintextDigest: h.hasTrailerField(trailerDigest),
+		RecordSize:      h.hasTrailerField(trailerSize),
+		MerkleRoot:      h.hasTrailerField(trailerMerkle),
+		SyncInterval:    int(h.syncInterval),
+	}
+	for i, s := range h.slots {
+		info.KeyIDs[i] = s.KeyID
+	}
+	if len(info.KeyIDs) > 0 {
+		info.KeyID = info.KeyIDs[0]
+	}
+	if h.randomNonces {
+		info.Cipher = "XChaCha20-Poly1305"
+	}
+	if h.notAfter != 0 {
+		info.NotAfter = time.Unix(h.notAfter, 0)
+	}
+	return info
+}
+
+// ParseHeader parses the envelope header at the start of b, which starts
+// right after the outer prefix, and returns its properties and the rest of
+// b, which starts with the first chunk. It is meant for indexing sealed
+// blobs held in memory, e.g. by KeyIDs; to open them, use Prepare.
+//
+// A b too short to hold the header fails with io.ErrUnexpectedEOF. The
+// returned Slots don't alias b.
+func ParseHeader(b []byte) (HeaderInfo, []byte, error) {
+	r := bytes.NewReader(b)
+	hdr, _, err := readHeader(r, nil)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return HeaderInfo{}, nil, err
+	}
+	return hdr.info(), b[len(b)-r.Len():], nil
+}
@@ -0,0 +1,122 @@
+// Package bech32 implements Bech32 (BIP 173) encoding, as used by age keys
+// and sealer key strings, without the 90 character limit.
+package bech32
+
+import (
+	"errors"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	v := make([]byte, 0, len(hrp)*2+1)
+	for i := range len(hrp) {
+		v = append(v, hrp[i]>>5)
+	}
+	v = append(v, 0)
+	for i := range len(hrp) {
+		v = append(v, hrp[i]&31)
+	}
+	return v
+}
+
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<to - 1
+	var out []byte
+	for _, b := range data {
+		if uint32(b)>>from != 0 {
+			return nil, false
+		}
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// Encode returns the Bech32 encoding of data with the given human-readable
+// part. The result is uppercase if hrp is.
+func Encode(hrp string, data []byte) string {
+	values, _ := convertBits(data, 8, 5, true)
+	lower := strings.ToLower(hrp)
+	check := append(hrpExpand(lower), values...)
+	check = append(check, 0, 0, 0, 0, 0, 0)
+	mod := polymod(check) ^ 1
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(charset[v])
+	}
+	for i := range 6 {
+		b.WriteByte(charset[(mod>>(5*(5-i)))&31])
+	}
+	if hrp != lower {
+		return strings.ToUpper(b.String())
+	}
+	return b.String()
+}
+
+// ErrInvalid is returned by Decode for malformed strings and checksum
+// mismatches.
+var ErrInvalid = errors.New("invalid bech32 encoding")
+
+// Decode decodes a Bech32 string, returning its human-readable part in
+// lowercase.
+func Decode(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, ErrInvalid
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, ErrInvalid
+	}
+	hrp = s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(charset, s[i])
+		if v < 0 {
+			return "", nil, ErrInvalid
+		}
+		values = append(values, byte(v))
+	}
+	if polymod(append(hrpExpand(hrp), values...)) != 1 {
+		return "", nil, ErrInvalid
+	}
+	data, ok := convertBits(values[:len(values)-6], 5, 8, false)
+	if !ok {
+		return "", nil, ErrInvalid
+	}
+	return hrp, data, nil
+}
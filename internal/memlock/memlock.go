@@ -0,0 +1,8 @@
+// Package memlock allocates memory that is locked into RAM, so that it never
+// ends up in swap, and is surrounded by inaccessible guard pages.
+package memlock
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without mlock.
+var ErrUnsupported = errors.New("locked memory is not supported on this platform")
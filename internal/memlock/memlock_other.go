@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package memlock
+
+// Alloc returns ErrUnsupported on this platform.
+func Alloc(size int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Free returns false on this platform.
+func Free(b []byte) bool {
+	return false
+}
+
+// LockAll returns ErrUnsupported on this platform.
+func LockAll() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,77 @@
+//go:build linux || darwin
+
+package memlock
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	mu     sync.Mutex
+	allocs = make(map[uintptr][]byte) // data start => whole mapping
+)
+
+// Alloc returns size bytes of zeroed locked memory, placed at the end of
+// a locked region followed by a guard page, so that overruns fault.
+func Alloc(size int) ([]byte, error) {
+	page := os.Getpagesize()
+	dataPages := (size + page - 1) / page
+	mem, err := syscall.Mmap(-1, 0, (dataPages+2)*page, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	data := mem[page : (dataPages+1)*page]
+	err = syscall.Mprotect(mem[:page], syscall.PROT_NONE)
+	if err == nil {
+		err = syscall.Mprotect(mem[(dataPages+1)*page:], syscall.PROT_NONE)
+	}
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, fmt.Errorf("mprotect: %w", err)
+	}
+	err = syscall.Mlock(data)
+	if err != nil {
+		syscall.Munmap(mem)
+		return nil, fmt.Errorf("mlock: %w", err)
+	}
+
+	b := data[len(data)-size:]
+	mu.Lock()
+	allocs[uintptr(unsafe.Pointer(unsafe.SliceData(b)))] = mem
+	mu.Unlock()
+	return b, nil
+}
+
+// Free zeroes and releases memory returned by Alloc. It returns false if
+// b was not returned by Alloc (or has already been freed).
+func Free(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	mu.Lock()
+	mem, ok := allocs[p]
+	delete(allocs, p)
+	mu.Unlock()
+	if !ok {
+		return false
+	}
+	clear(b)
+	page := os.Getpagesize()
+	syscall.Munlock(mem[page : len(mem)-page])
+	syscall.Munmap(mem)
+	return true
+}
+
+// LockAll locks all current and future memory of the process.
+func LockAll() error {
+	err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+	if err != nil {
+		return fmt.Errorf("mlockall: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Package slotdata implements the slot data layout shared by key wrappers
+// that identify their key by name:
+//
+//   - nameLen    uint16
+//   - name       [nameLen]byte
+//   - ciphertext (the wrapped file key, as returned by the service)
+package slotdata
+
+import (
+	"encoding/binary"
+)
+
+// Pack returns slot data holding the key name and the wrapped key.
+func Pack(name string, ciphertext []byte) []byte {
+	data := make([]byte, 0, 2+len(name)+len(ciphertext))
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(name)))
+	data = append(data, name...)
+	return append(data, ciphertext...)
+}
+
+// Unpack splits slot data produced by Pack.
+func Unpack(data []byte) (name string, ciphertext []byte, ok bool) {
+	if len(data) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.LittleEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, false
+	}
+	return string(data[2 : 2+n]), data[2+n:], true
+}
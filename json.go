@@ -0,0 +1,103 @@
+package sealer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSON envelope is JSON Lines, one record per line: the envelope prefix,
+// then every chunk in turn, all base64-encoded:
+//
+//	{"format":"sealer","header":"AQAAAAAAgAA..."}
+//	{"chunk":"//////wwAAAA..."}
+//
+// Each record stands on its own, so the file survives pipelines that only
+// pass JSON documents, like webhooks and log shippers.
+
+type jsonRecord struct {
+	Format string `json:"format,omitempty"`
+	Header []byte `json:"header,omitempty"`
+	Chunk  []byte `json:"chunk,omitempty"`
+}
+
+const jsonFormat = "sealer"
+
+var errInvalidJSON = errors.New("invalid JSON envelope")
+
+// SealJSON is like Seal, but writes the sealed file to out as JSON Lines:
+// a record holding the header, followed by a record per chunk. Open it
+// with PrepareJSON. There's no outer prefix; put whatever you'd put there
+// into the surrounding JSON.
+func SealJSON(out io.Writer, keys []*Key, opt SealOptions) (*Writer, error) {
+	return Seal(&jsonWriter{out: out}, keys, nil, opt)
+}
+
+// jsonWriter turns each write into a record. This relies on the encryptor
+// writing the envelope prefix and each chunk with a single Write call.
+type jsonWriter struct {
+	out     io.Writer
+	started bool
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	var rec jsonRecord
+	if w.started {
+		rec.Chunk = p
+	} else {
+		rec.Format, rec.Header = jsonFormat, p
+		w.started = true
+	}
+	b, err := json.Marshal(&rec)
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.out.Write(append(b, '\n'))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// PrepareJSON is Prepare for files written by SealJSON.
+func PrepareJSON(in io.Reader) (*Openable, error) {
+	return Prepare(&jsonReader{dec: json.NewDecoder(in)}, nil)
+}
+
+// jsonReader yields the contents of the records in turn.
+type jsonReader struct {
+	dec     *json.Decoder
+	started bool
+	buf     []byte
+}
+
+func (r *jsonReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		var rec jsonRecord
+		err := r.dec.Decode(&rec)
+		if err == io.EOF {
+			if !r.started {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, fmt.Errorf("%w: %w", errInvalidJSON, err)
+		}
+		if r.started {
+			if rec.Format != "" || rec.Header != nil || len(rec.Chunk) == 0 {
+				return 0, errInvalidJSON
+			}
+			r.buf = rec.Chunk
+		} else {
+			if rec.Format != jsonFormat || len(rec.Header) == 0 || rec.Chunk != nil {
+				return 0, errInvalidJSON
+			}
+			r.buf = rec.Header
+			r.started = true
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
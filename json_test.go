@@ -0,0 +1,75 @@
+package sealer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealJSON(t *testing.T) {
+	key1, key2 := generateKeyWithID("key1"), generateKeyWithID("key2")
+	original := bytes.Repeat([]byte("hello, world "), 100)
+	for _, keys := range [][]*sealer.Key{{key1}, {key1, key2}} {
+		var buf bytes.Buffer
+		w, err := sealer.SealJSON(&buf, keys, sealer.SealOptions{ChunkSize: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) < 3 {
+			t.Fatalf("%d lines", len(lines))
+		}
+		for i, line := range lines {
+			var rec map[string]any
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("line %d: %v", i, err)
+			}
+			if _, ok := rec["header"]; ok != (i == 0) {
+				t.Errorf("line %d: %s", i, line)
+			}
+		}
+
+		actual, err := openJSON(buf.String(), key1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+
+		// dropping a chunk record
+		dropped := strings.Join(append(lines[:1:1], lines[2:]...), "\n")
+		if _, err := openJSON(dropped, key1); err == nil {
+			t.Errorf("opened with a chunk missing")
+		}
+	}
+
+	for _, input := range []string{"", "{}", `{"chunk":"AAAA"}`, "not json"} {
+		if _, err := sealer.PrepareJSON(strings.NewReader(input)); err == nil {
+			t.Errorf("prepared %q", input)
+		}
+	}
+}
+
+func openJSON(sealed string, key *sealer.Key) ([]byte, error) {
+	opn, err := sealer.PrepareJSON(strings.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
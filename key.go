@@ -0,0 +1,159 @@
+package sealer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andreyvit/sealer/internal/bech32"
+)
+
+// keyHRP is the human-readable part of key strings. Key strings are
+// uppercase like age identities, to set them apart from public recipients.
+const keyHRP = "SEALER-KEY-"
+
+// MarshalText encodes the key (both its ID and material) as a single-line
+// string like SEALER-KEY-1..., using Bech32 with a checksum, so that it's
+// safe to copy, paste and store in configuration files. Treat the string
+// as secret.
+func (key *Key) MarshalText() ([]byte, error) {
+	data := make([]byte, 0, IDSize+KeySize)
+	data = append(data, key.ID[:]...)
+	data = append(data, key.Key[:]...)
+	return []byte(bech32.Encode(keyHRP, data)), nil
+}
+
+// UnmarshalText decodes a key string produced by MarshalText.
+func (key *Key) UnmarshalText(text []byte) error {
+	hrp, data, err := bech32.Decode(string(text))
+	if err != nil || hrp != strings.ToLower(keyHRP) || len(data) != IDSize+KeySize {
+		return ErrMalformedKey
+	}
+	copy(key.ID[:], data[:IDSize])
+	copy(key.Key[:], data[IDSize:])
+	clear(data)
+	return nil
+}
+
+// ParseKey decodes a key string produced by Key.MarshalText. Surrounding
+// whitespace is ignored.
+func ParseKey(s string) (*Key, error) {
+	key := &Key{}
+	err := key.UnmarshalText([]byte(strings.TrimSpace(s)))
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// MaxKeyNameSize is the maximum length of a key name in a versioned key ID.
+const MaxKeyNameSize = IDSize - len(versionedIDMarker) - 4
+
+// Versioned key IDs start with versionedIDMarker, followed by a name padded
+// with zeros to MaxKeyNameSize bytes and a uint32 version in big endian (so
+// that IDs of the same name sort by version). The marker starts with 0xFF,
+// which never occurs in UTF-8, so that plain text IDs are never taken for
+// versioned ones, and random IDs (such as DeriveKeyID) only have a 2^-32
+// chance to start with it. Version 0 is not used.
+const versionedIDMarker = "\xffver"
+
+// VersionedKeyID returns a key ID holding the key name and version, which
+// must be at least 1. It fails if the name is longer than MaxKeyNameSize.
+// Use Keyring.Latest to find the latest version of a key.
+func VersionedKeyID(name string, version uint32) ([IDSize]byte, error) {
+	var id [IDSize]byte
+	if len(name) > MaxKeyNameSize {
+		return id, errKeyNameTooLong
+	}
+	if version == 0 {
+		return id, errors.New("key version must be at least 1")
+	}
+	copy(id[:], versionedIDMarker)
+	copy(id[len(versionedIDMarker):], name)
+	binary.BigEndian.PutUint32(id[IDSize-4:], version)
+	return id, nil
+}
+
+// ParseVersionedKeyID returns the name and version held by a key ID made by
+// VersionedKeyID, or false if the ID is not versioned.
+func ParseVersionedKeyID(id [IDSize]byte) (name string, version uint32, ok bool) {
+	if string(id[:len(versionedIDMarker)]) != versionedIDMarker {
+		return "", 0, false
+	}
+	version = binary.BigEndian.Uint32(id[IDSize-4:])
+	if version == 0 {
+		return "", 0, false
+	}
+	return string(bytes.TrimRight(id[len(versionedIDMarker):IDSize-4], "\x00")), version, true
+}
+
+// KeyOption configures GenerateKey.
+type KeyOption func(*keyOptions)
+
+type keyOptions struct {
+	derivedID bool
+	version   uint32
+	random    io.Reader
+}
+
+// WithDerivedID makes GenerateKey set the key ID to DeriveKeyID of the key
+// material, ignoring the id argument.
+func WithDerivedID() KeyOption {
+	return func(o *keyOptions) {
+		o.derivedID = true
+	}
+}
+
+// WithVersion makes GenerateKey use VersionedKeyID of the id argument (as
+// the key name) and the given version.
+func WithVersion(version uint32) KeyOption {
+	return func(o *keyOptions) {
+		o.version = version
+	}
+}
+
+// WithRandomReader makes GenerateKey read the key material from r instead
+// of crypto/rand.Reader.
+func WithRandomReader(r io.Reader) KeyOption {
+	return func(o *keyOptions) {
+		o.random = r
+	}
+}
+
+// GenerateKey returns a new random key with the given ID. IDs of up to
+// IDSize bytes are padded with zeros, and longer IDs are replaced by their
+// SHA-256 hash.
+func GenerateKey(id string, opts ...KeyOption) (*Key, error) {
+	o := keyOptions{random: rand.Reader}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.version != 0 && len(id) > MaxKeyNameSize {
+		return nil, errKeyNameTooLong
+	}
+
+	key := &Key{}
+	_, err := io.ReadFull(o.random, key.Key[:])
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	if o.derivedID {
+		key.ID = DeriveKeyID(key.Key[:])
+	} else if o.version != 0 {
+		key.ID, err = VersionedKeyID(id, o.version)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(id) <= IDSize {
+		copy(key.ID[:], id)
+	} else {
+		key.ID = sha256.Sum256([]byte(id))
+	}
+	return key, nil
+}
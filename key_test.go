@@ -0,0 +1,94 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestKey_text(t *testing.T) {
+	key := generateKeyWithID("my key")
+	text, err := key.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(text)
+	if !strings.HasPrefix(s, "SEALER-KEY-1") || strings.ContainsAny(s, " \n") {
+		t.Fatalf("MarshalText = %q", s)
+	}
+
+	for _, input := range []string{s, strings.ToLower(s), " " + s + "\n"} {
+		parsed, err := sealer.ParseKey(input)
+		if err != nil {
+			t.Fatalf("ParseKey(%q): %v", input, err)
+		}
+		if *parsed != *key {
+			t.Errorf("ParseKey(%q) returned a different key", input)
+		}
+	}
+
+	// a typo is caught by the checksum
+	typo := []byte(s)
+	if typo[20] == 'Q' {
+		typo[20] = 'P'
+	} else {
+		typo[20] = 'Q'
+	}
+	for _, input := range []string{string(typo), s[:len(s)-1], "AGE-SECRET-KEY-" + s[len("SEALER-KEY-"):], ""} {
+		if _, err := sealer.ParseKey(input); err != sealer.ErrMalformedKey {
+			t.Errorf("ParseKey(%q) = %v, wanted ErrMalformedKey", input, err)
+		}
+	}
+
+	// works in config files
+	var config struct{ Key *sealer.Key }
+	err = json.Unmarshal([]byte(`{"Key": "`+s+`"}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *config.Key != *key {
+		t.Errorf("json.Unmarshal returned a different key")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := sealer.GenerateKey("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantID [sealer.IDSize]byte
+	copy(wantID[:], "backup")
+	if key.ID != wantID {
+		t.Errorf("ID = %q, wanted %q", key.ID, wantID)
+	}
+	if key.Key == [sealer.KeySize]byte{} {
+		t.Errorf("key material is zero")
+	}
+
+	long := strings.Repeat("x", sealer.IDSize+1)
+	key, err = sealer.GenerateKey(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != sha256.Sum256([]byte(long)) {
+		t.Errorf("long ID not hashed")
+	}
+
+	random := bytes.NewReader(bytes.Repeat([]byte{0x42}, sealer.KeySize))
+	key, err = sealer.GenerateKey("ignored", sealer.WithDerivedID(), sealer.WithRandomReader(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key != [sealer.KeySize]byte(bytes.Repeat([]byte{0x42}, sealer.KeySize)) || key.ID != sealer.DeriveKeyID(key.Key[:]) {
+		t.Errorf("GenerateKey with derived ID = %x", *key)
+	}
+
+	_, err = sealer.GenerateKey("", sealer.WithRandomReader(bytes.NewReader(nil)))
+	if err == nil {
+		t.Errorf("GenerateKey with a failing reader succeeded")
+	}
+}
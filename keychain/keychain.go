@@ -0,0 +1,52 @@
+// Package keychain keeps sealer keys in the macOS Keychain, so that desktop
+// apps never have to write raw keys to disk.
+//
+// Keys are stored as generic password items identified by a service and an
+// account name, and are subject to the usual Keychain access control: the
+// user may be asked to allow access the first time another binary reads
+// the item. The package shells out to /usr/bin/security and needs no cgo;
+// it is only functional on darwin.
+package keychain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+)
+
+var (
+	// ErrNotFound is returned when the Keychain has no item for the given
+	// service and account.
+	ErrNotFound = errors.New("keychain: item not found")
+
+	// ErrUnsupported is returned on platforms other than darwin.
+	ErrUnsupported = errors.New("keychain: only supported on macOS")
+
+	errInvalidName = errors.New("keychain: service and account names cannot contain quotes, backslashes or newlines")
+	errInvalidItem = errors.New("keychain: item does not contain a sealer key")
+)
+
+// Keys are stored as hex of ID || Key, so that the item stays printable and
+// is returned verbatim by `security find-generic-password -w`.
+
+func encodeKey(key *sealer.Key) string {
+	return hex.EncodeToString(key.ID[:]) + hex.EncodeToString(key.Key[:])
+}
+
+func decodeKey(s string) (*sealer.Key, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != sealer.IDSize+sealer.KeySize {
+		return nil, errInvalidItem
+	}
+	key := &sealer.Key{}
+	copy(key.ID[:], raw[:sealer.IDSize])
+	copy(key.Key[:], raw[sealer.IDSize:])
+	clear(raw)
+	return key, nil
+}
+
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "\"\\\n\r")
+}
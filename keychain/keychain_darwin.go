@@ -0,0 +1,96 @@
+//go:build darwin
+
+package keychain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/andreyvit/sealer"
+)
+
+const securityTool = "/usr/bin/security"
+
+// errSecItemNotFound is the exit status of `security` when no item matches.
+const errSecItemNotFound = 44
+
+// Save stores key in the login Keychain, replacing any existing item with
+// the same service and account.
+func Save(service, account string, key *sealer.Key) error {
+	if !validName(service) || !validName(account) {
+		return errInvalidName
+	}
+	// commands are fed via stdin in interactive mode so that the key does
+	// not show up in the process list
+	var cmd bytes.Buffer
+	fmt.Fprintf(&cmd, "add-generic-password -U -s \"%s\" -a \"%s\" -w %s\n", service, account, encodeKey(key))
+	c := exec.Command(securityTool, "-i")
+	c.Stdin = &cmd
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("keychain: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	// interactive mode exits with 0 even when a command fails, but still
+	// reports it on stderr
+	if stderr.Len() > 0 {
+		return fmt.Errorf("keychain: %s", bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// Load returns the key stored for the given service and account, or
+// ErrNotFound.
+func Load(service, account string) (*sealer.Key, error) {
+	if !validName(service) || !validName(account) {
+		return nil, errInvalidName
+	}
+	out, err := run(securityTool, "find-generic-password", "-s", service, "-a", account, "-w")
+	if err != nil {
+		return nil, err
+	}
+	defer clear(out)
+	return decodeKey(string(out))
+}
+
+// Delete removes the key stored for the given service and account.
+func Delete(service, account string) error {
+	if !validName(service) || !validName(account) {
+		return errInvalidName
+	}
+	_, err := run(securityTool, "delete-generic-password", "-s", service, "-a", account)
+	return err
+}
+
+// LoadOrGenerate returns the key stored for the given service and account,
+// generating and saving a new random key if there is none yet.
+func LoadOrGenerate(service, account string) (*sealer.Key, error) {
+	key, err := Load(service, account)
+	if !errors.Is(err, ErrNotFound) {
+		return key, err
+	}
+	key = &sealer.Key{}
+	rand.Read(key.ID[:])
+	rand.Read(key.Key[:])
+	if err := Save(service, account, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func run(name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(name, args...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == errSecItemNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("keychain: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
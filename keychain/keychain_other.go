@@ -0,0 +1,29 @@
+//go:build !darwin
+
+package keychain
+
+import "github.com/andreyvit/sealer"
+
+// Save stores key in the login Keychain. It returns ErrUnsupported on this
+// platform.
+func Save(service, account string, key *sealer.Key) error {
+	return ErrUnsupported
+}
+
+// Load returns the key stored for the given service and account. It returns
+// ErrUnsupported on this platform.
+func Load(service, account string) (*sealer.Key, error) {
+	return nil, ErrUnsupported
+}
+
+// Delete removes the key stored for the given service and account. It
+// returns ErrUnsupported on this platform.
+func Delete(service, account string) error {
+	return ErrUnsupported
+}
+
+// LoadOrGenerate returns the key stored for the given service and account.
+// It returns ErrUnsupported on this platform.
+func LoadOrGenerate(service, account string) (*sealer.Key, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,45 @@
+package keychain
+
+import (
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestEncodeKey(t *testing.T) {
+	key := &sealer.Key{}
+	for i := range key.ID {
+		key.ID[i] = byte(i)
+	}
+	for i := range key.Key {
+		key.Key[i] = byte(100 + i)
+	}
+	s := encodeKey(key)
+	if len(s) != 2*(sealer.IDSize+sealer.KeySize) {
+		t.Fatalf("len = %d", len(s))
+	}
+	key2, err := decodeKey(s + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *key2 != *key {
+		t.Errorf("decodeKey = %x, wanted %x", *key2, *key)
+	}
+
+	if _, err := decodeKey(s[2:]); err != errInvalidItem {
+		t.Errorf("decodeKey(short) = %v", err)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, s := range []string{"com.example.app", "backup key 1"} {
+		if !validName(s) {
+			t.Errorf("validName(%q) = false", s)
+		}
+	}
+	for _, s := range []string{"", `a"b`, `a\b`, "a\nb"} {
+		if validName(s) {
+			t.Errorf("validName(%q) = true", s)
+		}
+	}
+}
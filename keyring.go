@@ -0,0 +1,83 @@
+package sealer
+
+import (
+	"crypto/subtle"
+)
+
+// Keyring holds a set of keys and picks the right one to open a file.
+// Key ID lookups take the same time regardless of which key (if any)
+// matches, so that timing does not reveal which keys the keyring holds.
+//
+// The zero Keyring is empty and ready to use.
+type Keyring struct {
+	keys []*Key
+}
+
+// NewKeyring returns a keyring holding the given keys.
+func NewKeyring(keys ...*Key) *Keyring {
+	kr := &Keyring{}
+	for _, key := range keys {
+		kr.Add(key)
+	}
+	return kr
+}
+
+// Add adds a key to the keyring, replacing any key with the same ID.
+func (kr *Keyring) Add(key *Key) {
+	if i := kr.index(key.ID); i >= 0 {
+		kr.keys[i] = key
+	} else {
+		kr.keys = append(kr.keys, key)
+	}
+}
+
+// Len returns the number of keys in the keyring.
+func (kr *Keyring) Len() int {
+	return len(kr.keys)
+}
+
+// Lookup returns the key with the given ID, or nil if there's none.
+func (kr *Keyring) Lookup(id [IDSize]byte) *Key {
+	if i := kr.index(id); i >= 0 {
+		return kr.keys[i]
+	}
+	return nil
+}
+
+// Latest returns the key with the highest version among the keys with
+// versioned IDs (see VersionedKeyID) of the given name, or nil if there are
+// none. Seal new files with the latest key, and keep the older versions in
+// the keyring to open existing files.
+func (kr *Keyring) Latest(name string) *Key {
+	var latest *Key
+	var latestVersion uint32
+	for _, key := range kr.keys {
+		n, v, ok := ParseVersionedKeyID(key.ID)
+		if ok && n == name && v > latestVersion {
+			latest, latestVersion = key, v
+		}
+	}
+	return latest
+}
+
+func (kr *Keyring) index(id [IDSize]byte) int {
+	found := -1
+	for i, key := range kr.keys {
+		eq := subtle.ConstantTimeCompare(key.ID[:], id[:])
+		found = subtle.ConstantTimeSelect(eq, i, found)
+	}
+	return found
+}
+
+// OpenWithKeyring opens the file using the first key slot whose key is
+// found in the keyring. Anonymous slots (see SealOptions.Anonymous) are
+// tried with every key of the keyring.
+func (opn *Openable) OpenWithKeyring(kr *Keyring) (*Reader, error) {
+	r, err := opn.OpenWithKeyFunc(func(id [IDSize]byte) (*Key, error) {
+		return kr.Lookup(id), nil
+	})
+	if err == ErrNoMatchingKey && opn.hasAnonymousKeySlots() {
+		r, _, err = opn.OpenAny(kr.keys...)
+	}
+	return r, err
+}
@@ -0,0 +1,232 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestKeyring(t *testing.T) {
+	a, b, c := generateKeyWithID("A"), generateKeyWithID("B"), generateKeyWithID("C")
+	kr := sealer.NewKeyring(a, b)
+
+	if k := kr.Lookup(a.ID); k != a {
+		t.Errorf("Lookup(A) = %v, wanted A", k)
+	}
+	if k := kr.Lookup(c.ID); k != nil {
+		t.Errorf("Lookup(C) = %v, wanted nil", k)
+	}
+
+	b2 := generateKeyWithID("B")
+	kr.Add(b2)
+	if kr.Len() != 2 {
+		t.Errorf("Len = %d, wanted 2", kr.Len())
+	}
+	if k := kr.Lookup(b.ID); k != b2 {
+		t.Errorf("Lookup(B) did not return the replacement key")
+	}
+
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{c, b2}, nil, sealer.SealOptions{}, original)
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.OpenWithKeyring(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.OpenWithKeyring(sealer.NewKeyring(a))
+	if err != sealer.ErrNoMatchingKey {
+		t.Fatalf("got %v, wanted %v", err, sealer.ErrNoMatchingKey)
+	}
+}
+
+func TestOpenWithKeyFunc(t *testing.T) {
+	a, b := generateKeyWithID("A"), generateKeyWithID("B")
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{a, b}, nil, sealer.SealOptions{}, original)
+
+	var requested []string
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.OpenWithKeyFunc(func(id [sealer.IDSize]byte) (*sealer.Key, error) {
+		requested = append(requested, string(bytes.TrimRight(id[:], "\x00")))
+		if id == b.ID {
+			return b, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+	if len(requested) != 2 || requested[0] != "A" || requested[1] != "B" {
+		t.Fatalf("requested keys %q, wanted [A B]", requested)
+	}
+
+	errLookup := errors.New("lookup failed")
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.OpenWithKeyFunc(func(id [sealer.IDSize]byte) (*sealer.Key, error) {
+		return nil, errLookup
+	})
+	if err != errLookup {
+		t.Fatalf("got %v, wanted %v", err, errLookup)
+	}
+}
+
+func TestOpenAny(t *testing.T) {
+	oldKey, newKey := generateKeyWithID("old"), generateKeyWithID("new")
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{oldKey, generateKeyWithID("other")}, nil, sealer.SealOptions{}, original)
+
+	// same key material, but the ID has been reassigned
+	renamed := *oldKey
+	copy(renamed.ID[:], "renamed")
+
+	for _, keys := range [][]*sealer.Key{{newKey, oldKey}, {newKey, &renamed}} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, key, err := opn.OpenAny(keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != keys[1] {
+			t.Errorf("OpenAny returned key %q, wanted %q", key.ID[:], keys[1].ID[:])
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = opn.OpenAny(newKey)
+	if err == nil {
+		t.Fatal("OpenAny succeeded with a wrong key")
+	}
+}
+
+func TestKeyring_Latest(t *testing.T) {
+	v1, err := sealer.GenerateKey("invoices", sealer.WithVersion(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v3, err := sealer.GenerateKey("invoices", sealer.WithVersion(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := sealer.GenerateKey("invoices", sealer.WithVersion(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sealer.GenerateKey("invoicesX", sealer.WithVersion(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := sealer.NewKeyring(v1, v3, v2, other, generateKeyWithID("invoices"))
+
+	if k := kr.Latest("invoices"); k != v3 {
+		t.Errorf("Latest = %q, wanted version 3", k.ID[:])
+	}
+	if k := kr.Latest("reports"); k != nil {
+		t.Errorf("Latest of an unknown name = %q, wanted nil", k.ID[:])
+	}
+
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{v1}, nil, sealer.SealOptions{}, original)
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.OpenWithKeyring(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+}
+
+func TestVersionedKeyID(t *testing.T) {
+	id, err := sealer.VersionedKeyID("invoices", 0x01020304)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(id[:], []byte("\xffverinvoices\x00")) || !bytes.HasSuffix(id[:], []byte{1, 2, 3, 4}) {
+		t.Errorf("VersionedKeyID = %x", id)
+	}
+	name, version, ok := sealer.ParseVersionedKeyID(id)
+	if !ok || name != "invoices" || version != 0x01020304 {
+		t.Errorf("ParseVersionedKeyID = %q, %d, %v", name, version, ok)
+	}
+	_, _, ok = sealer.ParseVersionedKeyID(generateKeyWithID("invoices").ID)
+	if ok {
+		t.Errorf("plain ID parsed as versioned")
+	}
+	// a 32-byte name used to end with a non-zero "version"
+	_, _, ok = sealer.ParseVersionedKeyID(generateKeyWithID("invoices-2024-q1-eu-west-archive").ID)
+	if ok {
+		t.Errorf("plain 32-byte ID parsed as versioned")
+	}
+	for range 100 {
+		key, err := sealer.GenerateKey("", sealer.WithDerivedID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, version, ok := sealer.ParseVersionedKeyID(key.ID); ok {
+			t.Errorf("derived ID %x parsed as versioned: %q, %d", key.ID, name, version)
+		}
+	}
+
+	_, err = sealer.GenerateKey(strings.Repeat("x", sealer.MaxKeyNameSize+1), sealer.WithVersion(1))
+	if err == nil {
+		t.Errorf("GenerateKey with a long versioned name succeeded")
+	}
+	if _, err := sealer.VersionedKeyID(strings.Repeat("x", sealer.MaxKeyNameSize+1), 1); err == nil {
+		t.Errorf("VersionedKeyID with a long name succeeded")
+	}
+	if _, err := sealer.VersionedKeyID("invoices", 0); err == nil {
+		t.Errorf("VersionedKeyID with version 0 succeeded")
+	}
+}
@@ -0,0 +1,113 @@
+package sealer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Key file format: keyFileMagic as the outer prefix, followed by an envelope
+// sealed to the passphrase (using the default Argon2id parameters) holding
+// the key ID and key material.
+const keyFileMagic = "SEALER KEY FILE v1\n"
+
+// ErrNotKeyFile is returned by LoadKey for files that aren't key files.
+var ErrNotKeyFile = errors.New("not a sealer key file")
+
+// SaveKey writes the key to a file at path, encrypted with a key derived
+// from the passphrase with Argon2id. The file is replaced atomically and is
+// only readable by the owner.
+func SaveKey(path string, key *Key, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+
+	var buf bytes.Buffer
+	w, err := Seal(&buf, nil, []byte(keyFileMagic), SealOptions{Passphrase: passphrase})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(key.ID[:])
+	if err == nil {
+		_, err = w.Write(key.Key[:])
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	err = f.Chmod(0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
+
+// LoadKey reads a key file written by SaveKey.
+func LoadKey(path string, passphrase []byte) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(keyFileMagic))
+	_, err = io.ReadFull(f, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && string(magic) != keyFileMagic) {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotKeyFile)
+	} else if err != nil {
+		return nil, err
+	}
+
+	opn, err := Prepare(f, magic)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r, err := opn.OpenPassphrase(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var data [IDSize + KeySize + 1]byte
+	n, err := io.ReadFull(r, data[:])
+	if err != io.ErrUnexpectedEOF || n != IDSize+KeySize {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotKeyFile)
+	}
+	key := &Key{}
+	copy(key.ID[:], data[:IDSize])
+	copy(key.Key[:], data[IDSize:])
+	clear(data[:])
+	return key, nil
+}
@@ -0,0 +1,68 @@
+package sealer_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSaveKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.key")
+	key := generateKeyWithID("master")
+	passphrase := []byte("correct horse battery staple")
+
+	err := sealer.SaveKey(path, key, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("key file mode = %v, wanted 0600", fi.Mode().Perm())
+		}
+	}
+
+	loaded, err := sealer.LoadKey(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *key {
+		t.Errorf("LoadKey returned a different key")
+	}
+
+	_, err = sealer.LoadKey(path, []byte("wrong"))
+	if err == nil {
+		t.Errorf("LoadKey with a wrong passphrase succeeded")
+	}
+
+	// overwriting leaves no temporary files behind
+	err = sealer.SaveKey(path, generateKeyWithID("another"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, wanted 1", len(entries))
+	}
+
+	other := filepath.Join(dir, "other")
+	err = os.WriteFile(other, []byte("hello"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sealer.LoadKey(other, passphrase)
+	if !errors.Is(err, sealer.ErrNotKeyFile) {
+		t.Errorf("LoadKey(other) = %v, wanted ErrNotKeyFile", err)
+	}
+}
@@ -0,0 +1,48 @@
+package sealer
+
+import (
+	"unsafe"
+
+	"github.com/andreyvit/sealer/internal/memlock"
+)
+
+// ErrLockedMemoryUnsupported is returned by NewLockedKey and LockMemory on
+// platforms other than Linux and macOS.
+var ErrLockedMemoryUnsupported = memlock.ErrUnsupported
+
+// NewLockedKey returns a zero Key allocated in memory that is locked into
+// RAM, so that the key never ends up in swap (or in a hibernation file),
+// and that is surrounded by inaccessible guard pages. Fill in the key and
+// call Destroy when it's no longer needed.
+//
+// Locked memory is only supported on Linux and macOS, and is limited by
+// RLIMIT_MEMLOCK; each key takes up a page of it.
+func NewLockedKey() (*Key, error) {
+	b, err := memlock.Alloc(int(unsafe.Sizeof(Key{})))
+	if err != nil {
+		return nil, err
+	}
+	return (*Key)(unsafe.Pointer(unsafe.SliceData(b))), nil
+}
+
+// Destroy zeroes the key. For keys returned by NewLockedKey, it also
+// releases their memory, and any further use of the key will crash.
+func (key *Key) Destroy() {
+	b := unsafe.Slice((*byte)(unsafe.Pointer(key)), unsafe.Sizeof(*key))
+	if !memlock.Free(b) {
+		clear(b)
+	}
+}
+
+// LockMemory locks all current and future memory of the process into RAM.
+// NewLockedKey only protects the keys you hold, while the file keys and
+// cipher states are kept by this package in ordinary memory; call
+// LockMemory at startup if those must never be swapped out either.
+//
+// The process must be allowed to lock all of its memory, e.g. by running
+// with CAP_IPC_LOCK or an unlimited RLIMIT_MEMLOCK on Linux, otherwise
+// LockMemory (or later allocations) will fail. Only supported on Linux and
+// macOS.
+func LockMemory() error {
+	return memlock.LockAll()
+}
@@ -0,0 +1,50 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestNewLockedKey(t *testing.T) {
+	key, err := sealer.NewLockedKey()
+	if err == sealer.ErrLockedMemoryUnsupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+
+	if *key != (sealer.Key{}) {
+		t.Fatalf("locked key is not zero")
+	}
+	*key = *generateKeyWithID("locked")
+
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, original)
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("got %q, wanted %q", actual, original)
+	}
+}
+
+func TestKey_Destroy(t *testing.T) {
+	key := generateKeyWithID("plain")
+	key.Destroy()
+	if *key != (sealer.Key{}) {
+		t.Errorf("Destroy did not zero the key")
+	}
+}
@@ -0,0 +1,45 @@
+package sealer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Magic is written before the header when SealOptions.Magic is set, so that
+// sealed files can be recognized (see DetectSealed and Sniff). Like PNG's
+// signature, it starts with a non-ASCII byte, and includes CR LF, ^Z and LF
+// to detect newline conversion and truncation by text-mode transfers. Its
+// first four bytes are not a valid header version, so Prepare tells files
+// with and without it apart.
+const Magic = "\x89SLR\r\n\x1a\n"
+
+// SniffLen is the number of bytes Sniff reads to find Magic, and thus the
+// longest outer prefix it can see past.
+const SniffLen = 512
+
+// DetectSealed reports whether data, the first bytes of a file, holds Magic,
+// either at the very start or after an outer prefix.
+func DetectSealed(data []byte) bool {
+	return bytes.Contains(data, []byte(Magic))
+}
+
+// Sniff reads up to SniffLen bytes from r to find Magic, and returns the
+// length of the outer prefix that precedes it, or -1 if r doesn't look like
+// a sealed file, and a reader that yields all of r, including the bytes read.
+//
+// The outer prefix is not authenticated at this point, and Magic may occur
+// in it by chance, so treat the result as a hint.
+func Sniff(r io.Reader) (int, io.Reader, error) {
+	buf := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	buf = buf[:n]
+	mr := io.MultiReader(bytes.NewReader(buf), r)
+	if err != nil {
+		return -1, mr, err
+	}
+	return bytes.Index(buf, []byte(Magic)), mr, nil
+}
@@ -0,0 +1,73 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSniff(t *testing.T) {
+	key1, key2 := generateKeyWithID("key1"), generateKeyWithID("key2")
+	original := []byte("hello, world")
+	outer := []byte("OUTER")
+
+	plain := seal(t, []*sealer.Key{key1}, outer, sealer.SealOptions{}, original)
+	if sealer.DetectSealed(plain) {
+		t.Errorf("detected a file sealed without magic")
+	}
+	if n, _, err := sealer.Sniff(bytes.NewReader(plain)); n != -1 || err != nil {
+		t.Errorf("Sniff = %d, %v, wanted -1", n, err)
+	}
+
+	sealed := seal(t, []*sealer.Key{key1}, outer, sealer.SealOptions{Magic: true}, original)
+	if !sealer.DetectSealed(sealed[:64]) {
+		t.Errorf("didn't detect a file sealed with magic")
+	}
+	outerLen, r, err := sealer.Sniff(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outerLen != len(outer) {
+		t.Fatalf("Sniff = %d, wanted %d", outerLen, len(outer))
+	}
+	prefix := make([]byte, outerLen)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		t.Fatal(err)
+	}
+	opn, err := sealer.Prepare(r, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resealed bytes.Buffer
+	if err := opn.Reseal(&resealed, key1, []*sealer.Key{key2}, sealer.SealOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		sealed []byte
+		key    *sealer.Key
+	}{
+		{sealed, key1},
+		{resealed.Bytes(), key2},
+	} {
+		if !bytes.HasPrefix(tc.sealed[len(outer):], []byte(sealer.Magic)) {
+			t.Errorf("no magic after the outer prefix")
+		}
+		actual, err := openSealed(tc.sealed[len(outer):], outer, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	// magic is authenticated
+	tampered := bytes.Clone(sealed)
+	tampered[len(outer)+len(sealer.Magic)-1] ^= 1
+	if _, err := openSealed(tampered[len(outer):], outer, key1); err == nil {
+		t.Errorf("opened a file with tampered magic")
+	}
+}
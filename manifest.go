@@ -0,0 +1,191 @@
+package sealer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrManifestMismatch is returned by Manifest.Verify when the file has been
+// modified or damaged since the manifest has been made.
+var ErrManifestMismatch = errors.New("sealed file does not match its manifest")
+
+// ErrInvalidManifest is returned when parsing a malformed manifest.
+var ErrInvalidManifest = errors.New("invalid sealed file manifest")
+
+// Manifest lists the SHA-256 hashes of the header and of every chunk of
+// a sealed file, as stored. Storage systems can keep it next to the file
+// and audit the file for bit rot with Verify, which needs no key, and
+// pinpoints the damaged chunks.
+//
+// Get a manifest while sealing with SealOptions.Manifest, or for an
+// existing file with NewManifest. Resealing rewrites the header, so make
+// a new manifest afterwards.
+type Manifest struct {
+	// Header is the hash of the header, from the end of the outer prefix
+	// to the first chunk.
+	Header [sha256.Size]byte
+
+	// Chunks are the hashes of the chunks, headers included.
+	Chunks [][sha256.Size]byte
+}
+
+// Manifest format:
+//  - version         uint32 (1)
+//  - chunkCount      uint32
+//  - header          [sha256.Size]byte
+//  - chunks          [chunkCount][sha256.Size]byte
+
+const manifestVersion = 1
+
+// NewManifest reads a sealed file from in, which starts right after the
+// outer prefix, and returns its manifest. The file is not decrypted, so it
+// isn't authenticated either; make manifests of trusted files only.
+func NewManifest(in io.Reader) (*Manifest, error) {
+	hdr, prefix, err := readHeader(in, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Header: sha256.Sum256(prefix)}
+	err = hdr.walkChunks(in, func(index int, chunk []byte) error {
+		m.Chunks = append(m.Chunks, sha256.Sum256(chunk))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParseManifest loads a manifest returned by Manifest.MarshalBinary.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := new(Manifest)
+	err := m.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (m *Manifest) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 8+(1+len(m.Chunks))*sha256.Size)
+	buf = binary.LittleEndian.AppendUint32(buf, manifestVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Chunks)))
+	buf = append(buf, m.Header[:]...)
+	for _, h := range m.Chunks {
+		buf = append(buf, h[:]...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (m *Manifest) UnmarshalBinary(data []byte) error {
+	if len(data) < 8+sha256.Size || binary.LittleEndian.Uint32(data) != manifestVersion {
+		return ErrInvalidManifest
+	}
+	count := int(binary.LittleEndian.Uint32(data[4:]))
+	data = data[8:]
+	if len(data) != (1+count)*sha256.Size {
+		return ErrInvalidManifest
+	}
+	m.Header = [sha256.Size]byte(data)
+	m.Chunks = make([][sha256.Size]byte, count)
+	for i := range m.Chunks {
+		data = data[sha256.Size:]
+		m.Chunks[i] = [sha256.Size]byte(data)
+	}
+	return nil
+}
+
+// Verify reads a sealed file from in, which starts right after the outer
+// prefix, and checks it against the manifest. It returns an error wrapping
+// ErrManifestMismatch that names the first damaged part of the file.
+func (m *Manifest) Verify(in io.Reader) error {
+	hdr, prefix, err := readHeader(in, nil)
+	if err != nil {
+		return fmt.Errorf("%w: header: %w", ErrManifestMismatch, err)
+	}
+	if sha256.Sum256(prefix) != m.Header {
+		return fmt.Errorf("%w: header", ErrManifestMismatch)
+	}
+	var count int
+	err = hdr.walkChunks(in, func(index int, chunk []byte) error {
+		if index >= len(m.Chunks) {
+			return fmt.Errorf("%w: extra chunk %d", ErrManifestMismatch, index)
+		}
+		if sha256.Sum256(chunk) != m.Chunks[index] {
+			return fmt.Errorf("%w: chunk %d", ErrManifestMismatch, index)
+		}
+		count++
+		return nil
+	})
+	if errors.Is(err, ErrManifestMismatch) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("%w: chunk %d: %w", ErrManifestMismatch, count, err)
+	}
+	if count != len(m.Chunks) {
+		return fmt.Errorf("%w: missing chunks after %d", ErrManifestMismatch, count)
+	}
+	return nil
+}
+
+// walkChunks reads the chunks of the file from in, without decrypting them,
+// and calls f with each. It stops after the final chunk, and fails if
+// anything follows it.
+func (h *header) walkChunks(in io.Reader, f func(index int, chunk []byte) error) error {
+	hdrLen := h.chunkHeaderLen()
+	buf := make([]byte, hdrLen+h.chunkSize+overhead)
+	for index := 0; ; index++ {
+		var n int
+		var err error
+		if h.framed {
+			if hasSyncBefore(h.syncInterval, uint32(index)) {
+				// markers can only be authenticated with the key
+				var marker [SyncMarkerSize]byte
+				_, err = io.ReadFull(in, marker[:])
+				if err == nil && string(marker[:len(SyncMarker)]) != SyncMarker {
+					return fmt.Errorf("%w: no sync marker before chunk %d", ErrCorrupted, index)
+				}
+			}
+			if err == nil {
+				_, err = io.ReadFull(in, buf[:hdrLen])
+			}
+			if err == nil {
+				size := int(binary.LittleEndian.Uint32(buf[chunkHeaderSize:]))
+				if size > h.chunkSize {
+					return fmt.Errorf("%w: chunk %d has size %d", ErrCorrupted, index, size)
+				}
+				n = hdrLen + size + overhead
+				_, err = io.ReadFull(in, buf[hdrLen:n])
+			}
+		} else {
+			n, err = io.ReadFull(in, buf)
+			if err == io.ErrUnexpectedEOF && n >= hdrLen+overhead {
+				err = nil
+			}
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		err = f(index, buf[:n])
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf) == finalChunkIndex {
+			break
+		}
+	}
+	var probe [1]byte
+	if _, err := io.ReadFull(in, probe[:]); err == nil {
+		return fmt.Errorf("%w: data after the final chunk", ErrCorrupted)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestManifest(t *testing.T) {
+	key1, key2 := generateKeyWithID("key1"), generateKeyWithID("key2")
+	outer := []byte("OUTER")
+	original := randomBytes(t, 1000)
+
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, Magic: true},
+		{Compression: sealer.CompressionNone, RandomNonces: true, RecordSize: true},
+	} {
+		var m sealer.Manifest
+		opt.ChunkSize = 100
+		opt.Manifest = &m
+		sealed := seal(t, []*sealer.Key{key1}, outer, opt, original)
+		file := sealed[len(outer):]
+		if len(m.Chunks) < 10 {
+			t.Fatalf("manifest has %d chunks", len(m.Chunks))
+		}
+
+		m2, err := sealer.NewManifest(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m2, &m) {
+			t.Errorf("NewManifest doesn't match SealOptions.Manifest")
+		}
+		data, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m3, err := sealer.ParseManifest(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m3, &m) {
+			t.Errorf("ParseManifest doesn't match MarshalBinary")
+		}
+		if err := m3.Verify(bytes.NewReader(file)); err != nil {
+			t.Fatal(err)
+		}
+
+		damaged := bytes.Clone(file)
+		damaged[len(damaged)-150]++
+		err = m.Verify(bytes.NewReader(damaged))
+		if !errors.Is(err, sealer.ErrManifestMismatch) || !strings.Contains(err.Error(), "chunk") {
+			t.Errorf("Verify of a damaged file = %v", err)
+		}
+		err = m.Verify(bytes.NewReader(file[:len(file)-1]))
+		if !errors.Is(err, sealer.ErrManifestMismatch) {
+			t.Errorf("Verify of a truncated file = %v", err)
+		}
+
+		if opt.Magic {
+			continue
+		}
+		opn, err := sealer.Prepare(bytes.NewReader(file), outer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resealed bytes.Buffer
+		if err := opn.Reseal(&resealed, key1, []*sealer.Key{key2}, sealer.SealOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		err = m.Verify(bytes.NewReader(resealed.Bytes()[len(outer):]))
+		if !errors.Is(err, sealer.ErrManifestMismatch) || !strings.Contains(err.Error(), "header") {
+			t.Errorf("Verify of a resealed file = %v", err)
+		}
+	}
+
+	if _, err := sealer.ParseManifest([]byte("garbage")); err != sealer.ErrInvalidManifest {
+		t.Errorf("ParseManifest = %v, wanted ErrInvalidManifest", err)
+	}
+}
@@ -0,0 +1,61 @@
+package sealer
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+)
+
+// Master key slot data:
+//  - salt            [masterSaltSize]byte
+//  - check           [masterCheckSize]byte
+//
+// The file key followed by check is HKDF-SHA256 of the master key with
+// the salt, so each file still gets its own key, and check lets the opener
+// tell a wrong master key from a corrupted file.
+
+const (
+	masterSaltSize  = 32
+	masterCheckSize = 16
+	masterSlotSize  = masterSaltSize + masterCheckSize
+
+	masterKeyInfo = "github.com/andreyvit/sealer master key"
+)
+
+var errMasterKeyMismatch = fmt.Errorf("%w: master key does not match", ErrWrongKey)
+
+// deriveFileKey generates a random salt and returns the file key derived
+// from master along with the slot that lets master open the file.
+func deriveFileKey(master *Key, random io.Reader) ([]byte, Slot, error) {
+	data := make([]byte, masterSlotSize)
+	_, err := io.ReadFull(random, data[:masterSaltSize])
+	if err != nil {
+		return nil, Slot{}, fmt.Errorf("generating salt: %w", err)
+	}
+	okm := deriveMaster(master, data[:masterSaltSize])
+	copy(data[masterSaltSize:], okm[KeySize:])
+	return okm[:KeySize:KeySize], Slot{Type: SlotTypeMasterKey, KeyID: master.ID, Data: data}, nil
+}
+
+func decapsulateMaster(output []byte, key *Key, s *Slot) error {
+	if len(s.Data) != masterSlotSize {
+		return ErrUnsupportedVersion
+	}
+	okm := deriveMaster(key, s.Data[:masterSaltSize])
+	defer clear(okm)
+	if subtle.ConstantTimeCompare(okm[KeySize:], s.Data[masterSaltSize:]) != 1 {
+		return errMasterKeyMismatch
+	}
+	copy(output, okm[:KeySize])
+	return nil
+}
+
+func deriveMaster(master *Key, salt []byte) []byte {
+	okm, err := hkdf.Key(sha256.New, master.Key[:], salt, masterKeyInfo, KeySize+masterCheckSize)
+	if err != nil {
+		panic(err)
+	}
+	return okm
+}
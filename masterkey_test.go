@@ -0,0 +1,84 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_masterKey(t *testing.T) {
+	master := generateKeyWithID("master")
+	backup := generateKeyWithID("backup")
+	original := []byte("hello, world")
+
+	sealed1 := seal(t, []*sealer.Key{backup}, nil, sealer.SealOptions{MasterKey: master}, original)
+	sealed2 := seal(t, nil, nil, sealer.SealOptions{MasterKey: master}, original)
+
+	for _, tc := range []struct {
+		sealed []byte
+		key    *sealer.Key
+	}{
+		{sealed1, master},
+		{sealed1, backup},
+		{sealed2, master},
+	} {
+		opn, err := sealer.Prepare(bytes.NewReader(tc.sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.OpenWithKeyring(sealer.NewKeyring(tc.key))
+		if err != nil {
+			t.Fatalf("opening with %q: %v", tc.key.ID[:6], err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	// wrong key material with the right ID
+	impostor := generateKeyWithID("master")
+	opn, err := sealer.Prepare(bytes.NewReader(sealed2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.Open(impostor); err == nil {
+		t.Errorf("opened with a wrong master key")
+	}
+
+	// reseal from a master key to a regular key and back
+	var buf bytes.Buffer
+	err = opn.Reseal(&buf, master, []*sealer.Key{backup}, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn, err = sealer.Prepare(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf2 bytes.Buffer
+	err = opn.Reseal(&buf2, backup, nil, sealer.SealOptions{MasterKey: master})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn, err = sealer.Prepare(bytes.NewReader(buf2.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Errorf("resealed: got %q, wanted %q", actual, original)
+	}
+}
@@ -0,0 +1,186 @@
+package sealer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// ErrNoMerkleRoot is returned by Reader.MerkleRoot when the file doesn't
+// record a Merkle root, or the root can't be read before the end of the
+// file (see Reader.Size).
+var ErrNoMerkleRoot = errors.New("sealed file has no Merkle root")
+
+// Files sealed with SealOptions.MerkleRoot record in their trailer the root
+// of a Merkle tree over the chunks as stored, the trailer chunk excluded.
+// The tree is the one of RFC 6962 (Certificate Transparency): a leaf is the
+// SHA-256 of a zero byte and the chunk, a node is the SHA-256 of a one byte
+// and its children, and a tree of n leaves is split into a complete tree
+// of the largest power of two below n leaves and the rest.
+//
+// Somebody holding the key reads the root from the trailer, which
+// authenticates it, and publishes it. Anybody can then check that a chunk
+// they have downloaded is intact given its inclusion proof (see MerkleTree),
+// without downloading or decrypting the rest of the file.
+
+// MerkleTree is the Merkle tree over the chunks of a sealed file, which is
+// built from the file without a key, and provides inclusion proofs of the
+// chunks. To prove that a byte range of the file is intact, prove every
+// chunk it overlaps.
+type MerkleTree struct {
+	// Leaves are the leaf hashes of the chunks.
+	Leaves [][sha256.Size]byte
+}
+
+// NewMerkleTree reads a sealed file from in, which starts right after the
+// outer prefix, and builds the Merkle tree over its chunks.
+func NewMerkleTree(in io.Reader) (*MerkleTree, error) {
+	hdr, _, err := readHeader(in, nil)
+	if err != nil {
+		return nil, err
+	}
+	t := new(MerkleTree)
+	err = hdr.walkChunks(in, func(index int, chunk []byte) error {
+		t.Leaves = append(t.Leaves, merkleLeaf(chunk))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hdr.trailerSize != 0 {
+		t.Leaves = t.Leaves[:len(t.Leaves)-1]
+	}
+	return t, nil
+}
+
+// Root returns the root hash of the tree.
+func (t *MerkleTree) Root() [sha256.Size]byte {
+	return merkleTreeHash(t.Leaves)
+}
+
+// Proof returns the inclusion proof of the chunk with the given index.
+func (t *MerkleTree) Proof(index int) [][sha256.Size]byte {
+	if index < 0 || index >= len(t.Leaves) {
+		return nil
+	}
+	return merklePath(index, t.Leaves)
+}
+
+// VerifyMerkleProof checks that chunk, as stored, is the chunk with the given
+// index in a file of count chunks (trailer excluded) with the given Merkle
+// root, given its inclusion proof returned by MerkleTree.Proof.
+func VerifyMerkleProof(root [sha256.Size]byte, index, count int, chunk []byte, proof [][sha256.Size]byte) bool {
+	if index < 0 || index >= count {
+		return false
+	}
+	// RFC 9162, section 2.1.3.2
+	fn, sn := index, count-1
+	r := merkleLeaf(chunk)
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNode(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r == root
+}
+
+// MerkleRoot returns the Merkle root recorded in the trailer (see
+// SealOptions.MerkleRoot), reading the trailer early like Size does, or
+// ErrNoMerkleRoot. The root is verified against the chunks once the file
+// has been read to the end.
+func (r *Reader) MerkleRoot() ([]byte, error) {
+	if r.dec.merkle == nil {
+		return nil, ErrNoMerkleRoot
+	}
+	t, err := r.peekTrailer()
+	if err == errTrailerUnavailable {
+		return nil, ErrNoMerkleRoot
+	} else if err != nil {
+		return nil, err
+	}
+	if t.merkleRoot == nil {
+		return nil, errBadTrailer
+	}
+	return t.merkleRoot, nil
+}
+
+// merkleBuilder computes the Merkle tree hash of chunks added one by one,
+// keeping the roots of the complete subtrees built so far.
+type merkleBuilder struct {
+	hashes [][sha256.Size]byte
+	sizes  []int
+}
+
+func (b *merkleBuilder) add(chunk []byte) {
+	h, size := merkleLeaf(chunk), 1
+	for n := len(b.sizes); n > 0 && b.sizes[n-1] == size; n-- {
+		h, size = merkleNode(b.hashes[n-1], h), 2*size
+		b.hashes, b.sizes = b.hashes[:n-1], b.sizes[:n-1]
+	}
+	b.hashes, b.sizes = append(b.hashes, h), append(b.sizes, size)
+}
+
+func (b *merkleBuilder) root() [sha256.Size]byte {
+	if len(b.hashes) == 0 {
+		return sha256.Sum256(nil)
+	}
+	h := b.hashes[len(b.hashes)-1]
+	for i := len(b.hashes) - 2; i >= 0; i-- {
+		h = merkleNode(b.hashes[i], h)
+	}
+	return h
+}
+
+func merkleLeaf(chunk []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(chunk)
+	return [sha256.Size]byte(h.Sum(nil))
+}
+
+func merkleNode(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var buf [1 + 2*sha256.Size]byte
+	buf[0] = 1
+	copy(buf[1:], left[:])
+	copy(buf[1+sha256.Size:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleSplit returns the largest power of two below n, for n > 1.
+func merkleSplit(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+func merkleTreeHash(leaves [][sha256.Size]byte) [sha256.Size]byte {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNode(merkleTreeHash(leaves[:k]), merkleTreeHash(leaves[k:]))
+}
+
+func merklePath(index int, leaves [][sha256.Size]byte) [][sha256.Size]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(merklePath(index, leaves[:k]), merkleTreeHash(leaves[k:]))
+	}
+	return append(merklePath(index-k, leaves[k:]), merkleTreeHash(leaves[:k]))
+}
@@ -0,0 +1,97 @@
+package sealer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestMerkleTree(t *testing.T) {
+	key, master := generateKeyWithID("key"), generateKeyWithID("master")
+	for _, size := range []int{0, 1, 99, 100, 101, 250, 1000, 1234} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			original := randomBytes(t, size)
+			opt := sealer.SealOptions{MerkleRoot: true, ChunkSize: 100, Compression: sealer.CompressionNone, MasterKey: master}
+			sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+			tree := checkMerkleRoot(t, sealed, key)
+			if want := (size + 99) / 100; len(tree.Leaves) != want {
+				t.Errorf("tree has %d leaves, wanted %d", len(tree.Leaves), want)
+			}
+
+			chunks := chunksOf(t, sealed)
+			root := tree.Root()
+			for i, chunk := range chunks {
+				proof := tree.Proof(i)
+				if !sealer.VerifyMerkleProof(root, i, len(chunks), chunk, proof) {
+					t.Errorf("proof of chunk %d doesn't verify", i)
+				}
+				damaged := bytes.Clone(chunk)
+				damaged[len(damaged)-1]++
+				if sealer.VerifyMerkleProof(root, i, len(chunks), damaged, proof) {
+					t.Errorf("proof of damaged chunk %d verifies", i)
+				}
+				if len(chunks) > 1 && sealer.VerifyMerkleProof(root, (i+1)%len(chunks), len(chunks), chunk, proof) {
+					t.Errorf("proof of chunk %d verifies at another index", i)
+				}
+			}
+
+			// re-encrypting the chunks recomputes the root
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = opn.Reseal(&buf, key, nil, sealer.SealOptions{MasterKey: master})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resealed := checkMerkleRoot(t, buf.Bytes(), master)
+			if len(chunks) > 0 && resealed.Root() == root {
+				t.Errorf("root hasn't changed")
+			}
+		})
+	}
+}
+
+// checkMerkleRoot checks that the Merkle root read by Reader matches the one
+// computed without a key, and returns the tree.
+func checkMerkleRoot(t testing.TB, sealed []byte, key *sealer.Key) *sealer.MerkleTree {
+	t.Helper()
+	tree, err := sealer.NewMerkleTree(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tree.Root()
+
+	r := openReader(t, sealed, key)
+	root, err := r.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, want[:]) {
+		t.Errorf("MerkleRoot = %x, wanted %x", root, want)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// chunksOf returns the stored chunks of a file with a trailer, the trailer
+// chunk excluded.
+func chunksOf(t testing.TB, sealed []byte) [][]byte {
+	body := sealed[len(sealed)-bodySize(t, sealed, nil):]
+	var chunks [][]byte
+	for len(body) > 0 {
+		// index, size, data and the tag
+		n := 8 + int(binary.LittleEndian.Uint32(body[4:])) + 16
+		chunks = append(chunks, body[:n])
+		body = body[n:]
+	}
+	return chunks[:len(chunks)-1]
+}
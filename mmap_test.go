@@ -0,0 +1,107 @@
+package sealer_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestMapFile(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	clear(original[:50000])
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, SeekIndex: true, MerkleRoot: true},
+		{Compression: sealer.CompressionZstdChunks, SyncInterval: 3, PlaintextDigest: true},
+		{Compression: sealer.CompressionBrotli, RandomNonces: true, Padding: sealer.PaddingPadme},
+	} {
+		opt.ChunkSize = 4096
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.SeekIndex), func(t *testing.T) {
+			sealed := seal(t, []*sealer.Key{key}, []byte("prefix"), opt, original)
+			fn := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(fn, sealed, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			m, err := sealer.MapFile(fn, len("prefix"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			for _, concurrency := range []int{0, 4} {
+				m.Concurrency = concurrency
+				r, err := m.Open(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) {
+					t.Errorf("concurrency %d: got %d bytes, wanted %d", concurrency, len(actual), len(original))
+				}
+			}
+			m.Concurrency = 0
+
+			if opt.SeekIndex {
+				r, err := m.Open(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Seek(70000, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(actual, original[70000:]) {
+					t.Errorf("after seeking, got %d bytes: %v", len(actual), err)
+				}
+
+				ra, err := m.NewReaderAt(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := make([]byte, 10000)
+				if _, err := ra.ReadAt(p, 45000); err != nil || !bytes.Equal(p, original[45000:55000]) {
+					t.Errorf("ReadAt: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMapFile_damaged(t *testing.T) {
+	key := generateKeyWithID("key")
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 1024}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, randomBytes(t, 10000))
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"damaged":   append(bytes.Clone(sealed[:5000]), append([]byte{sealed[5000] ^ 1}, sealed[5001:]...)...),
+		"truncated": sealed[:len(sealed)-100],
+	} {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		m, err := sealer.MapFile(fn, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := m.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s file read without an error", name)
+		}
+		if err := m.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package mnemonic encodes sealer keys as 24-word BIP 39 mnemonics, so that
+// operators can write down an offline backup of a key.
+//
+// A mnemonic holds the 256-bit key material and a checksum, but not the key
+// ID; the ID of a restored key is sealer.DeriveKeyID of its material. Encode
+// refuses keys with other IDs, so that a backup always restores the key
+// exactly.
+package mnemonic
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+)
+
+// Words is the number of words in a mnemonic.
+const Words = 24
+
+var (
+	// ErrIDNotDerived is returned by Encode for keys whose ID is not
+	// derived from the key material.
+	ErrIDNotDerived = errors.New("mnemonic: key ID is not derived from the key material, it would not be restored")
+
+	// ErrInvalid is returned by Decode for mnemonics with unknown words,
+	// a wrong number of words or a checksum mismatch.
+	ErrInvalid = errors.New("mnemonic: invalid mnemonic")
+)
+
+// english.txt is the BIP 39 English wordlist.
+//
+//go:embed english.txt
+var englishTxt string
+
+var (
+	wordlist  = strings.Fields(englishTxt)
+	wordIndex = func() map[string]int {
+		m := make(map[string]int, len(wordlist))
+		for i, w := range wordlist {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+// 24 words of 11 bits each hold 256 bits of the key and 8 bits of
+// checksum, which are the first byte of its SHA-256 hash.
+const bitsPerWord = 11
+
+// Encode returns the mnemonic of the key material, as 24 words separated by
+// spaces.
+func Encode(key *sealer.Key) (string, error) {
+	if key.ID != sealer.DeriveKeyID(key.Key[:]) {
+		return "", ErrIDNotDerived
+	}
+
+	// entropy followed by the checksum byte
+	var data [sealer.KeySize + 1]byte
+	copy(data[:], key.Key[:])
+	sum := sha256.Sum256(key.Key[:])
+	data[sealer.KeySize] = sum[0]
+	defer clear(data[:])
+
+	words := make([]string, Words)
+	for i := range words {
+		words[i] = wordlist[bits(data[:], i*bitsPerWord, bitsPerWord)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Decode restores a key from its mnemonic. Words can be separated by any
+// whitespace and are case-insensitive.
+func Decode(mnemonic string) (*sealer.Key, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != Words {
+		return nil, ErrInvalid
+	}
+
+	var data [sealer.KeySize + 1]byte
+	defer clear(data[:])
+	for i, w := range words {
+		idx, ok := wordIndex[w]
+		if !ok {
+			return nil, ErrInvalid
+		}
+		setBits(data[:], i*bitsPerWord, bitsPerWord, idx)
+	}
+	sum := sha256.Sum256(data[:sealer.KeySize])
+	if data[sealer.KeySize] != sum[0] {
+		return nil, ErrInvalid
+	}
+
+	key := &sealer.Key{}
+	copy(key.Key[:], data[:sealer.KeySize])
+	key.ID = sealer.DeriveKeyID(key.Key[:])
+	return key, nil
+}
+
+// bits returns n bits of data starting at bit offset off, most significant
+// bit first.
+func bits(data []byte, off, n int) int {
+	v := 0
+	for i := off; i < off+n; i++ {
+		v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+	}
+	return v
+}
+
+func setBits(data []byte, off, n int, v int) {
+	for i := range n {
+		if v>>(n-1-i)&1 == 1 {
+			j := off + i
+			data[j/8] |= 1 << (7 - j%8)
+		}
+	}
+}
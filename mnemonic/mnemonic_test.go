@@ -0,0 +1,66 @@
+package mnemonic_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/mnemonic"
+)
+
+// BIP 39 test vectors for 256-bit entropy.
+var vectors = []struct {
+	entropy  byte
+	mnemonic string
+}{
+	{0x00, strings.Repeat("abandon ", 23) + "art"},
+	{0x7f, "legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth title"},
+	{0x80, "letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless"},
+	{0xff, strings.Repeat("zoo ", 23) + "vote"},
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		key := &sealer.Key{}
+		copy(key.Key[:], bytes.Repeat([]byte{v.entropy}, sealer.KeySize))
+		key.ID = sealer.DeriveKeyID(key.Key[:])
+
+		m, err := mnemonic.Encode(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != v.mnemonic {
+			t.Errorf("Encode(%02x...) = %q, wanted %q", v.entropy, m, v.mnemonic)
+		}
+
+		decoded, err := mnemonic.Decode(strings.ToUpper(strings.ReplaceAll(v.mnemonic, " ", "\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *decoded != *key {
+			t.Errorf("Decode(%q) returned a different key", v.mnemonic)
+		}
+	}
+}
+
+func TestDecode_invalid(t *testing.T) {
+	for _, m := range []string{
+		"",
+		strings.Repeat("abandon ", 24),          // bad checksum
+		strings.Repeat("abandon ", 22) + "art",  // too short
+		strings.Repeat("abandon ", 23) + "arts", // unknown word
+	} {
+		if _, err := mnemonic.Decode(m); err != mnemonic.ErrInvalid {
+			t.Errorf("Decode(%q) = %v, wanted ErrInvalid", m, err)
+		}
+	}
+}
+
+func TestEncode_nonDerivedID(t *testing.T) {
+	key := &sealer.Key{}
+	copy(key.ID[:], "custom")
+	if _, err := mnemonic.Encode(key); err != mnemonic.ErrIDNotDerived {
+		t.Errorf("Encode = %v, wanted ErrIDNotDerived", err)
+	}
+}
@@ -0,0 +1,78 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_randomNonces(t *testing.T) {
+	const chunkSize = 16
+	key := generateKey()
+	original := make([]byte, 10*chunkSize)
+	if _, err := io.ReadFull(rand.Reader, original); err != nil {
+		t.Fatal(err)
+	}
+	opt := sealer.SealOptions{ChunkSize: chunkSize, RandomNonces: true}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+	counter := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ChunkSize: chunkSize}, original)
+	if len(sealed) <= len(counter) {
+		t.Errorf("sealed with random nonces to %d bytes, with counter nonces to %d", len(sealed), len(counter))
+	}
+
+	open := func(sealed []byte) ([]byte, error) {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			return nil, err
+		}
+		r, err := opn.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	actual, err := open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %x, wanted %x", actual, original)
+	}
+
+	// removing any chunk, including the one before the final chunk, must
+	// be detected
+	const chunkLen = 4 + 4 + 24 + chunkSize + 16
+	for i := 0; i+chunkLen <= len(sealed); i++ {
+		cut := append(bytes.Clone(sealed[:i]), sealed[i+chunkLen:]...)
+		if _, err := open(cut); err == nil {
+			t.Fatalf("opened a file with bytes %d to %d removed", i, i+chunkLen)
+		}
+	}
+	corrupted := bytes.Clone(sealed)
+	corrupted[len(corrupted)-chunkSize] ^= 1
+	if _, err := open(corrupted); err == nil {
+		t.Errorf("opened a corrupted file")
+	}
+
+	// re-encrypting reseal keeps random nonces
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = opn.Reseal(&buf, key, nil, sealer.SealOptions{MasterKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err = open(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("resealed: got %x, wanted %x", actual, original)
+	}
+}
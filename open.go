@@ -1,102 +1,784 @@
 package sealer
 
 import (
+	"bytes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"time"
 
-	"github.com/klauspost/compress/zstd"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // Prepare read a sealed file header and prepares to open it. Crucially,
-// the Openable returned contains a KeyID which you can use to decide
+// the Openable returned contains KeyIDs which you can use to decide
 // which key to provide to the Open method.
 func Prepare(in io.Reader, outerPrefix []byte) (*Openable, error) {
-	oplen := len(outerPrefix)
-	prefix := make([]byte, oplen+headerSize)
+	return PrepareWithOptions(in, outerPrefix, OpenOptions{})
+}
+
+// PrepareWithOptions is like Prepare, but also sets the Openable's
+// OpenOptions, and rejects files whose chunks are larger than
+// opts.MaxChunkSize with ErrChunkSizeTooLarge.
+func PrepareWithOptions(in io.Reader, outerPrefix []byte, opts OpenOptions) (*Openable, error) {
+	prefix := make([]byte, len(outerPrefix), len(outerPrefix)+headerSize)
 	copy(prefix, outerPrefix)
-	header := prefix[oplen:]
 
-	if _, err := io.ReadFull(in, header); err != nil {
+	hdr, prefix, err := readHeader(in, prefix)
+	if err != nil {
+		return nil, err
+	}
+	opn, err := newOpenable(in, hdr, prefix, len(outerPrefix), opts)
+	if err != nil {
 		return nil, err
 	}
+	if s, ok := in.(io.Seeker); ok {
+		opn.bodyOff, err = s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			opn.bodyOff = -1
+		}
+	}
+	return opn, nil
+}
 
-	version := int(binary.LittleEndian.Uint32(header[offVersion : offVersion+4]))
-	chunkSize := int(binary.LittleEndian.Uint32(header[offChunkSize : offChunkSize+4]))
+// PrepareAt is like Prepare, but reads the sealed file at offset off of ra,
+// starting with an outer prefix of prefixLen bytes. Every Open reads the
+// file anew through ra, so the Openable can be opened multiple times, and
+// concurrently. If ra has a Size method (like bytes.Reader and
+// io.SectionReader) or is an *os.File, the sealed file is taken to extend
+// to its end, which allows reading the trailer early (see Reader.Size).
+func PrepareAt(ra io.ReaderAt, off int64, prefixLen int) (*Openable, error) {
+	prefix := make([]byte, prefixLen, prefixLen+headerSize)
+	_, err := ra.ReadAt(prefix, off)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
 
-	if version != 0 {
-		return nil, ErrUnsupportedVersion
+	hdr, prefix, err := readHeader(io.NewSectionReader(ra, off+int64(prefixLen), math.MaxInt64-off-int64(prefixLen)), prefix)
+	if err != nil {
+		return nil, err
 	}
-	if chunkSize == 0 || chunkSize > MaxChunkSize {
+	bodyStart := off + int64(len(prefix))
+	bodyLen := math.MaxInt64 - bodyStart
+	if size, ok := readerAtSize(ra); ok && size >= bodyStart {
+		bodyLen = size - bodyStart
+	}
+	opn, err := newOpenable(io.NewSectionReader(ra, bodyStart, bodyLen), hdr, prefix, prefixLen, OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	opn.ra, opn.bodyStart, opn.bodyLen = ra, bodyStart, bodyLen
+	if bodyLen != math.MaxInt64-bodyStart {
+		opn.bodyOff = 0
+	}
+	return opn, nil
+}
+
+// readerAtSize returns the size of the data behind ra, if it can tell.
+func readerAtSize(ra io.ReaderAt) (int64, bool) {
+	switch ra := ra.(type) {
+	case interface{ Size() int64 }:
+		return ra.Size(), true
+	case *os.File:
+		fi, err := ra.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		return fi.Size(), true
+	}
+	return 0, false
+}
+
+func newOpenable(in io.Reader, hdr *header, prefix []byte, outerLen int, opts OpenOptions) (*Openable, error) {
+	if opts.MaxChunkSize != 0 && hdr.chunkSize > opts.MaxChunkSize {
 		return nil, ErrChunkSizeTooLarge
 	}
+	if hdr.version < opts.MinVersion || opts.MaxVersion != 0 && hdr.version > opts.MaxVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrVersionNotAllowed, hdr.version)
+	}
 
+	if hdr.magic {
+		outerLen += len(Magic)
+	}
 	opn := &Openable{
+		KeyIDs:    make([][IDSize]byte, len(hdr.slots)),
+		Slots:     hdr.slots,
 		in:        in,
 		prefix:    prefix,
-		chunkSize: chunkSize,
-	}
-	copy(opn.KeyID[:], header[offKeyID:offKeyID+IDSize])
-	copy(opn.encapsulated[:], header[offEncKey:headerSize])
+		ad:        hdr.firstChunkAD(prefix, outerLen),
+		outerLen:  outerLen,
+		chunkSize: hdr.chunkSize,
+		hdr:       hdr,
+		bodyOff:   -1,
+		Version:   hdr.version,
 
+		OpenOptions: opts,
+	}
+	for i, s := range hdr.slots {
+		opn.KeyIDs[i] = s.KeyID
+	}
+	opn.KeyID = opn.KeyIDs[0]
+	opn.DictionaryID = hdr.dictID
+	if hdr.notAfter != 0 {
+		opn.NotAfter = time.Unix(hdr.notAfter, 0)
+	}
 	return opn, nil
 }
 
 type Openable struct {
-	KeyID        [IDSize]byte
-	in           io.Reader
-	prefix       []byte
-	chunkSize    int
-	encapsulated [nonceSizeX + KeySize + overhead]byte
+	// Version is the envelope format version: 0 for the legacy format of
+	// files sealed to a single key, 1 for the current one, and 2 for files
+	// sealed with SealOptions.PostQuantum.
+	Version int
+
+	// KeyID is the ID of the first key the file has been sealed to.
+	KeyID [IDSize]byte
+
+	// KeyIDs are the IDs of all keys the file has been sealed to, any of
+	// which can be used to open it.
+	KeyIDs [][IDSize]byte
+
+	// Slots are the raw header slots, for use with custom Decapsulators.
+	// Do not modify.
+	Slots []Slot
+
+	// NotAfter is the expiry time of the file (see SealOptions.NotAfter),
+	// or zero if it doesn't expire. It is not authenticated until the file
+	// is opened.
+	NotAfter time.Time
+
+	// Context is the context string the file has been sealed with (see
+	// SealOptions.Context). If the context is wrong, the first chunk fails
+	// to decrypt; if Context is set but the file has been sealed without
+	// one, opening fails with ErrContextMismatch.
+	Context string
+
+	// RequireKeyCommitment makes opening fail with ErrNoKeyCommitment for
+	// files without a key commitment, i.e. those sealed to a single key
+	// without SealOptions.CommitKey, or by older versions of this package.
+	// The commitment ensures that no file opens to different plaintexts
+	// under different keys, which matters when the file comes from someone
+	// who also holds one of the keys.
+	RequireKeyCommitment bool
+
+	// DictionaryID is the ID of the zstd dictionary the file has been
+	// compressed with (see SealOptions.Dictionary), or 0 if none. It is not
+	// authenticated until the file is opened.
+	DictionaryID uint32
+
+	// Dictionaries are the zstd dictionaries to pick from when opening
+	// a file with a DictionaryID. Opening fails with ErrNoDictionary if
+	// none of them matches.
+	Dictionaries []*Dictionary
+
+	// Salvage makes the Reader skip damaged chunks instead of failing, as
+	// a last resort for recovering damaged files: Reader.Gaps reports the
+	// parts of the plaintext lost. The chunks that are returned are still
+	// authenticated, but the file as a whole isn't, since chunks can be
+	// missing, and the trailer (see SealOptions.PlaintextDigest) isn't
+	// verified. Files compressed as a single stream (the default zstd and
+	// brotli) can only be decompressed up to the first gap, so salvaging is
+	// most useful with CompressionNone and CompressionZstdChunks. A wrong
+	// key makes the whole file look lost, unless the header has a key
+	// commitment or a MAC to catch it.
+	Salvage bool
+
+	// Readahead, if not zero, is the number of chunks a ReaderAt (see
+	// NewReaderAt) decrypts in the background ahead of reads that follow
+	// each other, which hides the latency of high-RTT sources like object
+	// stores. Random reads don't trigger it.
+	Readahead int
+
+	// Clock returns the current time to check NotAfter against, defaults to
+	// time.Now. Replace it in tests.
+	Clock func() time.Time
+
+	OpenOptions
+
+	in        io.Reader
+	prefix    []byte
+	ad        []byte
+	outerLen  int
+	chunkSize int
+	hdr       *header
+
+	// bodyOff is the offset of the first chunk in a seekable input, or -1.
+	bodyOff int64
+
+	// ra is the input of an Openable returned by PrepareAt, and the body
+	// of the file (the chunks) is the bodyLen bytes of it at bodyStart.
+	ra        io.ReaderAt
+	bodyStart int64
+	bodyLen   int64
+
+	// mem, if not nil, is the mapping of a MappedFile, which ra reads.
+	mem []byte
+
+	// reader is the Reader returned by Open, if any.
+	reader *Reader
+
+	// index is the sidecar index set by SetIndex, if any.
+	index *seekIndex
+}
+
+// OpenOptions limit the resources used by Readers, so that services opening
+// untrusted files can bound their worst-case allocations. They are embedded
+// into Openable, so that a service can set them all at once.
+type OpenOptions struct {
+	// MaxChunkSize, if not zero, is the largest chunk size the reader will
+	// accept, which is lower than the global MaxChunkSize. A reader buffers
+	// a couple of chunks, so this bounds its memory use. It is checked by
+	// PrepareWithOptions.
+	MaxChunkSize int
+
+	// MaxWindowSize, if not zero, is the largest zstd window size (see
+	// SealOptions.ZstdWindowSize) the reader will accept; larger windows
+	// fail the read with zstd.ErrWindowSizeExceeded. The decoder allocates
+	// a buffer of the window size, so this bounds its memory use. The
+	// default allows windows up to zstd.MaxWindowSize.
+	MaxWindowSize int
+
+	// MaxDecoderMemory, if not zero, caps the memory the zstd decoder
+	// allocates for its buffers, and thus the window size as well, failing
+	// the read with zstd.ErrDecoderSizeExceeded when a frame needs more.
+	MaxDecoderMemory uint64
+
+	// MaxPlaintextSize, if not zero, is the largest number of bytes the
+	// reader will return; reading past it fails with ErrPlaintextTooLarge.
+	// This protects servers from decompression bombs, which expand a small
+	// file into terabytes of data.
+	MaxPlaintextSize int64
+
+	// LowMemory makes the zstd decoder release its buffers as soon as
+	// possible and allocate smaller ones, at some cost in speed.
+	LowMemory bool
+
+	// StrictEOF makes the reader check that the input ends right after the
+	// final chunk, and fail with ErrTrailingData otherwise, which detects
+	// appended data and accidental double writes. The trailing data is read
+	// to the end to count it (see Reader.TrailingBytes).
+	StrictEOF bool
+
+	// MinVersion and MaxVersion, if not zero, are the oldest and the newest
+	// envelope versions (see Openable.Version) the reader will accept;
+	// PrepareWithOptions fails with ErrVersionNotAllowed for others. Set
+	// MinVersion to 1 to refuse legacy single-key files once all of them
+	// have been resealed.
+	MinVersion int
+	MaxVersion int
+
+	// Concurrency, if greater than 1, makes the reader read up to twice as
+	// many chunks ahead, and authenticate and decrypt up to Concurrency of
+	// them at once on separate goroutines, feeding them to the decompressor
+	// in order, which speeds up reading large files on machines with many
+	// cores. The reader then buffers those chunks, and the input is read
+	// ahead of the plaintext, though never past the final chunk. It has no
+	// effect on legacy single-key files, whose final chunk can't be told
+	// before it is decrypted, and when salvaging.
+	Concurrency int
+
+	// Buffers, if not nil, are the chunk buffers to read with instead of
+	// allocating them (see NewBuffers). A ReaderAt doesn't use them.
+	Buffers *Buffers
 }
 
+// Open decrypts the file key using the slot matching key.ID and returns
+// a Reader of the plaintext.
 func (opn *Openable) Open(key *Key) (*Reader, error) {
 	var ephemeralKey [KeySize]byte
-	err := decapsulate(ephemeralKey[:], key.Key[:], opn.encapsulated[:])
+	defer clear(ephemeralKey[:])
+	err := opn.decapsulateKey(ephemeralKey[:], key)
 	if err != nil {
 		return nil, err
 	}
-	// log.Printf("dec: ephemeral key = [%s] %x", hash(ephemeralKey[:]), ephemeralKey[:])
+	return opn.open(ephemeralKey[:])
+}
+
+func (opn *Openable) decapsulateKey(output []byte, key *Key) error {
+	err := ErrNoMatchingKey
+	for i := range opn.hdr.slots {
+		s := &opn.hdr.slots[i]
+		if !isKeySlot(s.Type) {
+			continue
+		}
+		// v0 files have a single slot, and have always been opened
+		// regardless of the key ID
+		if !idMatches(s.KeyID, key.ID) && opn.hdr.version != 0 {
+			continue
+		}
+		err = decapsulateKey(output, key, s)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
 
-	aead, err := chacha20poly1305.New(ephemeralKey[:])
+func (opn *Openable) hasAnonymousKeySlots() bool {
+	for i := range opn.hdr.slots {
+		if s := &opn.hdr.slots[i]; isKeySlot(s.Type) && s.KeyID == [IDSize]byte{} {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenWithKeyFunc opens the file by asking keyFunc for the key of each key
+// slot in turn, until a slot can be opened. keyFunc can return a nil key to
+// skip the slot, and any error it returns aborts the process. This lets you
+// fetch keys lazily from a database or a secrets manager.
+func (opn *Openable) OpenWithKeyFunc(keyFunc func(id [IDSize]byte) (*Key, error)) (*Reader, error) {
+	var ephemeralKey [KeySize]byte
+	defer clear(ephemeralKey[:])
+	err := ErrNoMatchingKey
+	for i := range opn.hdr.slots {
+		s := &opn.hdr.slots[i]
+		if !isKeySlot(s.Type) {
+			continue
+		}
+		key, kerr := keyFunc(s.KeyID)
+		if kerr != nil {
+			return nil, kerr
+		}
+		if key == nil {
+			continue
+		}
+		err = decapsulateKey(ephemeralKey[:], key, s)
+		if err == nil {
+			return opn.open(ephemeralKey[:])
+		}
+	}
+	return nil, err
+}
+
+// OpenAny opens the file with any of the given keys, and returns the key
+// that worked. Keys whose ID matches a slot are tried first, then all keys
+// are tried against all key slots regardless of IDs, which helps during key
+// rotation when the IDs might not have been assigned consistently.
+func (opn *Openable) OpenAny(keys ...*Key) (*Reader, *Key, error) {
+	var ephemeralKey [KeySize]byte
+	defer clear(ephemeralKey[:])
+	err := ErrNoMatchingKey
+	for _, matchID := range []bool{true, false} {
+		for i := range opn.hdr.slots {
+			s := &opn.hdr.slots[i]
+			if !isKeySlot(s.Type) {
+				continue
+			}
+			for _, key := range keys {
+				if (s.KeyID == key.ID) != matchID {
+					continue
+				}
+				err = decapsulateKey(ephemeralKey[:], key, s)
+				if err == nil {
+					r, err := opn.open(ephemeralKey[:])
+					if err != nil {
+						return nil, nil, err
+					}
+					return r, key, nil
+				}
+			}
+		}
+	}
+	return nil, nil, err
+}
+
+func (opn *Openable) open(ephemeralKey []byte) (*Reader, error) {
+	r := new(Reader)
+	err := opn.openInto(r, ephemeralKey)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return r, nil
+}
 
-	r := &Reader{
-		dec: decryptor{
-			in:        opn.in,
-			chunkSize: opn.chunkSize,
-			readBuf:   make([]byte, chunkHeaderSize+opn.chunkSize+overhead),
-			decBuf:    make([]byte, opn.chunkSize),
-			aead:      aead,
-		},
+// Reset decrypts the file key of opn using the slot matching key.ID, and
+// makes the Reader read its plaintext, like opn.Open does, reusing the
+// buffers of the Reader, and its decompressor if the file is compressed
+// the same way. Services opening many small files can keep a Reader per
+// goroutine instead of paying for new ones.
+func (r *Reader) Reset(opn *Openable, key *Key) error {
+	var ephemeralKey [KeySize]byte
+	defer clear(ephemeralKey[:])
+	err := opn.decapsulateKey(ephemeralKey[:], key)
+	if err != nil {
+		return err
 	}
+	return opn.openInto(r, ephemeralKey[:])
+}
 
-	err = r.dec.read(opn.prefix)
+// openInto initializes r to read the plaintext, reusing what it can.
+func (opn *Openable) openInto(r *Reader, ephemeralKey []byte) error {
+	// log.Printf("dec: ephemeral key = [%s] %x", hash(ephemeralKey), ephemeralKey)
+	if err := opn.checkFileKey(ephemeralKey); err != nil {
+		return err
+	}
+	dopts, err := opn.decompressorOptions()
+	if err != nil {
+		return err
+	}
+	if b := opn.Buffers; b != nil {
+		err = b.check(opn.chunkSize, opn.hdr.chunkHeaderLen()+opn.chunkSize+overhead)
+		if err != nil {
+			return err
+		}
+	}
+	in := opn.body()
+	old := *r
+	err = opn.resetReader(r, in, ephemeralKey)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decrypt the first chunk: %w", err)
+		return err
+	}
+	if opn.Salvage {
+		r.dec.salvage = &salvager{
+			in:        in,
+			exactSize: opn.hdr.compression == CompressionNone && !opn.hdr.padded,
+			gap: func(first, count int, size int64) {
+				r.gaps = append(r.gaps, Gap{Offset: r.total, FirstChunk: first, Chunks: count, Size: size})
+			},
+		}
+		r.dec.merkle = nil
+		r.hash = nil
+		r.hasSize = false
+	}
+	if opn.ra == nil {
+		opn.reader = r
 	}
 
-	r.decompr, err = zstd.NewReader(&r.dec, zstd.WithDecoderConcurrency(1))
+	err = r.dec.read(opn.ad)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("cannot decrypt the first chunk: %w", withPlaintext(err, 0))
+	}
+
+	r.dopts = dopts
+	if old.decompr != nil && old.dopts == dopts {
+		r.decompr = old.decompr
+		return resetReader(r.decompr, &r.dec)
 	}
+	r.decompr, err = opn.hdr.compression.newReader(&r.dec, dopts.zstdOptions())
+	return err
+}
 
+// newReader returns a Reader of the chunks read from in, before reading the
+// first one.
+func (opn *Openable) newReader(in io.Reader, fileKey []byte) (*Reader, error) {
+	r := new(Reader)
+	err := opn.resetReader(r, in, fileKey)
+	if err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
+// resetReader initializes r to read the chunks from in, before reading the
+// first one, reusing its buffers.
+func (opn *Openable) resetReader(r *Reader, in io.Reader, fileKey []byte) error {
+	old := &r.dec
+	if b := opn.Buffers; b != nil {
+		old = &decryptor{readBuf: b.Sealed, decBuf: b.Plaintext}
+	}
+	dec, err := opn.reuseDecryptor(old, in, fileKey)
+	if err != nil {
+		return err
+	}
+	*r = Reader{
+		dec:       dec,
+		remaining: -1,
+		strictEOF: opn.StrictEOF,
+		trailing:  -1,
+		start:     time.Now(),
+	}
+	if opn.MaxPlaintextSize > 0 {
+		r.remaining = opn.MaxPlaintextSize
+	}
+	if opn.hdr.hasTrailerField(trailerDigest) {
+		r.hash = sha256.New()
+	}
+	r.hasSize = opn.hdr.hasTrailerField(trailerSize)
+	r.bodyOff = opn.bodyOff
+	r.firstAD = opn.ad
+	r.index = opn.index
+	if opn.Concurrency > 1 && opn.hdr.framed {
+		r.dec.par = &parallelOpener{sem: make(chan struct{}, opn.Concurrency)}
+	}
+	return nil
+}
+
+// dictionary returns the dictionary with the given ID from Dictionaries, or
+// nil if none.
+func (opn *Openable) dictionary(id uint32) *Dictionary {
+	for _, d := range opn.Dictionaries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// body returns the input to read the chunks from: opn.in, or a new reader
+// of the body for Openables returned by PrepareAt.
+func (opn *Openable) body() io.Reader {
+	if opn.mem != nil {
+		return newMemInput(opn.mem[opn.bodyStart : opn.bodyStart+opn.bodyLen])
+	}
+	if opn.ra != nil {
+		return io.NewSectionReader(opn.ra, opn.bodyStart, opn.bodyLen)
+	}
+	return opn.in
+}
+
+func (opn *Openable) decryptor(in io.Reader, fileKey []byte) (decryptor, error) {
+	return opn.reuseDecryptor(nil, in, fileKey)
+}
+
+// reuseDecryptor is like decryptor, but takes the buffers of old, if not
+// nil and large enough.
+func (opn *Openable) reuseDecryptor(old *decryptor, in io.Reader, fileKey []byte) (decryptor, error) {
+	aead, err := opn.hdr.chunkAEAD(fileKey, opn.Context)
+	if err != nil {
+		return decryptor{}, err
+	}
+	var readBuf, decBuf []byte
+	if old != nil {
+		readBuf, decBuf = old.readBuf, old.decBuf
+	}
+	d := decryptor{
+		in:        in,
+		chunkSize: opn.chunkSize,
+		readBuf:   reuseBuf(readBuf, opn.hdr.chunkHeaderLen()+opn.chunkSize+overhead),
+		decBuf:    reuseBuf(decBuf, opn.chunkSize),
+		aead:      aead,
+		ad:        opn.hdr.chunkAD(opn.prefix, opn.outerLen),
+		framed:    opn.hdr.framed,
+		padded:    opn.hdr.padded,
+
+		variableChunks: opn.hdr.compression == CompressionZstdChunks,
+		trailerSize:    opn.hdr.trailerSize,
+	}
+	if opn.hdr.randomNonces {
+		d.nonceSize = nonceSizeX
+	}
+	if opn.hdr.hasTrailerField(trailerMerkle) {
+		d.merkle = new(merkleBuilder)
+	}
+	d.detached = opn.hdr.hasTrailerField(trailerIndex)
+	d.syncInterval = opn.hdr.syncInterval
+	return d, nil
+}
+
+// reuseBuf returns buf resized to n bytes, or a new buffer if it's too small.
+func reuseBuf(buf []byte, n int) []byte {
+	if cap(buf) >= n {
+		return buf[:n]
+	}
+	return make([]byte, n)
+}
+
+// checkFileKey runs the checks of the header that need the file key, and
+// those that precede opening.
+func (opn *Openable) checkFileKey(fileKey []byte) error {
+	if err := opn.verifyCommitment(fileKey); err != nil {
+		return err
+	}
+	if err := opn.verifyMAC(fileKey); err != nil {
+		return err
+	}
+	if err := opn.checkExpiry(); err != nil {
+		return err
+	}
+	if !opn.hdr.context && opn.Context != "" {
+		return ErrContextMismatch
+	}
+	return nil
+}
+
+// checkExpiry returns ErrExpired if the file has expired.
+func (opn *Openable) checkExpiry() error {
+	if opn.hdr.notAfter == 0 {
+		return nil
+	}
+	now := time.Now
+	if opn.Clock != nil {
+		now = opn.Clock
+	}
+	if now().Unix() > opn.hdr.notAfter {
+		return ErrExpired
+	}
+	return nil
+}
+
+// verifyCommitment checks the key commitment, if any, against the file key.
+func (opn *Openable) verifyCommitment(fileKey []byte) error {
+	if opn.hdr.commitment == nil {
+		if opn.RequireKeyCommitment {
+			return ErrNoKeyCommitment
+		}
+		return nil
+	}
+	if !hmac.Equal(keyCommitment(fileKey), opn.hdr.commitment) {
+		return errKeyCommitment
+	}
+	return nil
+}
+
+// verifyMAC checks the header MAC, if any, using the file key.
+func (opn *Openable) verifyMAC(fileKey []byte) error {
+	if opn.hdr.mac == nil {
+		return nil
+	}
+	macced := bytes.Clone(opn.prefix)
+	clear(macced[opn.outerLen+opn.hdr.macOff:][:headerMACSize])
+	if !hmac.Equal(headerMAC(fileKey, macced), opn.hdr.mac) {
+		return errHeaderMAC
+	}
+	return nil
+}
+
 type Reader struct {
-	decompr *zstd.Decoder
+	decompr io.Reader
+	dopts   decompressorOptions
 	dec     decryptor
+
+	// remaining is the number of bytes left until MaxPlaintextSize, or -1
+	// if there is no limit.
+	remaining int64
+
+	// hash computes the digest of the plaintext if the trailer has one, and
+	// total counts the plaintext bytes.
+	hash      hasher
+	total     int64
+	trailer   trailer
+	finished  bool
+	finishErr error
+
+	// hasSize is set if the trailer records the size; bodyOff is the offset
+	// of the first chunk in the input, or -1 if unknown, and firstAD is the
+	// additional data of the first chunk, for reading the trailer early
+	// into peeked.
+	hasSize bool
+	bodyOff int64
+	firstAD []byte
+	peeked  *trailer
+
+	// gaps are the parts of the file lost so far when salvaging.
+	gaps []Gap
+
+	// seeked is set once Seek has been called, after which the plaintext
+	// as a whole is no longer verified.
+	seeked bool
+
+	// index is the sidecar index of the file, if any (see Openable.SetIndex).
+	index *seekIndex
+
+	// strictEOF is set for OpenOptions.StrictEOF; trailing is the number of
+	// bytes after the final chunk, or -1 if not counted yet.
+	strictEOF bool
+	trailing  int64
+
+	// start is when the Reader has been opened, and elapsed how long it
+	// took to read the plaintext once finished is set (see Stats).
+	start   time.Time
+	elapsed time.Duration
+
+	// closed is set by Close.
+	closed bool
 }
 
 func (r *Reader) Read(p []byte) (n int, err error) {
-	return r.decompr.Read(p)
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+	n, err = r.read(p)
+	if r.hash != nil {
+		r.hash.Write(p[:n])
+	}
+	r.total += int64(n)
+	if err == io.EOF {
+		if ferr := r.finish(); ferr != nil {
+			return n, ferr
+		}
+	}
+	return n, withPlaintext(err, r.total)
+}
+
+// TrailingBytes reads the input to the end once the file has been read to
+// the end, and returns the number of bytes that follow the final chunk. Data
+// after the final chunk isn't a part of the file, and is ignored otherwise.
+func (r *Reader) TrailingBytes() (int64, error) {
+	if !r.dec.eof {
+		return 0, errors.New("sealed file hasn't been read to the end")
+	}
+	if r.trailing >= 0 {
+		return r.trailing, nil
+	}
+	var n int64
+	if s := r.dec.salvage; s != nil {
+		n = int64(len(s.buf) - s.off)
+		s.off = len(s.buf)
+	}
+	m, err := io.Copy(io.Discard, r.dec.in)
+	n += m
+	if err != nil {
+		return n, err
+	}
+	r.trailing = n
+	return n, nil
+}
+
+// Close stops the decompressor, wipes the buffers that have held the
+// plaintext, including OpenOptions.Buffers, and drops the chunk cipher.
+// Close can't wipe the copy of the chunk key held by the cipher, nor the
+// buffers of the zstd and brotli decoders, which are left to the garbage
+// collector. Reads after Close fail with ErrReaderClosed. Close doesn't
+// close the input, and a closed Reader can still be Reset.
+func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	closeReader(r.decompr)
+	r.decompr = nil
+
+	dec := &r.dec
+	if dec.par != nil {
+		dec.par.wipe()
+		dec.par = nil
+	}
+	clear(dec.decBuf[:cap(dec.decBuf)])
+	clear(dec.trailer)
+	dec.buf, dec.out, dec.trailer = nil, nil, nil
+	dec.aead = nil
+	return nil
+}
+
+func (r *Reader) read(p []byte) (n int, err error) {
+	if r.remaining < 0 {
+		return r.decompr.Read(p)
+	}
+	if r.remaining == 0 {
+		var probe [1]byte
+		n, err = r.decompr.Read(probe[:])
+		if n > 0 {
+			return 0, ErrPlaintextTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err = r.decompr.Read(p)
+	r.remaining -= int64(n)
+	return n, err
 }
 
 type decryptor struct {
@@ -108,9 +790,78 @@ type decryptor struct {
 	chunkIndex uint32
 	aead       cipher.AEAD
 	eof        bool
+
+	// ad is the additional data of the chunks after the first.
+	ad []byte
+
+	// nonceSize is the size of the random nonce in chunk headers, or 0 for
+	// counter nonces.
+	nonceSize int
+	adBuf     []byte
+
+	// framed is set if chunk headers hold the chunk size.
+	framed bool
+
+	// variableChunks is set if framed chunks other than the final one may be
+	// shorter than chunkSize.
+	variableChunks bool
+
+	// trailerSize is the size of the trailer held by the final chunk, which
+	// is read into trailer, or 0 if none. The chunk before the trailer may
+	// be short; short is set once a short chunk has been read.
+	trailerSize int
+	short       bool
+	trailer     []byte
+
+	// merkle, if not nil, computes the Merkle root of the chunks before the
+	// trailer.
+	merkle *merkleBuilder
+
+	// compressed, sealed and chunks count the chunks opened, for
+	// Reader.Stats.
+	compressed int64
+	sealed     int64
+	chunks     int64
+
+	// pos is the offset of the next chunk (or of the sync marker before
+	// it) from the first chunk, and lastPos that of the current one, for
+	// ChunkErrors.
+	pos     int64
+	lastPos int64
+
+	// detached is set if the trailer chunk is encrypted independently of
+	// its position, in files with a seek index.
+	detached bool
+
+	// syncInterval is the number of chunks between sync markers, or 0 for
+	// none (see sync.go).
+	syncInterval uint32
+
+	// indexer, if not nil, collects a sidecar index of the chunks.
+	indexer *indexer
+
+	// salvage, if not nil, reads the chunks of a damaged file.
+	salvage *salvager
+
+	// par, if not nil, reads ahead and decrypts chunks in parallel (see
+	// OpenOptions.Concurrency).
+	par *parallelOpener
+
+	// padded is set if the stream is padded (see readUnpadded): held is
+	// set if a padding marker followed by heldZeros zeros has been held
+	// back, and pendMarker, pendZeros and out are to be output next.
+	padded     bool
+	held       bool
+	heldZeros  int64
+	pendMarker bool
+	pendZeros  int64
+	out        []byte
 }
 
 func (dec *decryptor) Read(p []byte) (n int, err error) {
+	if dec.padded {
+		return dec.readUnpadded(p)
+	}
 	if len(dec.buf) == 0 {
 		err = dec.read(nil)
 		if err != nil {
@@ -124,59 +875,229 @@ func (dec *decryptor) Read(p []byte) (n int, err error) {
 	return
 }
 
+// read reads and decrypts the next chunk. prefix is the additional data of
+// the first chunk, and nil for the others.
 func (dec *decryptor) read(prefix []byte) error {
 	if dec.eof {
 		return io.EOF
 	}
-	n, err := io.ReadFull(dec.in, dec.readBuf)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		err = nil
+	if prefix == nil {
+		prefix = dec.ad
+	}
+	if dec.salvage != nil {
+		// sync markers look like damage, and are skipped as such
+		return dec.readSalvaged(prefix)
+	}
+	if dec.par != nil {
+		return dec.readParallel(prefix)
 	}
+	index := dec.chunkIndex
+	chunk, err := dec.readChunk(dec.readBuf, index)
 	if err != nil {
-		return err
+		return chunkError(err, int64(index), dec.pos)
 	}
-	if n < chunkHeaderSize+overhead {
-		return io.ErrUnexpectedEOF
+	buf, isFinal, err := dec.open(dec.decBuf[:0], chunk, index, prefix)
+	dec.chunkIndex++
+	if err != nil {
+		return chunkError(err, int64(index), dec.pos)
 	}
+	dec.opened(chunk, buf, isFinal)
+	return nil
+}
 
-	headerIndex := binary.LittleEndian.Uint32(dec.readBuf[:chunkHeaderSize])
-	isFinal := (headerIndex == finalChunkIndex)
-	if !isFinal && headerIndex != dec.chunkIndex {
-		return fmt.Errorf("data corruption: wanted chunk %d, got %d", dec.chunkIndex, headerIndex)
+// readChunk reads the chunk with the given index into buf, checking the
+// sync marker before it if any, and returns it. Chunks of a memInput are
+// returned where they are instead.
+func (dec *decryptor) readChunk(buf []byte, index uint32) ([]byte, error) {
+	mem, _ := dec.in.(*memInput)
+	if hasSyncBefore(dec.syncInterval, index) {
+		var marker []byte
+		var err error
+		if mem != nil {
+			marker, err = mem.take(SyncMarkerSize)
+		} else {
+			marker = buf[:SyncMarkerSize]
+			_, err = io.ReadFull(dec.in, marker)
+		}
+		if err != nil {
+			return nil, truncated(err)
+		}
+		if !checkSyncMarker(dec.aead, dec.ad, marker, index) {
+			return nil, fmt.Errorf("%w: bad sync marker", ErrCorrupted)
+		}
 	}
 
-	var nonce [nonceSizeS]byte
-	fillNonce(&nonce, dec.chunkIndex, isFinal)
-	dec.chunkIndex++
-
-	sealed := dec.readBuf[chunkHeaderSize:n]
+	hdrLen := chunkHeaderSize + dec.nonceSize
+	var n int
+	if dec.framed {
+		hdrLen += chunkSizeFieldSize
+		var err error
+		if mem != nil {
+			buf, err = mem.peek(hdrLen)
+		} else {
+			_, err = io.ReadFull(dec.in, buf[:hdrLen])
+		}
+		if err != nil {
+			return nil, truncated(err)
+		}
+		size := int(binary.LittleEndian.Uint32(buf[chunkHeaderSize:]))
+		isFinal := binary.LittleEndian.Uint32(buf) == finalChunkIndex
+		if size > dec.chunkSize || dec.short && !isFinal || isFinal && dec.trailerSize != 0 && size != dec.trailerSize {
+			return nil, fmt.Errorf("%w: chunk has size %d", ErrCorrupted, size)
+		}
+		if !isFinal && !dec.variableChunks && size != dec.chunkSize {
+			if dec.trailerSize == 0 {
+				return nil, fmt.Errorf("%w: chunk has size %d", ErrCorrupted, size)
+			}
+			dec.short = true
+		}
+		n = hdrLen + size + overhead
+		if mem != nil {
+			chunk, err := mem.take(n)
+			return chunk, truncated(err)
+		}
+		_, err = io.ReadFull(dec.in, buf[hdrLen:n])
+		if err != nil {
+			return nil, truncated(err)
+		}
+	} else {
+		var err error
+		if mem != nil {
+			buf = mem.next(len(buf))
+			n = len(buf)
+		} else {
+			n, err = io.ReadFull(dec.in, buf)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < hdrLen+overhead {
+			return nil, ErrTruncated
+		}
+	}
+	return buf[:n], nil
+}
 
-	// log.Printf("dec: headerIndex = %d, prefix = %d [%s], nonce = %x", headerIndex, len(prefix), hash(prefix), nonce[:])
-	// log.Printf("dec: sealed = %d [%s]: %x", len(sealed), hash(sealed), sealed)
+// truncated returns ErrTruncated for the errors of reading past the end of
+// the input, and err otherwise.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
 
-	buf, err := dec.aead.Open(dec.decBuf[:0], nonce[:], sealed, prefix)
-	if err != nil {
-		return err
+// opened is called with every chunk read and decrypted in order, and makes
+// it the current one.
+func (dec *decryptor) opened(chunk, buf []byte, isFinal bool) {
+	n := int64(len(chunk))
+	if hasSyncBefore(dec.syncInterval, dec.chunkIndex-1) {
+		n += int64(SyncMarkerSize)
+	}
+	dec.sealed += n
+	dec.lastPos = dec.pos
+	dec.pos += n
+	if dec.merkle != nil && !isFinal {
+		dec.merkle.add(chunk)
 	}
+	if dec.indexer != nil {
+		dec.indexer.chunk(dec.chunkIndex-1, chunk)
+	}
+	dec.accept(buf, isFinal)
+}
+
+// accept makes a decrypted chunk the current one.
+func (dec *decryptor) accept(buf []byte, isFinal bool) {
+	dec.compressed += int64(len(buf))
+	dec.chunks++
 	dec.buf = buf
 	dec.eof = isFinal
-	return nil
+	if isFinal && dec.trailerSize != 0 {
+		dec.trailer = bytes.Clone(buf)
+		dec.buf = nil
+	}
+}
+
+// open decrypts a chunk read from the file, header included, appending the
+// plaintext to dst, and reports whether the chunk is the final one. index
+// is the position of the chunk in the file, and ad its additional data (see
+// read). Chunks don't depend on each other, so they can be decrypted out of
+// order given their positions.
+func (dec *decryptor) open(dst, chunk []byte, index uint32, ad []byte) ([]byte, bool, error) {
+	hdrLen := chunkHeaderSize + dec.nonceSize
+	if dec.framed {
+		hdrLen += chunkSizeFieldSize
+	}
+	if len(chunk) < hdrLen+overhead {
+		return nil, false, ErrTruncated
+	}
+	headerIndex := binary.LittleEndian.Uint32(chunk[:chunkHeaderSize])
+	isFinal := (headerIndex == finalChunkIndex)
+	if !isFinal && headerIndex != index {
+		return nil, false, fmt.Errorf("%w: wanted chunk %d, got %d", ErrCorrupted, index, headerIndex)
+	}
+	nonceIndex := index
+	if isFinal && dec.detached {
+		nonceIndex = finalChunkIndex
+	}
+
+	sealed := chunk[hdrLen:]
+	var buf []byte
+	var err error
+	if dec.nonceSize != 0 {
+		nonce := chunk[hdrLen-dec.nonceSize : hdrLen]
+		dec.adBuf = appendChunkAD(append(dec.adBuf[:0], ad...), nonceIndex, isFinal)
+		buf, err = dec.aead.Open(dst, nonce, sealed, dec.adBuf)
+	} else {
+		var nonce [nonceSizeS]byte
+		fillNonce(&nonce, nonceIndex, isFinal)
+
+		// log.Printf("dec: headerIndex = %d, prefix = %d [%s], nonce = %x", headerIndex, len(ad), hash(ad), nonce[:])
+		// log.Printf("dec: sealed = %d [%s]: %x", len(sealed), hash(sealed), sealed)
+
+		buf, err = dec.aead.Open(dst, nonce[:], sealed, ad)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: authentication failed", ErrCorrupted)
+	}
+	return buf, isFinal, nil
+}
+
+// isKeySlot returns whether slots of the given type are opened by a Key.
+func isKeySlot(typ uint16) bool {
+	return typ == SlotTypeKey || typ == SlotTypeMasterKey
 }
 
-func decapsulate(output []byte, key []byte, encapsulated []byte) error {
+func decapsulateKey(output []byte, key *Key, s *Slot) error {
+	if s.Type == SlotTypeMasterKey {
+		return decapsulateMaster(output, key, s)
+	}
+	if len(s.Data) != encapsulatedSize {
+		return ErrUnsupportedVersion
+	}
+	return decapsulate(output, key.Key[:], s.Data, nil)
+}
+
+func decapsulate(output []byte, key []byte, encapsulated []byte, additionalData []byte) error {
 	ea, err := chacha20poly1305.NewX(key)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// log.Printf("decapsulate: sealed = [%s]: %x", hash(encapsulated[:]), encapsulated[:])
 	// log.Printf("decapsulate: pre-key = [%s]: %x", hash(encapsulated[nonceSizeX:nonceSizeX+KeySize]), encapsulated[nonceSizeX:nonceSizeX+KeySize])
 
-	_, err = ea.Open(output[:0], encapsulated[:nonceSizeX], encapsulated[nonceSizeX:nonceSizeX+KeySize+overhead], nil)
+	_, err = ea.Open(output[:0], encapsulated[:nonceSizeX], encapsulated[nonceSizeX:nonceSizeX+KeySize+overhead], additionalData)
 
 	// log.Printf("decapsulate: nonce = [%s]: %x", hash(encapsulated[:nonceSizeX]), encapsulated[:nonceSizeX])
 	// log.Printf("decapsulate: key = [%s]: %x", hash(output), output)
-	return err
+	if err != nil {
+		return ErrWrongKey
+	}
+	return nil
 }
 
 func hash(data []byte) string {
@@ -0,0 +1,102 @@
+// Package oskeyring stores sealer keys in the operating system's credential
+// store, indexed by key ID: the Keychain on macOS, the Secret Service
+// (GNOME Keyring, KWallet) on Linux and BSDs, and the Credential Manager on
+// Windows.
+//
+// Store.Load has the signature expected by sealer.Openable.OpenWithKeyFunc,
+// so a desktop tool can open any file whose key the user has saved:
+//
+//	store := oskeyring.New("com.example.app")
+//	r, err := opn.OpenWithKeyFunc(store.Load)
+//
+// On macOS the package uses the keychain sub-package; on Linux and BSDs it
+// shells out to secret-tool from libsecret. No cgo is needed.
+package oskeyring
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+)
+
+var (
+	// ErrNotFound is returned when the store has no key with the given ID.
+	ErrNotFound = errors.New("oskeyring: key not found")
+
+	// ErrUnsupported is returned when there's no supported credential store
+	// on this platform (or, on Linux and BSDs, secret-tool is not
+	// installed).
+	ErrUnsupported = errors.New("oskeyring: no supported credential store")
+
+	errInvalidService = errors.New("oskeyring: service name cannot be empty or contain quotes, backslashes or newlines")
+	errInvalidItem    = errors.New("oskeyring: item does not contain a sealer key")
+)
+
+// Store keeps keys under a service name identifying your app, such as
+// "com.example.app".
+type Store struct {
+	service string
+}
+
+// New returns a store for the given service name.
+func New(service string) *Store {
+	return &Store{service: service}
+}
+
+// Save stores the key, replacing any key with the same ID.
+func (s *Store) Save(key *sealer.Key) error {
+	if !validService(s.service) {
+		return errInvalidService
+	}
+	return save(s.service, account(key.ID), key)
+}
+
+// Load returns the key with the given ID, or ErrNotFound.
+func (s *Store) Load(id [sealer.IDSize]byte) (*sealer.Key, error) {
+	if !validService(s.service) {
+		return nil, errInvalidService
+	}
+	key, err := load(s.service, account(id))
+	if err != nil {
+		return nil, err
+	}
+	if key.ID != id {
+		return nil, errInvalidItem
+	}
+	return key, nil
+}
+
+// Delete removes the key with the given ID.
+func (s *Store) Delete(id [sealer.IDSize]byte) error {
+	if !validService(s.service) {
+		return errInvalidService
+	}
+	return del(s.service, account(id))
+}
+
+// account is the name of the item holding a key, the hex-encoded key ID.
+func account(id [sealer.IDSize]byte) string {
+	return hex.EncodeToString(id[:])
+}
+
+// Except on macOS (where the keychain package has its own encoding), items
+// hold the key string produced by sealer.Key.MarshalText.
+
+func encodeKey(key *sealer.Key) []byte {
+	text, _ := key.MarshalText()
+	return text
+}
+
+func decodeKey(s string) (*sealer.Key, error) {
+	key, err := sealer.ParseKey(s)
+	if err != nil {
+		return nil, errInvalidItem
+	}
+	return key, nil
+}
+
+func validService(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "\"\\\n\r")
+}
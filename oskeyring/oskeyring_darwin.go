@@ -0,0 +1,30 @@
+//go:build darwin
+
+package oskeyring
+
+import (
+	"errors"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/keychain"
+)
+
+func save(service, account string, key *sealer.Key) error {
+	return keychain.Save(service, account, key)
+}
+
+func load(service, account string) (*sealer.Key, error) {
+	key, err := keychain.Load(service, account)
+	if errors.Is(err, keychain.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+func del(service, account string) error {
+	err := keychain.Delete(service, account)
+	if errors.Is(err, keychain.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !windows && !linux && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package oskeyring
+
+import "github.com/andreyvit/sealer"
+
+func save(service, account string, key *sealer.Key) error {
+	return ErrUnsupported
+}
+
+func load(service, account string) (*sealer.Key, error) {
+	return nil, ErrUnsupported
+}
+
+func del(service, account string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,73 @@
+package oskeyring
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestEncodeKey(t *testing.T) {
+	key := &sealer.Key{}
+	for i := range key.ID {
+		key.ID[i] = byte(i)
+	}
+	for i := range key.Key {
+		key.Key[i] = byte(100 + i)
+	}
+	key2, err := decodeKey(string(encodeKey(key)) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *key2 != *key {
+		t.Errorf("decodeKey = %x, wanted %x", *key2, *key)
+	}
+	if _, err := decodeKey("hello"); err != errInvalidItem {
+		t.Errorf("decodeKey(garbage) = %v", err)
+	}
+
+	if a := account(key.ID); len(a) != 2*sealer.IDSize || strings.ToLower(a) != a {
+		t.Errorf("account = %q", a)
+	}
+}
+
+func TestStore_invalidService(t *testing.T) {
+	for _, s := range []string{"", `a"b`, "a\nb"} {
+		if _, err := New(s).Load([sealer.IDSize]byte{}); err != errInvalidService {
+			t.Errorf("Load with service %q = %v, wanted errInvalidService", s, err)
+		}
+	}
+}
+
+// TestStore uses the real credential store, so it only runs when
+// OSKEYRING_TEST=1.
+func TestStore(t *testing.T) {
+	if os.Getenv("OSKEYRING_TEST") != "1" {
+		t.Skip("set OSKEYRING_TEST=1 to test against the OS credential store")
+	}
+	store := New("com.github.andreyvit.sealer.test")
+	key := &sealer.Key{}
+	copy(key.ID[:], "oskeyring test")
+	copy(key.Key[:], "oskeyring test key material")
+
+	err := store.Save(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *key {
+		t.Errorf("Load returned a different key")
+	}
+	err = store.Delete(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load after Delete = %v, wanted ErrNotFound", err)
+	}
+}
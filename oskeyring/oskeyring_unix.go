@@ -0,0 +1,65 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package oskeyring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/andreyvit/sealer"
+)
+
+// secret-tool (part of libsecret) talks to the Secret Service over D-Bus.
+// Items are stored with "service" and "account" attributes, the same way
+// other tools (e.g. Python keyring) do it.
+
+const secretTool = "secret-tool"
+
+func save(service, account string, key *sealer.Key) error {
+	secret := encodeKey(key)
+	defer clear(secret)
+	// the secret is read from stdin, so it doesn't show up in the process
+	// list
+	_, err := run(secret, "store", "--label="+service+" "+account, "service", service, "account", account)
+	return err
+}
+
+func load(service, account string) (*sealer.Key, error) {
+	out, err := run(nil, "lookup", "service", service, "account", account)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(out)
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return decodeKey(string(out))
+}
+
+func del(service, account string) error {
+	_, err := run(nil, "clear", "service", service, "account", account)
+	return err
+}
+
+func run(stdin []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(secretTool)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(path, args...)
+	c.Stdin = bytes.NewReader(stdin)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		// lookup exits with 1 and no message when nothing matches
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("oskeyring: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
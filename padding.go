@@ -0,0 +1,149 @@
+package sealer
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Padding selects how Seal pads the compressed stream before encrypting it
+// (see SealOptions.Padding), so that the size of the sealed file reveals
+// less about the size of the plaintext.
+type Padding int
+
+const (
+	// PaddingNone doesn't pad the stream.
+	PaddingNone Padding = iota
+
+	// PaddingPadme pads the stream using the Padmé scheme (from "Reducing
+	// Metadata Leakage from Encrypted Files and Communication with PURBs"),
+	// which leaks O(log log n) bits of the size of an n-byte stream, and
+	// adds at most 12% of overhead (less for larger streams).
+	PaddingPadme
+
+	// PaddingPowerOfTwo pads the stream to the next power of two, which
+	// leaks O(log log n) bits as well, but adds up to 100% of overhead.
+	PaddingPowerOfTwo
+
+	// PaddingChunk pads the final chunk to the full chunk size, so that
+	// files consist of full chunks only, and their sizes only reveal the
+	// number of chunks.
+	PaddingChunk
+)
+
+// Padded streams end with ISO/IEC 7816-4 padding: a 0x80 byte followed
+// by zeros. Readers strip it as they go, holding back any 0x80 byte and
+// counting the zeros that follow it until the next nonzero byte proves
+// them to be data, so padding of any size needs no buffering.
+
+var errBadPadding = fmt.Errorf("%w: bad padding", ErrCorrupted)
+
+const paddingMarker = 0x80
+
+// paddedSize returns the size of a stream of n bytes (including the padding
+// marker) after padding.
+func (p Padding) paddedSize(n int64, chunkSize int) int64 {
+	if n <= 1 && p != PaddingChunk {
+		return n
+	}
+	switch p {
+	case PaddingPadme:
+		e := bits.Len64(uint64(n)) - 1
+		s := bits.Len64(uint64(e))
+		mask := int64(1)<<(e-s) - 1
+		return (n + mask) &^ mask
+	case PaddingPowerOfTwo:
+		return int64(1) << bits.Len64(uint64(n-1))
+	case PaddingChunk:
+		cs := int64(chunkSize)
+		return (n + cs - 1) / cs * cs
+	default:
+		return n
+	}
+}
+
+// pad writes the padding after the compressed stream.
+func (e *encryptor) pad(p Padding) error {
+	zeros := p.paddedSize(e.written+1, e.chunkSize) - e.written - 1
+	_, err := e.Write([]byte{paddingMarker})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, min(zeros, int64(e.chunkSize)))
+	for zeros > 0 {
+		n := min(zeros, int64(len(buf)))
+		_, err = e.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+		zeros -= n
+	}
+	return nil
+}
+
+// readUnpadded implements Read for padded streams.
+func (dec *decryptor) readUnpadded(p []byte) (int, error) {
+	for {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if dec.pendMarker {
+			p[0] = paddingMarker
+			dec.pendMarker = false
+			return 1, nil
+		}
+		if dec.pendZeros > 0 {
+			n := int(min(int64(len(p)), dec.pendZeros))
+			clear(p[:n])
+			dec.pendZeros -= int64(n)
+			return n, nil
+		}
+		if len(dec.out) > 0 {
+			n := copy(p, dec.out)
+			dec.out = dec.out[n:]
+			return n, nil
+		}
+
+		if len(dec.buf) > 0 {
+			dec.unpad(dec.buf)
+			dec.buf = nil
+			continue
+		}
+		if dec.eof {
+			if !dec.held {
+				return 0, errBadPadding
+			}
+			return 0, io.EOF
+		}
+		err := dec.read(nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// unpad processes a decrypted chunk, setting the data to output next.
+func (dec *decryptor) unpad(c []byte) {
+	j := len(c) - 1
+	for j >= 0 && c[j] == 0 {
+		j--
+	}
+	if j < 0 {
+		if dec.held {
+			dec.heldZeros += int64(len(c))
+		} else {
+			dec.out = c
+		}
+		return
+	}
+	if dec.held {
+		dec.pendMarker, dec.pendZeros = true, dec.heldZeros
+		dec.held, dec.heldZeros = false, 0
+	}
+	if c[j] == paddingMarker {
+		dec.out = c[:j]
+		dec.held, dec.heldZeros = true, int64(len(c)-j-1)
+	} else {
+		dec.out = c
+	}
+}
@@ -0,0 +1,83 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_padding(t *testing.T) {
+	key := generateKey()
+	keys := []*sealer.Key{key}
+
+	for name, padding := range map[string]sealer.Padding{"padme": sealer.PaddingPadme, "pow2": sealer.PaddingPowerOfTwo, "chunk": sealer.PaddingChunk} {
+		t.Run(name, func(t *testing.T) {
+			sizes := make(map[int]bool)
+			for n := 1000; n < 1200; n++ {
+				original := make([]byte, n)
+				if _, err := io.ReadFull(rand.Reader, original); err != nil {
+					t.Fatal(err)
+				}
+				// padding markers inside the data must survive
+				copy(original[n/2:], "\x80\x00\x00\x00")
+				copy(original[n-3:], "\x80\x00\x00")
+
+				sealed := seal(t, keys, nil, sealer.SealOptions{ChunkSize: 64, Padding: padding}, original)
+				sizes[len(sealed)] = true
+
+				opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := opn.Open(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) {
+					t.Fatalf("size %d: got %x, wanted %x", n, actual, original)
+				}
+			}
+			if len(sizes) > 10 {
+				t.Errorf("200 plaintext sizes sealed to %d different sizes", len(sizes))
+			}
+		})
+	}
+
+	sealed := seal(t, keys, nil, sealer.SealOptions{Padding: sealer.PaddingPadme}, nil)
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil || len(actual) != 0 {
+		t.Errorf("empty plaintext: got %q, %v", actual, err)
+	}
+}
+
+func TestSealer_paddingChunk(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	const chunkSize = 64
+	const chunkLen = 4 + 4 + chunkSize + 16
+	headerLen := len(seal(t, keys, nil, sealer.SealOptions{ChunkSize: chunkSize, Padding: sealer.PaddingChunk}, nil)) - chunkLen
+	for n := range 300 {
+		original := make([]byte, n)
+		if _, err := io.ReadFull(rand.Reader, original); err != nil {
+			t.Fatal(err)
+		}
+		sealed := seal(t, keys, nil, sealer.SealOptions{ChunkSize: chunkSize, Padding: sealer.PaddingChunk}, original)
+		if (len(sealed)-headerLen)%chunkLen != 0 {
+			t.Fatalf("size %d: sealed to %d bytes, not a whole number of chunks", n, len(sealed))
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package sealer
+
+import "encoding/binary"
+
+// parallelSealer seals the chunks of an encryptor on up to workers
+// goroutines at once. The encryptor does everything else in order,
+// including reading random nonces, so that the output is the same as when
+// sealing sequentially; the sealed chunks are queued, and written out in
+// order by the encryptor's goroutine as they are done.
+type parallelSealer struct {
+	sem   chan struct{}
+	queue []*sealJob
+	free  [][]byte
+	sent  [][]byte
+	size  int
+}
+
+// sealJob is a chunk being sealed, or a prefix or sync marker queued
+// behind one.
+type sealJob struct {
+	output  []byte
+	isChunk bool
+	isFinal bool
+	done    chan struct{}
+}
+
+func newParallelSealer(workers, size int) *parallelSealer {
+	return &parallelSealer{sem: make(chan struct{}, workers), size: size}
+}
+
+// buffer returns a buffer for a chunk of the maximum size.
+func (p *parallelSealer) buffer() []byte {
+	if n := len(p.free); n > 0 {
+		buf := p.free[n-1]
+		p.free = p.free[:n-1]
+		return buf[:p.size]
+	}
+	return make([]byte, p.size)
+}
+
+// seal starts sealing data into a chunk starting with header, which is
+// a buffer returned by buffer, and queues it. It waits for the oldest
+// chunks to be written out if too many are queued.
+func (p *parallelSealer) seal(e *encryptor, header, nonce, data, ad []byte, isFinal bool) error {
+	err := e.drain(2*cap(p.sem) - 1)
+	if err != nil {
+		return err
+	}
+	hdrSize := len(header)
+	// the arguments other than header are reused by the caller
+	plaintext := header[hdrSize : hdrSize+copy(header[hdrSize:cap(header)], data)]
+	jobNonce := append([]byte(nil), nonce...)
+	jobAD := append([]byte(nil), ad...)
+	// the encryptor can be reset while abandoned jobs are running
+	aead := e.aead
+	job := &sealJob{isChunk: true, isFinal: isFinal, done: make(chan struct{})}
+	p.queue = append(p.queue, job)
+	go func() {
+		p.sem <- struct{}{}
+		sealed := aead.Seal(plaintext[:0], jobNonce, plaintext, jobAD)
+		job.output = header[:hdrSize+len(sealed)]
+		<-p.sem
+		close(job.done)
+	}()
+	return nil
+}
+
+// write writes data out after the chunks queued for sealing, if any, along
+// with the next chunk. data has to remain unchanged until then.
+func (e *encryptor) write(data []byte) error {
+	if e.par == nil || len(e.par.queue) == 0 {
+		e.vec = append(e.vec, data)
+		return nil
+	}
+	job := &sealJob{output: append([]byte(nil), data...), done: make(chan struct{})}
+	close(job.done)
+	e.par.queue = append(e.par.queue, job)
+	return nil
+}
+
+// drain writes out queued chunks, waiting for them to be sealed, until at
+// most keep remain queued.
+func (e *encryptor) drain(keep int) error {
+	p := e.par
+	if len(p.queue) <= keep {
+		// anything written before stays to go out with the next chunk
+		return nil
+	}
+	for len(p.queue) > keep {
+		job := p.queue[0]
+		select {
+		case <-job.done:
+		default:
+			// write out what is ready while waiting
+			err := e.sendDrained()
+			if err != nil {
+				return err
+			}
+			<-job.done
+		}
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		if job.isChunk {
+			e.emit(job.output, job.isFinal)
+			p.sent = append(p.sent, job.output)
+		} else {
+			e.vec = append(e.vec, job.output)
+		}
+	}
+	return e.sendDrained()
+}
+
+// sendDrained writes out the chunks drained so far, and reuses their
+// buffers.
+func (e *encryptor) sendDrained() error {
+	p := e.par
+	err := e.send()
+	p.free = append(p.free, p.sent...)
+	clear(p.sent)
+	p.sent = p.sent[:0]
+	return err
+}
+
+// parallelOpener reads chunks ahead for a decryptor, and opens them on up
+// to cap(sem) goroutines at once. Chunks are read and handed out in order
+// by the decryptor's goroutine; reading stops at the final chunk, so that
+// the input is left right after it, like when reading sequentially.
+type parallelOpener struct {
+	sem   chan struct{}
+	queue []*openJob
+	free  []*openJob
+
+	// next is the index of the next chunk to read ahead, and done is set
+	// once the final chunk, or an error, has been read.
+	next uint32
+	done bool
+
+	// err is the error of the chunk that failed, after which the input is
+	// no longer where the decryptor expects it.
+	err error
+
+	// current is the job holding the current chunk.
+	current *openJob
+}
+
+// openJob is a chunk being opened, read into buf (or held by a memInput).
+type openJob struct {
+	buf     []byte
+	chunk   []byte
+	plain   []byte
+	isFinal bool
+	err     error
+	done    chan struct{}
+}
+
+// reset drops the chunks read ahead, after the decryptor has been moved to
+// another chunk.
+func (p *parallelOpener) reset() {
+	// the jobs being opened may still write into their buffers
+	p.queue, p.free, p.current = nil, nil, nil
+	p.done, p.err = false, nil
+}
+
+// wipe waits for the chunks being opened, and clears the plaintext of all
+// the chunks.
+func (p *parallelOpener) wipe() {
+	jobs := append(p.queue, p.free...)
+	if p.current != nil {
+		jobs = append(jobs, p.current)
+	}
+	for _, job := range jobs {
+		<-job.done
+		clear(job.plain[:cap(job.plain)])
+	}
+	p.queue, p.free, p.current = nil, nil, nil
+}
+
+// readParallel implements read for decryptors with a parallelOpener.
+func (dec *decryptor) readParallel(prefix []byte) error {
+	p := dec.par
+	if p.err != nil {
+		return p.err
+	}
+	if p.current != nil {
+		p.free = append(p.free, p.current)
+		p.current = nil
+	}
+	if len(p.queue) == 0 {
+		p.next = dec.chunkIndex
+	}
+	for len(p.queue) < 2*cap(p.sem) && !p.done {
+		ad := dec.ad
+		if p.next == dec.chunkIndex {
+			ad = prefix
+		}
+		dec.readAhead(ad)
+	}
+
+	job := p.queue[0]
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	<-job.done
+	dec.chunkIndex++
+	p.current = job
+	if job.err != nil {
+		p.err = chunkError(job.err, int64(dec.chunkIndex-1), dec.pos)
+		return p.err
+	}
+	dec.opened(job.chunk, job.plain, job.isFinal)
+	return nil
+}
+
+// readAhead reads the next chunk, and starts opening it with the given
+// additional data.
+func (dec *decryptor) readAhead(ad []byte) {
+	p := dec.par
+	var job *openJob
+	if n := len(p.free); n > 0 {
+		job = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		job = &openJob{buf: make([]byte, len(dec.readBuf)), plain: make([]byte, 0, dec.chunkSize)}
+	}
+	job.done = make(chan struct{})
+	p.queue = append(p.queue, job)
+	index := p.next
+	p.next++
+
+	job.chunk, job.err = dec.readChunk(job.buf, index)
+	if job.err != nil {
+		p.done = true
+		close(job.done)
+		return
+	}
+	if binary.LittleEndian.Uint32(job.chunk) == finalChunkIndex {
+		p.done = true
+	}
+	// a copy for the scratch buffers of this job
+	d := *dec
+	d.adBuf = nil
+	go func() {
+		p.sem <- struct{}{}
+		job.plain, job.isFinal, job.err = d.open(job.plain[:0], job.chunk, index, ad)
+		<-p.sem
+		close(job.done)
+	}()
+}
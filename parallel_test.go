@@ -0,0 +1,253 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealOptions_concurrency(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 300000)
+	for i := 0; i < len(original); i += 2000 {
+		clear(original[i : i+1000])
+	}
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, RandomNonces: true, SyncInterval: 3, Padding: sealer.PaddingPadme},
+		{Compression: sealer.CompressionNone, SeekIndex: true, MerkleRoot: true, PlaintextDigest: true},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true, MerkleRoot: true, RecordSize: true},
+	} {
+		opt.ChunkSize = 4096
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.RandomNonces), func(t *testing.T) {
+			// with the same nonces, the output is the same
+			var sealed [2][]byte
+			var manifests [2]sealer.Manifest
+			for i, concurrency := range []int{0, 4} {
+				opt.Concurrency = concurrency
+				opt.RandomReader = rand.NewChaCha8([32]byte{1})
+				opt.Manifest = &manifests[i]
+				sealed[i] = seal(t, []*sealer.Key{key}, nil, opt, original)
+			}
+			if !bytes.Equal(sealed[0], sealed[1]) {
+				t.Fatalf("sealed %d bytes in parallel, %d sequentially, which differ", len(sealed[1]), len(sealed[0]))
+			}
+			if len(manifests[1].Chunks) != len(manifests[0].Chunks) {
+				t.Errorf("manifest has %d chunks, wanted %d", len(manifests[1].Chunks), len(manifests[0].Chunks))
+			}
+
+			actual, err := io.ReadAll(openReader(t, sealed[1], key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("got %d bytes, wanted %d", len(actual), len(original))
+			}
+		})
+	}
+}
+
+func TestOpenOptions_concurrency(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 300000)
+	for i := 0; i < len(original); i += 2000 {
+		clear(original[i : i+1000])
+	}
+	for _, opt := range []sealer.SealOptions{
+		{CommitKey: true},
+		{Compression: sealer.CompressionNone, RandomNonces: true, SyncInterval: 3, Padding: sealer.PaddingPadme},
+		{Compression: sealer.CompressionNone, SeekIndex: true, MerkleRoot: true, PlaintextDigest: true},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true, MerkleRoot: true, RecordSize: true},
+	} {
+		opt.ChunkSize = 4096
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.RandomNonces), func(t *testing.T) {
+			sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+			open := func(sealed []byte) (*sealer.Reader, *bytes.Reader) {
+				in := bytes.NewReader(sealed)
+				opn, err := sealer.Prepare(in, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opn.Concurrency = 4
+				r, err := opn.Open(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return r, in
+			}
+
+			// the input is left right after the final chunk
+			r, in := open(append(bytes.Clone(sealed), "more"...))
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("got %d bytes, wanted %d", len(actual), len(original))
+			}
+			if rest, _ := io.ReadAll(in); string(rest) != "more" {
+				t.Errorf("input left at %q", rest)
+			}
+
+			if opt.SeekIndex {
+				r, _ := open(sealed)
+				if _, err := io.CopyN(io.Discard, r, 100000); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Seek(5000, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(actual, original[5000:]) {
+					t.Errorf("after seeking, got %d bytes: %v", len(actual), err)
+				}
+			}
+
+			damaged := bytes.Clone(sealed)
+			damaged[len(damaged)/2] ^= 1
+			r, _ = open(damaged)
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("damaged file read without an error")
+			}
+			r, _ = open(sealed[:len(sealed)-100])
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("truncated file read without an error")
+			}
+		})
+	}
+}
+
+func TestSeal_vectoredWrites(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, SyncInterval: 2, RandomNonces: true},
+		{Compression: sealer.CompressionZstdChunks, SyncInterval: 1, Concurrency: 4, MerkleRoot: true},
+	} {
+		opt.ChunkSize = 4096
+		opt.RandomReader = rand.NewChaCha8([32]byte{1})
+		expected := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+		// connections get the prefix and the sync markers written along
+		// with the chunks in writev calls
+		received := make(chan []byte)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}()
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt.RandomReader = rand.NewChaCha8([32]byte{1})
+		w, err := sealer.Seal(conn, []*sealer.Key{key}, nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if actual := <-received; !bytes.Equal(actual, expected) {
+			t.Errorf("%+v: received %d bytes, wanted %d", opt, len(actual), len(expected))
+		}
+	}
+}
+
+// failingWriter fails with errDiskFull once more than n bytes have been
+// written.
+type failingWriter struct {
+	n int
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errDiskFull
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestSealOptions_pipeline(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 300000)
+	for i := 0; i < len(original); i += 2000 {
+		clear(original[i : i+1000])
+	}
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionBrotli, RandomNonces: true, SyncInterval: 3, Padding: sealer.PaddingPadme},
+		{Compression: sealer.CompressionZstd, MerkleRoot: true, PlaintextDigest: true, Concurrency: 4},
+	} {
+		opt.ChunkSize = 4096
+		t.Run(opt.Compression.String(), func(t *testing.T) {
+			var sealed [2][]byte
+			for i, pipeline := range []bool{false, true} {
+				opt.Pipeline = pipeline
+				opt.RandomReader = rand.NewChaCha8([32]byte{1})
+				sealed[i] = seal(t, []*sealer.Key{key}, nil, opt, original)
+			}
+			if !bytes.Equal(sealed[0], sealed[1]) {
+				t.Fatalf("sealed %d bytes with a pipeline, %d without, which differ", len(sealed[1]), len(sealed[0]))
+			}
+			actual, err := io.ReadAll(openReader(t, sealed[1], key))
+			if err != nil || !bytes.Equal(actual, original) {
+				t.Errorf("got %d bytes: %v", len(actual), err)
+			}
+
+			// write errors come out of a later Write or Close
+			opt.Pipeline = true
+			w, err := sealer.Seal(&failingWriter{n: 10000}, []*sealer.Key{key}, nil, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = w.Write(original)
+			if err == nil {
+				err = w.Close()
+			}
+			if !errors.Is(err, errDiskFull) {
+				t.Errorf("err = %v, wanted the write error", err)
+			}
+
+			// an abandoned stream doesn't leak into the next one
+			var buf bytes.Buffer
+			if err := w.Reset(&buf, []*sealer.Key{key}, nil, opt); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(original); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			actual, err = io.ReadAll(openReader(t, buf.Bytes(), key))
+			if err != nil || !bytes.Equal(actual, original) {
+				t.Errorf("after Reset, got %d bytes: %v", len(actual), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package sealer
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies a passphrase-based key derivation function.
+type KDF uint8
+
+const (
+	KDFArgon2id KDF = 1
+	KDFScrypt   KDF = 2
+	KDFPBKDF2   KDF = 3 // PBKDF2-HMAC-SHA256
+)
+
+// KDFParams configures the derivation of a wrapping key from a passphrase.
+// The parameters are stored in the header, so a file can always be opened
+// with the parameters it was sealed with. Zero fields take defaults for
+// the chosen algorithm, and a zero Algorithm means Argon2id.
+type KDFParams struct {
+	Algorithm KDF
+
+	// Time is the number of Argon2id passes or PBKDF2 iterations.
+	Time uint32
+
+	// Memory is the Argon2id memory size in KiB.
+	Memory uint32
+
+	// Parallelism is the number of Argon2id threads or scrypt's p.
+	Parallelism uint8
+
+	// LogN is scrypt's CPU/memory cost, N = 2^LogN.
+	LogN uint8
+
+	// BlockSize is scrypt's r.
+	BlockSize uint8
+}
+
+// Default and maximum KDF parameters. Opener refuses files with parameters
+// above the maximums, so that an untrusted file cannot make it burn
+// gigabytes of memory or minutes of CPU time.
+const (
+	DefaultArgon2Time        = 3
+	DefaultArgon2Memory      = 64 * 1024
+	DefaultArgon2Parallelism = 4
+	MaxArgon2Time            = 64
+	MaxArgon2Memory          = 1024 * 1024
+	MaxArgon2Parallelism     = 16
+
+	DefaultScryptLogN        = 18
+	DefaultScryptBlockSize   = 8
+	DefaultScryptParallelism = 1
+	MaxScryptMemory          = 1024 * 1024 * 1024 // 128 * r * N bytes
+	MaxScryptParallelism     = 16
+
+	DefaultPBKDF2Iterations = 600_000
+	MaxPBKDF2Iterations     = 10_000_000
+)
+
+// Passphrase slot data:
+//  - algorithm       uint8
+//  - time            uint32
+//  - memory          uint32
+//  - parallelism     uint8
+//  - logN            uint8
+//  - blockSize       uint8
+//  - salt            [passphraseSaltSize]byte
+//  - encapsulatedKey [nonceSizeX + KeySize + overhead]byte
+//
+// The parameters are authenticated as additional data of encapsulatedKey.
+
+const (
+	kdfParamsSize       = 12
+	passphraseSaltSize  = 16
+	passphraseSlotSize  = kdfParamsSize + passphraseSaltSize + encapsulatedSize
+	passphraseSlotOffEK = kdfParamsSize + passphraseSaltSize
+)
+
+func (p KDFParams) withDefaults() KDFParams {
+	if p.Algorithm == 0 {
+		p.Algorithm = KDFArgon2id
+	}
+	switch p.Algorithm {
+	case KDFArgon2id:
+		if p.Time == 0 {
+			p.Time = DefaultArgon2Time
+		}
+		if p.Memory == 0 {
+			p.Memory = DefaultArgon2Memory
+		}
+		if p.Parallelism == 0 {
+			p.Parallelism = DefaultArgon2Parallelism
+		}
+	case KDFScrypt:
+		if p.LogN == 0 {
+			p.LogN = DefaultScryptLogN
+		}
+		if p.BlockSize == 0 {
+			p.BlockSize = DefaultScryptBlockSize
+		}
+		if p.Parallelism == 0 {
+			p.Parallelism = DefaultScryptParallelism
+		}
+	case KDFPBKDF2:
+		if p.Time == 0 {
+			p.Time = DefaultPBKDF2Iterations
+		}
+	}
+	return p
+}
+
+func (p KDFParams) validate() error {
+	switch p.Algorithm {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Memory < 8*uint32(p.Parallelism) || p.Parallelism == 0 {
+			return fmt.Errorf("%w: invalid Argon2id parameters", ErrInvalidKDFParams)
+		}
+		if p.Time > MaxArgon2Time || p.Memory > MaxArgon2Memory || p.Parallelism > MaxArgon2Parallelism {
+			return fmt.Errorf("%w: Argon2id parameters exceed limits", ErrInvalidKDFParams)
+		}
+	case KDFScrypt:
+		if p.LogN < 1 || p.LogN > 63 || p.BlockSize == 0 || p.Parallelism == 0 {
+			return fmt.Errorf("%w: invalid scrypt parameters", ErrInvalidKDFParams)
+		}
+		if p.LogN > 30 || 128*uint64(p.BlockSize)<<p.LogN > MaxScryptMemory || p.Parallelism > MaxScryptParallelism {
+			return fmt.Errorf("%w: scrypt parameters exceed limits", ErrInvalidKDFParams)
+		}
+	case KDFPBKDF2:
+		if p.Time == 0 {
+			return fmt.Errorf("%w: invalid PBKDF2 parameters", ErrInvalidKDFParams)
+		}
+		if p.Time > MaxPBKDF2Iterations {
+			return fmt.Errorf("%w: PBKDF2 parameters exceed limits", ErrInvalidKDFParams)
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %d", ErrInvalidKDFParams, p.Algorithm)
+	}
+	return nil
+}
+
+func (p KDFParams) append(buf []byte) []byte {
+	buf = append(buf, byte(p.Algorithm))
+	buf = binary.LittleEndian.AppendUint32(buf, p.Time)
+	buf = binary.LittleEndian.AppendUint32(buf, p.Memory)
+	return append(buf, p.Parallelism, p.LogN, p.BlockSize)
+}
+
+func parseKDFParams(b []byte) KDFParams {
+	return KDFParams{
+		Algorithm:   KDF(b[0]),
+		Time:        binary.LittleEndian.Uint32(b[1:]),
+		Memory:      binary.LittleEndian.Uint32(b[5:]),
+		Parallelism: b[9],
+		LogN:        b[10],
+		BlockSize:   b[11],
+	}
+}
+
+func (p KDFParams) deriveKey(passphrase, salt []byte) ([]byte, error) {
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Parallelism, KeySize), nil
+	case KDFScrypt:
+		return scrypt.Key(passphrase, salt, 1<<p.LogN, int(p.BlockSize), int(p.Parallelism), KeySize)
+	case KDFPBKDF2:
+		return pbkdf2.Key(sha256.New, string(passphrase), salt, int(p.Time), KeySize)
+	default:
+		panic("unreachable")
+	}
+}
+
+func encapsulatePassphrase(passphrase []byte, params KDFParams, ephemeralKey []byte, random io.Reader) (Slot, error) {
+	params = params.withDefaults()
+	if err := params.validate(); err != nil {
+		return Slot{}, err
+	}
+
+	data := make([]byte, 0, passphraseSlotSize)
+	data = params.append(data)
+	data = data[:passphraseSlotSize]
+	if _, err := io.ReadFull(random, data[kdfParamsSize:passphraseSlotOffEK+nonceSizeX]); err != nil {
+		return Slot{}, fmt.Errorf("generating salt: %w", err)
+	}
+
+	wrappingKey, err := params.deriveKey(passphrase, data[kdfParamsSize:passphraseSlotOffEK])
+	if err != nil {
+		return Slot{}, err
+	}
+	defer clear(wrappingKey)
+
+	encapsulated := data[passphraseSlotOffEK:]
+	copy(encapsulated[nonceSizeX:], ephemeralKey)
+	err = encapsulate(wrappingKey, encapsulated, data[:kdfParamsSize])
+	if err != nil {
+		return Slot{}, err
+	}
+	return Slot{Type: SlotTypePassphrase, Data: data}, nil
+}
+
+func decapsulatePassphrase(output []byte, passphrase []byte, s *Slot) error {
+	if len(s.Data) != passphraseSlotSize {
+		return ErrUnsupportedVersion
+	}
+	params := parseKDFParams(s.Data)
+	if err := params.validate(); err != nil {
+		return err
+	}
+	wrappingKey, err := params.deriveKey(passphrase, s.Data[kdfParamsSize:passphraseSlotOffEK])
+	if err != nil {
+		return err
+	}
+	defer clear(wrappingKey)
+	return decapsulate(output, wrappingKey, s.Data[passphraseSlotOffEK:], s.Data[:kdfParamsSize])
+}
+
+// OpenPassphrase decrypts the file key using a passphrase slot and returns
+// a Reader of the plaintext. Files with KDF parameters exceeding the maximum
+// limits are rejected with ErrInvalidKDFParams before any work is done.
+func (opn *Openable) OpenPassphrase(passphrase []byte) (*Reader, error) {
+	var ephemeralKey [KeySize]byte
+	defer clear(ephemeralKey[:])
+	err := ErrNoMatchingKey
+	for i := range opn.hdr.slots {
+		s := &opn.hdr.slots[i]
+		if s.Type != SlotTypePassphrase {
+			continue
+		}
+		err = decapsulatePassphrase(ephemeralKey[:], passphrase, s)
+		if err == nil {
+			return opn.open(ephemeralKey[:])
+		}
+	}
+	return nil, err
+}
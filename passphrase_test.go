@@ -0,0 +1,95 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_passphrase(t *testing.T) {
+	tests := []sealer.KDFParams{
+		{Algorithm: sealer.KDFArgon2id, Time: 1, Memory: 1024, Parallelism: 1},
+		{Algorithm: sealer.KDFScrypt, LogN: 10},
+		{Algorithm: sealer.KDFPBKDF2, Time: 1000},
+	}
+	for _, params := range tests {
+		original := []byte("hello, world")
+		sealed := seal(t, nil, nil, sealer.SealOptions{
+			Passphrase: []byte("correct horse battery staple"),
+			KDF:        params,
+		}, original)
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = opn.OpenPassphrase([]byte("wrong"))
+		if err == nil {
+			t.Fatalf("KDF %d: opened with a wrong passphrase", params.Algorithm)
+		}
+
+		opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.OpenPassphrase([]byte("correct horse battery staple"))
+		if err != nil {
+			t.Fatalf("KDF %d: %v", params.Algorithm, err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+}
+
+func TestSealer_passphraseLimits(t *testing.T) {
+	_, err := sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+		Passphrase: []byte("x"),
+		KDF:        sealer.KDFParams{Algorithm: sealer.KDFArgon2id, Memory: sealer.MaxArgon2Memory + 1},
+	})
+	if !errors.Is(err, sealer.ErrInvalidKDFParams) {
+		t.Fatalf("got %v, wanted %v", err, sealer.ErrInvalidKDFParams)
+	}
+
+	sealed := seal(t, nil, nil, sealer.SealOptions{
+		Passphrase: []byte("x"),
+		KDF:        sealer.KDFParams{Algorithm: sealer.KDFPBKDF2, Time: 1000},
+	}, []byte("hello"))
+
+	// bump PBKDF2 iterations in the header: v1 fixed header, field header,
+	// slot type, key ID, then algorithm byte
+	const offTime = 12 + 4 + 2 + sealer.IDSize + 1
+	sealed[offTime+3] = 0xff
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.OpenPassphrase([]byte("x"))
+	if !errors.Is(err, sealer.ErrInvalidKDFParams) {
+		t.Fatalf("got %v, wanted %v", err, sealer.ErrInvalidKDFParams)
+	}
+
+	// bump Argon2id parallelism, which follows time and memory, to 255
+	sealed = seal(t, nil, nil, sealer.SealOptions{
+		Passphrase: []byte("x"),
+		KDF:        sealer.KDFParams{Algorithm: sealer.KDFArgon2id, Time: 1, Memory: 4096, Parallelism: 1},
+	}, []byte("hello"))
+	sealed[offTime+8] = 0xff
+
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.OpenPassphrase([]byte("x"))
+	if !errors.Is(err, sealer.ErrInvalidKDFParams) {
+		t.Fatalf("parallelism 255: got %v, wanted %v", err, sealer.ErrInvalidKDFParams)
+	}
+}
@@ -0,0 +1,119 @@
+package sealer
+
+import (
+	"io"
+	"sync"
+)
+
+// pipelineDepth is the number of chunk buffers in flight between the
+// stages of a pipeline (see SealOptions.Pipeline).
+const pipelineDepth = 4
+
+// pipeline runs the encryptor on a goroutine of its own, so that
+// compressing and sealing overlap. The compressor fills chunk buffers that
+// are handed over through full, and come back through free once sealed.
+type pipeline struct {
+	size int
+	buf  []byte
+	full chan []byte
+	free chan []byte
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+
+	closed bool
+}
+
+func newPipeline(enc *encryptor, old *pipeline) *pipeline {
+	p := &pipeline{
+		size: enc.chunkSize,
+		full: make(chan []byte, pipelineDepth-1),
+		free: make(chan []byte, pipelineDepth),
+		done: make(chan struct{}),
+	}
+	for range pipelineDepth {
+		var buf []byte
+		if old != nil && len(old.free) > 0 {
+			buf = <-old.free
+		}
+		p.free <- buf
+	}
+	go p.run(enc)
+	return p
+}
+
+// run seals the chunks handed over until full is closed. After an error,
+// the chunks are still taken and returned, so that Write doesn't block.
+func (p *pipeline) run(enc *encryptor) {
+	defer close(p.done)
+	for buf := range p.full {
+		if p.failed() == nil {
+			_, err := enc.Write(buf)
+			if err != nil {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+		}
+		p.free <- buf[:0]
+	}
+}
+
+func (p *pipeline) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Write copies data into chunk buffers, handing them over as they fill up.
+// Errors of the encryptor are reported by a later Write or by close.
+func (p *pipeline) Write(data []byte) (int, error) {
+	if err := p.failed(); err != nil {
+		return 0, err
+	}
+	n := len(data)
+	for len(data) > 0 {
+		if p.buf == nil {
+			p.buf = <-p.free
+			if cap(p.buf) < p.size {
+				p.buf = make([]byte, 0, p.size)
+			}
+		}
+		k := min(len(data), p.size-len(p.buf))
+		p.buf = append(p.buf, data[:k]...)
+		data = data[k:]
+		if len(p.buf) == p.size {
+			p.full <- p.buf
+			p.buf = nil
+		}
+	}
+	return n, nil
+}
+
+// abandon stops the pipeline without sealing what is left.
+func (p *pipeline) abandon() {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = io.ErrClosedPipe
+	}
+	p.mu.Unlock()
+	p.close()
+}
+
+// close hands over the last chunk buffer and waits for everything to be
+// sealed. It can be called more than once.
+func (p *pipeline) close() error {
+	if !p.closed {
+		p.closed = true
+		if len(p.buf) > 0 {
+			p.full <- p.buf
+		} else if p.buf != nil {
+			p.free <- p.buf
+		}
+		p.buf = nil
+		close(p.full)
+		<-p.done
+	}
+	return p.failed()
+}
@@ -0,0 +1,154 @@
+// Package pkcs11wrap wraps sealer file keys inside a hardware security
+// module, so that the key-encryption key never leaves the token.
+//
+// The package does not link against a PKCS#11 library itself. Instead, you
+// provide a Session backed by your PKCS#11 binding of choice, typically
+// calling C_EncryptInit/C_Encrypt and C_DecryptInit/C_Decrypt (or
+// C_WrapKey/C_UnwrapKey) with the requested mechanism and the KEK found by
+// its CKA_LABEL. With github.com/miekg/pkcs11, WrapKey is roughly:
+//
+//	obj := findObjectByLabel(ctx, sh, kekLabel)
+//	err := ctx.EncryptInit(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(uint(mech), nil)}, obj)
+//	return ctx.Encrypt(sh, key)
+//
+// Alternatively, NewRSA works with any crypto.Decrypter backed by an HSM
+// RSA key (e.g. from github.com/ThalesIgnite/crypto11), encrypting with the
+// public key locally and decrypting on the token.
+package pkcs11wrap
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+)
+
+// Mechanism is a PKCS#11 mechanism type (CKM_*).
+type Mechanism uint32
+
+// Mechanisms suitable for wrapping file keys.
+const (
+	CKM_RSA_PKCS_OAEP    Mechanism = 0x00000009
+	CKM_AES_KEY_WRAP     Mechanism = 0x00002109 // RFC 3394
+	CKM_AES_KEY_WRAP_KWP Mechanism = 0x0000210B // RFC 5649
+)
+
+// Session performs wrapping operations on the token using the KEK with the
+// given label.
+type Session interface {
+	WrapKey(mech Mechanism, kekLabel string, key []byte) ([]byte, error)
+	UnwrapKey(mech Mechanism, kekLabel string, wrapped []byte) ([]byte, error)
+}
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using a KEK
+// stored on a token.
+type Wrapper struct {
+	session Session
+	label   string
+	mech    Mechanism
+}
+
+// New returns a Wrapper that wraps file keys with the on-token KEK with the
+// given label, using mech (e.g. CKM_AES_KEY_WRAP).
+func New(session Session, kekLabel string, mech Mechanism) *Wrapper {
+	return &Wrapper{
+		session: session,
+		label:   kekLabel,
+		mech:    mech,
+	}
+}
+
+// NewRSA returns a Wrapper that wraps file keys with RSA-OAEP (SHA-256)
+// using pub, and unwraps them using dec, which is normally an HSM-backed
+// private key. dec can be nil if the wrapper is only used for sealing.
+func NewRSA(pub *rsa.PublicKey, dec crypto.Decrypter, kekLabel string) *Wrapper {
+	return &Wrapper{
+		session: &rsaSession{pub, dec},
+		label:   kekLabel,
+		mech:    CKM_RSA_PKCS_OAEP,
+	}
+}
+
+// PKCS#11 slot data is the KEK label and, packed within, the mechanism
+// (uint32) followed by the wrapped key.
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	wrapped, err := w.session.WrapKey(w.mech, w.label, fileKey)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("pkcs11wrap: %w", err)
+	}
+	ciphertext := binary.LittleEndian.AppendUint32(nil, uint32(w.mech))
+	ciphertext = append(ciphertext, wrapped...)
+	data := slotdata.Pack(w.label, ciphertext)
+	return sealer.Slot{Type: sealer.SlotTypePKCS11, KeyID: KeyID(w.label), Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter: only AES key wrapping
+// is symmetric.
+func (w *Wrapper) PostQuantum() bool {
+	return w.mech == CKM_AES_KEY_WRAP || w.mech == CKM_AES_KEY_WRAP_KWP
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	label, mech, wrapped, ok := parseSlot(s)
+	if !ok || label != w.label {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.session.UnwrapKey(mech, w.label, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11wrap: %w", err)
+	}
+	return fileKey, nil
+}
+
+// KeyID returns the sealer key ID used for slots wrapped by the KEK with
+// the given label.
+func KeyID(kekLabel string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("pkcs11:" + kekLabel))
+}
+
+// KEKLabel returns the label of the KEK and the mechanism required to open
+// the slot, or false if it's not a PKCS#11 slot.
+func KEKLabel(s sealer.Slot) (string, Mechanism, bool) {
+	label, mech, _, ok := parseSlot(s)
+	return label, mech, ok
+}
+
+func parseSlot(s sealer.Slot) (label string, mech Mechanism, wrapped []byte, ok bool) {
+	if s.Type != sealer.SlotTypePKCS11 {
+		return "", 0, nil, false
+	}
+	label, rest, ok := slotdata.Unpack(s.Data)
+	if !ok || len(rest) < 4 {
+		return "", 0, nil, false
+	}
+	return label, Mechanism(binary.LittleEndian.Uint32(rest)), rest[4:], true
+}
+
+type rsaSession struct {
+	pub *rsa.PublicKey
+	dec crypto.Decrypter
+}
+
+func (s *rsaSession) WrapKey(mech Mechanism, kekLabel string, key []byte) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, s.pub, key, nil)
+}
+
+func (s *rsaSession) UnwrapKey(mech Mechanism, kekLabel string, wrapped []byte) ([]byte, error) {
+	if mech != CKM_RSA_PKCS_OAEP {
+		return nil, fmt.Errorf("unsupported mechanism 0x%x", mech)
+	}
+	if s.dec == nil {
+		return nil, errors.New("no decrypter")
+	}
+	return s.dec.Decrypt(rand.Reader, wrapped, &rsa.OAEPOptions{Hash: crypto.SHA256})
+}
@@ -0,0 +1,129 @@
+package pkcs11wrap_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/pkcs11wrap"
+)
+
+// softToken implements CKM_AES_KEY_WRAP (RFC 3394) in software.
+type softToken struct {
+	keks map[string][]byte
+}
+
+var iv = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+func (tok *softToken) WrapKey(mech pkcs11wrap.Mechanism, label string, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(tok.keks[label])
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, iv)
+	copy(out[8:], key)
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:])
+			block.Encrypt(b[:], b[:])
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(out[8*i:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+func (tok *softToken) UnwrapKey(mech pkcs11wrap.Mechanism, label string, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(tok.keks[label])
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := bytes.Clone(wrapped)
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^uint64(n*j+i))
+			copy(b[8:], out[8*i:])
+			block.Decrypt(b[:], b[:])
+			copy(out[:8], b[:8])
+			copy(out[8*i:], b[8:])
+		}
+	}
+	if !bytes.Equal(out[:8], iv) {
+		return nil, errors.New("integrity check failed")
+	}
+	return out[8:], nil
+}
+
+func TestWrapper(t *testing.T) {
+	kek := make([]byte, 32)
+	rand.Read(kek)
+	tok := &softToken{keks: map[string][]byte{"kek1": kek}}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrappers := []*pkcs11wrap.Wrapper{
+		pkcs11wrap.New(tok, "kek1", pkcs11wrap.CKM_AES_KEY_WRAP),
+		pkcs11wrap.NewRSA(&rsaKey.PublicKey, rsaKey, "rsa1"),
+	}
+	for i, wrapper := range wrappers {
+		// only AES key wrapping is post-quantum
+		var wanted error
+		if i == 1 {
+			wanted = sealer.ErrNotPostQuantum
+		}
+		_, err := sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+			Encapsulators: []sealer.Encapsulator{wrapper},
+			PostQuantum:   true,
+		})
+		if err != wanted {
+			t.Errorf("Seal to wrapper %d with PostQuantum = %v, wanted %v", i, err, wanted)
+		}
+
+		original := []byte("hello, world")
+		var sealed bytes.Buffer
+		w, err := sealer.Seal(&sealed, nil, nil, sealer.SealOptions{
+			Encapsulators: []sealer.Encapsulator{wrapper},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(original)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opn, err := sealer.Prepare(&sealed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := pkcs11wrap.KEKLabel(opn.Slots[0]); !ok {
+			t.Fatal("KEKLabel failed")
+		}
+		r, err := opn.OpenWith(wrapper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+}
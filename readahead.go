@@ -0,0 +1,84 @@
+package sealer
+
+import "sync"
+
+// prefetcher decrypts the chunks following a sequential read of a ReaderAt
+// in background goroutines, so that the next read finds them ready.
+type prefetcher struct {
+	depth int64
+
+	mu sync.Mutex
+
+	// next is the chunk after the last one read; a read starting there, or
+	// in the last chunk, continues the previous one.
+	next int64
+
+	// pending are the chunks being, or already, decrypted ahead.
+	pending map[int64]*prefetch
+}
+
+type prefetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// take returns the plaintext of the chunk with the given index if it has
+// been prefetched, waiting for it to be decrypted if needed.
+func (pf *prefetcher) take(index int64) ([]byte, bool) {
+	if pf == nil {
+		return nil, false
+	}
+	pf.mu.Lock()
+	p := pf.pending[index]
+	delete(pf.pending, index)
+	pf.mu.Unlock()
+	if p == nil {
+		return nil, false
+	}
+	<-p.done
+	// on errors, the chunk is read again to report them
+	return p.data, p.err == nil
+}
+
+// readahead is called after a read of chunks first to last, and prefetches
+// the chunks after them if the read continues the previous one. tail is the
+// plaintext of the last chunk if the read ended before its end.
+func (pf *prefetcher) readahead(ra *ReaderAt, first, last int64, tail []byte) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	sequential := first == pf.next || first == pf.next-1
+	pf.next = last + 1
+	if !sequential {
+		clear(pf.pending)
+		return
+	}
+	for index := range pf.pending {
+		if index < last || index > last+pf.depth {
+			delete(pf.pending, index)
+		}
+	}
+	if tail != nil {
+		p := &prefetch{done: make(chan struct{}), data: tail}
+		close(p.done)
+		pf.pending[last] = p
+	}
+
+	perChunk := int64(ra.dec.chunkSize)
+	if ra.dec.variableChunks {
+		perChunk--
+	}
+	end := min(last+pf.depth, (ra.index.plaintext-1)/perChunk)
+	for index := last + 1; index <= end; index++ {
+		if pf.pending[index] != nil {
+			continue
+		}
+		p := &prefetch{done: make(chan struct{})}
+		pf.pending[index] = p
+		go func() {
+			defer close(p.done)
+			dec := ra.dec
+			p.data, _, p.err = ra.readChunk(&dec, index, nil, nil)
+		}()
+	}
+}
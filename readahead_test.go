@@ -0,0 +1,75 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+// countingReaderAt counts the reads from a bytes.Reader.
+type countingReaderAt struct {
+	*bytes.Reader
+	reads atomic.Int32
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads.Add(1)
+	return c.Reader.ReadAt(p, off)
+}
+
+func TestOpenable_readahead(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true},
+	} {
+		opt.SeekIndex = true
+		opt.ChunkSize = 4096
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+		in := &countingReaderAt{Reader: bytes.NewReader(sealed)}
+		opn, err := sealer.PrepareAt(in, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.Readahead = 4
+		ra, err := opn.NewReaderAt(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// sequential reads smaller than a chunk decrypt every chunk once
+		before := in.reads.Load()
+		actual, err := io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("%v: got %d bytes, wanted %d", opt.Compression, len(actual), len(original))
+		}
+		chunks := (len(original) + 4095) / 4096
+		if opt.Compression == sealer.CompressionNone {
+			if n := in.reads.Load() - before; n > int32(2*chunks) {
+				t.Errorf("%v: %d reads of %d chunks", opt.Compression, n, chunks)
+			}
+		}
+
+		// random reads still return the right data
+		for range 200 {
+			off := rand.IntN(len(original))
+			buf := make([]byte, rand.IntN(10000))
+			n, err := ra.ReadAt(buf, int64(off))
+			if n < len(buf) && err != io.EOF || n == len(buf) && err != nil {
+				t.Fatalf("ReadAt(%d bytes at %d) = %d, %v", len(buf), off, n, err)
+			}
+			if !bytes.Equal(buf[:n], original[off:off+n]) {
+				t.Fatalf("ReadAt(%d bytes at %d) returned wrong data", len(buf), off)
+			}
+		}
+	}
+}
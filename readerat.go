@@ -0,0 +1,234 @@
+package sealer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ReaderAt reads the plaintext of a file sealed with SealOptions.SeekIndex,
+// or given a sidecar index (see Openable.SetIndex), at random positions, decrypting only the chunks that hold the requested
+// bytes. Unlike Reader, it has no cursor, so any number of goroutines can
+// read from it at once. Every chunk is authenticated, but the plaintext as
+// a whole isn't checked against the trailer.
+type ReaderAt struct {
+	dec   decryptor
+	ra    io.ReaderAt
+	start int64
+	index *seekIndex
+	ad    []byte
+
+	// zstd decodes the chunks of CompressionZstdChunks files; DecodeAll is
+	// safe for concurrent use.
+	zstd *zstd.Decoder
+
+	// pf decrypts chunks ahead of sequential reads, if Openable.Readahead
+	// is set.
+	pf *prefetcher
+}
+
+// NewReaderAt decrypts the file key using the slot matching key.ID, and
+// returns a ReaderAt of the plaintext. The Openable has to come from
+// PrepareAt, with a source of a known size (see PrepareAt), and the file
+// has to be sealed with SealOptions.SeekIndex, or given a sidecar index;
+// NewReaderAt returns ErrNotSeekable otherwise.
+func (opn *Openable) NewReaderAt(key *Key) (*ReaderAt, error) {
+	if opn.ra == nil || opn.bodyOff < 0 || opn.index == nil && !opn.hdr.hasTrailerField(trailerIndex) {
+		return nil, ErrNotSeekable
+	}
+	var fileKey [KeySize]byte
+	defer clear(fileKey[:])
+	err := opn.decapsulateKey(fileKey[:], key)
+	if err != nil {
+		return nil, err
+	}
+	err = opn.checkFileKey(fileKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := opn.decryptor(nil, fileKey[:])
+	if err != nil {
+		return nil, err
+	}
+	ra := &ReaderAt{
+		dec:   dec,
+		ra:    opn.ra,
+		start: opn.bodyStart,
+		ad:    opn.ad,
+	}
+	ra.dec.readBuf, ra.dec.decBuf, ra.dec.merkle = nil, nil, nil
+	if opn.hdr.compression == CompressionZstdChunks {
+		zopts, err := opn.zstdOptions()
+		if err != nil {
+			return nil, err
+		}
+		ra.zstd, err = zstd.NewReader(nil, zopts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opn.Readahead > 0 {
+		ra.pf = &prefetcher{depth: int64(opn.Readahead), pending: make(map[int64]*prefetch)}
+	}
+	if opn.index != nil {
+		ra.index = opn.index
+		return ra, nil
+	}
+
+	hdrLen := chunkHeaderSize + chunkSizeFieldSize + ra.dec.nonceSize
+	chunk := make([]byte, hdrLen+opn.hdr.trailerSize+overhead)
+	if opn.bodyLen < int64(len(chunk)) {
+		return nil, ErrTruncated
+	}
+	_, err = opn.ra.ReadAt(chunk, opn.bodyStart+opn.bodyLen-int64(len(chunk)))
+	if err != nil {
+		return nil, err
+	}
+	dec = ra.dec
+	data, isFinal, err := dec.open(nil, chunk, finalChunkIndex, dec.ad)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the trailer: %w", err)
+	}
+	var t trailer
+	if !isFinal || t.parse(data) != nil || t.index == nil {
+		return nil, errBadTrailer
+	}
+	ra.index = t.index
+	return ra, nil
+}
+
+// Size returns the size of the plaintext.
+func (ra *ReaderAt) Size() int64 {
+	return ra.index.plaintext
+}
+
+// ReadAt implements io.ReaderAt.
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("sealer: negative offset %d", off)
+	}
+	// a copy for the buffers of this call
+	dec := ra.dec
+	perChunk := int64(dec.chunkSize)
+	if dec.variableChunks {
+		perChunk--
+	}
+
+	var n int
+	var chunk, data []byte
+	first, last := off/perChunk, int64(-1)
+	for n < len(p) && off < ra.index.plaintext {
+		index, within := off/perChunk, off%perChunk
+		var err error
+		if d, ok := ra.pf.take(index); ok {
+			data = d
+		} else {
+			data, chunk, err = ra.readChunk(&dec, index, chunk, data[:0])
+			if err != nil {
+				return n, withPlaintext(err, off)
+			}
+		}
+		if within >= int64(len(data)) {
+			return n, fmt.Errorf("%w: chunk %d is short", ErrCorrupted, index)
+		}
+		c := copy(p[n:], data[within:min(int64(len(data)), within+ra.index.plaintext-off)])
+		n += c
+		off += int64(c)
+		last = index
+	}
+	if ra.pf != nil && last >= 0 {
+		var tail []byte
+		if off%perChunk != 0 {
+			// the next sequential read continues in this chunk
+			tail = data
+		}
+		ra.pf.readahead(ra, first, last, tail)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readChunk returns the plaintext of the chunk with the given index, reusing
+// the chunk buffer and appending to data when it has to be decrypted, and
+// chunk for reuse.
+func (ra *ReaderAt) readChunk(dec *decryptor, index int64, chunk, data []byte) ([]byte, []byte, error) {
+	hdrLen := chunkHeaderSize + chunkSizeFieldSize + dec.nonceSize
+	readAt := func(p []byte, off int64) error {
+		_, err := ra.ra.ReadAt(p, ra.start+off)
+		return truncated(err)
+	}
+	pos, err := ra.index.chunkOffset(dec, index, readAt)
+	if err != nil {
+		return nil, chunk, err
+	}
+	chunk = chunk[:cap(chunk)]
+	if len(chunk) < hdrLen {
+		chunk = make([]byte, hdrLen+dec.chunkSize+overhead)
+	}
+	err = readAt(chunk[:hdrLen], pos)
+	if err != nil {
+		return nil, chunk, chunkError(err, index, pos)
+	}
+	size := int(binary.LittleEndian.Uint32(chunk[chunkHeaderSize:]))
+	if size > dec.chunkSize {
+		return nil, chunk, chunkError(fmt.Errorf("%w: chunk has size %d", ErrCorrupted, size), index, pos)
+	}
+	chunk = chunk[:hdrLen+size+overhead]
+	err = readAt(chunk[hdrLen:], pos+int64(hdrLen))
+	if err != nil {
+		return nil, chunk, chunkError(err, index, pos)
+	}
+
+	ad := dec.ad
+	if index == 0 {
+		ad = ra.ad
+	}
+	data, _, err = dec.open(data, chunk, uint32(index), ad)
+	if err != nil {
+		return nil, chunk, chunkError(err, index, pos)
+	}
+	if ra.zstd != nil {
+		data, err = decodeChunk(ra.zstd, nil, data, dec.chunkSize)
+		if err != nil {
+			return nil, chunk, chunkError(fmt.Errorf("%w: %w", ErrCorrupted, err), index, pos)
+		}
+	}
+	return data, chunk, nil
+}
+
+// OpenRange decrypts the file key using the slot matching key.ID, and
+// returns a reader of length bytes of the plaintext starting at off, or of
+// fewer bytes if the plaintext ends before, for serving HTTP range requests.
+// Only the chunks covering the range are read and decrypted: the file has
+// to be sealed with SealOptions.SeekIndex or given a sidecar index, and the
+// Openable has to come from PrepareAt, or from Prepare given an
+// io.ReadSeeker; OpenRange returns ErrNotSeekable otherwise.
+func (opn *Openable) OpenRange(key *Key, off, length int64) (io.Reader, error) {
+	if off < 0 || length < 0 {
+		return nil, fmt.Errorf("sealer: invalid range of %d bytes at %d", length, off)
+	}
+	if opn.ra != nil {
+		ra, err := opn.NewReaderAt(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(ra, off, length), nil
+	}
+	if opn.index == nil && !opn.hdr.hasTrailerField(trailerIndex) {
+		return nil, ErrNotSeekable
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(off, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, length), nil
+}
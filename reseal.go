@@ -0,0 +1,159 @@
+package sealer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Reseal reads a sealed file from in and writes it to out sealed to newKeys
+// instead of the keys it has been sealed to. oldKey must be able to open
+// the file.
+//
+// Files with a header MAC (all files written by this version, except those
+// sealed to a single key) are resealed by rewriting only the header, and
+// their chunks are copied verbatim, so resealing a huge file is cheap. Other
+// files have their chunks decrypted and re-encrypted under a new file key
+// (but not decompressed); the result has a header MAC, so it can be
+// resealed cheaply next time.
+func Reseal(in io.Reader, out io.Writer, oldKey *Key, newKeys ...*Key) error {
+	opn, err := Prepare(in, nil)
+	if err != nil {
+		return err
+	}
+	return opn.Reseal(out, oldKey, newKeys, SealOptions{})
+}
+
+// Reseal writes the prepared file to out sealed to keys and the slots
+// configured by opt instead of the original slots, using dec to obtain
+// the file key (see the Reseal function). The outer prefix passed to
+// Prepare is written to out as well. The options that affect the chunks
+// (opt.ChunkSize, opt.ZstdLevel, opt.Compression, opt.Dictionary,
+// opt.Padding, opt.RandomNonces and opt.SyncInterval) are ignored, and the
+// original ones are retained, and so is the expiry time unless opt.NotAfter
+// is set. The context string, if any, is retained too. Expired files cannot
+// be resealed. Resealing to opt.MasterKey always re-encrypts the chunks,
+// since the file key has to change. Openable.Context only needs to be set
+// when the chunks are re-encrypted.
+func (opn *Openable) Reseal(out io.Writer, dec Decapsulator, keys []*Key, opt SealOptions) error {
+	var fileKey []byte
+	if key, ok := dec.(*Key); ok {
+		fileKey = make([]byte, KeySize)
+		err := opn.decapsulateKey(fileKey, key)
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		fileKey, err = opn.decapsulateWith(dec)
+		if err != nil {
+			return err
+		}
+	}
+	defer clear(fileKey)
+
+	err := opn.verifyCommitment(fileKey)
+	if err != nil {
+		return err
+	}
+	err = opn.verifyMAC(fileKey)
+	if err != nil {
+		return err
+	}
+	err = opn.checkExpiry()
+	if err != nil {
+		return err
+	}
+	if opt.NotAfter.IsZero() {
+		opt.NotAfter = opn.NotAfter
+	}
+
+	outerPrefix := opn.prefix[:opn.outerLen]
+	hdr := header{
+		version:   1,
+		chunkSize: opn.chunkSize,
+		context:   opn.hdr.context,
+
+		boundChunks:   opn.hdr.boundChunks,
+		framed:        opn.hdr.framed,
+		padded:        opn.hdr.padded,
+		compression:   opn.hdr.compression,
+		dictID:        opn.hdr.dictID,
+		trailerSize:   opn.hdr.trailerSize,
+		trailerFields: opn.hdr.trailerFields,
+		randomNonces:  opn.hdr.randomNonces,
+		syncInterval:  opn.hdr.syncInterval,
+	}
+
+	if opn.hdr.mac != nil && opt.MasterKey == nil {
+		prefix, err := hdr.seal(fileKey, keys, outerPrefix, opt)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(prefix)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, opn.body())
+		return err
+	}
+
+	// Legacy header is authenticated by the first chunk (and a master key
+	// derives a different file key), so the chunks have to be re-encrypted
+	// under a new file key.
+	var newFileKey [KeySize]byte
+	err = hdr.newFileKey(newFileKey[:], opt)
+	if err != nil {
+		return err
+	}
+	hdr.boundChunks = true
+	hdr.framed = true
+	aead, err := hdr.chunkAEAD(newFileKey[:], opn.Context)
+	if err != nil {
+		clear(newFileKey[:])
+		return err
+	}
+	prefix, err := hdr.seal(newFileKey[:], keys, outerPrefix, opt)
+	clear(newFileKey[:])
+	if err != nil {
+		return err
+	}
+
+	enc := newEncryptor(out, &hdr, aead, prefix, len(outerPrefix), opt.RandomReader)
+	d, err := opn.decryptor(opn.body(), fileKey)
+	if err != nil {
+		return err
+	}
+	err = d.read(opn.ad)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt the first chunk: %w", err)
+	}
+	// Chunks are re-encrypted one for one, since with CompressionZstdChunks
+	// their boundaries matter. A chunk is only known to be the final one
+	// once the next one has been read.
+	chunk := bytes.Clone(d.buf)
+	for {
+		err = d.read(nil)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		err = enc.flush(chunk, false)
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk[:0], d.buf...)
+	}
+	if d.trailer != nil {
+		// the last data chunk has been flushed when the trailer has been
+		// read; the trailer is recomputed, since the chunks have changed
+		var t trailer
+		err = t.parse(d.trailer)
+		if err != nil {
+			return err
+		}
+		return enc.closeWithTrailer(&t)
+	}
+	return enc.flush(chunk, true)
+}
@@ -0,0 +1,139 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestReseal(t *testing.T) {
+	oldKey := generateKeyWithID("old")
+	otherKey := generateKeyWithID("other")
+	newKey := generateKeyWithID("new")
+	prefix := []byte("PREFIX")
+
+	// incompressible, so that it spans several chunks
+	original := make([]byte, 10000)
+	rand.NewChaCha8([32]byte{}).Read(original)
+
+	tests := []struct {
+		name     string
+		keys     []*sealer.Key
+		verbatim bool
+	}{
+		{"v0", []*sealer.Key{oldKey}, false},
+		{"multiple keys", []*sealer.Key{oldKey, otherKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed := seal(t, tt.keys, prefix, sealer.SealOptions{ChunkSize: 1024}, original)
+
+			opn, err := sealer.Prepare(bytes.NewReader(sealed[len(prefix):]), prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = opn.Reseal(&buf, oldKey, []*sealer.Key{newKey}, sealer.SealOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resealed := buf.Bytes()
+
+			if !bytes.HasPrefix(resealed, prefix) {
+				t.Fatalf("resealed file does not start with the prefix")
+			}
+			actual, err := openSealed(resealed[len(prefix):], prefix, newKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("resealed file opened to different content")
+			}
+			if _, err := openSealed(resealed[len(prefix):], prefix, oldKey); err != sealer.ErrNoMatchingKey {
+				t.Errorf("opening with the old key: err = %v, wanted ErrNoMatchingKey", err)
+			}
+
+			body := sealed[len(sealed)-bodySize(t, sealed, prefix):]
+			if hasBody := bytes.HasSuffix(resealed, body); hasBody != tt.verbatim {
+				t.Errorf("body copied verbatim = %v, wanted %v", hasBody, tt.verbatim)
+			}
+
+			// the result can always be resealed cheaply
+			opn, err = sealer.Prepare(bytes.NewReader(resealed[len(prefix):]), prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf2 bytes.Buffer
+			err = opn.Reseal(&buf2, newKey, []*sealer.Key{otherKey}, sealer.SealOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resealed2 := buf2.Bytes()
+			if !bytes.HasSuffix(resealed2, resealed[len(resealed)-bodySize(t, resealed, prefix):]) {
+				t.Errorf("second reseal did not copy the body verbatim")
+			}
+			actual, err = openSealed(resealed2[len(prefix):], prefix, otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("resealed twice file opened to different content")
+			}
+		})
+	}
+}
+
+func TestReseal_wrongKey(t *testing.T) {
+	sealed := seal(t, []*sealer.Key{generateKeyWithID("one"), generateKeyWithID("two")}, nil, sealer.SealOptions{}, []byte("hello"))
+	err := sealer.Reseal(bytes.NewReader(sealed), io.Discard, generateKeyWithID("three"), generateKeyWithID("four"))
+	if err != sealer.ErrNoMatchingKey {
+		t.Errorf("err = %v, wanted ErrNoMatchingKey", err)
+	}
+}
+
+func TestSealer_headerTampering(t *testing.T) {
+	key1, key2 := generateKeyWithID("one"), generateKeyWithID("two")
+	sealed := seal(t, []*sealer.Key{key1, key2}, nil, sealer.SealOptions{}, []byte("hello"))
+
+	// change the ID of the second key, which key1 does not otherwise
+	// depend on
+	i := bytes.Index(sealed, key2.ID[:])
+	if i < 0 {
+		t.Fatal("key ID not found")
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[i] ^= 1
+
+	if _, err := openSealed(tampered, nil, key1); err == nil {
+		t.Errorf("tampered header opened successfully")
+	}
+	if err := sealer.Reseal(bytes.NewReader(tampered), io.Discard, key1, key2); err == nil {
+		t.Errorf("tampered header resealed successfully")
+	}
+}
+
+func openSealed(sealed, prefix []byte, key *sealer.Key) ([]byte, error) {
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), prefix)
+	if err != nil {
+		return nil, err
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// bodySize returns the size of the chunks of a sealed file, i.e. everything
+// after the header.
+func bodySize(t testing.TB, sealed, prefix []byte) int {
+	in := bytes.NewReader(sealed[len(prefix):])
+	_, err := sealer.Prepare(in, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in.Len()
+}
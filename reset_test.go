@@ -0,0 +1,161 @@
+package sealer_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+// resetOptions covers every compressor, and changes of options in between.
+var resetOptions = []sealer.SealOptions{
+	{},
+	{},
+	{Compression: sealer.CompressionZstdChunks, ChunkSize: 1024},
+	{Compression: sealer.CompressionZstdChunks, ChunkSize: 1024, MerkleRoot: true},
+	{Compression: sealer.CompressionZstdChunks, ChunkSize: 4096},
+	{Compression: sealer.CompressionNone, PlaintextDigest: true},
+	{Compression: sealer.CompressionNone, ChunkSize: 100000, Concurrency: 2},
+	{Compression: sealer.CompressionBrotli},
+	{Compression: sealer.CompressionBrotli, Padding: sealer.PaddingPadme},
+	{ZstdLevel: 19},
+	{},
+}
+
+func TestWriter_reset(t *testing.T) {
+	key := generateKeyWithID("key")
+	var w *sealer.Writer
+	for i, opt := range resetOptions {
+		original := randomBytes(t, 1000*i)
+		clear(original[:len(original)/2])
+		var buf bytes.Buffer
+		var err error
+		if w == nil {
+			w, err = sealer.Seal(&buf, []*sealer.Key{key}, nil, opt)
+		} else {
+			err = w.Reset(&buf, []*sealer.Key{key}, nil, opt)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := io.ReadAll(openReader(t, buf.Bytes(), key))
+		if err != nil {
+			t.Fatalf("%d %+v: %v", i, opt, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("%d %+v: got %d bytes, wanted %d", i, opt, len(actual), len(original))
+		}
+	}
+}
+
+func TestReader_reset(t *testing.T) {
+	key := generateKeyWithID("key")
+	r := new(sealer.Reader)
+	for i, opt := range resetOptions {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			original := randomBytes(t, 1000*i)
+			clear(original[:len(original)/2])
+			sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+			// a Reader stopped halfway is reset just as well
+			for range 2 {
+				opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Reset(opn, key); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.CopyN(io.Discard, r, int64(len(original)/3)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Reset(opn, key); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Errorf("got %d bytes, wanted %d", len(actual), len(original))
+			}
+
+			damaged := bytes.Clone(sealed)
+			damaged[len(damaged)-20] ^= 1
+			opn, err = sealer.Prepare(bytes.NewReader(damaged), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Reset(opn, key); err == nil {
+				if _, err := io.ReadAll(r); err == nil {
+					t.Errorf("damaged file read without an error")
+				}
+			}
+		})
+	}
+}
+
+func TestReader_close(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	clear(original[:50000])
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstd},
+		{Compression: sealer.CompressionZstdChunks},
+	} {
+		opt.ChunkSize = 4096
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+		bufs := sealer.NewBuffers(opt.ChunkSize)
+		r := new(sealer.Reader)
+		for _, concurrency := range []int{0, 4} {
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opn.Buffers, opn.Concurrency = bufs, concurrency
+			if err := r.Reset(opn, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.CopyN(io.Discard, r, 70000); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(bufs.Plaintext[:cap(bufs.Plaintext)], make([]byte, cap(bufs.Plaintext))) {
+				t.Errorf("%v: plaintext buffer not wiped", opt.Compression)
+			}
+			if _, err := r.Read(make([]byte, 10)); err != sealer.ErrReaderClosed {
+				t.Errorf("%v: Read after Close: err = %v, wanted ErrReaderClosed", opt.Compression, err)
+			}
+		}
+
+		// a closed Reader can be reset
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reset(opn, key); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(actual, original) {
+			t.Errorf("%v: after Close and Reset, got %d bytes: %v", opt.Compression, len(actual), err)
+		}
+	}
+}
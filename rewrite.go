@@ -0,0 +1,196 @@
+package sealer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotRewritable is returned by Openable.RewriteAt for files that can't
+// be rewritten in place.
+var ErrNotRewritable = errors.New("sealed file cannot be rewritten in place")
+
+// RewriteAt decrypts the file key using the slot matching key.ID, and
+// overwrites len(p) bytes of the plaintext at off with p, re-sealing only
+// the chunks that hold them, and writing them to out, which has to write
+// to the same file that the Openable reads, at the same offsets. This
+// allows using a sealed file like a database file, mutating records in
+// place. The plaintext can't grow: a range past its end fails the call
+// before anything is written.
+//
+// The Openable has to come from PrepareAt, with a source of a known size
+// (see PrepareAt), and the file has to be sealed with CompressionNone,
+// RandomNonces and without padding, so that every chunk holds a fixed
+// range of the plaintext, and re-sealed chunks get fresh nonces; files
+// with a PlaintextDigest can't be rewritten either, since that would take
+// reading all of them. RewriteAt returns ErrNotRewritable otherwise. A
+// MerkleRoot is updated, which takes reading the chunks, but not
+// decrypting them. Sizes, seek indexes and checkpoints stay valid as they
+// are.
+//
+// Chunks are written one by one, so a failed or interrupted RewriteAt can
+// leave the file with only some of them rewritten, each either old or new.
+// Readers of the file opened before remain valid, but see the old or new
+// plaintext depending on what they read when.
+func (opn *Openable) RewriteAt(out io.WriterAt, key *Key, p []byte, off int64) error {
+	h := opn.hdr
+	if opn.ra == nil || opn.bodyOff < 0 || h.compression != CompressionNone || !h.randomNonces || !h.framed || h.padded || h.hasTrailerField(trailerDigest) {
+		return ErrNotRewritable
+	}
+	if off < 0 {
+		return fmt.Errorf("sealer: negative offset %d", off)
+	}
+	var fileKey [KeySize]byte
+	defer clear(fileKey[:])
+	err := opn.decapsulateKey(fileKey[:], key)
+	if err != nil {
+		return err
+	}
+	err = opn.checkFileKey(fileKey[:])
+	if err != nil {
+		return err
+	}
+	dec, err := opn.decryptor(nil, fileKey[:])
+	if err != nil {
+		return err
+	}
+
+	var sealed bytes.Buffer
+	enc := newEncryptor(&sealed, h, dec.aead, nil, 0, nil)
+	// the sync markers stay where they are
+	enc.syncInterval = 0
+
+	type rewrite struct {
+		pos   int64
+		chunk []byte
+	}
+	var rewrites []rewrite
+	cs := int64(h.chunkSize)
+	for n := 0; n < len(p); {
+		index, within := (off+int64(n))/cs, int((off+int64(n))%cs)
+		pos := opn.chunkPos(index)
+		chunk, err := opn.readChunkAt(pos)
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("sealer: cannot rewrite past the end of the plaintext")
+		} else if err != nil {
+			return err
+		}
+		data, isFinal, err := dec.open(nil, chunk, uint32(index), opn.chunkAD(&dec, index))
+		if err != nil {
+			return &ChunkError{Chunk: index, Offset: pos, Plaintext: off + int64(n), Err: err}
+		}
+		if isFinal && h.trailerSize != 0 || within+len(p)-n > len(data) && len(data) < h.chunkSize {
+			return fmt.Errorf("sealer: cannot rewrite past the end of the plaintext")
+		}
+		n += copy(data[within:], p[n:])
+
+		enc.chunkIndex, enc.ad = uint32(index), opn.chunkAD(&dec, index)
+		sealed.Reset()
+		err = enc.flush(data, isFinal)
+		if err != nil {
+			return err
+		}
+		rewrites = append(rewrites, rewrite{pos, bytes.Clone(sealed.Bytes())})
+	}
+
+	for _, rw := range rewrites {
+		_, err = out.WriteAt(rw.chunk, opn.bodyStart+rw.pos)
+		if err != nil {
+			return err
+		}
+	}
+	if len(rewrites) != 0 && h.hasTrailerField(trailerMerkle) {
+		return opn.rewriteMerkleRoot(out, &dec, &enc, &sealed)
+	}
+	return nil
+}
+
+// rewriteMerkleRoot recomputes the Merkle root of the chunks of a file being
+// rewritten, and rewrites the trailer holding it.
+func (opn *Openable) rewriteMerkleRoot(out io.WriterAt, dec *decryptor, enc *encryptor, sealed *bytes.Buffer) error {
+	var leaves [][sha256.Size]byte
+	var trailerChunk []byte
+	err := opn.hdr.walkChunks(io.NewSectionReader(opn.ra, opn.bodyStart, opn.bodyLen), func(index int, chunk []byte) error {
+		leaves = append(leaves, merkleLeaf(chunk))
+		trailerChunk = append(trailerChunk[:0], chunk...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	index := int64(len(leaves) - 1)
+	leaves = leaves[:index]
+
+	data, isFinal, err := dec.open(nil, trailerChunk, uint32(index), opn.chunkAD(dec, index))
+	if err != nil {
+		return fmt.Errorf("cannot decrypt the trailer: %w", err)
+	}
+	var t trailer
+	if !isFinal || t.parse(data) != nil || t.merkleRoot == nil {
+		return errBadTrailer
+	}
+	root := merkleTreeHash(leaves)
+	t.merkleRoot = root[:]
+
+	enc.chunkIndex, enc.ad = uint32(index), opn.chunkAD(dec, index)
+	sealed.Reset()
+	data, err = t.append(nil)
+	if err != nil {
+		return err
+	}
+	err = enc.flush(data, true)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteAt(sealed.Bytes(), opn.bodyStart+opn.bodyLen-int64(len(trailerChunk)))
+	return err
+}
+
+// chunkAD returns the additional data of the chunk with the given index.
+func (opn *Openable) chunkAD(dec *decryptor, index int64) []byte {
+	if index == 0 {
+		return opn.ad
+	}
+	return dec.ad
+}
+
+// chunkPos returns the offset of the chunk with the given index from the
+// first chunk in a file of fixed-size chunks.
+func (opn *Openable) chunkPos(index int64) int64 {
+	pos := index * int64(opn.hdr.chunkHeaderLen()+opn.hdr.chunkSize+overhead)
+	if opn.hdr.syncInterval != 0 {
+		pos += index / int64(opn.hdr.syncInterval) * int64(SyncMarkerSize)
+	}
+	return pos
+}
+
+// readChunkAt reads the chunk at the given offset from the first chunk of a
+// framed file opened with PrepareAt.
+func (opn *Openable) readChunkAt(pos int64) ([]byte, error) {
+	hdrLen := opn.hdr.chunkHeaderLen()
+	chunk := make([]byte, hdrLen, hdrLen+opn.hdr.chunkSize+overhead)
+	readAt := func(p []byte, off int64) error {
+		if off+int64(len(p)) > opn.bodyLen {
+			return io.ErrUnexpectedEOF
+		}
+		_, err := opn.ra.ReadAt(p, opn.bodyStart+off)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	err := readAt(chunk, pos)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(chunk[chunkHeaderSize:]))
+	if size > opn.hdr.chunkSize {
+		return nil, fmt.Errorf("%w: chunk at %d has size %d", ErrCorrupted, pos, size)
+	}
+	chunk = chunk[:hdrLen+size+overhead]
+	err = readAt(chunk[hdrLen:], pos+int64(hdrLen))
+	return chunk, err
+}
@@ -0,0 +1,114 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestOpenable_rewriteAt(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{RecordSize: true, MerkleRoot: true, SeekIndex: true},
+		{MerkleRoot: true, SyncInterval: 3},
+	} {
+		opt.Compression = sealer.CompressionNone
+		opt.RandomNonces = true
+		opt.ChunkSize = 1024
+		t.Run(fmt.Sprintf("%v/%v/%v", opt.SeekIndex, opt.MerkleRoot, opt.SyncInterval), func(t *testing.T) {
+			original := randomBytes(t, 10000)
+			sealed := seal(t, []*sealer.Key{key}, []byte("prefix"), opt, original)
+			fn := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(fn, sealed, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(fn, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			expected := bytes.Clone(original)
+			for _, tc := range []struct{ off, n int }{{0, 10}, {1000, 100}, {5000, 3000}, {9990, 10}} {
+				p := randomBytes(t, tc.n)
+				copy(expected[tc.off:], p)
+				opn, err := sealer.PrepareAt(f, 0, len("prefix"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := opn.RewriteAt(f, key, p, int64(tc.off)); err != nil {
+					t.Fatalf("RewriteAt(%d bytes at %d): %v", tc.n, tc.off, err)
+				}
+			}
+			opn, err := sealer.PrepareAt(f, 0, len("prefix"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := opn.RewriteAt(f, key, make([]byte, 10), 9995); err == nil {
+				t.Errorf("RewriteAt past the end: no error")
+			}
+
+			rewritten, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rewritten) != len(sealed) {
+				t.Errorf("size changed from %d to %d", len(sealed), len(rewritten))
+			}
+			opn, err = sealer.PrepareAt(bytes.NewReader(rewritten), 0, len("prefix"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := opn.Open(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("got wrong plaintext after rewriting")
+			}
+			if opt.MerkleRoot {
+				root, err := r.MerkleRoot()
+				if err != nil {
+					t.Fatal(err)
+				}
+				tree, err := sealer.NewMerkleTree(bytes.NewReader(rewritten[len("prefix"):]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if actual := tree.Root(); !bytes.Equal(root, actual[:]) {
+					t.Errorf("Merkle root not updated")
+				}
+			}
+		})
+	}
+}
+
+func TestOpenable_rewriteAtUnsupported(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true},
+		{Compression: sealer.CompressionNone, RandomNonces: true, Padding: sealer.PaddingPadme},
+		{Compression: sealer.CompressionNone, RandomNonces: true, PlaintextDigest: true},
+	} {
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, []byte("hello"))
+		opn, err := sealer.PrepareAt(bytes.NewReader(sealed), 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := opn.RewriteAt(nil, key, []byte("j"), 0); !errors.Is(err, sealer.ErrNotRewritable) {
+			t.Errorf("%+v: err = %v, wanted ErrNotRewritable", opt, err)
+		}
+	}
+}
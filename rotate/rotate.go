@@ -0,0 +1,297 @@
+// Package rotate reseals many files from an old key to new keys, for
+// example after a key has been compromised or as part of a scheduled key
+// rotation.
+//
+// Only the headers are rewritten when possible (see sealer.Reseal), so
+// rotating even a large archive is mostly I/O bound on reading the headers.
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/andreyvit/sealer"
+)
+
+// Object is a sealed file to be rotated. File returns an Object for a file
+// on disk; implement it to rotate objects in other storage.
+type Object interface {
+	// Name identifies the object in results.
+	Name() string
+
+	// Open returns the object's content.
+	Open() (io.ReadCloser, error)
+
+	// Replace calls write to produce the new content of the object, and
+	// atomically replaces the object with it if write succeeds. Replace is
+	// called while the reader returned by Open is still open.
+	Replace(write func(w io.Writer) error) error
+}
+
+// Options configure a rotation.
+type Options struct {
+	// OldKey is the key being rotated out, and is required. Files without
+	// a slot for OldKey.ID are skipped.
+	OldKey *sealer.Key
+
+	// NewKeys and Seal specify the new slots of the files. The files end up
+	// sealed to these only, so list all keys that should retain access,
+	// including those that the files have been sealed to alongside OldKey.
+	NewKeys []*sealer.Key
+	Seal    sealer.SealOptions
+
+	// PrefixSize is the size of the outer prefix preceding the sealed
+	// envelope in each file. The prefix is retained.
+	PrefixSize int
+
+	// Concurrency is the number of files processed at once, defaults to
+	// the number of CPUs.
+	Concurrency int
+
+	// DryRun checks that each matching file can be resealed, but leaves
+	// it intact.
+	DryRun bool
+
+	// Progress, if not nil, is called after each file is processed. Calls
+	// are not concurrent.
+	Progress func(Result)
+}
+
+// Status is the outcome of processing a single file.
+type Status int
+
+const (
+	// Skipped means the file is not sealed, or not sealed to OldKey.
+	Skipped Status = iota
+
+	// Rotated means the file has been resealed (or, in dry-run mode, can
+	// be resealed).
+	Rotated
+
+	// Failed means the file has been sealed to OldKey, but could not be
+	// resealed. The file is left intact.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Skipped:
+		return "skipped"
+	case Rotated:
+		return "rotated"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// Result describes the outcome of processing a single file.
+type Result struct {
+	Name   string
+	Status Status
+	Err    error
+}
+
+// Stats are the totals of a rotation.
+type Stats struct {
+	Rotated int
+	Skipped int
+	Failed  int
+}
+
+// Dir rotates all regular files under root. Files that are not sealed are
+// skipped.
+func Dir(ctx context.Context, root string, opt Options) (Stats, error) {
+	var walkErr error
+	objects := func(yield func(Object) bool) {
+		walkErr = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if !yield(File(path)) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+	stats, err := Run(ctx, objects, opt)
+	return stats, errors.Join(walkErr, err)
+}
+
+// Run rotates the given objects concurrently. It processes all objects even
+// if some of them fail, and returns the errors of all failed objects joined
+// together; it stops early only if ctx is done.
+func Run(ctx context.Context, objects iter.Seq[Object], opt Options) (Stats, error) {
+	if opt.OldKey == nil {
+		return Stats{}, fmt.Errorf("rotate: %w: OldKey is required", sealer.ErrInvalidOptions)
+	}
+	n := opt.Concurrency
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	var (
+		stats Stats
+		errs  []error
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		queue = make(chan Object)
+	)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range queue {
+				res := rotate(obj, &opt)
+				mu.Lock()
+				switch res.Status {
+				case Rotated:
+					stats.Rotated++
+				case Skipped:
+					stats.Skipped++
+				case Failed:
+					stats.Failed++
+					errs = append(errs, fmt.Errorf("%s: %w", res.Name, res.Err))
+				}
+				if opt.Progress != nil {
+					opt.Progress(res)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var ctxErr error
+loop:
+	for obj := range objects {
+		select {
+		case queue <- obj:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return stats, errors.Join(append(errs, ctxErr)...)
+}
+
+func rotate(obj Object, opt *Options) Result {
+	res := Result{Name: obj.Name()}
+	fail := func(err error) Result {
+		res.Status = Failed
+		res.Err = err
+		return res
+	}
+
+	in, err := obj.Open()
+	if err != nil {
+		return fail(err)
+	}
+	defer in.Close()
+
+	prefix := make([]byte, opt.PrefixSize)
+	_, err = io.ReadFull(in, prefix)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return res // too short to be sealed
+	} else if err != nil {
+		return fail(err)
+	}
+
+	opn, err := sealer.Prepare(in, prefix)
+	if err != nil {
+		// not sealed, or not sealed by a version of this package we
+		// understand
+		return res
+	}
+	if !slices.Contains(opn.KeyIDs, opt.OldKey.ID) {
+		return res
+	}
+
+	if opt.DryRun {
+		err = opn.Reseal(io.Discard, opt.OldKey, opt.NewKeys, opt.Seal)
+	} else {
+		err = obj.Replace(func(w io.Writer) error {
+			return opn.Reseal(w, opt.OldKey, opt.NewKeys, opt.Seal)
+		})
+	}
+	if err != nil {
+		return fail(err)
+	}
+	res.Status = Rotated
+	return res
+}
+
+// File returns an Object for a file on disk. Replace writes a temporary
+// file in the same directory and renames it over the original, preserving
+// its permissions.
+func File(path string) Object {
+	return file(path)
+}
+
+type file string
+
+func (f file) Name() string {
+	return string(f)
+}
+
+func (f file) Open() (io.ReadCloser, error) {
+	return os.Open(string(f))
+}
+
+func (f file) Replace(write func(w io.Writer) error) error {
+	path := string(f)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".rotate-*")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
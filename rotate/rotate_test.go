@@ -0,0 +1,197 @@
+package rotate_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/rotate"
+)
+
+func TestDir(t *testing.T) {
+	oldKey, otherKey, newKey := generateKey("old"), generateKey("other"), generateKey("new")
+	prefix := []byte("HDR:")
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, data, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("single", seal(t, prefix, "single", oldKey))
+	write("sub/multiple", seal(t, prefix, "multiple", oldKey, otherKey))
+	write("sub/unrelated", seal(t, prefix, "unrelated", otherKey))
+	write("plain.txt", []byte("not sealed at all"))
+	write("tiny", []byte("HD"))
+
+	snapshot := func() map[string][]byte {
+		m := make(map[string][]byte)
+		for _, name := range []string{"single", "sub/multiple", "sub/unrelated", "plain.txt", "tiny"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m[name] = data
+		}
+		return m
+	}
+	before := snapshot()
+
+	opt := rotate.Options{
+		OldKey:      oldKey,
+		NewKeys:     []*sealer.Key{newKey},
+		PrefixSize:  len(prefix),
+		Concurrency: 2,
+		DryRun:      true,
+	}
+	stats, err := rotate.Dir(context.Background(), dir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := (rotate.Stats{Rotated: 2, Skipped: 3}); stats != e {
+		t.Errorf("dry run stats = %+v, wanted %+v", stats, e)
+	}
+	for name, data := range snapshot() {
+		if !bytes.Equal(data, before[name]) {
+			t.Errorf("%s modified by dry run", name)
+		}
+	}
+
+	opt.DryRun = false
+	var rotated []string
+	opt.Progress = func(res rotate.Result) {
+		if res.Status == rotate.Rotated {
+			rotated = append(rotated, filepath.Base(res.Name))
+		}
+	}
+	stats, err = rotate.Dir(context.Background(), dir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := (rotate.Stats{Rotated: 2, Skipped: 3}); stats != e {
+		t.Errorf("stats = %+v, wanted %+v", stats, e)
+	}
+	if len(rotated) != 2 {
+		t.Errorf("progress reported rotated %q, wanted 2 files", rotated)
+	}
+
+	after := snapshot()
+	for _, name := range []string{"single", "sub/multiple"} {
+		if got := open(t, after[name], prefix, newKey); got != filepath.Base(name) {
+			t.Errorf("%s opened to %q", name, got)
+		}
+	}
+	for _, name := range []string{"sub/unrelated", "plain.txt", "tiny"} {
+		if !bytes.Equal(after[name], before[name]) {
+			t.Errorf("%s modified", name)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dir, "single"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("permissions changed to %v", fi.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestRun_failure(t *testing.T) {
+	oldKey := generateKey("old")
+	wrongKey := &sealer.Key{ID: oldKey.ID} // same ID, different material
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	original := seal(t, nil, "file", oldKey, generateKey("other"))
+	err := os.WriteFile(path, original, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := rotate.Run(context.Background(), func(yield func(rotate.Object) bool) {
+		yield(rotate.File(path))
+	}, rotate.Options{OldKey: wrongKey, NewKeys: []*sealer.Key{generateKey("new")}})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if e := (rotate.Stats{Failed: 1}); stats != e {
+		t.Errorf("stats = %+v, wanted %+v", stats, e)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Errorf("failed file modified")
+	}
+}
+
+func TestRun_noOldKey(t *testing.T) {
+	_, err := rotate.Run(context.Background(), func(yield func(rotate.Object) bool) {
+		t.Error("object yielded without an OldKey")
+	}, rotate.Options{NewKeys: []*sealer.Key{generateKey("new")}})
+	if !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("err = %v, wanted ErrInvalidOptions", err)
+	}
+}
+
+func generateKey(id string) *sealer.Key {
+	key := &sealer.Key{}
+	copy(key.ID[:], id)
+	if _, err := io.ReadFull(rand.Reader, key.Key[:]); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func seal(t testing.TB, prefix []byte, content string, keys ...*sealer.Key) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, keys, prefix, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.WriteString(w, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func open(t testing.TB, sealed, prefix []byte, key *sealer.Key) string {
+	t.Helper()
+	opn, err := sealer.Prepare(bytes.NewReader(sealed[len(prefix):]), prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,145 @@
+package sealer
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Gap describes a part of a damaged file lost by a salvaging Reader (see
+// Openable.Salvage).
+type Gap struct {
+	// Offset is the offset in the plaintext returned by the Reader at which
+	// the data is missing.
+	Offset int64
+
+	// FirstChunk is the index of the first lost chunk, and Chunks is the
+	// number of chunks lost, or -1 if the rest of the file is lost.
+	FirstChunk int
+	Chunks     int
+
+	// Size is the number of plaintext bytes lost, which is only known for
+	// uncompressed unpadded files when the following chunk has been found,
+	// and -1 otherwise.
+	Size int64
+}
+
+// Gaps returns the parts of the file lost so far by a salvaging Reader (see
+// Openable.Salvage), in order.
+func (r *Reader) Gaps() []Gap {
+	return r.gaps
+}
+
+// salvager reads a damaged file for the decryptor, skipping damaged chunks
+// and resynchronizing on the next chunk that authenticates.
+type salvager struct {
+	in  io.Reader
+	buf []byte
+	off int
+	eof bool
+
+	// exactSize is set if lost chunks are known to hold chunkSize bytes of
+	// plaintext each.
+	exactSize bool
+
+	// gap is called for every gap found, with count -1 if the rest of the
+	// file is lost.
+	gap func(first, count int, size int64)
+}
+
+// peek returns at least n buffered bytes, or fewer at the end of input.
+func (s *salvager) peek(n int) ([]byte, error) {
+	for len(s.buf)-s.off < n && !s.eof {
+		if s.off > 0 {
+			s.buf = s.buf[:copy(s.buf, s.buf[s.off:])]
+			s.off = 0
+		}
+		if cap(s.buf) < n {
+			s.buf = append(make([]byte, 0, 2*n), s.buf...)
+		}
+		m, err := s.in.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+m]
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return s.buf[s.off:min(len(s.buf), s.off+n)], nil
+}
+
+const (
+	// maxFinalSkip is the number of positions tried for a final chunk found
+	// while resynchronizing, which doesn't have its position in its header.
+	maxFinalSkip = 1024
+
+	// maxSkippedChunks is the largest number of chunks that can be lost
+	// in a single gap, to avoid decrypting at every byte of a damaged area.
+	maxSkippedChunks = 1 << 20
+)
+
+// readSalvaged is read for a salvaging decryptor: it reads the chunk at the
+// current position if it authenticates, and otherwise scans forward for the
+// next chunk that does, reporting the chunks in between as lost.
+func (dec *decryptor) readSalvaged(prefix []byte) error {
+	s := dec.salvage
+	hdrLen := chunkHeaderSize + dec.nonceSize
+	if dec.framed {
+		hdrLen += chunkSizeFieldSize
+	}
+	fullLen := hdrLen + dec.chunkSize + overhead
+
+	for skip := 0; ; skip++ {
+		data, err := s.peek(fullLen)
+		if err != nil {
+			return err
+		}
+		if len(data) < hdrLen+overhead {
+			s.gap(int(dec.chunkIndex), -1, -1)
+			dec.eof = true
+			dec.buf = nil
+			return nil
+		}
+
+		n := len(data)
+		if dec.framed {
+			size := int(binary.LittleEndian.Uint32(data[chunkHeaderSize:]))
+			n = hdrLen + size + overhead
+		}
+		if n <= len(data) {
+			// a chunk found after skipping might be anywhere after the lost
+			// ones, and a final chunk doesn't say where
+			first, last := dec.chunkIndex, dec.chunkIndex
+			plausible := true
+			if skip > 0 {
+				first = binary.LittleEndian.Uint32(data)
+				last = first
+				if first == finalChunkIndex {
+					first, last = dec.chunkIndex, dec.chunkIndex+maxFinalSkip
+				}
+				plausible = first >= dec.chunkIndex && first-dec.chunkIndex <= maxSkippedChunks && n-hdrLen-overhead <= dec.chunkSize
+			}
+			for pos := first; plausible && pos <= last; pos++ {
+				ad := dec.ad
+				if pos == dec.chunkIndex {
+					ad = prefix
+				}
+				buf, isFinal, err := dec.open(dec.decBuf[:0], data[:n], pos, ad)
+				if err != nil {
+					continue
+				}
+				if pos > dec.chunkIndex {
+					size := int64(-1)
+					if s.exactSize && !isFinal {
+						size = int64(pos-dec.chunkIndex) * int64(dec.chunkSize)
+					}
+					s.gap(int(dec.chunkIndex), int(pos-dec.chunkIndex), size)
+				}
+				s.off += n
+				dec.chunkIndex = pos + 1
+				dec.accept(buf, isFinal)
+				return nil
+			}
+		}
+		s.off++
+	}
+}
@@ -0,0 +1,134 @@
+package sealer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestOpenable_salvage(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 1000)
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 100, PlaintextDigest: true}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+	hdrLen := len(sealed) - bodySize(t, sealed, nil)
+	const chunkLen = 8 + 100 + 16
+	chunk := func(i int) int { return hdrLen + i*chunkLen }
+
+	tests := []struct {
+		name     string
+		sealed   []byte
+		expected []byte
+		gaps     []sealer.Gap
+	}{
+		{"intact", sealed, original, nil},
+		{
+			"damaged chunk",
+			damage(sealed, chunk(3)+50),
+			slices.Concat(original[:300], original[400:]),
+			[]sealer.Gap{{Offset: 300, FirstChunk: 3, Chunks: 1, Size: 100}},
+		},
+		{
+			"two damaged chunks",
+			damage(damage(sealed, chunk(3)+50), chunk(4)+2),
+			slices.Concat(original[:300], original[500:]),
+			[]sealer.Gap{{Offset: 300, FirstChunk: 3, Chunks: 2, Size: 200}},
+		},
+		{
+			"damaged first chunk",
+			damage(sealed, chunk(0)+20),
+			original[100:],
+			[]sealer.Gap{{Offset: 0, FirstChunk: 0, Chunks: 1, Size: 100}},
+		},
+		{
+			"deleted bytes",
+			slices.Concat(sealed[:chunk(5)+10], sealed[chunk(5)+60:]),
+			slices.Concat(original[:500], original[600:]),
+			[]sealer.Gap{{Offset: 500, FirstChunk: 5, Chunks: 1, Size: 100}},
+		},
+		{
+			"inserted bytes",
+			slices.Concat(sealed[:chunk(5)+10], make([]byte, 500), sealed[chunk(5)+10:]),
+			slices.Concat(original[:500], original[600:]),
+			[]sealer.Gap{{Offset: 500, FirstChunk: 5, Chunks: 1, Size: 100}},
+		},
+		{
+			"damaged last data chunk",
+			damage(sealed, chunk(9)+50),
+			original[:900],
+			// followed by the trailer, so it could have been short
+			[]sealer.Gap{{Offset: 900, FirstChunk: 9, Chunks: 1, Size: -1}},
+		},
+		{
+			"truncated",
+			sealed[:chunk(7)+30],
+			original[:700],
+			[]sealer.Gap{{Offset: 700, FirstChunk: 7, Chunks: -1, Size: -1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opn, err := sealer.Prepare(bytes.NewReader(tt.sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opn.Salvage = true
+			r, err := opn.Open(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, tt.expected) {
+				t.Errorf("got %d bytes, wanted %d", len(actual), len(tt.expected))
+			}
+			if !reflect.DeepEqual(r.Gaps(), tt.gaps) {
+				t.Errorf("Gaps = %+v, wanted %+v", r.Gaps(), tt.gaps)
+			}
+		})
+	}
+}
+
+func TestOpenable_salvageZstdChunks(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := bytes.Repeat(randomBytes(t, 50), 200)
+	opt := sealer.SealOptions{Compression: sealer.CompressionZstdChunks, ChunkSize: 1000}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+	hdrLen := len(sealed) - bodySize(t, sealed, nil)
+	second := hdrLen + 8 + int(binary.LittleEndian.Uint32(sealed[hdrLen+4:])) + 16
+
+	opn, err := sealer.Prepare(bytes.NewReader(damage(sealed, second+10)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn.Salvage = true
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gaps := r.Gaps()
+	if len(gaps) != 1 || gaps[0].Chunks != 1 || gaps[0].Size != -1 {
+		t.Fatalf("Gaps = %+v, wanted a single lost chunk", gaps)
+	}
+	lost := len(original) - len(actual)
+	if lost <= 0 || !bytes.Equal(actual[:gaps[0].Offset], original[:gaps[0].Offset]) || !bytes.Equal(actual[gaps[0].Offset:], original[int(gaps[0].Offset)+lost:]) {
+		t.Errorf("salvaged data doesn't match the original around the gap")
+	}
+}
+
+func damage(sealed []byte, off int) []byte {
+	sealed = bytes.Clone(sealed)
+	sealed[off] ^= 0x55
+	return sealed
+}
@@ -3,84 +3,294 @@ package sealer
 import (
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
-func Seal(out io.Writer, key *Key, outerPrefix []byte, opt SealOptions) (*Writer, error) {
+// Seal starts sealing a stream to out. The stream can be opened with any of
+// the given keys. Sealing to a single key produces a v0 envelope, which is
+// understood by all versions of this package.
+func Seal(out io.Writer, keys []*Key, outerPrefix []byte, opt SealOptions) (*Writer, error) {
+	w := new(Writer)
+	err := w.reset(out, keys, outerPrefix, opt)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Reset makes the Writer seal a new stream to out, like Seal does, reusing
+// its buffers, and its compressor if the compression options are the same.
+// Services sealing many small objects can keep a Writer per goroutine
+// instead of paying for new ones. The previous stream has to be closed, or
+// is abandoned. Unlike the other methods, Reset can't be called
+// concurrently with them.
+func (w *Writer) Reset(out io.Writer, keys []*Key, outerPrefix []byte, opt SealOptions) error {
+	return w.reset(out, keys, outerPrefix, opt)
+}
+
+func (w *Writer) reset(out io.Writer, keys []*Key, outerPrefix []byte, opt SealOptions) error {
+	if out == nil {
+		return ErrNoOutput
+	}
 	if opt.ChunkSize == 0 {
 		opt.ChunkSize = DefaultChunkSize
 	}
-	if opt.ChunkSize < 0 {
-		panic("chunk size cannot be negative")
-	}
-	if opt.ChunkSize > MaxChunkSize {
-		return nil, ErrChunkSizeTooLarge
+	err := opt.validate()
+	if err != nil {
+		return err
 	}
 	if opt.RandomReader == nil {
 		opt.RandomReader = rand.Reader
 	}
+	zopts := opt.zstdOptions()
+	hdrOff := len(outerPrefix)
+	if opt.Magic {
+		// authenticated as part of the outer prefix
+		outerPrefix = append(slices.Clip(outerPrefix), Magic...)
+	}
 
-	var encapsulated [nonceSizeX + KeySize + overhead]byte
+	hdr := newHeader(keys, &opt)
 
-	_, err := io.ReadFull(opt.RandomReader, encapsulated[:nonceSizeX+KeySize])
+	var ephemeralKey [KeySize]byte
+	err = hdr.newFileKey(ephemeralKey[:], opt)
 	if err != nil {
-		return nil, fmt.Errorf("generating ephemeral key: %w", err)
+		return err
 	}
 
-	aead, err := chacha20poly1305.New(encapsulated[nonceSizeX : nonceSizeX+KeySize])
+	aead, err := hdr.chunkAEAD(ephemeralKey[:], opt.Context)
 	if err != nil {
-		panic(err)
+		clear(ephemeralKey[:])
+		return err
 	}
-	// log.Printf("enc: ephemeral key = [%s] %x", hash(encapsulated[nonceSizeX:nonceSizeX+KeySize]), encapsulated[nonceSizeX:nonceSizeX+KeySize])
-
-	// after this call, plaintext key is no longer on the stack (just in case)
-	encapsulate(key.Key[:], encapsulated[:])
+	// log.Printf("enc: ephemeral key = [%s] %x", hash(ephemeralKey[:]), ephemeralKey[:])
 
-	prefix := make([]byte, 0, len(outerPrefix)+headerSize)
-	prefix = append(prefix, outerPrefix...)
-	prefix = binary.LittleEndian.AppendUint32(prefix, 0)
-	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(opt.ChunkSize))
-	prefix = append(prefix, key.ID[:]...)
-	prefix = append(prefix, encapsulated[:]...)
-
-	w := &Writer{
-		enc: encryptor{
-			out:       out,
-			chunkSize: int(opt.ChunkSize),
-			buf:       make([]byte, 0, 2*opt.ChunkSize),
-			outputBuf: make([]byte, chunkHeaderSize+opt.ChunkSize+overhead),
-			prefix:    prefix,
-			aead:      aead,
-		},
-	}
-
-	w.compr, err = zstd.NewWriter(&w.enc)
+	prefix, err := hdr.seal(ephemeralKey[:], keys, outerPrefix, opt)
+	// plaintext key is no longer needed on the stack (just in case)
+	clear(ephemeralKey[:])
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	return w, nil
+	// the parts to reuse (the Writer holds a mutex, so it isn't copied)
+	oldEnc, oldPipe, oldCompr, oldCopts := w.enc, w.pipe, w.compr, w.copts
+	*w = Writer{
+		enc:        newEncryptor(out, &hdr, aead, prefix, len(outerPrefix), opt.RandomReader),
+		padding:    opt.Padding,
+		trailer:    opt.hasTrailer(),
+		recordSize: opt.RecordSize,
+
+		layout: newLayout(&hdr, len(prefix)-hdrOff, opt.Padding),
+		copts:  opt.compressorOptions(),
+		start:  time.Now(),
+	}
+	if b := opt.Buffers; b != nil {
+		err = b.check(w.enc.chunkSize, w.enc.chunkLen(w.enc.chunkSize))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+		}
+		w.enc.reuse(b.Plaintext, b.Sealed)
+	} else {
+		w.enc.reuse(oldEnc.buf, oldEnc.outputBuf)
+	}
+	if opt.PlaintextDigest {
+		w.hash = sha256.New()
+	}
+	if opt.Checkpoints > 0 {
+		w.enc.checkpoints = &checkpoints{capacity: opt.Checkpoints}
+	}
+	if opt.Concurrency > 1 {
+		w.enc.par = newParallelSealer(opt.Concurrency, w.enc.chunkLen(w.enc.chunkSize))
+	}
+	if m := opt.Manifest; m != nil {
+		*m = Manifest{Header: sha256.Sum256(prefix[hdrOff:])}
+		w.enc.manifest = m
+	}
+
+	if oldPipe != nil {
+		oldPipe.abandon()
+	}
+	var compressed io.Writer = &w.enc
+	if opt.Pipeline && (opt.Compression == CompressionZstd || opt.Compression == CompressionBrotli) {
+		w.pipe = newPipeline(&w.enc, oldPipe)
+		compressed = w.pipe
+	}
+	if oldCompr != nil && oldCopts == w.copts {
+		w.compr = oldCompr
+		resetWriter(w.compr, &w.enc, compressed)
+	} else {
+		w.compr, err = opt.Compression.newWriter(&w.enc, compressed, &opt, zopts)
+		if err != nil && w.pipe != nil {
+			w.pipe.abandon()
+		}
+	}
+	return err
 }
 
 type Writer struct {
-	enc   encryptor
-	compr *zstd.Encoder
+	enc     encryptor
+	compr   io.WriteCloser
+	pipe    *pipeline
+	padding Padding
+
+	// trailer is set if the file ends with a trailer; hash computes the
+	// plaintext digest for it, and total counts the plaintext bytes if
+	// recordSize is set.
+	trailer    bool
+	hash       hasher
+	recordSize bool
+	total      int64
+
+	// layout is kept for HeaderSize and SealedSizeEstimate.
+	layout layout
+
+	// copts are the options compr has been created with, for Reset.
+	copts compressorOptions
+
+	// start is when the stream has begun, and elapsed how long it took
+	// once closed is set (see Stats).
+	start   time.Time
+	elapsed time.Duration
+	closed  bool
+
+	// err is the first error of a Write, which fails everything after it,
+	// or the result of Close or CloseWithError once closed is set.
+	err error
+
+	// mu serializes the methods, so that the Writer can be closed from
+	// another goroutine than the one writing.
+	mu sync.Mutex
 }
 
+// Write seals data. Once a Write fails, the stream is broken: later Writes
+// and Close return the same error, and the file is left without its final
+// chunk, so that it fails to open rather than passes for a shorter one.
+// Writes after Close fail with ErrWriterClosed.
 func (w *Writer) Write(data []byte) (int, error) {
-	return w.compr.Write(data)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.compr.Write(data)
+	if w.hash != nil {
+		w.hash.Write(data[:n])
+	}
+	w.total += int64(n)
+	if err != nil {
+		w.err = withPlaintext(err, w.total)
+	}
+	return n, w.err
 }
 
+// Close seals the rest of the data and the final chunk, and returns the
+// error of the first failed Write if any. Closing again returns the same
+// result.
 func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		if w.err != nil {
+			w.abort()
+		} else {
+			w.err = withPlaintext(w.close(), w.total)
+		}
+		w.elapsed, w.closed = time.Since(w.start), true
+	}
+	return w.err
+}
+
+// CloseWithError is Close if err is nil. Otherwise, like
+// io.PipeWriter.CloseWithError, it stops the stream (see Abort): later
+// Writes fail with ErrWriterClosed, and Close returns err, which lets a
+// server cancel the goroutine writing to the Writer, and that goroutine
+// learn why. It waits for a Write in progress to return. CloseWithError
+// never replaces an earlier error, and does nothing after Close.
+func (w *Writer) CloseWithError(err error) error {
+	if err == nil {
+		return w.Close()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.abort()
+		if w.err == nil {
+			w.err = err
+		}
+		w.elapsed, w.closed = time.Since(w.start), true
+	}
+	return nil
+}
+
+// Abort discards the stream after an upstream error, without sealing the
+// data that hasn't been written out yet, nor the final chunk. The chunks
+// already written don't need a marker: without the final chunk, the file
+// fails to open with ErrTruncated rather than passes for a complete one.
+// Writes and Close after Abort fail with ErrWriterClosed, while Abort after
+// Close does nothing, so it can be deferred. The Writer can be Reset.
+func (w *Writer) Abort() error {
+	return w.CloseWithError(ErrWriterClosed)
+}
+
+// abort drops the data that hasn't been written out yet.
+func (w *Writer) abort() {
+	if w.pipe != nil {
+		w.pipe.abandon()
+	}
+	// drops what the compressor holds, and stops its goroutines
+	resetWriter(w.compr, &w.enc, io.Discard)
+	w.enc.buf = w.enc.buf[:0]
+	if w.enc.par != nil {
+		w.enc.par.queue = nil
+	}
+	clear(w.enc.vec)
+	w.enc.vec = w.enc.vec[:0]
+}
+
+func (w *Writer) close() error {
 	err := w.compr.Close()
 	if err != nil {
 		return err
 	}
+	if w.pipe != nil {
+		err = w.pipe.close()
+		if err != nil {
+			return err
+		}
+	}
+	if w.padding != PaddingNone {
+		err = w.enc.pad(w.padding)
+		if err != nil {
+			return err
+		}
+	}
+	if w.trailer {
+		var t trailer
+		if w.hash != nil {
+			t.digest = w.hash.Sum(nil)
+		}
+		if w.recordSize {
+			t.size = &Size{Plaintext: w.total}
+		}
+		if w.enc.index != nil {
+			t.index = &seekIndex{plaintext: w.total}
+		}
+		return w.enc.closeWithTrailer(&t)
+	}
 	return w.enc.Close()
 }
 
@@ -88,10 +298,114 @@ type encryptor struct {
 	out        io.Writer
 	chunkSize  int
 	prefix     []byte
+	ad         []byte
 	buf        []byte
 	outputBuf  []byte
 	chunkIndex uint32
 	aead       cipher.AEAD
+
+	// nextAD is the additional data of the chunks after the first.
+	nextAD []byte
+
+	// framed is set if chunk headers hold the chunk size.
+	framed bool
+
+	// written is the number of bytes written so far, compressed the number
+	// of bytes sealed into chunks, and sealed the number of bytes of the
+	// chunks and sync markers output so far.
+	written    int64
+	compressed int64
+	sealed     int64
+
+	// statCompressed, statSealed and statChunks are compressed, sealed and
+	// chunkIndex as of the last chunk, stored atomically for Writer.Stats,
+	// since with a pipeline the chunks are sealed on another goroutine.
+	statCompressed int64
+	statSealed     int64
+	statChunks     int64
+
+	// sentChunks and sentBytes count the chunks, and the bytes from the
+	// first chunk, written out so far, for ChunkErrors; vecChunks is the
+	// number of chunks in vec.
+	sentChunks int64
+	sentBytes  int64
+	vecChunks  int64
+
+	// random is the source of chunk nonces, or nil for counter nonces.
+	random   io.Reader
+	adBuf    []byte
+	nonceBuf [nonceSizeS]byte
+
+	// manifest, if not nil, collects the hashes of the chunks, and merkle
+	// the Merkle tree of the chunks before the trailer.
+	manifest *Manifest
+	merkle   *merkleBuilder
+
+	// index, if not nil, collects the seek index, and makes the trailer
+	// chunk independent of its position (see seek.go).
+	index *seekIndexBuilder
+
+	// syncInterval is the number of chunks between sync markers, or 0 for
+	// none (see sync.go).
+	syncInterval uint32
+
+	// checkpoints, if not nil, collects the checkpoints for the trailer
+	// (see Writer.Checkpoint).
+	checkpoints *checkpoints
+
+	// par, if not nil, seals the chunks in parallel (see
+	// SealOptions.Concurrency).
+	par *parallelSealer
+
+	// vec collects what is to be written out together, like the envelope
+	// prefix and the sync markers along with the chunks that follow them,
+	// so that network connections get them in a single writev; sending is
+	// a copy of it being written, and markerBuf holds the sync marker.
+	vec       net.Buffers
+	sending   net.Buffers
+	markerBuf [SyncMarkerSize]byte
+}
+
+// newEncryptor returns an encryptor of the chunks of a file with the given
+// header and envelope prefix (which starts with an outer prefix of outerLen
+// bytes, and is written before the first chunk). random is the source of
+// chunk nonces if the header calls for random nonces.
+func newEncryptor(out io.Writer, hdr *header, aead cipher.AEAD, prefix []byte, outerLen int, random io.Reader) encryptor {
+	e := encryptor{
+		out:       out,
+		sentBytes: -int64(len(prefix)),
+		chunkSize: hdr.chunkSize,
+		prefix:    prefix,
+		ad:        hdr.firstChunkAD(prefix, outerLen),
+		nextAD:    hdr.chunkAD(prefix, outerLen),
+		framed:    hdr.framed,
+		aead:      aead,
+
+		syncInterval: hdr.syncInterval,
+	}
+	if hdr.randomNonces {
+		if random == nil {
+			random = rand.Reader
+		}
+		e.random = random
+	}
+	if hdr.hasTrailerField(trailerMerkle) {
+		e.merkle = new(merkleBuilder)
+	}
+	if hdr.hasTrailerField(trailerIndex) {
+		e.index = newSeekIndexBuilder(hdr)
+	}
+	return e
+}
+
+// reuse takes the given buffers, if they are large enough.
+func (e *encryptor) reuse(buf, outputBuf []byte) {
+	if cap(buf) >= e.chunkSize {
+		e.buf = buf[:0]
+	}
+	if n := e.chunkLen(e.chunkSize); cap(outputBuf) >= n {
+		e.outputBuf = outputBuf[:n]
+	}
 }
 
 func (w *encryptor) Write(data []byte) (int, error) {
@@ -99,30 +413,38 @@ func (w *encryptor) Write(data []byte) (int, error) {
 		return 0, nil
 	}
 
-	buf := append(w.buf, data...)
-	n := len(buf)
+	n := len(data)
+	w.written += int64(n)
 	cs := w.chunkSize
-	if n > cs {
-		start := 0
-		for start+cs < n {
-			// log.Printf("enc: flushing: start = %d, cs = %d, n = %d", start, cs, n)
-			err := w.flush(buf[start:start+cs], false)
-			if err != nil {
-				return 0, err
-			}
-			start += cs
+	if w.buf == nil {
+		w.buf = make([]byte, 0, cs)
+	}
+	if len(w.buf) > 0 {
+		// top up the pending chunk, and flush it if more data follows
+		k := min(cs-len(w.buf), len(data))
+		w.buf = append(w.buf, data[:k]...)
+		data = data[k:]
+		if len(data) == 0 {
+			return n, nil
+		}
+		err := w.flush(w.buf, false)
+		if err != nil {
+			return 0, err
 		}
-		rem := n - start
-		// log.Printf("enc: after flush: start = %d, n = %d, rem = %d", start, n, rem)
-		if start > 0 {
-			copy(buf, buf[start:])
+		w.buf = w.buf[:0]
+	}
+	// whole chunks are sealed straight from data, except the last one,
+	// which is the final chunk if nothing follows
+	for len(data) > cs {
+		err := w.flush(data[:cs], false)
+		if err != nil {
+			return 0, err
 		}
-		buf = buf[:rem]
-		// log.Printf("enc: final after flush: len(buf) = %d", len(buf))
+		data = data[cs:]
 	}
-	w.buf = buf
+	w.buf = append(w.buf, data...)
 
-	return len(data), nil
+	return n, nil
 }
 
 func (w *encryptor) Close() error {
@@ -135,43 +457,341 @@ func (w *encryptor) Close() error {
 
 func (e *encryptor) flush(buf []byte, isFinal bool) error {
 	if e.prefix != nil {
-		_, err := e.out.Write(e.prefix)
+		err := e.write(e.prefix)
 		if err != nil {
 			return err
 		}
 	}
 
-	headerIndex := e.chunkIndex
+	headerIndex, nonceIndex := e.chunkIndex, e.chunkIndex
 	if isFinal {
 		headerIndex = finalChunkIndex
+		if e.index != nil {
+			nonceIndex = finalChunkIndex
+		}
+	} else if headerIndex == finalChunkIndex {
+		return ErrStreamTooLarge
+	}
+	if hasSyncBefore(e.syncInterval, e.chunkIndex) {
+		marker := appendSyncMarker(e.markerBuf[:0], e.aead, e.nextAD, e.chunkIndex)
+		e.sealed += int64(len(marker))
+		err := e.write(marker)
+		if err != nil {
+			return err
+		}
+	}
+	e.placeCheckpoints(e.sealed)
+	if e.index != nil && !isFinal {
+		e.index.add(e.chunkIndex, e.sealed)
 	}
 
-	var nonce [nonceSizeS]byte
-	fillNonce(&nonce, e.chunkIndex, isFinal)
+	if e.outputBuf == nil {
+		e.outputBuf = make([]byte, e.chunkLen(e.chunkSize))
+	}
+	outputBuf := e.outputBuf
+	if e.par != nil {
+		outputBuf = e.par.buffer()
+	}
+	hdrSize := chunkHeaderSize
+	if e.framed {
+		binary.LittleEndian.PutUint32(outputBuf[hdrSize:], uint32(len(buf)))
+		hdrSize += chunkSizeFieldSize
+	}
+	var nonce, ad []byte
+	if e.random != nil {
+		nonce = outputBuf[hdrSize : hdrSize+nonceSizeX]
+		hdrSize += nonceSizeX
+		_, err := io.ReadFull(e.random, nonce)
+		if err != nil {
+			return chunkError(fmt.Errorf("generating nonce: %w", err), int64(e.chunkIndex), e.sealed)
+		}
+		e.adBuf = appendChunkAD(append(e.adBuf[:0], e.ad...), nonceIndex, isFinal)
+		ad = e.adBuf
+	} else {
+		fillNonce(&e.nonceBuf, nonceIndex, isFinal)
+		nonce, ad = e.nonceBuf[:], e.ad
+	}
+	binary.LittleEndian.PutUint32(outputBuf[:chunkHeaderSize], headerIndex)
 	e.chunkIndex++
+	e.prefix = nil
+	e.ad = e.nextAD
+	e.compressed += int64(len(buf))
+	e.sealed += int64(hdrSize + len(buf) + overhead)
+	atomic.StoreInt64(&e.statCompressed, e.compressed)
+	atomic.StoreInt64(&e.statSealed, e.sealed)
+	atomic.StoreInt64(&e.statChunks, int64(e.chunkIndex))
+
+	if e.par != nil {
+		err := e.par.seal(e, outputBuf[:hdrSize], nonce, buf, ad, isFinal)
+		if err == nil && isFinal {
+			err = e.drain(0)
+		}
+		return err
+	}
+	sealed := e.aead.Seal(outputBuf[hdrSize:hdrSize], nonce, buf, ad)
+	e.emit(outputBuf[:hdrSize+len(sealed)], isFinal)
+	return e.send()
+}
 
-	// log.Printf("enc: headerIndex = %d, prefix = %d [%s], nonce = %x, buf = %d [%s]: %x", headerIndex, len(e.prefix), hash(e.prefix), nonce[:], len(buf), hash(buf), buf)
+// emit adds a sealed chunk to what is to be written out, and to the
+// manifest and the Merkle tree.
+func (e *encryptor) emit(output []byte, isFinal bool) {
+	if e.manifest != nil {
+		e.manifest.Chunks = append(e.manifest.Chunks, sha256.Sum256(output))
+	}
+	if e.merkle != nil && !isFinal {
+		e.merkle.add(output)
+	}
+	e.vec = append(e.vec, output)
+	e.vecChunks++
+}
 
-	sealed := e.aead.Seal(e.outputBuf[chunkHeaderSize:chunkHeaderSize], nonce[:], buf, e.prefix)
-	// log.Printf("enc: sealed = %d [%s]: %x", len(sealed), hash(sealed), sealed)
-	output := e.outputBuf[:chunkHeaderSize+len(sealed)]
-	e.prefix = nil
+// send writes out what has been collected in vec.
+func (e *encryptor) send() error {
+	var err error
+	var n int64
+	switch len(e.vec) {
+	case 0:
+	case 1:
+		n = int64(len(e.vec[0]))
+		_, err = e.out.Write(e.vec[0])
+	default:
+		for _, b := range e.vec {
+			n += int64(len(b))
+		}
+		// WriteTo consumes sending, and vec keeps its capacity
+		e.sending = e.vec
+		_, err = e.sending.WriteTo(e.out)
+	}
+	clear(e.vec)
+	e.vec = e.vec[:0]
+	if err != nil {
+		return chunkError(err, e.sentChunks, max(0, e.sentBytes))
+	}
+	e.sentChunks += e.vecChunks
+	e.sentBytes += n
+	e.vecChunks = 0
+	return nil
+}
 
-	binary.LittleEndian.PutUint32(output[:chunkHeaderSize], headerIndex)
+// validate checks the options before anything is written, so that invalid
+// ones fail Seal rather than produce a malformed file.
+func (opt *SealOptions) validate() error {
+	if opt.ChunkSize < 0 || opt.Compression == CompressionZstdChunks && opt.ChunkSize < 2 {
+		return ErrInvalidChunkSize
+	}
+	if opt.ChunkSize > MaxChunkSize {
+		return ErrChunkSizeTooLarge
+	}
+	if opt.Checkpoints > maxCheckpoints {
+		return fmt.Errorf("%w: at most %d Checkpoints", ErrInvalidOptions, maxCheckpoints)
+	}
+	if opt.ChunkSize < opt.trailerSize() {
+		return fmt.Errorf("%w: the trailer needs a chunk size of at least %d", ErrInvalidChunkSize, opt.trailerSize())
+	}
+	if opt.Compression < CompressionZstd || opt.Compression > CompressionBrotli {
+		return fmt.Errorf("%w: unknown compression %d", ErrInvalidOptions, opt.Compression)
+	}
+	if opt.Padding < PaddingNone || opt.Padding > PaddingChunk {
+		return fmt.Errorf("%w: unknown padding %d", ErrInvalidOptions, opt.Padding)
+	}
+	if opt.SeekIndex && opt.Compression != CompressionNone && opt.Compression != CompressionZstdChunks {
+		return fmt.Errorf("%w: SeekIndex needs CompressionNone or CompressionZstdChunks", ErrInvalidOptions)
+	}
+	if opt.SyncInterval < 0 || int64(opt.SyncInterval) >= int64(finalChunkIndex) || opt.SyncInterval > 0 && opt.SeekIndex {
+		return fmt.Errorf("%w: SyncInterval must be positive, and cannot be combined with SeekIndex", ErrInvalidOptions)
+	}
+	if opt.Concurrency < 0 {
+		return fmt.Errorf("%w: negative Concurrency", ErrInvalidOptions)
+	}
+	if opt.Checkpoints < 0 || opt.Checkpoints > 0 && opt.Compression != CompressionNone && opt.Compression != CompressionZstdChunks {
+		return fmt.Errorf("%w: Checkpoints needs CompressionNone or CompressionZstdChunks", ErrInvalidOptions)
+	}
+	if opt.Compression == CompressionZstdChunks && opt.Padding != PaddingNone {
+		return fmt.Errorf("%w: CompressionZstdChunks cannot be combined with padding", ErrInvalidOptions)
+	}
+	if opt.ZstdLevel < 0 || opt.ZstdLevel > maxZstdLevel {
+		return ErrInvalidLevel
+	}
+	if opt.BrotliLevel != 0 && (opt.BrotliLevel < brotli.BestSpeed || opt.BrotliLevel > brotli.BestCompression) {
+		return ErrInvalidLevel
+	}
+	if w := opt.ZstdWindowSize; w != 0 && (w < zstd.MinWindowSize || w > zstd.MaxWindowSize || w&(w-1) != 0) {
+		return fmt.Errorf("%w: invalid zstd window size %d", ErrInvalidOptions, w)
+	}
+	if opt.ZstdConcurrency < 0 {
+		return fmt.Errorf("%w: negative ZstdConcurrency", ErrInvalidOptions)
+	}
+	if opt.ZstdLiterals < ZstdLiteralsDefault || opt.ZstdLiterals > ZstdLiteralsRaw {
+		return fmt.Errorf("%w: invalid ZstdLiterals %d", ErrInvalidOptions, opt.ZstdLiterals)
+	}
+	if opt.Dictionary != nil {
+		if !opt.Compression.isZstd() {
+			return fmt.Errorf("%w: a dictionary can only be used with zstd", ErrInvalidOptions)
+		}
+		err := opt.Dictionary.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	_, err := e.out.Write(output)
-	return err
+// newFileKey generates a random file key into output, or derives it from
+// opt.MasterKey and adds the master key slot to the header.
+func (hdr *header) newFileKey(output []byte, opt SealOptions) error {
+	if opt.RandomReader == nil {
+		opt.RandomReader = rand.Reader
+	}
+	if opt.MasterKey == nil {
+		_, err := io.ReadFull(opt.RandomReader, output)
+		if err != nil {
+			return fmt.Errorf("generating ephemeral key: %w", err)
+		}
+		return nil
+	}
+	fileKey, s, err := deriveFileKey(opt.MasterKey, opt.RandomReader)
+	if err != nil {
+		return err
+	}
+	copy(output, fileKey)
+	clear(fileKey)
+	hdr.slots = append(hdr.slots, s)
+	return nil
+}
+
+// newHeader returns the header of a file sealed to keys with opt, without
+// any slots yet.
+func newHeader(keys []*Key, opt *SealOptions) header {
+	hdr := header{
+		version:   1,
+		chunkSize: opt.ChunkSize,
+	}
+	if len(keys) == 1 && len(opt.Recipients)+len(opt.Encapsulators)+len(opt.Escrow) == 0 && opt.Passphrase == nil && !opt.PostQuantum && opt.NotAfter.IsZero() && opt.MasterKey == nil && opt.Context == "" && !opt.RandomNonces && !opt.CommitKey && opt.Padding == PaddingNone && opt.Compression == CompressionZstd && opt.Dictionary == nil && !opt.hasTrailer() && opt.SyncInterval == 0 {
+		hdr.version = 0
+	}
+	hdr.context = opt.Context != ""
+	hdr.boundChunks = hdr.version != 0
+	hdr.framed = hdr.version != 0
+	hdr.padded = opt.Padding != PaddingNone
+	hdr.compression = opt.Compression
+	hdr.randomNonces = opt.RandomNonces
+	hdr.syncInterval = uint32(opt.SyncInterval)
+	if opt.hasTrailer() {
+		hdr.trailerSize = opt.trailerSize()
+		hdr.trailerFields = opt.trailerFields()
+	}
+	if opt.Dictionary != nil {
+		hdr.dictID = opt.Dictionary.ID
+	}
+	return hdr
+}
+
+// seal encapsulates fileKey for all keys, recipients and other slots
+// configured by opt, appending them to the slots already in the header (if
+// any), and returns the envelope prefix: outerPrefix followed by the header.
+// Version 0 headers are only used for a single key; other headers get
+// version 1 (or 2 for opt.PostQuantum), a key commitment and a header MAC.
+func (hdr *header) seal(fileKey []byte, keys []*Key, outerPrefix []byte, opt SealOptions) ([]byte, error) {
+	if len(keys)+len(opt.Recipients)+len(opt.Encapsulators)+len(hdr.slots) == 0 && opt.Passphrase == nil {
+		return nil, ErrNoKeys
+	}
+	if opt.RandomReader == nil {
+		opt.RandomReader = rand.Reader
+	}
+
+	encs := opt.encapsulators(keys)
+
+	if opt.PostQuantum {
+		hdr.version = 2
+	}
+	hdr.slots = slices.Grow(hdr.slots, len(encs)+1)
+	for _, enc := range encs {
+		if opt.PostQuantum && !isPostQuantum(enc) {
+			return nil, ErrNotPostQuantum
+		}
+		s, err := enc.Encapsulate(fileKey, opt.RandomReader)
+		if err != nil {
+			return nil, err
+		}
+		if len(s.Data) > maxSlotDataSize {
+			return nil, ErrHeaderTooLarge
+		}
+		hdr.slots = append(hdr.slots, s)
+	}
+	if opt.Passphrase != nil {
+		s, err := encapsulatePassphrase(opt.Passphrase, opt.KDF, fileKey, opt.RandomReader)
+		if err != nil {
+			return nil, err
+		}
+		hdr.slots = append(hdr.slots, s)
+	}
+	if opt.Anonymous {
+		for i := range hdr.slots {
+			switch hdr.slots[i].Type {
+			case SlotTypeKey, SlotTypeMasterKey, SlotTypeHPKE:
+				hdr.slots[i].KeyID = [IDSize]byte{}
+			}
+		}
+	}
+	if !opt.NotAfter.IsZero() {
+		hdr.notAfter = opt.NotAfter.Unix()
+	}
+	if hdr.version != 0 {
+		hdr.commitment = keyCommitment(fileKey)
+		hdr.mac = make([]byte, headerMACSize)
+	}
+
+	prefix := make([]byte, 0, len(outerPrefix)+headerSize)
+	prefix = append(prefix, outerPrefix...)
+	prefix, err := hdr.append(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix)-len(outerPrefix)-headerSizeV1Fixed > MaxHeaderSize {
+		return nil, ErrHeaderTooLarge
+	}
+	if hdr.mac != nil {
+		hdr.mac = headerMAC(fileKey, prefix)
+		copy(prefix[len(outerPrefix)+hdr.macOff:], hdr.mac)
+	}
+	return prefix, nil
 }
 
-func encapsulate(key []byte, encapsulated []byte) {
+// encapsulators returns everything that gets a slot in the header, other
+// than the master key and the passphrase.
+func (opt *SealOptions) encapsulators(keys []*Key) []Encapsulator {
+	encs := make([]Encapsulator, 0, len(keys)+len(opt.Recipients)+len(opt.Encapsulators)+len(opt.Escrow))
+	for _, key := range keys {
+		encs = append(encs, key)
+	}
+	for _, r := range opt.Recipients {
+		encs = append(encs, r)
+	}
+	encs = append(encs, opt.Encapsulators...)
+	for _, r := range opt.Escrow {
+		encs = append(encs, escrowEncapsulator{r})
+	}
+	return encs
+}
+
+// encapsulate wraps the key held in encapsulated after the nonce with key.
+//
+// The wrapping cipher isn't cached per Key: chacha20poly1305.NewX only
+// copies the key, XChaCha20 derives its subkey from each nonce, so there
+// is no key schedule to save, and Key has to remain a plain comparable
+// value that can live in locked memory (see NewLockedKey). The per-file
+// work (the file key, its commitment and the header MAC) can't be reused.
+func encapsulate(key []byte, encapsulated []byte, additionalData []byte) error {
 	ea, err := chacha20poly1305.NewX(key)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// log.Printf("encapsulate: nonce = [%s]: %x", hash(encapsulated[:nonceSizeX]), encapsulated[:nonceSizeX])
 	// log.Printf("encapsulate: key = [%s]: %x", hash(encapsulated[nonceSizeX:nonceSizeX+KeySize]), encapsulated[nonceSizeX:nonceSizeX+KeySize])
 
-	ea.Seal(encapsulated[nonceSizeX:nonceSizeX], encapsulated[:nonceSizeX], encapsulated[nonceSizeX:nonceSizeX+KeySize], nil)
+	ea.Seal(encapsulated[nonceSizeX:nonceSizeX], encapsulated[:nonceSizeX], encapsulated[nonceSizeX:nonceSizeX+KeySize], additionalData)
 	// log.Printf("encapsulate: sealed = [%s]: %x", hash(encapsulated[:]), encapsulated[:])
+	return nil
 }
@@ -0,0 +1,215 @@
+package sealer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestEncryptor_chunkLimit(t *testing.T) {
+	var key Key
+	aead, err := (&header{}).chunkAEAD(key.Key[:], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := newEncryptor(&buf, &header{chunkSize: 4}, aead, nil, 0, nil)
+	enc.chunkIndex = finalChunkIndex - 1
+
+	_, err = enc.Write([]byte("12345678"))
+	if err != nil {
+		t.Fatalf("writing the last non-final chunk: %v", err)
+	}
+	_, err = enc.Write([]byte("9"))
+	if err != ErrStreamTooLarge {
+		t.Fatalf("writing past the limit: err = %v, wanted ErrStreamTooLarge", err)
+	}
+
+	// a stream that fits exactly can still be closed
+	buf.Reset()
+	enc = newEncryptor(&buf, &header{chunkSize: 4}, aead, nil, 0, nil)
+	enc.chunkIndex = finalChunkIndex - 1
+	_, err = enc.Write([]byte("12345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.Close()
+	if err != nil {
+		t.Fatalf("closing a stream of the maximum size: %v", err)
+	}
+	out := buf.Bytes()
+	if len(out) != 2*(chunkHeaderSize+4+overhead) {
+		t.Fatalf("wrote %d bytes, wanted 2 chunks", len(out))
+	}
+	if !bytes.Equal(out[:4], []byte{0xfe, 0xff, 0xff, 0xff}) || !bytes.Equal(out[len(out)/2:][:4], []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected chunk indices in %x", out)
+	}
+}
+
+func TestSeal_boundChunks(t *testing.T) {
+	key := &Key{}
+	var buf bytes.Buffer
+	w, err := Seal(&buf, []*Key{key, {ID: [IDSize]byte{1}}}, []byte("OUTER"), SealOptions{ChunkSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(bytes.Repeat([]byte("hello, world"), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unbind := range []bool{false, true} {
+		opn, err := Prepare(bytes.NewReader(buf.Bytes()[5:]), []byte("OUTER"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !opn.hdr.boundChunks {
+			t.Fatal("chunks not bound to the header")
+		}
+		// simulates splicing chunks onto a header that doesn't bind them
+		opn.hdr.boundChunks = !unbind
+		r, err := opn.Open(key)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if unbind && err == nil {
+			t.Errorf("decrypted bound chunks without the header digest")
+		} else if !unbind && err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSealer_independentChunks(t *testing.T) {
+	key := &Key{}
+	var original []byte
+	for i := range 20 {
+		original = fmt.Appendf(original, "chunk %d is compressed on its own. ", i)
+	}
+	var buf bytes.Buffer
+	w, err := Seal(&buf, []*Key{key}, nil, SealOptions{ChunkSize: 64, Compression: CompressionZstdChunks, RandomNonces: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(original)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := bytes.NewReader(buf.Bytes())
+	opn, err := Prepare(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fileKey [KeySize]byte
+	err = opn.decapsulateKey(fileKey[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := opn.decryptor(opn.in, fileKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// split the body into chunks using the sizes in their headers
+	body := buf.Bytes()[len(buf.Bytes())-in.Len():]
+	var chunks [][]byte
+	for len(body) > 0 {
+		n := opn.hdr.chunkHeaderLen() + int(binary.LittleEndian.Uint32(body[chunkHeaderSize:])) + overhead
+		chunks = append(chunks, body[:n])
+		body = body[n:]
+	}
+	if len(chunks) < 10 {
+		t.Fatalf("%d chunks", len(chunks))
+	}
+
+	// and decode them back to front
+	parts := make([][]byte, len(chunks))
+	for i := len(chunks) - 1; i >= 0; i-- {
+		ad := dec.ad
+		if i == 0 {
+			ad = opn.ad
+		}
+		plain, isFinal, err := dec.open(nil, chunks[i], uint32(i), ad)
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+		if isFinal != (i == len(chunks)-1) {
+			t.Errorf("chunk %d: isFinal = %v", i, isFinal)
+		}
+		parts[i], err = decodeChunk(z, nil, plain, opn.chunkSize)
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+	if actual := bytes.Join(parts, nil); !bytes.Equal(actual, original) {
+		t.Errorf("got %q, wanted %q", actual, original)
+	}
+}
+
+func TestEncryptor_writeSizes(t *testing.T) {
+	var key Key
+	aead, err := (&header{}).chunkAEAD(key.Key[:], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	seal := func(sizes ...int) []byte {
+		var buf bytes.Buffer
+		enc := newEncryptor(&buf, &header{chunkSize: 64, framed: true}, aead, nil, 0, nil)
+		rest := data
+		for i := 0; len(rest) > 0; i++ {
+			n := min(sizes[i%len(sizes)], len(rest))
+			if _, err := enc.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	expected := seal(1)
+	for _, sizes := range [][]int{{64}, {128}, {1000}, {63, 65}, {10, 200}, {64, 1, 127}} {
+		if actual := seal(sizes...); !bytes.Equal(actual, expected) {
+			t.Errorf("writes of %v: got %d bytes, which differ from writing byte by byte", sizes, len(actual))
+		}
+	}
+
+	// whole chunks are sealed without copying them into the buffer
+	enc := newEncryptor(io.Discard, &header{chunkSize: 64, framed: true}, aead, nil, 0, nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		enc.Write(data)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per write", allocs)
+	}
+}
+
+func TestHeader_fieldTooLarge(t *testing.T) {
+	hdr := header{version: 1, chunkSize: 1024, slots: []Slot{{Type: SlotTypeCustom, Data: make([]byte, maxFieldSize)}}}
+	if _, err := hdr.append(nil); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Errorf("append with an oversized slot = %v, wanted ErrHeaderTooLarge", err)
+	}
+	tr := trailer{digest: make([]byte, maxFieldSize+1)}
+	if _, err := tr.append(nil); err == nil {
+		t.Errorf("append with an oversized trailer field succeeded")
+	}
+}
@@ -2,9 +2,13 @@
 package sealer
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -25,16 +29,222 @@ const (
 // Key is a user-provided encrypted key. It is used once per sealing operation,
 // to encapsulate (i.e. encrypt) an ephemeral file key. You can generate the key
 // bytes by reading from crypto/rand.Reader. NIST recommends that you limit
-// using a single key to no more than 2^32 Seal operations.
+// using a single key to no more than 2^32 Seal operations; high-volume
+// writers should use it as SealOptions.MasterKey instead.
 type Key struct {
 	ID  [IDSize]byte
 	Key [KeySize]byte
 }
 
+// keyIDDomain separates DeriveKeyID from any other use of the key material.
+const keyIDDomain = "github.com/andreyvit/sealer key ID v1"
+
+// DeriveKeyID computes a key ID from the key material, as HMAC-SHA256 keyed
+// by the key material over a fixed domain tag. All applications derive
+// the same ID for the same key, so keyrings and keystores interoperate,
+// and the ID reveals nothing about the key.
+func DeriveKeyID(keyMaterial []byte) [IDSize]byte {
+	m := hmac.New(sha256.New, keyMaterial)
+	m.Write([]byte(keyIDDomain))
+	return [IDSize]byte(m.Sum(nil))
+}
+
+// SealOptions configure Seal. Invalid options make Seal fail with
+// ErrInvalidChunkSize, ErrChunkSizeTooLarge, ErrInvalidLevel or
+// ErrInvalidOptions rather than produce a malformed file.
 type SealOptions struct {
-	ChunkSize    int
-	ZstdLevel    int
+	// ChunkSize is the size of plaintext chunks, DefaultChunkSize if zero,
+	// up to MaxChunkSize.
+	ChunkSize int
+
+	// ZstdLevel is the zstd compression level, from 1 to 22 like the zstd
+	// command; 0 means the library default (about 3). The library only
+	// has four levels internally, so nearby levels behave the same.
+	ZstdLevel int
+
 	RandomReader io.Reader
+
+	// Concurrency, if greater than 1, is the number of chunks sealed at
+	// once on separate goroutines, which speeds up sealing large files on
+	// machines with many cores, at the cost of buffering up to twice as
+	// many chunks. The chunks are written out in order, and the output is
+	// the same as without it. Write errors of out may be reported by a
+	// later Write or Close.
+	Concurrency int
+
+	// ZstdWindowSize, if not zero, is the zstd window size: a power of two
+	// between zstd.MinWindowSize and zstd.MaxWindowSize. A larger window
+	// finds matches further back in the stream, which pays off for huge
+	// repetitive streams such as backups, but readers need that much
+	// memory to decompress the file (see OpenOptions.MaxWindowSize).
+	ZstdWindowSize int
+
+	// ZstdLongRange sets the window size to DefaultLongRangeWindowSize,
+	// unless ZstdWindowSize is set. This is the equivalent of `zstd
+	// --long`; the zstd library has no separate long-distance matcher.
+	ZstdLongRange bool
+
+	// ZstdConcurrency, if not zero, is the number of blocks the encoder of
+	// CompressionZstd compresses at once on separate goroutines, GOMAXPROCS
+	// by default; 1 compresses on the calling goroutine, using less memory.
+	// CompressionZstdChunks always compresses chunks one by one (see
+	// Concurrency).
+	ZstdConcurrency int
+
+	// ZstdLiterals selects how zstd compresses literals, the bytes not
+	// found earlier in the stream, which is decided by the level by default.
+	ZstdLiterals ZstdLiterals
+
+	// ZstdLowMemory makes the zstd encoder use less memory at the cost of
+	// speed. The window size matters more (see ZstdWindowSize).
+	ZstdLowMemory bool
+
+	// Pipeline makes CompressionZstd and CompressionBrotli compress on the
+	// calling goroutine while the chunks are sealed and written out on
+	// another one, so that the two overlap. A few chunks are buffered in
+	// between. Write errors of out may be reported by a later Write or
+	// Close. It has no effect with other compressions.
+	Pipeline bool
+
+	// Buffers, if not nil, are the chunk buffers to seal with instead of
+	// allocating them (see NewBuffers).
+	Buffers *Buffers
+
+	// Recipients are public keys to seal the file to, in addition to the
+	// secret keys passed to Seal.
+	Recipients []*Recipient
+
+	// PostQuantum makes Seal reject recipients that aren't post-quantum
+	// secure (see KEMMLKEM768X25519) and Encapsulators that don't report
+	// being so (see PostQuantumReporter), and marks the file with header
+	// v2, which readers only accept when all slots are post-quantum.
+	PostQuantum bool
+
+	// MasterKey, if not nil, makes Seal derive the file key from the master
+	// key and a random salt stored in the header, instead of generating
+	// a random file key and encapsulating it. This is cheaper and yields a
+	// smaller slot, and the derived keys are unrelated to each other, so
+	// a master key can seal any number of files. Open such files as usual,
+	// passing the master key to Open or adding it to a Keyring. Other keys,
+	// recipients and slots can still be added.
+	MasterKey *Key
+
+	// Passphrase, if not nil, adds a slot that can be opened with
+	// Openable.OpenPassphrase, using a key derived according to KDF.
+	Passphrase []byte
+	KDF        KDFParams
+
+	// Encapsulators add slots wrapping the file key by other means, e.g.
+	// by an external key management service.
+	Encapsulators []Encapsulator
+
+	// Escrow are organizational recovery keys. Their slots are marked as
+	// escrow slots, which are only opened by Openable.OpenEscrow. Escrow
+	// alone does not count as a key to seal to.
+	Escrow []*Recipient
+
+	// Context, if not empty, is a label such as "tenant-42/invoices" that
+	// is mixed into the derivation of the chunk encryption key, and has to
+	// be provided verbatim (via Openable.Context) to open the file. This
+	// binds files to an application or a tenant, so they cannot be passed
+	// off as belonging to another one even when both use the same keys.
+	// The context itself is not stored in the file.
+	Context string
+
+	// Anonymous omits the key IDs of key, master key and recipient slots
+	// from the header, so that the file doesn't reveal whom it's for.
+	// Opening such files involves trying each candidate key in turn: Open,
+	// OpenAny, OpenWithKeyring and OpenIdentity do it automatically. Note
+	// that other kinds of slots may reveal their keys in slot data.
+	Anonymous bool
+
+	// RandomNonces makes Seal encrypt each chunk with XChaCha20-Poly1305
+	// under a random nonce stored in the chunk header, instead of
+	// ChaCha20-Poly1305 with the chunk index as the nonce. This costs 24
+	// bytes per chunk, but the chunks no longer have to be encrypted in
+	// order. Readers that predate this option refuse such files.
+	RandomNonces bool
+
+	// Compression selects the compression algorithm, zstd by default. Use
+	// CompressionNone for data that doesn't compress, and
+	// CompressionZstdChunks for data that is partly compressible.
+	Compression Compression
+
+	// BrotliLevel is the brotli quality for CompressionBrotli, from 1
+	// (fastest) to 11 (smallest); 0 means the library default of 6.
+	BrotliLevel int
+
+	// Dictionary, if not nil, is the zstd dictionary to compress the data
+	// with. Its ID is stored in the header, and the dictionary has to be
+	// provided via Openable.Dictionaries to open the file.
+	Dictionary *Dictionary
+
+	// Padding pads the compressed stream so that the size of the file
+	// reveals less about the size of the plaintext, which matters when
+	// the plaintext is one of a few guessable documents. See PaddingPadme.
+	Padding Padding
+
+	// PlaintextDigest makes Seal record the SHA-256 of the plaintext in an
+	// authenticated trailer at the end of the file, which Reader verifies
+	// when it reaches the end (see ErrDigestMismatch and Reader.Digest).
+	// The chunks are authenticated anyway; the digest guards against bugs
+	// anywhere between the writer's and the reader's buffers, and gives
+	// a content hash for free. Readers that predate trailers refuse such
+	// files.
+	PlaintextDigest bool
+
+	// MerkleRoot makes Seal record the root of a Merkle tree over the chunks
+	// in the trailer, so that the root can be published, and downloaded
+	// chunks checked against it without a key (see MerkleTree).
+	MerkleRoot bool
+
+	// Manifest, if not nil, is filled with the hashes of the header and the
+	// chunks as they are written, and is complete once the Writer is closed.
+	// Store it next to the file to audit the file without a key (see
+	// Manifest.Verify).
+	Manifest *Manifest
+
+	// RecordSize makes Seal record the size of the plaintext, the size of
+	// the chunks and their number in the trailer, so that readers can learn
+	// them up front (see Reader.Size) to pre-allocate buffers or show
+	// progress.
+	RecordSize bool
+
+	// SeekIndex makes Seal record an index of the chunks in the trailer, so
+	// that readers can seek (see Reader.Seek). It needs CompressionNone, or
+	// CompressionZstdChunks, whose chunks are compressed independently, and
+	// so can be decompressed starting from any of them.
+	SeekIndex bool
+
+	// SyncInterval, if not zero, makes Seal write a sync marker before
+	// every SyncInterval-th chunk, so that tools can find chunk boundaries
+	// in the middle of a file without an index (see Openable.OpenAtSync),
+	// e.g. to split a huge file for parallel processing. Each marker adds
+	// SyncMarkerSize bytes. It cannot be combined with SeekIndex, and
+	// Reader.Size only works at the end of such files.
+	SyncInterval int
+
+	// Checkpoints is the number of named checkpoints the trailer has room
+	// for (see Writer.Checkpoint), each taking 53 bytes of it, up to 1236.
+	// Like SeekIndex, it needs CompressionNone or CompressionZstdChunks.
+	Checkpoints int
+
+	// CommitKey makes Seal write a v1 header, which carries a key commitment
+	// (see Openable.RequireKeyCommitment), even when sealing to a single
+	// key. All other files get one anyway.
+	CommitKey bool
+
+	// Magic makes Seal write the Magic signature right after the outer
+	// prefix, so that tools can recognize sealed files (see DetectSealed
+	// and Sniff). Prepare handles such files transparently; older readers
+	// refuse them.
+	Magic bool
+
+	// NotAfter, if not zero, is the time after which the file can no longer
+	// be opened (see ErrExpired). It is stored in the header, which is
+	// authenticated, so it cannot be changed without the file key. Readers
+	// that predate expiry support refuse such files.
+	NotAfter time.Time
 }
 
 // DefaultChunkSize is the default value of SealOptions.ChunkSize used by
@@ -46,29 +256,255 @@ const DefaultChunkSize int = 32 * 1024
 // in order to avoid DoS attacks when reading untrusted files.
 const MaxChunkSize int = 1024 * 1024
 
+// MaxHeaderSize is the maximum size of the envelope header fields that
+// opener will accept, for the same reason as MaxChunkSize.
+const MaxHeaderSize int = 1024 * 1024
+
 var (
 	ErrChunkSizeTooLarge  = errors.New("chunk size too large")
+	ErrInvalidChunkSize   = errors.New("invalid chunk size")
+	ErrInvalidLevel       = errors.New("invalid compression level")
+	ErrInvalidOptions     = errors.New("invalid seal options")
+	ErrNoOutput           = errors.New("no output to seal to")
 	ErrUnsupportedVersion = errors.New("unsupported or corrupted sealed file")
+	ErrNoKeys             = errors.New("no keys to seal with")
+	ErrHeaderTooLarge     = errors.New("header too large")
+	ErrNoMatchingKey      = errors.New("sealed file has no slot for this key")
+	ErrUnsupportedKEM     = errors.New("unsupported HPKE KEM")
+	ErrNotPostQuantum     = errors.New("recipient is not post-quantum secure")
+	ErrInvalidKDFParams   = errors.New("invalid KDF parameters")
+	ErrMalformedKey       = errors.New("malformed key string")
+	ErrExpired            = errors.New("sealed file has expired")
+	ErrContextMismatch    = errors.New("sealed file has not been sealed with a context")
+	ErrStreamTooLarge     = errors.New("sealed stream too large for its chunk size")
+	ErrNoKeyCommitment    = errors.New("sealed file has no key commitment")
+	ErrPlaintextTooLarge  = errors.New("sealed file plaintext exceeds the size limit")
+	ErrTrailingData       = errors.New("sealed file is followed by trailing data")
+	ErrVersionNotAllowed  = errors.New("sealed file version not allowed")
+	ErrWriterClosed       = errors.New("sealed file writer is closed")
+	ErrReaderClosed       = errors.New("sealed file reader is closed")
+
+	// ErrWrongKey is returned when a slot meant for the key fails to
+	// decrypt with it: the key (or passphrase) is wrong, while another one
+	// may open the file.
+	ErrWrongKey = errors.New("sealed file cannot be decrypted with this key")
+
+	// ErrCorrupted is wrapped by the errors of chunks that fail
+	// authentication or are malformed, and of headers whose MAC or key
+	// commitment doesn't match, which mean that the file has been damaged
+	// or tampered with.
+	ErrCorrupted = errors.New("data corruption")
+
+	// ErrTruncated is returned when the file ends before its final chunk.
+	// It wraps io.ErrUnexpectedEOF.
+	ErrTruncated = fmt.Errorf("sealed file is truncated: %w", io.ErrUnexpectedEOF)
+
+	errHeaderMAC      = fmt.Errorf("%w: header authentication failed", ErrCorrupted)
+	errKeyCommitment  = fmt.Errorf("%w: key commitment mismatch", ErrCorrupted)
+	errKeyNameTooLong = errors.New("key name too long")
 )
 
-// Envelope header format:
-//  - version         uint32 (zero so far, for version and/or multiple keys)
+// ChunkError is the error of reading or writing a chunk, with the position
+// of the chunk, so that failures can be matched with the logs of the
+// storage, and damaged ranges repaired.
+type ChunkError struct {
+	// Chunk is the index of the chunk, from 0. For a Writer, it's the
+	// first chunk that hasn't been written out.
+	Chunk int64
+
+	// Offset is the offset of the chunk (or of the sync marker before it)
+	// from the first chunk, which follows the header (see HeaderSize).
+	Offset int64
+
+	// Plaintext is the offset in the plaintext at which the error occurred:
+	// the number of bytes read from a Reader or written to a Writer before
+	// it, or the offset of the read of a ReaderAt.
+	Plaintext int64
+
+	Err error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d at offset %d (plaintext offset %d): %v", e.Chunk, e.Offset, e.Plaintext, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// chunkError returns err as a ChunkError of the given chunk. Encryptors and
+// decryptors don't know the plaintext offset, which is filled in by
+// withPlaintext.
+func chunkError(err error, chunk, offset int64) error {
+	if _, ok := err.(*ChunkError); ok {
+		return err
+	}
+	return &ChunkError{Chunk: chunk, Offset: offset, Plaintext: -1, Err: err}
+}
+
+// withPlaintext returns err with the plaintext offset filled in, if it's a
+// ChunkError from chunkError.
+func withPlaintext(err error, plaintext int64) error {
+	ce, ok := err.(*ChunkError)
+	if !ok || ce.Plaintext >= 0 {
+		return err
+	}
+	c := *ce
+	c.Plaintext = plaintext
+	return &c
+}
+
+// The header can be preceded by Magic (see SealOptions.Magic), which is
+// then treated as the end of the outer prefix.
+//
+// Envelope header format v0 (single key, written when sealing to one key):
+//  - version         uint32 (0)
 //  - chunkSize       uint32
 //  - accessKeyID     [IDSize]byte
 //  - encapsulatedKey [nonceSizeX + KeySize + overhead]byte
+//
+// Envelope header format v1:
+//  - version         uint32 (1)
+//  - chunkSize       uint32
+//  - fieldsSize      uint32 (total size of the fields that follow)
+//  - fields, each:
+//    - type          uint16 (high bit set if readers may skip it)
+//    - size          uint16
+//    - value         [size]byte
+//
+// Key slot field value:
+//  - slotType        uint16
+//  - keyID           [IDSize]byte
+//  - data            (encapsulatedKey for SlotTypeKey, defined by
+//                     the Encapsulator for other slot types)
+//
+// Expiry field value:
+//  - notAfter        int64 (Unix time in seconds)
+//
+// Context field value is empty; the field marks files whose chunks are
+// encrypted with a key derived from the file key and a context string
+// (see SealOptions.Context) rather than with the file key itself.
+//
+// Random nonces field value is empty; the field marks files whose chunks
+// are encrypted with XChaCha20-Poly1305 under random nonces (see
+// SealOptions.RandomNonces).
+//
+// Framing field value is empty; the field marks files whose chunk headers
+// hold the chunk size, so that readers stop right after the final chunk.
+//
+// Compression field value (absent for zstd):
+//  - compression     uint8 (see Compression)
+//
+// Dictionary field value:
+//  - dictionaryID    uint32 (see Dictionary)
+//
+// Padding field value is empty; the field marks files whose compressed
+// stream is followed by padding (see SealOptions.Padding): a 0x80 byte and
+// any number of zeros.
+//
+// Trailer field value (marks files whose final chunk holds a trailer rather
+// than data, see trailer.go):
+//  - trailerSize     uint16, size of the trailer
+//  - fields          [...]uint16, types of the trailer fields
+//
+// Sync field value (marks files with sync markers between chunks, see
+// sync.go):
+//  - interval        uint32, number of chunks between markers
+//
+// Chunk binding field value is empty; the field marks files whose chunks
+// all have a digest of the envelope prefix as their additional data (see
+// header.chunkAD), so that no chunk can be spliced onto another header.
+//
+// Key commitment field value (optional, so that older readers can skip it):
+//  - commitment      [keyCommitmentSize]byte, derived from the file key
+//
+// Header MAC field value (written by Seal as the last field):
+//  - mac             [headerMACSize]byte, HMAC-SHA256 of the outer prefix
+//                    and the header with mac zeroed, keyed by a key derived
+//                    from the file key
+//
+// v1 header is a list of typed fields so that new kinds of slots and options
+// can be added without bumping the version again.
+//
+// The additional data of the first chunk is the outer prefix followed by
+// the entire header, unless the header has a MAC field; then it is the outer
+// prefix followed by chunkSize, and the MAC authenticates the header
+// instead. This allows Reseal to replace the slots without touching the
+// chunks. Files with a chunk binding field (all v1 files written by Seal)
+// use the header digest as the additional data of every chunk instead.
+//
+// Envelope header format v2 is the same as v1, but all of its slots must be
+// post-quantum secure. A reader that doesn't understand post-quantum KEMs
+// will refuse the file outright.
 
 const (
-	headerSize   = 8 + IDSize + nonceSizeX + KeySize + overhead
+	headerSize   = 8 + IDSize + encapsulatedSize
 	offVersion   = 0
 	offChunkSize = offVersion + 4
 	offKeyID     = offChunkSize + 4
 	offEncKey    = offKeyID + IDSize
+
+	headerSizeV1Fixed = 12
+	offFieldsSize     = offChunkSize + 4
+	fieldHeaderSize   = 4
+	maxFieldSize      = 0xffff
+	maxSlotDataSize   = maxFieldSize - 2 - IDSize
+
+	encapsulatedSize = nonceSizeX + KeySize + overhead
 )
 
-const chunkHeaderSize = 4
+const (
+	fieldOptional     uint16 = 0x8000
+	fieldKeySlot      uint16 = 1
+	fieldHeaderMAC    uint16 = 2
+	fieldNotAfter     uint16 = 3
+	fieldContext      uint16 = 4
+	fieldNonces       uint16 = 5
+	fieldChunkBinding uint16 = 7
+	fieldFramed       uint16 = 8
+	fieldPadded       uint16 = 9
+	fieldCompression  uint16 = 10
+	fieldDictionary   uint16 = 11
+	fieldTrailer      uint16 = 12
+	fieldSync         uint16 = 13
+
+	fieldKeyCommitment uint16 = fieldOptional | 6
+
+	headerMACSize     = 32
+	keyCommitmentSize = 32
+)
 
+// Chunk format:
+//  - index           uint32 (finalChunkIndex for the last chunk)
+//  - size            uint32, only in framed files (chunkSize for all chunks
+//                    but the final one)
+//  - nonce           [nonceSizeX]byte, only with random nonces
+//  - sealed          [size + overhead]byte
+//
+// With counter nonces, the nonce of a chunk is its index (see fillNonce).
+// With random nonces, the chunk's index and whether it is final are
+// appended to its additional data instead (see appendChunkAD), so chunks
+// still cannot be reordered, dropped or truncated.
+
+const (
+	chunkHeaderSize    = 4
+	chunkSizeFieldSize = 4
+)
+
+// finalChunkIndex marks the final chunk, so other chunks have indices below
+// it, which limits a stream to 2^32 chunks: 128 TiB of compressed data with
+// the default chunk size, or 4 PiB with MaxChunkSize. Writer fails with
+// ErrStreamTooLarge instead of exceeding the limit.
 const finalChunkIndex uint32 = 0xffff_ffff
 
+func appendChunkAD(ad []byte, i uint32, isFinal bool) []byte {
+	ad = binary.LittleEndian.AppendUint32(ad, i)
+	if isFinal {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
 func fillNonce(nonce *[nonceSizeS]byte, i uint32, isFinal bool) {
 	binary.LittleEndian.PutUint32(nonce[:4], i)
 	if isFinal {
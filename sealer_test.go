@@ -3,6 +3,8 @@ package sealer_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"slices"
@@ -72,7 +74,7 @@ func run(t *testing.T, chunkSize, multiple, remainder, writeSize int) {
 
 	input := slices.Clone(original)
 	var sealedBuf bytes.Buffer
-	w, err := sealer.Seal(&sealedBuf, key, originalPrefix[:], sealer.SealOptions{ChunkSize: chunkSize})
+	w, err := sealer.Seal(&sealedBuf, []*sealer.Key{key}, originalPrefix[:], sealer.SealOptions{ChunkSize: chunkSize})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,3 +132,539 @@ func generateKey() *sealer.Key {
 	}
 	return key
 }
+
+func TestSealer_multipleKeys(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B"), generateKeyWithID("C")}
+	original := []byte("hello, world")
+
+	sealed := seal(t, keys, nil, sealer.SealOptions{}, original)
+
+	for _, key := range keys {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(opn.KeyIDs) != len(keys) {
+			t.Fatalf("got %d key IDs, wanted %d", len(opn.KeyIDs), len(keys))
+		}
+		for i, k := range keys {
+			if opn.KeyIDs[i] != k.ID {
+				t.Errorf("KeyIDs[%d] = %q, wanted %q", i, opn.KeyIDs[i][:], k.ID[:])
+			}
+		}
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.Open(generateKeyWithID("D"))
+	if err != sealer.ErrNoMatchingKey {
+		t.Fatalf("Open with a foreign key: got %v, wanted %v", err, sealer.ErrNoMatchingKey)
+	}
+}
+
+func seal(t testing.TB, keys []*sealer.Key, prefix []byte, opt sealer.SealOptions, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, keys, prefix, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func generateKeyWithID(id string) *sealer.Key {
+	key := generateKey()
+	key.ID = [sealer.IDSize]byte{}
+	copy(key.ID[:], id)
+	return key
+}
+
+func TestDeriveKeyID(t *testing.T) {
+	// must never change, IDs are stored in files and keystores
+	id := sealer.DeriveKeyID(bytes.Repeat([]byte{0x42}, sealer.KeySize))
+	if a, e := hex.EncodeToString(id[:]), "71cbfde368b4308b90f3d792c1cdb34422f1af6f9eb3b5230263c4ce4ca44950"; a != e {
+		t.Errorf("DeriveKeyID = %s, wanted %s", a, e)
+	}
+}
+
+func TestPrepareWithOptions_maxChunkSize(t *testing.T) {
+	key := generateKey()
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ChunkSize: 64 * 1024}, []byte("hello"))
+
+	_, err := sealer.PrepareWithOptions(bytes.NewReader(sealed), nil, sealer.OpenOptions{MaxChunkSize: 32 * 1024})
+	if err != sealer.ErrChunkSizeTooLarge {
+		t.Errorf("err = %v, wanted ErrChunkSizeTooLarge", err)
+	}
+	opn, err := sealer.PrepareWithOptions(bytes.NewReader(sealed), nil, sealer.OpenOptions{MaxChunkSize: 64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opn.MaxChunkSize != 64*1024 {
+		t.Errorf("OpenOptions not retained")
+	}
+}
+
+func TestOpenable_header(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	opt := sealer.SealOptions{
+		ChunkSize:    1024,
+		Compression:  sealer.CompressionZstdChunks,
+		RandomNonces: true,
+		Magic:        true,
+		MerkleRoot:   true,
+	}
+	sealed := seal(t, keys, nil, opt, []byte("hello"))
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := opn.Header()
+	if h.Version != 1 || h.ChunkSize != 1024 || h.KeyID != keys[0].ID || len(h.KeyIDs) != 2 || h.KeyIDs[1] != keys[1].ID {
+		t.Errorf("Header() = %+v", h)
+	}
+	if h.Cipher != "XChaCha20-Poly1305" || h.Compression != sealer.CompressionZstdChunks || h.Compression.String() != "zstd-chunks" {
+		t.Errorf("Cipher = %q, Compression = %v", h.Cipher, h.Compression)
+	}
+	if !h.Magic || !h.Framed || !h.HeaderMAC || !h.MerkleRoot || h.PlaintextDigest || h.RecordSize || h.Padded || h.Context || !h.NotAfter.IsZero() {
+		t.Errorf("Header() = %+v", h)
+	}
+
+	sealed = seal(t, keys[:1], nil, sealer.SealOptions{}, []byte("hello"))
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = opn.Header()
+	if h.Version != 0 || h.Cipher != "ChaCha20-Poly1305" || h.Compression != sealer.CompressionZstd || h.Framed || h.HeaderMAC || h.KeyCommitment {
+		t.Errorf("v0 Header() = %+v", h)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	for _, k := range []int{1, 2} {
+		sealed := seal(t, keys[:k], nil, sealer.SealOptions{}, []byte("hello"))
+		h, rest, err := sealer.ParseHeader(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(h.KeyIDs, [][sealer.IDSize]byte{keys[0].ID, keys[1].ID}[:k]) {
+			t.Errorf("KeyIDs = %x", h.KeyIDs)
+		}
+
+		// rest starts where Prepare stops reading
+		in := bytes.NewReader(sealed)
+		if _, err := sealer.Prepare(in, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != in.Len() {
+			t.Errorf("%d keys: len(rest) = %d, wanted %d", k, len(rest), in.Len())
+		}
+
+		_, _, err = sealer.ParseHeader(sealed[:len(sealed)-len(rest)-1])
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%d keys: truncated err = %v, wanted io.ErrUnexpectedEOF", k, err)
+		}
+	}
+}
+
+func TestPrepareAt(t *testing.T) {
+	key := generateKeyWithID("A")
+	prefix := []byte("prefix")
+	original := bytes.Repeat([]byte("hello, world "), 1000)
+	opt := sealer.SealOptions{ChunkSize: 256, Compression: sealer.CompressionNone, RecordSize: true}
+	sealed := seal(t, []*sealer.Key{key}, prefix, opt, original)
+	data := append([]byte("junk"), sealed...)
+
+	opn, err := sealer.PrepareAt(bytes.NewReader(data), 4, len(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opn.KeyID != key.ID {
+		t.Errorf("KeyID = %x, wanted %x", opn.KeyID, key.ID)
+	}
+
+	// every Open reads the file anew, even concurrently
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			r, err := opn.Open(key)
+			if err != nil {
+				errs <- err
+				return
+			}
+			size, err := r.Size()
+			if err != nil || size.Plaintext != int64(len(original)) {
+				errs <- fmt.Errorf("Size = %+v, %v", size, err)
+				return
+			}
+			actual, err := io.ReadAll(r)
+			if err == nil && !bytes.Equal(actual, original) {
+				err = fmt.Errorf("got %d bytes, wanted %d", len(actual), len(original))
+			}
+			errs <- err
+		}()
+	}
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	_, err = sealer.PrepareAt(bytes.NewReader(data[:8]), 4, len(prefix))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, wanted io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestSeal_invalidOptions(t *testing.T) {
+	key := generateKey()
+	for _, tc := range []struct {
+		opt sealer.SealOptions
+		err error
+	}{
+		{sealer.SealOptions{ChunkSize: -1}, sealer.ErrInvalidChunkSize},
+		{sealer.SealOptions{ChunkSize: 1, Compression: sealer.CompressionZstdChunks}, sealer.ErrInvalidChunkSize},
+		{sealer.SealOptions{ChunkSize: sealer.MaxChunkSize + 1}, sealer.ErrChunkSizeTooLarge},
+		{sealer.SealOptions{ZstdLevel: -1}, sealer.ErrInvalidLevel},
+		{sealer.SealOptions{ZstdLevel: 23}, sealer.ErrInvalidLevel},
+		{sealer.SealOptions{Compression: sealer.CompressionBrotli, BrotliLevel: 12}, sealer.ErrInvalidLevel},
+		{sealer.SealOptions{Compression: 42}, sealer.ErrInvalidOptions},
+		{sealer.SealOptions{Padding: 42}, sealer.ErrInvalidOptions},
+		{sealer.SealOptions{ZstdWindowSize: 3000}, sealer.ErrInvalidOptions},
+		{sealer.SealOptions{ZstdConcurrency: -1}, sealer.ErrInvalidOptions},
+		{sealer.SealOptions{ZstdLiterals: 42}, sealer.ErrInvalidOptions},
+		{sealer.SealOptions{Compression: sealer.CompressionZstdChunks, Padding: sealer.PaddingPadme}, sealer.ErrInvalidOptions},
+	} {
+		var buf bytes.Buffer
+		_, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, tc.opt)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%+v: err = %v, wanted %v", tc.opt, err, tc.err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: wrote %d bytes", tc.opt, buf.Len())
+		}
+	}
+
+	if _, err := sealer.Seal(nil, []*sealer.Key{key}, nil, sealer.SealOptions{}); err != sealer.ErrNoOutput {
+		t.Errorf("nil output: err = %v, wanted ErrNoOutput", err)
+	}
+
+	for _, level := range []int{1, 3, 19, 22} {
+		original := bytes.Repeat([]byte("hello, world "), 100)
+		actual, err := openSealed(seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{ZstdLevel: level}, original), nil, key)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("level %d: got %q", level, actual)
+		}
+	}
+}
+
+func TestOpen_errorKinds(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 200000)
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, ChunkSize: 1024, SyncInterval: 2},
+		{Compression: sealer.CompressionZstdChunks, ChunkSize: 1024, RandomNonces: true, MerkleRoot: true},
+	} {
+		sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+		readAll := func(sealed []byte, key *sealer.Key) error {
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				return err
+			}
+			r, err := opn.Open(key)
+			if err != nil {
+				return err
+			}
+			_, err = io.ReadAll(r)
+			return err
+		}
+
+		// the same ID with another key, which v0 files don't even check
+		if err := readAll(sealed, generateKeyWithID("key")); !errors.Is(err, sealer.ErrWrongKey) {
+			t.Errorf("%+v: wrong key err = %v, wanted ErrWrongKey", opt, err)
+		}
+
+		damaged := bytes.Clone(sealed)
+		damaged[len(damaged)/2] ^= 1
+		err := readAll(damaged, key)
+		if !errors.Is(err, sealer.ErrCorrupted) {
+			t.Errorf("%+v: damaged err = %v, wanted ErrCorrupted", opt, err)
+		}
+		if ce := (*sealer.ChunkError)(nil); !errors.As(err, &ce) || ce.Plaintext < 0 {
+			t.Errorf("%+v: damaged err = %v, wanted a ChunkError", opt, err)
+		}
+
+		if opt.ChunkSize != 0 {
+			// v0 files have no framing to tell a cut from damage
+			err := readAll(sealed[:len(sealed)/2], key)
+			if !errors.Is(err, sealer.ErrTruncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("%+v: truncated err = %v, wanted ErrTruncated", opt, err)
+			}
+		}
+	}
+}
+
+func TestChunkError(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 10000)
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 1024}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkLen := opn.ChunkOverhead() + 1024
+
+	damaged := bytes.Clone(sealed)
+	damaged[opn.HeaderSize()+3*chunkLen+100] ^= 1
+	for _, concurrency := range []int{0, 4} {
+		opn, err := sealer.Prepare(bytes.NewReader(damaged), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opn.Concurrency = concurrency
+		r, err := opn.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(r)
+		var ce *sealer.ChunkError
+		if !errors.As(err, &ce) || !errors.Is(err, sealer.ErrCorrupted) {
+			t.Fatalf("err = %v, wanted a ChunkError", err)
+		}
+		if ce.Chunk != 3 || ce.Offset != int64(3*chunkLen) || ce.Plaintext != 3*1024 {
+			t.Errorf("concurrency %d: %+v", concurrency, ce)
+		}
+	}
+
+	// the writer reports the first chunk that failed to be written out
+	w, err := sealer.Seal(&failingWriter{n: opn.HeaderSize() + 2*chunkLen}, []*sealer.Key{key}, nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; err == nil && i < len(original); i += 1024 {
+		_, err = w.Write(original[i:min(i+1024, len(original))])
+	}
+	var ce *sealer.ChunkError
+	if !errors.As(err, &ce) || !errors.Is(err, errDiskFull) {
+		t.Fatalf("err = %v, wanted a ChunkError", err)
+	}
+	if ce.Chunk != 2 || ce.Offset != int64(2*chunkLen) || ce.Plaintext != 3*1024 {
+		t.Errorf("Writer: %+v", ce)
+	}
+}
+
+// flakyWriter fails the write of the n-th buffer once.
+type flakyWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.n--
+	if w.n == 0 {
+		return 0, errDiskFull
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWriter_errorLatching(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 10000)
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstd, Pipeline: true},
+		{Compression: sealer.CompressionZstdChunks, Concurrency: 4},
+	} {
+		opt.ChunkSize = 1024
+		out := &flakyWriter{n: 3}
+		w, err := sealer.Seal(out, []*sealer.Key{key}, nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var failed error
+		for i := 0; i < len(original); i += 100 {
+			_, err := w.Write(original[i : i+100])
+			if failed == nil {
+				failed = err
+			} else if err != failed {
+				t.Fatalf("%v: Write after %v: err = %v", opt.Compression, failed, err)
+			}
+		}
+		if err := w.Close(); err == nil || failed != nil && err != failed {
+			t.Errorf("%v: Close after %v: err = %v", opt.Compression, failed, err)
+		} else if !errors.Is(err, errDiskFull) {
+			t.Errorf("%v: Close: err = %v, wanted the write error", opt.Compression, err)
+		}
+		if _, err := w.Write([]byte("more")); err != sealer.ErrWriterClosed {
+			t.Errorf("%v: Write after Close: err = %v, wanted ErrWriterClosed", opt.Compression, err)
+		}
+
+		// the chunks written after the failure don't make a valid file
+		opn, err := sealer.Prepare(bytes.NewReader(out.Bytes()), nil)
+		if err == nil {
+			var r *sealer.Reader
+			if r, err = opn.Open(key); err == nil {
+				_, err = io.ReadAll(r)
+			}
+		}
+		if err == nil {
+			t.Errorf("%v: file with a failed write opened", opt.Compression)
+		}
+	}
+}
+
+func TestWriter_abort(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone, ChunkSize: 4096},
+		{Compression: sealer.CompressionZstd, ChunkSize: 4096, Pipeline: true},
+		{Compression: sealer.CompressionZstdChunks, ChunkSize: 4096, Concurrency: 4},
+	} {
+		var buf bytes.Buffer
+		w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != sealer.ErrWriterClosed {
+			t.Errorf("%+v: Write after Abort: err = %v, wanted ErrWriterClosed", opt, err)
+		}
+		if err := w.Close(); err != sealer.ErrWriterClosed {
+			t.Errorf("%+v: Close after Abort: err = %v, wanted ErrWriterClosed", opt, err)
+		}
+
+		// what has been written, if anything, is not a complete file
+		opn, err := sealer.Prepare(bytes.NewReader(buf.Bytes()), nil)
+		if err == nil {
+			var r *sealer.Reader
+			if r, err = opn.Open(key); err == nil {
+				_, err = io.ReadAll(r)
+			}
+		}
+		if buf.Len() == 0 && err == nil || buf.Len() > 0 && !errors.Is(err, sealer.ErrTruncated) {
+			t.Errorf("%+v: reading %d bytes of an aborted file: err = %v, wanted ErrTruncated", opt, buf.Len(), err)
+		}
+
+		// the Writer can be reused
+		buf.Reset()
+		if err := w.Reset(&buf, []*sealer.Key{key}, nil, opt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Errorf("Abort after Close: %v", err)
+		}
+		actual, err := io.ReadAll(openReader(t, buf.Bytes(), key))
+		if err != nil || !bytes.Equal(actual, original) {
+			t.Errorf("%+v: after Reset, got %d bytes: %v", opt, len(actual), err)
+		}
+	}
+}
+
+func TestWriter_closeWithError(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+
+	// closing twice returns the same result
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, sealer.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(original); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.CloseWithError(errCanceled); err != nil {
+		t.Errorf("CloseWithError after Close: err = %v", err)
+	}
+	actual, err := io.ReadAll(openReader(t, buf.Bytes(), key))
+	if err != nil || !bytes.Equal(actual, original) {
+		t.Errorf("got %d bytes: %v", len(actual), err)
+	}
+
+	// a Writer closed by another goroutine fails the one writing to it
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstd, Pipeline: true},
+		{Compression: sealer.CompressionZstdChunks, Concurrency: 4},
+	} {
+		opt.ChunkSize = 1024
+		var buf bytes.Buffer
+		if err := w.Reset(&buf, []*sealer.Key{key}, nil, opt); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			for i := 0; ; i = (i + 100) % len(original) {
+				if _, err := w.Write(original[i : i+100]); err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+		if err := w.CloseWithError(errCanceled); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != sealer.ErrWriterClosed {
+			t.Errorf("%v: Write after CloseWithError: err = %v, wanted ErrWriterClosed", opt.Compression, err)
+		}
+		for range 2 {
+			if err := w.Close(); err != errCanceled {
+				t.Errorf("%v: Close after CloseWithError: err = %v, wanted errCanceled", opt.Compression, err)
+			}
+		}
+		if err := w.CloseWithError(errDiskFull); err != nil {
+			t.Errorf("%v: CloseWithError again: err = %v", opt.Compression, err)
+		}
+		if err := w.Close(); err != errCanceled {
+			t.Errorf("%v: error replaced with %v", opt.Compression, err)
+		}
+	}
+}
+
+var errCanceled = errors.New("canceled")
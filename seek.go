@@ -0,0 +1,361 @@
+package sealer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotSeekable is returned by Reader.Seek for files sealed without
+// SealOptions.SeekIndex, or read from an input that can't seek.
+var ErrNotSeekable = errors.New("sealed file is not seekable")
+
+// Files sealed with SealOptions.SeekIndex record in their trailer an index
+// of the offsets of some of the chunks, so that readers can jump close to
+// any position, and hop over the chunk headers from there. The index has
+// a fixed number of entries, which depends on the chunk size, and holds
+// every stride-th chunk, the stride doubling whenever the index fills up.
+//
+// Since compressed chunks can be short, the position of the trailer chunk
+// doesn't follow from the size of the file, so in these files the trailer
+// chunk is encrypted as chunk finalChunkIndex wherever it is, and the index
+// records the number of chunks instead to detect truncation.
+//
+// Index field value:
+//  - plaintextSize   uint64
+//  - chunks          uint32, number of chunks, trailer chunk included
+//  - stride          uint32
+//  - count           uint32, number of entries in use
+//  - entries         [capacity]entry, the unused ones zeroed
+//
+// Entry (of chunk i*stride for the i-th entry):
+//  - plaintext       uint64, offset of the chunk's data in the plaintext
+//  - ciphertext      uint64, offset of the chunk from the first chunk
+
+const (
+	seekIndexHeaderLen = 8 + 4 + 4 + 4
+	seekEntryLen       = 8 + 8
+
+	// maxSeekIndexEntries is the largest number of entries in an index.
+	maxSeekIndexEntries = 1024
+)
+
+// seekIndexCapacity returns the number of entries in the index of a file
+// with the given chunk size, which keeps the trailer within a quarter of a
+// chunk.
+func seekIndexCapacity(chunkSize int) int {
+	return min(max(1, chunkSize/64), maxSeekIndexEntries)
+}
+
+type seekEntry struct {
+	plaintext  int64
+	ciphertext int64
+}
+
+type seekIndex struct {
+	plaintext int64
+	chunks    int
+	stride    uint32
+	entries   []seekEntry
+	capacity  int
+
+	// sidecar is set for sidecar indexes (see Index), whose entries cover
+	// the final chunk too, and which have no trailer to account for.
+	sidecar bool
+}
+
+func (x *seekIndex) append(buf []byte) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(x.plaintext))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(x.chunks))
+	buf = binary.LittleEndian.AppendUint32(buf, x.stride)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(x.entries)))
+	for _, e := range x.entries {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.plaintext))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.ciphertext))
+	}
+	return append(buf, make([]byte, (x.capacity-len(x.entries))*seekEntryLen)...)
+}
+
+func (x *seekIndex) parse(value []byte) error {
+	if len(value) < seekIndexHeaderLen || (len(value)-seekIndexHeaderLen)%seekEntryLen != 0 {
+		return errBadTrailer
+	}
+	x.plaintext = int64(binary.LittleEndian.Uint64(value))
+	x.chunks = int(binary.LittleEndian.Uint32(value[8:]))
+	x.stride = binary.LittleEndian.Uint32(value[12:])
+	count := int(binary.LittleEndian.Uint32(value[16:]))
+	x.capacity = (len(value) - seekIndexHeaderLen) / seekEntryLen
+	// a trailer's index has no entry for the trailer chunk
+	if x.plaintext < 0 || x.chunks < 1 || x.stride == 0 || count > x.capacity || !x.sidecar && (count == 0) != (x.chunks == 1) {
+		return errBadTrailer
+	}
+	value = value[seekIndexHeaderLen:]
+	x.entries = make([]seekEntry, count)
+	for i := range x.entries {
+		e := &x.entries[i]
+		e.plaintext = int64(binary.LittleEndian.Uint64(value))
+		e.ciphertext = int64(binary.LittleEndian.Uint64(value[8:]))
+		if e.plaintext < 0 || e.ciphertext < 0 {
+			return errBadTrailer
+		}
+		value = value[seekEntryLen:]
+	}
+	return nil
+}
+
+// seekIndexBuilder collects the index entries of the chunks being written.
+type seekIndexBuilder struct {
+	seekIndex
+
+	// perChunk is the number of plaintext bytes held by a chunk.
+	perChunk int64
+}
+
+func newSeekIndexBuilder(hdr *header) *seekIndexBuilder {
+	b := &seekIndexBuilder{perChunk: int64(hdr.chunkSize)}
+	b.stride = 1
+	b.capacity = seekIndexCapacity(hdr.chunkSize)
+	if hdr.compression == CompressionZstdChunks {
+		b.perChunk--
+	}
+	return b
+}
+
+// add is called for every data chunk with its offset from the first chunk.
+func (b *seekIndexBuilder) add(chunk uint32, off int64) {
+	if chunk%b.stride != 0 {
+		return
+	}
+	if len(b.entries) == b.capacity {
+		// keep every other entry, doubling the stride
+		n := 0
+		for i := 0; i < len(b.entries); i += 2 {
+			b.entries[n] = b.entries[i]
+			n++
+		}
+		b.entries = b.entries[:n]
+		b.stride *= 2
+		if chunk%b.stride != 0 {
+			return
+		}
+	}
+	b.entries = append(b.entries, seekEntry{plaintext: int64(chunk) * b.perChunk, ciphertext: off})
+}
+
+// chunkOffset returns the offset of the given data chunk from the first
+// chunk. Chunks of variable size are found by hopping over the chunks that
+// follow the closest entry, reading their headers with readAt, which takes
+// offsets from the first chunk.
+func (x *seekIndex) chunkOffset(dec *decryptor, chunk int64, readAt func(p []byte, off int64) error) (int64, error) {
+	hdrLen := int64(chunkHeaderSize + chunkSizeFieldSize + dec.nonceSize)
+	if !dec.variableChunks {
+		return chunk * (hdrLen + int64(dec.chunkSize) + overhead), nil
+	}
+	i := min(chunk/int64(x.stride), int64(len(x.entries)-1))
+	off := x.entries[i].ciphertext
+	var hdr [chunkHeaderSize + chunkSizeFieldSize]byte
+	for c := i * int64(x.stride); c < chunk; c++ {
+		err := readAt(hdr[:], off)
+		if err != nil {
+			return 0, fmt.Errorf("seeking to chunk %d: %w", chunk, err)
+		}
+		off += hdrLen + int64(binary.LittleEndian.Uint32(hdr[chunkHeaderSize:])) + overhead
+	}
+	return off, nil
+}
+
+// Seek implements io.Seeker for files sealed with SealOptions.SeekIndex, or
+// given a sidecar index (see Openable.SetIndex), and read from an
+// io.ReadSeeker that ends with the file, and returns ErrNotSeekable
+// otherwise. It reads the trailer to find the chunk holding the new
+// position, decrypts it, and discards the data before the position within
+// it.
+//
+// Once the Reader has seeked, the chunks it returns are still
+// authenticated, but the plaintext as a whole isn't checked against the
+// trailer (see SealOptions.PlaintextDigest) at the end.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	in, x, err := r.seekIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.total + offset
+	case io.SeekEnd:
+		pos = x.plaintext + offset
+	default:
+		return 0, errors.New("sealer: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("sealer: negative position")
+	}
+	err = r.seek(in, x, pos)
+	if err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+// seekIndex returns the input and the seek index of the file, from the
+// sidecar index or the trailer, or ErrNotSeekable.
+func (r *Reader) seekIndex() (io.ReadSeeker, *seekIndex, error) {
+	in, ok := r.dec.in.(io.ReadSeeker)
+	if !ok || r.dec.salvage != nil {
+		return nil, nil, ErrNotSeekable
+	}
+	if r.index != nil {
+		if r.bodyOff < 0 {
+			return nil, nil, ErrNotSeekable
+		}
+		return in, r.index, nil
+	}
+	if !r.dec.detached {
+		return nil, nil, ErrNotSeekable
+	}
+	t, err := r.peekTrailer()
+	if err == errTrailerUnavailable {
+		return nil, nil, ErrNotSeekable
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if t.index == nil {
+		return nil, nil, errBadTrailer
+	}
+	return in, t.index, nil
+}
+
+// Skip advances the Reader by n bytes of plaintext without returning them,
+// and returns the number of bytes skipped, which is less than n only at the
+// end of the file, with io.EOF. If the Reader can seek (see Seek), the
+// chunks before the new position are neither read nor decrypted, with the
+// same loss of verification as Seek; otherwise, they are read, verified and
+// discarded.
+func (r *Reader) Skip(n int64) (int64, error) {
+	if n < 0 {
+		return 0, errors.New("sealer: negative skip")
+	}
+	in, x, err := r.seekIndex()
+	if err != nil && err != ErrNotSeekable {
+		return 0, err
+	}
+	if err == nil {
+		perChunk := int64(r.dec.chunkSize)
+		if r.dec.variableChunks {
+			perChunk--
+		}
+		start := r.total
+		pos := min(start+n, x.plaintext)
+		// the rest of the current chunk is cheaper to discard
+		if pos/perChunk >= int64(r.dec.chunkIndex) {
+			err = r.seek(in, x, pos)
+			if err != nil {
+				return 0, err
+			}
+			if pos-start < n {
+				return pos - start, io.EOF
+			}
+			return n, nil
+		}
+	}
+	return io.CopyN(io.Discard, r, n)
+}
+
+// seek positions the reader at pos given the index of the file.
+func (r *Reader) seek(in io.ReadSeeker, x *seekIndex, pos int64) error {
+	dec := &r.dec
+	hdrLen := int64(chunkHeaderSize + chunkSizeFieldSize + dec.nonceSize)
+	perChunk := int64(dec.chunkSize)
+	if dec.variableChunks {
+		perChunk--
+	}
+
+	// positions past the end are read as the end, which is in the chunk
+	// holding the padding if any, or right before the trailer chunk
+	chunk, within := min(pos, x.plaintext)/perChunk, min(pos, x.plaintext)%perChunk
+	last := int64(x.chunks - 1)
+	if x.sidecar && chunk == last+1 && within == 0 {
+		// the end of a full final chunk of a file without a trailer
+		chunk, within = last, perChunk
+	}
+	var off int64
+	if chunk > last || chunk == last && within != 0 && !x.sidecar {
+		return errBadTrailer
+	} else if chunk == last && !x.sidecar {
+		end, err := in.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		off = end - r.bodyOff - (hdrLen + int64(dec.trailerSize) + overhead)
+	} else {
+		var err error
+		off, err = x.chunkOffset(dec, chunk, func(p []byte, off int64) error {
+			_, err := in.Seek(r.bodyOff+off, io.SeekStart)
+			if err == nil {
+				_, err = io.ReadFull(in, p)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return r.seekChunk(in, chunk, off, within, pos)
+}
+
+// seekChunk moves the Reader to within bytes into the plaintext of the
+// chunk with the given index and offset from the first chunk, which is
+// pos bytes into the plaintext.
+func (r *Reader) seekChunk(in io.ReadSeeker, chunk, off, within, pos int64) error {
+	dec := &r.dec
+	if off < 0 {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := in.Seek(r.bodyOff+off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// the plaintext as a whole can no longer be verified
+	r.hash = nil
+	dec.merkle = nil
+	r.seeked = true
+	r.finished, r.finishErr = false, nil
+
+	dec.chunkIndex = uint32(chunk)
+	dec.pos = off
+	if dec.par != nil {
+		dec.par.reset()
+	}
+	dec.eof, dec.short = false, false
+	dec.buf, dec.trailer = nil, nil
+	dec.held, dec.heldZeros, dec.pendMarker, dec.pendZeros, dec.out = false, 0, false, 0, nil
+	var ad []byte
+	if chunk == 0 {
+		ad = r.firstAD
+	}
+	err = dec.read(ad)
+	if err != nil {
+		return withPlaintext(err, pos)
+	}
+	if cr, ok := r.decompr.(*chunkReader); ok {
+		cr.out, cr.next = nil, false
+	}
+
+	if r.remaining >= 0 {
+		r.remaining = max(0, r.remaining+r.total-pos)
+	}
+	r.total = pos
+	if within > 0 {
+		_, err = io.CopyN(io.Discard, r.decompr, within)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return err
+}
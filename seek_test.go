@@ -0,0 +1,261 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestReader_seek(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, Padding: sealer.PaddingPadme, RandomNonces: true},
+		{Compression: sealer.CompressionZstdChunks},
+		{Compression: sealer.CompressionZstdChunks, PlaintextDigest: true, RecordSize: true, MerkleRoot: true},
+	} {
+		opt.SeekIndex = true
+		opt.ChunkSize = 256
+		for _, size := range []int{0, 1, 255, 256, 1000, 50000} {
+			t.Run(fmt.Sprintf("%v/%v/%d", opt.Compression, opt.Padding, size), func(t *testing.T) {
+				// mix compressible and random data, so that chunks vary in size
+				original := randomBytes(t, size)
+				for i := 0; i < size; i += 1000 {
+					clear(original[i:min(size, i+500)])
+				}
+				sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+				r := openReader(t, sealed, key)
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil || end != int64(size) {
+					t.Fatalf("Seek(0, io.SeekEnd) = %d, %v, wanted %d", end, err, size)
+				}
+				for range 20 {
+					pos := rand.IntN(size + 2)
+					n := rand.IntN(600)
+					if _, err := r.Seek(int64(pos), io.SeekStart); err != nil {
+						t.Fatalf("Seek(%d): %v", pos, err)
+					}
+					actual, err := io.ReadAll(io.LimitReader(r, int64(n)))
+					if err != nil {
+						t.Fatalf("reading %d bytes at %d: %v", n, pos, err)
+					}
+					expected := original[min(pos, size):min(pos+n, size)]
+					if !bytes.Equal(actual, expected) {
+						t.Fatalf("at %d: got %d bytes, wanted %d", pos, len(actual), len(expected))
+					}
+				}
+
+				// reading to the end still checks the chunk count
+				if _, err := r.Seek(int64(size/2), io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+					t.Fatal(err)
+				}
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original[min(size, size/2+1):]) {
+					t.Errorf("got %d bytes after seeking to the middle", len(actual))
+				}
+
+				actual, err = io.ReadAll(openReader(t, sealed, key))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) {
+					t.Errorf("got %d bytes without seeking, wanted %d", len(actual), len(original))
+				}
+			})
+		}
+	}
+}
+
+func TestReader_seekTruncated(t *testing.T) {
+	key := generateKeyWithID("key")
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 64, SeekIndex: true}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, randomBytes(t, 20*64+20))
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the trailer chunk doesn't depend on its position, so make sure that
+	// dropping the last data chunk is detected anyway
+	dataEnd := opn.HeaderSize() + 20*(opn.ChunkOverhead()+64)
+	trailerStart := dataEnd + opn.ChunkOverhead() + 20
+	truncated := append(bytes.Clone(sealed[:dataEnd]), sealed[trailerStart:]...)
+	_, err = io.ReadAll(openReader(t, truncated, key))
+	if err == nil {
+		t.Errorf("truncated file read without an error")
+	}
+}
+
+func TestReader_seekUnsupported(t *testing.T) {
+	key := generateKeyWithID("key")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, RecordSize: true}, []byte("hello"))
+	if _, err := openReader(t, sealed, key).Seek(1, io.SeekStart); !errors.Is(err, sealer.ErrNotSeekable) {
+		t.Errorf("err = %v, wanted ErrNotSeekable", err)
+	}
+
+	_, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{SeekIndex: true})
+	if !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("SeekIndex with zstd: err = %v, wanted ErrInvalidOptions", err)
+	}
+}
+
+func TestOpenable_newReaderAt(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone, Padding: sealer.PaddingPadme},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true},
+	} {
+		opt.SeekIndex = true
+		opt.ChunkSize = 256
+		original := randomBytes(t, 50000)
+		for i := 0; i < len(original); i += 1000 {
+			clear(original[i : i+500])
+		}
+		sealed := seal(t, []*sealer.Key{key}, []byte("prefix"), opt, original)
+
+		opn, err := sealer.PrepareAt(bytes.NewReader(sealed), 0, len("prefix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ra, err := opn.NewReaderAt(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ra.Size() != int64(len(original)) {
+			t.Errorf("Size = %d, wanted %d", ra.Size(), len(original))
+		}
+
+		errs := make(chan error, 8)
+		for range 8 {
+			go func() {
+				for range 50 {
+					off := rand.IntN(len(original) + 10)
+					buf := make([]byte, rand.IntN(2000))
+					n, err := ra.ReadAt(buf, int64(off))
+					expected := original[min(off, len(original)):min(off+len(buf), len(original))]
+					if n < len(buf) && err != io.EOF || n == len(buf) && err != nil {
+						errs <- fmt.Errorf("ReadAt(%d bytes at %d) = %d, %v", len(buf), off, n, err)
+						return
+					}
+					if !bytes.Equal(buf[:n], expected) {
+						errs <- fmt.Errorf("ReadAt(%d bytes at %d): got %d bytes, wanted %d", len(buf), off, n, len(expected))
+						return
+					}
+				}
+				errs <- nil
+			}()
+		}
+		for range 8 {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// the Openable has to come from PrepareAt
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, SeekIndex: true}, []byte("hello"))
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.NewReaderAt(key); !errors.Is(err, sealer.ErrNotSeekable) {
+		t.Errorf("err = %v, wanted ErrNotSeekable", err)
+	}
+}
+
+func TestOpenable_openRange(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 10000)
+	opt := sealer.SealOptions{Compression: sealer.CompressionZstdChunks, ChunkSize: 256, SeekIndex: true}
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+	for _, tc := range []struct{ off, length int }{
+		{0, 0}, {0, 10}, {300, 1000}, {9990, 100}, {20000, 10},
+	} {
+		expected := original[min(tc.off, len(original)):min(tc.off+tc.length, len(original))]
+
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opnAt, err := sealer.PrepareAt(bytes.NewReader(sealed), 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range []*sealer.Openable{opn, opnAt} {
+			r, err := o.OpenRange(key, int64(tc.off), int64(tc.length))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("%d bytes at %d: got %d bytes, wanted %d", tc.length, tc.off, len(actual), len(expected))
+			}
+		}
+	}
+
+	sealed = seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{}, original)
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.OpenRange(key, 0, 10); !errors.Is(err, sealer.ErrNotSeekable) {
+		t.Errorf("err = %v, wanted ErrNotSeekable", err)
+	}
+}
+
+func TestReader_skip(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 20000)
+	for i := 0; i < len(original); i += 1000 {
+		clear(original[i : i+500])
+	}
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionZstdChunks, ChunkSize: 256, SeekIndex: true},
+		{Compression: sealer.CompressionZstd, ChunkSize: 256, PlaintextDigest: true},
+	} {
+		t.Run(opt.Compression.String(), func(t *testing.T) {
+			sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+			r := openReader(t, sealed, key)
+			var pos int64
+			for _, n := range []int64{0, 10, 3000, 1, 256, 7000} {
+				skipped, err := r.Skip(n)
+				if err != nil || skipped != n {
+					t.Fatalf("Skip(%d) at %d = %d, %v", n, pos, skipped, err)
+				}
+				pos += n
+				var buf [100]byte
+				if _, err := io.ReadFull(r, buf[:]); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf[:], original[pos:pos+100]) {
+					t.Fatalf("wrong data after skipping to %d", pos)
+				}
+				pos += 100
+			}
+
+			skipped, err := r.Skip(int64(len(original)))
+			if err != io.EOF || skipped != int64(len(original))-pos {
+				t.Errorf("Skip past the end = %d, %v, wanted %d, EOF", skipped, err, int64(len(original))-pos)
+			}
+			if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("Read at the end = %d, %v", n, err)
+			}
+		})
+	}
+}
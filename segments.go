@@ -0,0 +1,153 @@
+package sealer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+)
+
+// Segments returns an iterator over sealed streams written back to back
+// into in (e.g. by calling Seal several times with the same output), each
+// preceded by an outer prefix of prefixSize bytes, which is passed to
+// Prepare. Open the segments you need and ignore the others; once the loop
+// body returns, the iterator skips whatever is left of the segment, without
+// decrypting it. Iteration stops at the end of in, or after yielding an
+// error.
+//
+// A file sealed to a single key (without SealOptions.CommitKey), or by
+// older versions of this package, can only be the last segment, since its
+// chunks don't record their sizes.
+func Segments(in io.Reader, prefixSize int) iter.Seq2[*Openable, error] {
+	return func(yield func(*Openable, error) bool) {
+		for {
+			opn, err := prepareSegment(in, prefixSize)
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(opn, nil) || !opn.hdr.framed {
+				return
+			}
+			err = opn.skip()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// OpenSegments returns a reader of the plaintexts of all sealed streams
+// in in (see Segments) one after another, like a gzip reader does with
+// multistream files. open is called to open each segment, e.g.
+//
+//	func(opn *sealer.Openable) (*sealer.Reader, error) {
+//		return opn.OpenWithKeyring(keyring)
+//	}
+func OpenSegments(in io.Reader, prefixSize int, open func(opn *Openable) (*Reader, error)) io.Reader {
+	return &segmentsReader{in: in, prefixSize: prefixSize, open: open}
+}
+
+type segmentsReader struct {
+	in         io.Reader
+	prefixSize int
+	open       func(opn *Openable) (*Reader, error)
+	opn        *Openable
+	err        error
+}
+
+func (sr *segmentsReader) Read(p []byte) (int, error) {
+	for sr.err == nil {
+		if sr.opn == nil {
+			opn, err := prepareSegment(sr.in, sr.prefixSize)
+			if err != nil {
+				sr.err = err
+				break
+			}
+			_, err = sr.open(opn)
+			if err != nil {
+				sr.err = err
+				break
+			}
+			sr.opn = opn
+		}
+
+		n, err := sr.opn.reader.Read(p)
+		if err == io.EOF {
+			if !sr.opn.hdr.framed {
+				sr.err = io.EOF
+			} else {
+				sr.err = sr.opn.skip()
+				sr.opn = nil
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, sr.err
+}
+
+// prepareSegment reads the outer prefix and the header of the next segment,
+// returning io.EOF if there are no more segments.
+func prepareSegment(in io.Reader, prefixSize int) (*Openable, error) {
+	prefix := make([]byte, prefixSize)
+	_, err := io.ReadFull(in, prefix)
+	if err != nil {
+		return nil, err
+	}
+	opn, err := Prepare(in, prefix)
+	if err == io.EOF && prefixSize > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return opn, err
+}
+
+// skip reads the remaining chunks of a framed file without decrypting them.
+func (opn *Openable) skip() error {
+	if opn.reader != nil && opn.reader.dec.eof {
+		return nil
+	}
+	hdrLen := opn.hdr.chunkHeaderLen()
+	buf := make([]byte, max(hdrLen, SyncMarkerSize))
+	var index uint32
+	if opn.reader != nil {
+		index = opn.reader.dec.chunkIndex
+	}
+	for ; ; index++ {
+		if hasSyncBefore(opn.hdr.syncInterval, index) {
+			_, err := io.ReadFull(opn.in, buf[:SyncMarkerSize])
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err := io.ReadFull(opn.in, buf[:hdrLen])
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		size := int(binary.LittleEndian.Uint32(buf[chunkHeaderSize:]))
+		if size > opn.chunkSize {
+			return fmt.Errorf("%w: chunk of size %d", ErrCorrupted, size)
+		}
+		n, err := io.CopyN(io.Discard, opn.in, int64(size+overhead))
+		if err == io.EOF && n < int64(size+overhead) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf) == finalChunkIndex {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSegments(t *testing.T) {
+	a, b := generateKeyWithID("A"), generateKeyWithID("B")
+	prefix := []byte("P:")
+	plaintexts := [][]byte{
+		bytes.Repeat([]byte("first "), 1000),
+		bytes.Repeat([]byte("second "), 1000),
+		[]byte("third"),
+		bytes.Repeat([]byte("last "), 1000),
+	}
+	var file []byte
+	file = append(file, seal(t, []*sealer.Key{a, b}, prefix, sealer.SealOptions{ChunkSize: 100}, plaintexts[0])...)
+	file = append(file, seal(t, []*sealer.Key{a, b}, prefix, sealer.SealOptions{ChunkSize: 64, RandomNonces: true}, plaintexts[1])...)
+	file = append(file, seal(t, []*sealer.Key{a}, prefix, sealer.SealOptions{CommitKey: true}, plaintexts[2])...)
+	file = append(file, seal(t, []*sealer.Key{a}, prefix, sealer.SealOptions{}, plaintexts[3])...)
+
+	var i int
+	for opn, err := range sealer.Segments(bytes.NewReader(file), len(prefix)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch i {
+		case 0:
+			// read partially
+			r, err := opn.Open(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head := make([]byte, 10)
+			_, err = io.ReadFull(r, head)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case 1:
+			// skip without opening
+		default:
+			r, err := opn.Open(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, plaintexts[i]) {
+				t.Errorf("segment %d: got %q, wanted %q", i, actual, plaintexts[i])
+			}
+		}
+		i++
+	}
+	if i != len(plaintexts) {
+		t.Errorf("got %d segments, wanted %d", i, len(plaintexts))
+	}
+
+	r := sealer.OpenSegments(bytes.NewReader(file), len(prefix), func(opn *sealer.Openable) (*sealer.Reader, error) {
+		return opn.Open(a)
+	})
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := bytes.Join(plaintexts, nil); !bytes.Equal(actual, e) {
+		t.Errorf("OpenSegments read %d bytes, wanted %d", len(actual), len(e))
+	}
+
+	// truncated between segments vs. inside a segment
+	r = sealer.OpenSegments(bytes.NewReader(file[:len(file)-20]), len(prefix), func(opn *sealer.Openable) (*sealer.Reader, error) {
+		return opn.Open(a)
+	})
+	if _, err := io.ReadAll(r); err == nil {
+		t.Errorf("read a truncated file")
+	}
+}
@@ -0,0 +1,239 @@
+package sealer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotEnoughShares is returned by OpenShamir when fewer shares than the
+// threshold could be decapsulated.
+var ErrNotEnoughShares = errors.New("not enough shares to reconstruct the file key")
+
+// Shamir is an Encapsulator that splits the file key into Shamir shares,
+// wrapping each share to a different holder, so that opening the file
+// requires any Threshold of the holders to cooperate. Use it in
+// SealOptions.Encapsulators and open with Openable.OpenShamir.
+//
+// Holders can be any encapsulators: keys, recipients, or KMS wrappers.
+type Shamir struct {
+	Threshold int
+	Holders   []Encapsulator
+}
+
+// Shamir slot data:
+//  - threshold  uint8
+//  - count      uint8
+//  - count times:
+//    - x        uint8 (share index, 1 to 255)
+//    - type     uint16
+//    - keyID    [IDSize]byte
+//    - dataSize uint16
+//    - data     [dataSize]byte
+//
+// Each nested slot is the share encapsulated by the holder.
+
+// Encapsulate implements Encapsulator.
+func (sh *Shamir) Encapsulate(fileKey []byte, random io.Reader) (Slot, error) {
+	n := len(sh.Holders)
+	if sh.Threshold < 1 || sh.Threshold > n || n > 255 {
+		return Slot{}, fmt.Errorf("invalid Shamir threshold %d of %d", sh.Threshold, n)
+	}
+	shares, err := shamirSplit(fileKey, sh.Threshold, n, random)
+	if err != nil {
+		return Slot{}, err
+	}
+	defer func() {
+		for _, share := range shares {
+			clear(share)
+		}
+	}()
+
+	data := []byte{byte(sh.Threshold), byte(n)}
+	for i, holder := range sh.Holders {
+		s, err := holder.Encapsulate(shares[i], random)
+		if err != nil {
+			return Slot{}, err
+		}
+		if len(s.Data) > maxFieldSize {
+			return Slot{}, ErrHeaderTooLarge
+		}
+		data = append(data, byte(i+1))
+		data = binary.LittleEndian.AppendUint16(data, s.Type)
+		data = append(data, s.KeyID[:]...)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(s.Data)))
+		data = append(data, s.Data...)
+	}
+	return Slot{Type: SlotTypeShamir, Data: data}, nil
+}
+
+// PostQuantum implements PostQuantumReporter: a Shamir slot is as strong
+// as its weakest holder.
+func (sh *Shamir) PostQuantum() bool {
+	for _, holder := range sh.Holders {
+		if !isPostQuantum(holder) {
+			return false
+		}
+	}
+	return true
+}
+
+// ShamirShares returns the threshold and the nested per-holder slots of
+// a Shamir slot, e.g. to find out whose cooperation is needed. The Type of
+// each share is the holder's slot type.
+func ShamirShares(s Slot) (threshold int, shares []Slot, ok bool) {
+	threshold, shares, _, ok = parseShamir(&s)
+	return threshold, shares, ok
+}
+
+func parseShamir(s *Slot) (threshold int, shares []Slot, xs []byte, ok bool) {
+	data := s.Data
+	if s.Type != SlotTypeShamir || len(data) < 2 {
+		return 0, nil, nil, false
+	}
+	threshold, n := int(data[0]), int(data[1])
+	data = data[2:]
+	for range n {
+		if len(data) < 1+2+IDSize+2 {
+			return 0, nil, nil, false
+		}
+		x := data[0]
+		share := Slot{Type: binary.LittleEndian.Uint16(data[1:])}
+		copy(share.KeyID[:], data[3:3+IDSize])
+		size := int(binary.LittleEndian.Uint16(data[3+IDSize:]))
+		data = data[3+IDSize+2:]
+		if len(data) < size || x == 0 || bytes.IndexByte(xs, x) >= 0 {
+			return 0, nil, nil, false
+		}
+		share.Data = data[:size:size]
+		data = data[size:]
+		shares = append(shares, share)
+		xs = append(xs, x)
+	}
+	if len(data) != 0 || threshold < 1 || threshold > n {
+		return 0, nil, nil, false
+	}
+	return threshold, shares, xs, true
+}
+
+// OpenShamir opens the file using a Shamir slot, decapsulating shares with
+// the given decapsulators until enough of them are found. It returns
+// ErrNotEnoughShares if the quorum cannot be reached.
+func (opn *Openable) OpenShamir(decs ...Decapsulator) (*Reader, error) {
+	err := ErrNoMatchingKey
+	for i := range opn.hdr.slots {
+		s := &opn.hdr.slots[i]
+		if s.Type != SlotTypeShamir {
+			continue
+		}
+		threshold, shares, xs, ok := parseShamir(s)
+		if !ok {
+			err = ErrUnsupportedVersion
+			continue
+		}
+
+		var gotXs []byte
+		var gotShares [][]byte
+		err = ErrNotEnoughShares
+	shares:
+		for j, share := range shares {
+			for _, dec := range decs {
+				value, derr := dec.Decapsulate(share)
+				if derr == ErrNoMatchingKey {
+					continue
+				} else if derr != nil {
+					err = derr
+					continue
+				}
+				if len(value) != KeySize {
+					return nil, fmt.Errorf("%T returned a %d-byte share", dec, len(value))
+				}
+				gotXs = append(gotXs, xs[j])
+				gotShares = append(gotShares, value)
+				if len(gotShares) == threshold {
+					break shares
+				}
+				break
+			}
+		}
+		if len(gotShares) == threshold {
+			fileKey := shamirCombine(gotXs, gotShares)
+			for _, share := range gotShares {
+				clear(share)
+			}
+			return opn.open(fileKey)
+		}
+	}
+	return nil, err
+}
+
+// Shamir secret sharing over GF(2^8), byte by byte, with the AES reduction
+// polynomial x^8 + x^4 + x^3 + x + 1. Arithmetic is constant-time.
+
+func shamirSplit(secret []byte, k, n int, random io.Reader) ([][]byte, error) {
+	coeffs := make([]byte, len(secret)*(k-1))
+	if _, err := io.ReadFull(random, coeffs); err != nil {
+		return nil, fmt.Errorf("generating Shamir coefficients: %w", err)
+	}
+	defer clear(coeffs)
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		x := byte(i + 1)
+		share := make([]byte, len(secret))
+		for b := range secret {
+			// Horner's method, highest coefficient first
+			var y byte
+			for c := k - 2; c >= 0; c-- {
+				y = gfMul(y, x) ^ coeffs[b*(k-1)+c]
+			}
+			share[b] = gfMul(y, x) ^ secret[b]
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// shamirCombine interpolates the polynomials at x = 0.
+func shamirCombine(xs []byte, shares [][]byte) []byte {
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial at 0: prod xj / (xj - xi); subtraction
+		// is XOR in GF(2^8)
+		num, den := byte(1), byte(1)
+		for j, xj := range xs {
+			if i != j {
+				num = gfMul(num, xj)
+				den = gfMul(den, xj^xi)
+			}
+		}
+		l := gfMul(num, gfInv(den))
+		for b := range secret {
+			secret[b] ^= gfMul(shares[i][b], l)
+		}
+	}
+	return secret
+}
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		b >>= 1
+		// multiply a by x, reducing modulo the polynomial
+		a = a<<1 ^ 0x1b&-(a>>7)
+	}
+	return p
+}
+
+// gfInv returns a^254 = a^-1.
+func gfInv(a byte) byte {
+	r := a
+	for range 6 {
+		a = gfMul(a, a)
+		r = gfMul(r, a)
+	}
+	return gfMul(r, r)
+}
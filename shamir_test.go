@@ -0,0 +1,88 @@
+package sealer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestShamir(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("a"), generateKeyWithID("b"), generateKeyWithID("c")}
+	id, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := []byte("hello, world")
+	sealed := seal(t, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{&sealer.Shamir{
+			Threshold: 3,
+			Holders:   []sealer.Encapsulator{keys[0], keys[1], keys[2], id.Recipient()},
+		}},
+	}, original)
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threshold, shares, ok := sealer.ShamirShares(opn.Slots[0])
+	if !ok || threshold != 3 || len(shares) != 4 || shares[1].KeyID != keys[1].ID || shares[3].Type != sealer.SlotTypeHPKE {
+		t.Fatalf("ShamirShares = %d, %v, %v", threshold, shares, ok)
+	}
+
+	for _, tc := range []struct {
+		decs []sealer.Decapsulator
+		ok   bool
+	}{
+		{[]sealer.Decapsulator{keys[0], keys[1], keys[2]}, true},
+		{[]sealer.Decapsulator{id, keys[2], keys[0]}, true},
+		{[]sealer.Decapsulator{keys[0], keys[1], id, keys[2]}, true},
+		{[]sealer.Decapsulator{keys[0], id}, false},
+		{[]sealer.Decapsulator{keys[0], keys[0], keys[0]}, false},
+	} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.OpenShamir(tc.decs...)
+		if !tc.ok {
+			if err != sealer.ErrNotEnoughShares {
+				t.Errorf("OpenShamir(%d decs) = %v, wanted ErrNotEnoughShares", len(tc.decs), err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Errorf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	// a single share cannot open the file directly
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opn.OpenWith(keys[0]); err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenWith = %v, wanted ErrNoMatchingKey", err)
+	}
+}
+
+func TestShamir_invalidThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := sealer.Seal(&buf, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{&sealer.Shamir{
+			Threshold: 3,
+			Holders:   []sealer.Encapsulator{generateKey(), generateKey()},
+		}},
+	})
+	if err == nil {
+		t.Errorf("Seal succeeded with threshold above the number of holders")
+	}
+}
@@ -0,0 +1,187 @@
+package sealer
+
+import "fmt"
+
+// SlotSizer is implemented by Encapsulators that know the size of the slot
+// data they produce, which lets SealedSizeEstimate size the header without
+// wrapping anything, e.g. without a round trip to a KMS.
+type SlotSizer interface {
+	SlotDataSize() int
+}
+
+// layout is what the size of a sealed file depends on, besides the size of
+// the plaintext.
+type layout struct {
+	headerSize    int
+	chunkSize     int
+	chunkOverhead int
+	compression   Compression
+	padding       Padding
+	trailerSize   int
+	syncInterval  int
+}
+
+func newLayout(hdr *header, headerSize int, padding Padding) layout {
+	return layout{
+		headerSize:    headerSize,
+		chunkSize:     hdr.chunkSize,
+		chunkOverhead: hdr.chunkHeaderLen() + overhead,
+		compression:   hdr.compression,
+		padding:       padding,
+		trailerSize:   hdr.trailerSize,
+		syncInterval:  int(hdr.syncInterval),
+	}
+}
+
+func (l *layout) sealedSize(plaintext int64) int64 {
+	cs := int64(l.chunkSize)
+	n := plaintext
+	var chunks int64
+	if l.compression == CompressionZstdChunks {
+		// every chunk holds up to chunkSize-1 bytes and a flag
+		chunks = max(1, (n+cs-2)/(cs-1))
+		n += chunks
+	} else {
+		if l.padding != PaddingNone {
+			n = l.padding.paddedSize(n+1, l.chunkSize)
+		}
+		chunks = (n + cs - 1) / cs
+		if l.trailerSize == 0 {
+			chunks = max(1, chunks)
+		}
+	}
+	size := int64(l.headerSize) + n + chunks*int64(l.chunkOverhead)
+	if l.trailerSize != 0 {
+		size += int64(l.trailerSize + l.chunkOverhead)
+		chunks++
+	}
+	if l.syncInterval != 0 {
+		size += (chunks - 1) / int64(l.syncInterval) * int64(SyncMarkerSize)
+	}
+	return size
+}
+
+// SealedSizeEstimate returns the size of the file that sealing plaintext
+// bytes to keys with opt produces, the outer prefix excluded, the same as
+// Writer.SealedSizeEstimate would, but without sealing anything: no file
+// key is generated and nothing is wrapped. Every Encapsulator in opt
+// (Shamir holders included) has to implement SlotSizer.
+func SealedSizeEstimate(plaintext int64, keys []*Key, opt SealOptions) (int64, error) {
+	if opt.ChunkSize == 0 {
+		opt.ChunkSize = DefaultChunkSize
+	}
+	err := opt.validate()
+	if err != nil {
+		return 0, err
+	}
+	if len(keys)+len(opt.Recipients)+len(opt.Encapsulators) == 0 && opt.Passphrase == nil && opt.MasterKey == nil {
+		return 0, ErrNoKeys
+	}
+
+	hdr := newHeader(keys, &opt)
+	if opt.MasterKey != nil {
+		hdr.slots = append(hdr.slots, Slot{Type: SlotTypeMasterKey, Data: make([]byte, masterSlotSize)})
+	}
+	for _, enc := range opt.encapsulators(keys) {
+		size, err := slotDataSize(enc)
+		if err != nil {
+			return 0, err
+		}
+		if size > maxSlotDataSize {
+			return 0, ErrHeaderTooLarge
+		}
+		hdr.slots = append(hdr.slots, Slot{Data: make([]byte, size)})
+	}
+	if opt.Passphrase != nil {
+		hdr.slots = append(hdr.slots, Slot{Type: SlotTypePassphrase, Data: make([]byte, passphraseSlotSize)})
+	}
+	if !opt.NotAfter.IsZero() {
+		hdr.notAfter = opt.NotAfter.Unix()
+	}
+	if hdr.version != 0 {
+		hdr.commitment = make([]byte, keyCommitmentSize)
+		hdr.mac = make([]byte, headerMACSize)
+	}
+
+	buf, err := hdr.append(nil)
+	if err != nil {
+		return 0, err
+	}
+	headerSize := len(buf)
+	if headerSize-headerSizeV1Fixed > MaxHeaderSize {
+		return 0, ErrHeaderTooLarge
+	}
+	if opt.Magic {
+		headerSize += len(Magic)
+	}
+	l := newLayout(&hdr, headerSize, opt.Padding)
+	return l.sealedSize(plaintext), nil
+}
+
+// slotDataSize returns the size of the slot data enc produces.
+func slotDataSize(enc Encapsulator) (int, error) {
+	switch enc := enc.(type) {
+	case *Shamir:
+		if enc.Threshold < 1 || enc.Threshold > len(enc.Holders) || len(enc.Holders) > 255 {
+			return 0, fmt.Errorf("invalid Shamir threshold %d of %d", enc.Threshold, len(enc.Holders))
+		}
+		size := 2
+		for _, holder := range enc.Holders {
+			n, err := slotDataSize(holder)
+			if err != nil {
+				return 0, err
+			}
+			if n > maxFieldSize {
+				return 0, ErrHeaderTooLarge
+			}
+			size += 1 + 2 + IDSize + 2 + n
+		}
+		return size, nil
+	case SlotSizer:
+		return enc.SlotDataSize(), nil
+	default:
+		return 0, fmt.Errorf("cannot estimate the slot size of %T, which doesn't implement SlotSizer", enc)
+	}
+}
+
+// HeaderSize returns the size of the envelope header, from the end of the
+// outer prefix passed to Seal to the first chunk (Magic included).
+func (w *Writer) HeaderSize() int {
+	return w.layout.headerSize
+}
+
+// ChunkOverhead returns the number of bytes every chunk adds to the data
+// it holds: its header and the authentication tag.
+func (w *Writer) ChunkOverhead() int {
+	return w.layout.chunkOverhead
+}
+
+// SealedSizeEstimate returns the size of the file that sealing plaintext
+// bytes produces, the outer prefix excluded. Seal doesn't write anything
+// until the first chunk is full, so this can be used to set Content-Length
+// before writing. To find out the size before calling Seal, use the
+// package-level SealedSizeEstimate.
+//
+// The size is exact for CompressionNone. Otherwise it's the size for data
+// that doesn't compress at all, which is an upper bound for
+// CompressionZstdChunks, but is exceeded by a few bytes of framing with
+// the stream codecs (zstd and brotli).
+func (w *Writer) SealedSizeEstimate(plaintext int64) int64 {
+	return w.layout.sealedSize(plaintext)
+}
+
+// HeaderSize returns the size of the envelope header, from the end of the
+// outer prefix to the first chunk (Magic included).
+func (opn *Openable) HeaderSize() int {
+	n := len(opn.prefix) - opn.outerLen
+	if opn.hdr.magic {
+		n += len(Magic)
+	}
+	return n
+}
+
+// ChunkOverhead returns the number of bytes every chunk adds to the data
+// it holds: its header and the authentication tag.
+func (opn *Openable) ChunkOverhead() int {
+	return opn.hdr.chunkHeaderLen() + overhead
+}
@@ -0,0 +1,125 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestWriter_sealedSizeEstimate(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	prefix := []byte("prefix")
+	for _, tc := range []struct {
+		keys int
+		opt  sealer.SealOptions
+	}{
+		{1, sealer.SealOptions{Compression: sealer.CompressionNone}},
+		{2, sealer.SealOptions{Compression: sealer.CompressionNone}},
+		{1, sealer.SealOptions{Compression: sealer.CompressionNone, RandomNonces: true, Magic: true}},
+		{1, sealer.SealOptions{Compression: sealer.CompressionNone, PlaintextDigest: true, RecordSize: true}},
+		{2, sealer.SealOptions{Compression: sealer.CompressionNone, Padding: sealer.PaddingPadme}},
+		{2, sealer.SealOptions{Compression: sealer.CompressionNone, Padding: sealer.PaddingChunk, MerkleRoot: true}},
+		{2, sealer.SealOptions{Compression: sealer.CompressionZstdChunks}},
+		{2, sealer.SealOptions{Compression: sealer.CompressionZstdChunks, RecordSize: true}},
+	} {
+		tc.opt.ChunkSize = 64
+		for _, n := range []int{0, 1, 63, 64, 65, 128, 1000} {
+			// random data doesn't compress, which makes the estimate exact
+			original := make([]byte, n)
+			rand.Read(original)
+
+			var buf bytes.Buffer
+			w, err := sealer.Seal(&buf, keys[:tc.keys], prefix, tc.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimate := w.SealedSizeEstimate(int64(n))
+			if e, err := sealer.SealedSizeEstimate(int64(n), keys[:tc.keys], tc.opt); err != nil {
+				t.Fatal(err)
+			} else if e != estimate {
+				t.Errorf("%d keys, %+v, %d bytes: package SealedSizeEstimate = %d, wanted %d", tc.keys, tc.opt, n, e, estimate)
+			}
+			if _, err := w.Write(original); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if a := int64(buf.Len() - len(prefix)); estimate != a {
+				t.Errorf("%d keys, %+v, %d bytes: SealedSizeEstimate = %d, wanted %d", tc.keys, tc.opt, n, estimate, a)
+			}
+
+			opn, err := sealer.Prepare(bytes.NewReader(buf.Bytes()[len(prefix):]), prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opn.HeaderSize() != w.HeaderSize() || opn.ChunkOverhead() != w.ChunkOverhead() {
+				t.Errorf("Openable: HeaderSize = %d, ChunkOverhead = %d, wanted %d, %d", opn.HeaderSize(), opn.ChunkOverhead(), w.HeaderSize(), w.ChunkOverhead())
+			}
+		}
+	}
+}
+
+func TestSealedSizeEstimate_slots(t *testing.T) {
+	keys := []*sealer.Key{generateKeyWithID("A"), generateKeyWithID("B")}
+	classic, err := sealer.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pq, err := sealer.GenerateIdentityKEM(sealer.KEMMLKEM768X25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, _, err := sealer.GenerateThresholdKey(2, 3, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []sealer.SealOptions{
+		{Recipients: []*sealer.Recipient{classic.Recipient(), pq.Recipient()}},
+		{Recipients: []*sealer.Recipient{pq.Recipient()}, PostQuantum: true, Magic: true},
+		{MasterKey: keys[1], NotAfter: time.Now().Add(time.Hour), Context: "invoices"},
+		{Passphrase: []byte("x"), KDF: sealer.KDFParams{Algorithm: sealer.KDFPBKDF2, Time: 1000}},
+		{Escrow: []*sealer.Recipient{classic.Recipient()}, SyncInterval: 2},
+		{Encapsulators: []sealer.Encapsulator{
+			group,
+			&sealer.Shamir{Threshold: 2, Holders: []sealer.Encapsulator{keys[1], classic.Recipient(), pq.Recipient()}},
+		}},
+	} {
+		opt.Compression = sealer.CompressionNone
+		opt.ChunkSize = 100
+		original := randomBytes(t, 1000)
+		estimate, err := sealer.SealedSizeEstimate(int64(len(original)), keys[:1], opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := int64(len(seal(t, keys[:1], nil, opt, original))); estimate != a {
+			t.Errorf("%+v: SealedSizeEstimate = %d, wanted %d", opt, estimate, a)
+		}
+	}
+}
+
+type opaqueEncapsulator struct{}
+
+func (opaqueEncapsulator) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	panic("SealedSizeEstimate must not encapsulate")
+}
+
+func TestSealedSizeEstimate_errors(t *testing.T) {
+	key := generateKeyWithID("A")
+	opt := sealer.SealOptions{Encapsulators: []sealer.Encapsulator{opaqueEncapsulator{}}}
+	if _, err := sealer.SealedSizeEstimate(100, []*sealer.Key{key}, opt); err == nil {
+		t.Error("SealedSizeEstimate succeeded with an Encapsulator that doesn't implement SlotSizer")
+	}
+	opt = sealer.SealOptions{Encapsulators: []sealer.Encapsulator{&sealer.Shamir{Threshold: 1, Holders: []sealer.Encapsulator{opaqueEncapsulator{}}}}}
+	if _, err := sealer.SealedSizeEstimate(100, []*sealer.Key{key}, opt); err == nil {
+		t.Error("SealedSizeEstimate succeeded with a Shamir holder that doesn't implement SlotSizer")
+	}
+	if _, err := sealer.SealedSizeEstimate(100, nil, sealer.SealOptions{}); !errors.Is(err, sealer.ErrNoKeys) {
+		t.Errorf("SealedSizeEstimate without keys = %v, wanted ErrNoKeys", err)
+	}
+}
@@ -0,0 +1,143 @@
+package sealer
+
+import (
+	"fmt"
+	"io"
+)
+
+// Slot is an entry of the envelope header holding the ephemeral file key
+// encapsulated (i.e. encrypted) by one particular key. The meaning of Data
+// depends on Type; KeyID identifies the key that can open the slot.
+type Slot struct {
+	Type  uint16
+	KeyID [IDSize]byte
+	Data  []byte
+}
+
+// Slot types defined by this package and its sub-packages. Types
+// SlotTypeCustom and above are free for application-defined encapsulators.
+const (
+	SlotTypeKey        uint16 = 1
+	SlotTypeHPKE       uint16 = 2
+	SlotTypePassphrase uint16 = 3
+	SlotTypeShamir     uint16 = 4
+	SlotTypeEscrow     uint16 = 5
+	SlotTypeMasterKey  uint16 = 6
+	SlotTypeThreshold  uint16 = 7
+
+	SlotTypeAWSKMS uint16 = 0x10 // see awskms package
+	SlotTypeGCPKMS uint16 = 0x11 // see gcpkms package
+
+	SlotTypeAzureKeyVault uint16 = 0x12 // see azurekv package
+	SlotTypeVaultTransit  uint16 = 0x13 // see vaulttransit package
+	SlotTypePKCS11        uint16 = 0x14 // see pkcs11wrap package
+	SlotTypeTPM           uint16 = 0x15 // see tpmseal package
+	SlotTypeDPAPI         uint16 = 0x16 // see dpapi package
+	SlotTypeYubiKey       uint16 = 0x17 // see yubikey package
+	SlotTypeSSH           uint16 = 0x18 // see sshkey package
+	SlotTypeAge           uint16 = 0x19 // see age package
+
+	SlotTypeCustom uint16 = 0x8000
+)
+
+// Encapsulator wraps the ephemeral file key into a header slot, e.g. using
+// a locally held key, a public key, or an external KMS or HSM.
+type Encapsulator interface {
+	// Encapsulate returns a new slot holding the wrapped fileKey. random is
+	// the source of randomness configured by SealOptions.RandomReader.
+	Encapsulate(fileKey []byte, random io.Reader) (Slot, error)
+}
+
+// Decapsulator unwraps the ephemeral file key from a header slot.
+type Decapsulator interface {
+	// Decapsulate returns the file key wrapped in the slot, or
+	// ErrNoMatchingKey if the slot is not meant for this decapsulator.
+	Decapsulate(s Slot) ([]byte, error)
+}
+
+// PostQuantumReporter is implemented by Encapsulators that know whether the
+// slots they produce withstand a quantum adversary. With
+// SealOptions.PostQuantum, Seal rejects Encapsulators that don't implement
+// it, or report false.
+type PostQuantumReporter interface {
+	PostQuantum() bool
+}
+
+func isPostQuantum(enc Encapsulator) bool {
+	p, ok := enc.(PostQuantumReporter)
+	return ok && p.PostQuantum()
+}
+
+// Encapsulate implements Encapsulator using XChaCha20-Poly1305 with a random
+// nonce.
+func (key *Key) Encapsulate(fileKey []byte, random io.Reader) (Slot, error) {
+	encapsulated := make([]byte, encapsulatedSize)
+	_, err := io.ReadFull(random, encapsulated[:nonceSizeX])
+	if err != nil {
+		return Slot{}, fmt.Errorf("generating nonce: %w", err)
+	}
+	copy(encapsulated[nonceSizeX:], fileKey)
+	err = encapsulate(key.Key[:], encapsulated, nil)
+	if err != nil {
+		return Slot{}, err
+	}
+	return Slot{Type: SlotTypeKey, KeyID: key.ID, Data: encapsulated}, nil
+}
+
+// PostQuantum implements PostQuantumReporter.
+func (key *Key) PostQuantum() bool {
+	return true
+}
+
+// SlotDataSize implements SlotSizer.
+func (key *Key) SlotDataSize() int {
+	return encapsulatedSize
+}
+
+// Decapsulate implements Decapsulator for slots created by Key.Encapsulate
+// (or for SealOptions.MasterKey) with the same key ID, or anonymous ones.
+func (key *Key) Decapsulate(s Slot) ([]byte, error) {
+	if !isKeySlot(s.Type) || !idMatches(s.KeyID, key.ID) {
+		return nil, ErrNoMatchingKey
+	}
+	fileKey := make([]byte, KeySize)
+	err := decapsulateKey(fileKey, key, &s)
+	if err != nil {
+		return nil, err
+	}
+	return fileKey, nil
+}
+
+// OpenWith opens the file using the first slot dec can decapsulate.
+func (opn *Openable) OpenWith(dec Decapsulator) (*Reader, error) {
+	fileKey, err := opn.decapsulateWith(dec)
+	if err != nil {
+		return nil, err
+	}
+	return opn.open(fileKey)
+}
+
+func (opn *Openable) decapsulateWith(dec Decapsulator) ([]byte, error) {
+	err := ErrNoMatchingKey
+	for _, s := range opn.hdr.slots {
+		fileKey, derr := dec.Decapsulate(s)
+		if derr == ErrNoMatchingKey {
+			continue
+		} else if derr != nil {
+			err = derr
+			continue
+		}
+		if len(fileKey) != KeySize {
+			return nil, fmt.Errorf("%T returned a %d-byte file key", dec, len(fileKey))
+		}
+		return fileKey, nil
+	}
+	return nil, err
+}
+
+// idMatches returns whether a slot with the given key ID can be opened by
+// a key with the given ID. Anonymous slots (see SealOptions.Anonymous)
+// have a zero key ID, and are tried with any key.
+func idMatches(slotID, keyID [IDSize]byte) bool {
+	return slotID == keyID || slotID == [IDSize]byte{}
+}
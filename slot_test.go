@@ -0,0 +1,66 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+// xorWrapper is a toy Encapsulator/Decapsulator standing in for a KMS.
+type xorWrapper struct {
+	id  [sealer.IDSize]byte
+	pad [sealer.KeySize]byte
+}
+
+const slotTypeXOR = sealer.SlotTypeCustom + 1
+
+func (w *xorWrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	data := make([]byte, len(fileKey))
+	subtle.XORBytes(data, fileKey, w.pad[:])
+	return sealer.Slot{Type: slotTypeXOR, KeyID: w.id, Data: data}, nil
+}
+
+func (w *xorWrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	if s.Type != slotTypeXOR || s.KeyID != w.id {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey := make([]byte, len(s.Data))
+	subtle.XORBytes(fileKey, s.Data, w.pad[:])
+	return fileKey, nil
+}
+
+func TestSealer_customEncapsulator(t *testing.T) {
+	w := &xorWrapper{}
+	copy(w.id[:], "xor")
+	copy(w.pad[:], "0123456789abcdef0123456789abcdef")
+	key := generateKey()
+
+	original := []byte("hello, world")
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{w},
+	}, original)
+
+	for _, dec := range []sealer.Decapsulator{w, key} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(opn.Slots) != 2 || opn.Slots[1].Type != slotTypeXOR {
+			t.Fatalf("unexpected slots: %v", opn.Slots)
+		}
+		r, err := opn.OpenWith(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+}
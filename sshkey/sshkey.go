@@ -0,0 +1,394 @@
+// Package sshkey seals sealer file keys to SSH public keys, so that teams
+// can reuse the keys they already distribute (e.g. in authorized_keys or
+// on GitHub).
+//
+// ssh-ed25519 keys are converted to X25519 and used for ECDH, like age
+// does, and ssh-rsa keys use RSA-OAEP with SHA-256. Sealing only needs the
+// public key, and opening needs the private key.
+//
+// An ssh-agent cannot decrypt anything, only sign, so AgentWrapper derives
+// the wrapping key from a signature of a random challenge instead. That
+// relies on Ed25519 and RSA PKCS#1 v1.5 signatures being deterministic, and
+// needs the agent both to seal and to open.
+package sshkey
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+
+	"github.com/andreyvit/sealer"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrUnsupportedKeyType is returned for SSH keys other than ssh-ed25519 and
+// ssh-rsa.
+var ErrUnsupportedKeyType = errors.New("sshkey: only ssh-ed25519 and ssh-rsa keys are supported")
+
+// Kind identifies the mechanism of an SSH slot.
+type Kind uint8
+
+const (
+	KindEd25519 Kind = 1
+	KindRSA     Kind = 2
+	KindAgent   Kind = 3
+)
+
+const (
+	hkdfInfo         = "github.com/andreyvit/sealer/sshkey"
+	oaepLabel        = "github.com/andreyvit/sealer/sshkey"
+	challengeSize    = 32
+	encapsulatedSize = chacha20poly1305.NonceSizeX + sealer.KeySize + chacha20poly1305.Overhead
+)
+
+// SSH slot data:
+//  - kind           uint8
+//  - Ed25519:
+//    - ephemeralPub [32]byte (X25519)
+//    - encapsulated [nonce + key + tag]byte
+//  - RSA:
+//    - ciphertext   (RSA-OAEP-SHA256 of the file key)
+//  - agent:
+//    - challenge    [32]byte
+//    - encapsulated [nonce + key + tag]byte
+//
+// For Ed25519 and agent slots, everything before encapsulated is its
+// additional data.
+
+// KeyID returns the sealer key ID used for slots sealed to the key.
+func KeyID(pub ssh.PublicKey) [sealer.IDSize]byte {
+	return sha256.Sum256(append([]byte("ssh:"), pub.Marshal()...))
+}
+
+// SlotKind returns the kind of an SSH slot, or false if it's not one.
+func SlotKind(s sealer.Slot) (Kind, bool) {
+	if s.Type != sealer.SlotTypeSSH || len(s.Data) < 1 {
+		return 0, false
+	}
+	return Kind(s.Data[0]), true
+}
+
+// Recipient seals file keys to an SSH public key.
+type Recipient struct {
+	pub    ssh.PublicKey
+	x25519 *ecdh.PublicKey
+	rsa    *rsa.PublicKey
+}
+
+// NewRecipient returns a Recipient for an ssh-ed25519 or ssh-rsa key.
+func NewRecipient(pub ssh.PublicKey) (*Recipient, error) {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	r := &Recipient{pub: pub}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		x, err := ed25519PublicKeyToX25519(k)
+		if err != nil {
+			return nil, err
+		}
+		r.x25519 = x
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("sshkey: RSA keys must be at least 2048 bits")
+		}
+		r.rsa = k
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+	return r, nil
+}
+
+// ParseRecipient parses a public key in authorized_keys format.
+func ParseRecipient(authorizedKey string) (*Recipient, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, fmt.Errorf("sshkey: %w", err)
+	}
+	return NewRecipient(pub)
+}
+
+// KeyID returns the sealer key ID of the recipient's slots.
+func (r *Recipient) KeyID() [sealer.IDSize]byte {
+	return KeyID(r.pub)
+}
+
+// Encapsulate implements sealer.Encapsulator.
+func (r *Recipient) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	var data []byte
+	if r.rsa != nil {
+		ct, err := rsa.EncryptOAEP(sha256.New(), random, r.rsa, fileKey, []byte(oaepLabel))
+		if err != nil {
+			return sealer.Slot{}, fmt.Errorf("sshkey: %w", err)
+		}
+		data = append([]byte{byte(KindRSA)}, ct...)
+	} else {
+		eph, err := ecdh.X25519().GenerateKey(random)
+		if err != nil {
+			return sealer.Slot{}, err
+		}
+		shared, err := eph.ECDH(r.x25519)
+		if err != nil {
+			return sealer.Slot{}, err
+		}
+		data = append([]byte{byte(KindEd25519)}, eph.PublicKey().Bytes()...)
+		wrappingKey, err := hkdf.Key(sha256.New, shared, slices.Concat(eph.PublicKey().Bytes(), r.x25519.Bytes()), hkdfInfo, chacha20poly1305.KeySize)
+		if err != nil {
+			return sealer.Slot{}, err
+		}
+		data, err = wrap(data, wrappingKey, fileKey, random)
+		if err != nil {
+			return sealer.Slot{}, err
+		}
+	}
+	return sealer.Slot{Type: sealer.SlotTypeSSH, KeyID: r.KeyID(), Data: data}, nil
+}
+
+// SlotDataSize implements sealer.SlotSizer.
+func (r *Recipient) SlotDataSize() int {
+	if r.rsa != nil {
+		return 1 + r.rsa.Size()
+	}
+	return 1 + 32 + encapsulatedSize
+}
+
+// Identity opens slots sealed to an SSH public key using its private key.
+type Identity struct {
+	Recipient
+	x25519Priv *ecdh.PrivateKey
+	rsaPriv    *rsa.PrivateKey
+}
+
+// NewIdentity returns an Identity for a private key as returned by
+// ssh.ParseRawPrivateKey (ed25519.PrivateKey or *rsa.PrivateKey).
+func NewIdentity(key crypto.PrivateKey) (*Identity, error) {
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		key = *k
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, ErrUnsupportedKeyType
+	}
+	r, err := NewRecipient(signer.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{Recipient: *r}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		h := sha512.Sum512(k.Seed())
+		id.x25519Priv, err = ecdh.X25519().NewPrivateKey(h[:32])
+		if err != nil {
+			return nil, err
+		}
+	case *rsa.PrivateKey:
+		id.rsaPriv = k
+	}
+	return id, nil
+}
+
+// ParseIdentity parses an unencrypted PEM-encoded private key, e.g. the
+// contents of ~/.ssh/id_ed25519.
+func ParseIdentity(pemBytes []byte) (*Identity, error) {
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("sshkey: %w", err)
+	}
+	return NewIdentity(key)
+}
+
+// ParseIdentityWithPassphrase parses a passphrase-protected PEM-encoded
+// private key.
+func ParseIdentityWithPassphrase(pemBytes, passphrase []byte) (*Identity, error) {
+	key, err := ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("sshkey: %w", err)
+	}
+	return NewIdentity(key)
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (id *Identity) Decapsulate(s sealer.Slot) ([]byte, error) {
+	kind, ok := SlotKind(s)
+	if !ok || s.KeyID != id.KeyID() {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	data := s.Data[1:]
+	switch {
+	case kind == KindRSA && id.rsaPriv != nil:
+		fileKey, err := rsa.DecryptOAEP(sha256.New(), nil, id.rsaPriv, data, []byte(oaepLabel))
+		if err != nil {
+			return nil, fmt.Errorf("sshkey: %w", err)
+		}
+		return fileKey, nil
+	case kind == KindEd25519 && id.x25519Priv != nil:
+		if len(data) != 32+encapsulatedSize {
+			return nil, sealer.ErrUnsupportedVersion
+		}
+		eph, err := ecdh.X25519().NewPublicKey(data[:32])
+		if err != nil {
+			return nil, sealer.ErrUnsupportedVersion
+		}
+		shared, err := id.x25519Priv.ECDH(eph)
+		if err != nil {
+			return nil, sealer.ErrUnsupportedVersion
+		}
+		wrappingKey, err := hkdf.Key(sha256.New, shared, slices.Concat(data[:32], id.x25519.Bytes()), hkdfInfo, chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, err
+		}
+		return unwrap(s.Data[:1+32], wrappingKey, data[32:])
+	default:
+		return nil, sealer.ErrNoMatchingKey
+	}
+}
+
+// AgentWrapper seals and opens file keys using a key held by an ssh-agent.
+type AgentWrapper struct {
+	agent agent.Agent
+	pub   ssh.PublicKey
+}
+
+// NewAgentWrapper returns an AgentWrapper for the agent key with the given
+// public key, which must be ssh-ed25519 or ssh-rsa.
+func NewAgentWrapper(ag agent.Agent, pub ssh.PublicKey) (*AgentWrapper, error) {
+	switch pub.Type() {
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoRSA:
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+	return &AgentWrapper{agent: ag, pub: pub}, nil
+}
+
+// KeyID returns the sealer key ID of the wrapper's slots.
+func (w *AgentWrapper) KeyID() [sealer.IDSize]byte {
+	return KeyID(w.pub)
+}
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *AgentWrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	data := make([]byte, 1+challengeSize)
+	data[0] = byte(KindAgent)
+	if _, err := io.ReadFull(random, data[1:]); err != nil {
+		return sealer.Slot{}, fmt.Errorf("generating challenge: %w", err)
+	}
+	wrappingKey, err := w.deriveKey(data[1:])
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	data, err = wrap(data, wrappingKey, fileKey, random)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	return sealer.Slot{Type: sealer.SlotTypeSSH, KeyID: w.KeyID(), Data: data}, nil
+}
+
+// SlotDataSize implements sealer.SlotSizer.
+func (w *AgentWrapper) SlotDataSize() int {
+	return 1 + challengeSize + encapsulatedSize
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (w *AgentWrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	kind, ok := SlotKind(s)
+	if !ok || kind != KindAgent || s.KeyID != w.KeyID() {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	if len(s.Data) != 1+challengeSize+encapsulatedSize {
+		return nil, sealer.ErrUnsupportedVersion
+	}
+	wrappingKey, err := w.deriveKey(s.Data[1 : 1+challengeSize])
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(s.Data[:1+challengeSize], wrappingKey, s.Data[1+challengeSize:])
+}
+
+func (w *AgentWrapper) deriveKey(challenge []byte) ([]byte, error) {
+	msg := slices.Concat([]byte(hkdfInfo+" challenge\x00"), challenge)
+	var sig *ssh.Signature
+	var err error
+	if ext, ok := w.agent.(agent.ExtendedAgent); ok && w.pub.Type() == ssh.KeyAlgoRSA {
+		sig, err = ext.SignWithFlags(w.pub, msg, agent.SignatureFlagRsaSha256)
+	} else {
+		sig, err = w.agent.Sign(w.pub, msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sshkey: agent: %w", err)
+	}
+	// the signature is public-key verifiable, but only the key holder can
+	// produce it for a fresh challenge
+	if err := w.pub.Verify(msg, sig); err != nil {
+		return nil, fmt.Errorf("sshkey: agent returned an invalid signature: %w", err)
+	}
+	return hkdf.Key(sha256.New, sig.Blob, challenge, hkdfInfo+" "+sig.Format, chacha20poly1305.KeySize)
+}
+
+func wrap(meta, wrappingKey, fileKey []byte, random io.Reader) ([]byte, error) {
+	defer clear(wrappingKey)
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	start := len(meta)
+	data := append(meta, make([]byte, chacha20poly1305.NonceSizeX)...)
+	nonce := data[start:]
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(data, nonce, fileKey, data[:start]), nil
+}
+
+func unwrap(meta, wrappingKey, encapsulated []byte) ([]byte, error) {
+	defer clear(wrappingKey)
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := encapsulated[:chacha20poly1305.NonceSizeX]
+	return aead.Open(nil, nonce, encapsulated[chacha20poly1305.NonceSizeX:], meta)
+}
+
+// p is the order of the Curve25519 base field, 2^255 - 19.
+var p, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// ed25519PublicKeyToX25519 maps the Edwards point to the birationally
+// equivalent Montgomery u-coordinate, u = (1 + y) / (1 - y).
+func ed25519PublicKeyToX25519(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, ErrUnsupportedKeyType
+	}
+	le := slices.Clone([]byte(pub))
+	le[31] &= 0x7f
+	slices.Reverse(le)
+	y := new(big.Int).SetBytes(le)
+	if y.Cmp(p) >= 0 {
+		return nil, ErrUnsupportedKeyType
+	}
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, ErrUnsupportedKeyType
+	}
+	u := num.Mul(num, den.ModInverse(den, p))
+	u.Mod(u, p)
+
+	out := u.FillBytes(make([]byte, 32))
+	slices.Reverse(out)
+	return ecdh.X25519().NewPublicKey(out)
+}
@@ -0,0 +1,172 @@
+package sshkey_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/sshkey"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestEd25519(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKey(t, priv, sshkey.KindEd25519)
+}
+
+func TestRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKey(t, priv, sshkey.KindRSA)
+}
+
+func TestPostQuantum(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sshkey.NewRecipient(sshPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{r}, PostQuantum: true})
+	if err != sealer.ErrNotPostQuantum {
+		t.Errorf("Seal to an SSH key with PostQuantum: err = %v, wanted ErrNotPostQuantum", err)
+	}
+}
+
+func testKey(t *testing.T, priv any, kind sshkey.Kind) {
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sshkey.ParseRecipient(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := sealWith(t, r)
+
+	opn := prepare(t, sealed)
+	if a, e := opn.KeyID, sshkey.KeyID(signer.PublicKey()); a != e {
+		t.Errorf("KeyID = %x, wanted %x", a, e)
+	}
+	if k, ok := sshkey.SlotKind(opn.Slots[0]); !ok || k != kind {
+		t.Errorf("SlotKind = %v, %v, wanted %v", k, ok, kind)
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := sshkey.ParseIdentityWithPassphrase(pem.EncodeToMemory(block), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, opn, id)
+
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	otherID, err := sshkey.NewIdentity(otherPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prepare(t, sealed).OpenWith(otherID); err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenWith(other) = %v, wanted ErrNoMatchingKey", err)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	for _, gen := range []func() any{
+		func() any { _, k, _ := ed25519.GenerateKey(rand.Reader); return k },
+		func() any { k, _ := rsa.GenerateKey(rand.Reader, 2048); return k },
+	} {
+		priv := gen()
+		kr := agent.NewKeyring()
+		if err := kr.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := kr.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := sshkey.NewAgentWrapper(kr, keys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := sealWith(t, w)
+		opn := prepare(t, sealed)
+		if k, _ := sshkey.SlotKind(opn.Slots[0]); k != sshkey.KindAgent {
+			t.Errorf("SlotKind = %v", k)
+		}
+		verify(t, opn, w)
+
+		// the private key cannot open agent slots, and vice versa
+		id, err := sshkey.NewIdentity(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := prepare(t, sealed).OpenWith(id); err != sealer.ErrNoMatchingKey {
+			t.Errorf("OpenWith(identity) = %v, wanted ErrNoMatchingKey", err)
+		}
+	}
+}
+
+func sealWith(t *testing.T, enc sealer.Encapsulator) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	opt := sealer.SealOptions{Encapsulators: []sealer.Encapsulator{enc}}
+	w, err := sealer.Seal(&buf, nil, nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := sealer.SealedSizeEstimate(12, nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := w.SealedSizeEstimate(12); estimate != a {
+		t.Errorf("SealedSizeEstimate = %d, wanted %d", estimate, a)
+	}
+	io.WriteString(w, "hello, world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func prepare(t *testing.T, sealed []byte) *sealer.Openable {
+	t.Helper()
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opn
+}
+
+func verify(t *testing.T, opn *sealer.Openable, dec sealer.Decapsulator) {
+	t.Helper()
+	r, err := opn.OpenWith(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "hello, world"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+}
@@ -0,0 +1,65 @@
+package sealer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the counters of a Writer or a Reader, for logging compression
+// ratios and throughput.
+type Stats struct {
+	// Plaintext is the number of bytes written to the Writer, or read from
+	// the Reader.
+	Plaintext int64
+
+	// Compressed is the number of bytes held by the chunks: the compressed
+	// plaintext, followed by the padding and the trailer if any. Writers
+	// count compressed data once the compressor outputs it.
+	Compressed int64
+
+	// Sealed is the number of bytes of the chunks and the sync markers, the
+	// envelope header (see HeaderSize) excluded.
+	Sealed int64
+
+	// Chunks is the number of chunks sealed or opened.
+	Chunks int64
+
+	// Elapsed is the time since the Writer or Reader has been created or
+	// reset, until Close for a Writer, and the end of the plaintext for a
+	// Reader.
+	Elapsed time.Duration
+}
+
+// Stats returns the counters of the stream so far.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := w.elapsed
+	if !w.closed {
+		elapsed = time.Since(w.start)
+	}
+	return Stats{
+		Plaintext:  w.total,
+		Compressed: atomic.LoadInt64(&w.enc.statCompressed),
+		Sealed:     atomic.LoadInt64(&w.enc.statSealed),
+		Chunks:     atomic.LoadInt64(&w.enc.statChunks),
+		Elapsed:    elapsed,
+	}
+}
+
+// Stats returns the counters of the stream so far. Chunks skipped by
+// seeking aren't counted. With OpenOptions.Concurrency, chunks opened ahead
+// of the plaintext read are counted once it gets to them.
+func (r *Reader) Stats() Stats {
+	elapsed := r.elapsed
+	if !r.finished {
+		elapsed = time.Since(r.start)
+	}
+	return Stats{
+		Plaintext:  r.total,
+		Compressed: r.dec.compressed,
+		Sealed:     r.dec.sealed,
+		Chunks:     r.dec.chunks,
+		Elapsed:    elapsed,
+	}
+}
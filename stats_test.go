@@ -0,0 +1,91 @@
+package sealer_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestStats(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 100000)
+	clear(original[:60000])
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, SyncInterval: 3, MerkleRoot: true},
+		{Compression: sealer.CompressionZstdChunks, Concurrency: 4},
+		{Compression: sealer.CompressionZstd, Padding: sealer.PaddingPadme},
+	} {
+		opt.ChunkSize = 4096
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.SyncInterval), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(original); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			ws := w.Stats()
+			if ws.Plaintext != int64(len(original)) {
+				t.Errorf("Writer: Plaintext = %d, wanted %d", ws.Plaintext, len(original))
+			}
+			if ws.Sealed != int64(buf.Len()-w.HeaderSize()) {
+				t.Errorf("Writer: Sealed = %d, wanted %d", ws.Sealed, buf.Len()-w.HeaderSize())
+			}
+			if opt.Compression == sealer.CompressionNone && !opt.MerkleRoot && ws.Compressed != ws.Plaintext {
+				t.Errorf("Writer: Compressed = %d, wanted %d", ws.Compressed, ws.Plaintext)
+			}
+			if opt.Compression != sealer.CompressionNone && ws.Compressed >= ws.Plaintext {
+				t.Errorf("Writer: Compressed = %d, wanted less than %d", ws.Compressed, ws.Plaintext)
+			}
+			if ws.Chunks < 2 || ws.Elapsed <= 0 || w.Stats().Elapsed != ws.Elapsed {
+				t.Errorf("Writer: %+v", ws)
+			}
+
+			r := openReader(t, buf.Bytes(), key)
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				t.Fatal(err)
+			}
+			if rs := r.Stats(); rs.Plaintext != ws.Plaintext || rs.Compressed != ws.Compressed || rs.Sealed != ws.Sealed || rs.Chunks != ws.Chunks || rs.Elapsed <= 0 {
+				t.Errorf("Reader: %+v, Writer: %+v", rs, ws)
+			}
+		})
+	}
+}
+
+// TestStats_pipeline reads the counters while the pipeline goroutine
+// updates them, for the race detector.
+func TestStats_pipeline(t *testing.T) {
+	key := generateKeyWithID("key")
+	// incompressible, so that zstd outputs blocks as it goes
+	original := randomBytes(t, 1000000)
+	var buf bytes.Buffer
+	w, err := sealer.Seal(&buf, []*sealer.Key{key}, nil, sealer.SealOptions{Pipeline: true, ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last sealer.Stats
+	for i := 0; i < len(original); i += 10000 {
+		if _, err := w.Write(original[i : i+10000]); err != nil {
+			t.Fatal(err)
+		}
+		s := w.Stats()
+		if s.Plaintext != int64(i+10000) || s.Chunks < last.Chunks || s.Sealed < last.Sealed || s.Compressed < last.Compressed {
+			t.Fatalf("%+v after %+v", s, last)
+		}
+		last = s
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Stats(); s.Sealed != int64(buf.Len()-w.HeaderSize()) || s.Chunks <= last.Chunks {
+		t.Errorf("after Close: %+v, wanted Sealed = %d", s, buf.Len()-w.HeaderSize())
+	}
+}
@@ -0,0 +1,192 @@
+package sealer
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Files sealed with SealOptions.SyncInterval have a sync marker before
+// every chunk whose index is a positive multiple of the interval, the
+// trailer chunk included. Markers start with SyncMarker, so that they can
+// be found by scanning, and are authenticated with the chunk key, so that
+// a marker found this way is known to be one, and to precede the chunk it
+// names. Readers check the markers as they go.
+//
+// Sync marker:
+//  - magic           [8]byte (SyncMarker)
+//  - index           uint32, index of the chunk that follows
+//  - tag             [overhead]byte, the chunk AEAD's tag of an empty
+//                    message, with the additional data of the chunks and
+//                    a nonce holding index and a 2 in the last byte, which
+//                    no chunk nonce has (see fillNonce)
+
+// SyncMarker starts every sync marker (see SealOptions.SyncInterval).
+const SyncMarker = "\x89SLRSYNC"
+
+// SyncMarkerSize is the size of a sync marker.
+const SyncMarkerSize = len(SyncMarker) + 4 + overhead
+
+// ErrNoSyncMarker is returned by Openable.OpenAtSync when there are no sync
+// markers after the given offset.
+var ErrNoSyncMarker = errors.New("no sync marker found")
+
+// syncScanSize is the number of bytes OpenAtSync reads at once.
+const syncScanSize = 64 * 1024
+
+// hasSyncBefore returns whether a sync marker precedes the chunk with the
+// given index, given the interval from the header.
+func hasSyncBefore(interval, index uint32) bool {
+	return interval != 0 && index != 0 && index%interval == 0
+}
+
+func syncNonce(aead cipher.AEAD, index uint32) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.LittleEndian.PutUint32(nonce, index)
+	nonce[len(nonce)-1] = 2
+	return nonce
+}
+
+// appendSyncMarker appends the sync marker preceding the given chunk.
+func appendSyncMarker(buf []byte, aead cipher.AEAD, ad []byte, index uint32) []byte {
+	buf = append(buf, SyncMarker...)
+	buf = binary.LittleEndian.AppendUint32(buf, index)
+	return aead.Seal(buf, syncNonce(aead, index), nil, ad)
+}
+
+// checkSyncMarker returns whether marker is the sync marker preceding the
+// given chunk.
+func checkSyncMarker(aead cipher.AEAD, ad, marker []byte, index uint32) bool {
+	if len(marker) != SyncMarkerSize || string(marker[:len(SyncMarker)]) != SyncMarker || binary.LittleEndian.Uint32(marker[len(SyncMarker):]) != index {
+		return false
+	}
+	var expected [SyncMarkerSize]byte
+	return subtle.ConstantTimeCompare(appendSyncMarker(expected[:0], aead, ad, index), marker) == 1
+}
+
+// SyncPoint is a chunk boundary found by Openable.OpenAtSync.
+type SyncPoint struct {
+	// Offset is the offset of the sync marker from the first chunk.
+	Offset int64
+
+	// Chunk is the index of the chunk following the marker.
+	Chunk int
+
+	// Plaintext is the offset of the chunk's data in the plaintext.
+	Plaintext int64
+}
+
+// OpenAtSync decrypts the file key using the slot matching key.ID, finds the
+// first sync marker (see SealOptions.SyncInterval) at or after off bytes
+// from the first chunk, and returns a Reader of the plaintext from the
+// chunk following the marker to the end of the file, along with where that
+// chunk is. It allows coarse seeking in files without a seek index, and
+// splitting them between workers: worker i of n opens the file at
+// i*size/n, and reads up to the Plaintext of the next worker's SyncPoint.
+//
+// The Openable has to come from PrepareAt, and the file has to be sealed
+// with CompressionNone or CompressionZstdChunks, since other compression
+// can't start in the middle; OpenAtSync returns ErrNotSeekable otherwise.
+// Like after Reader.Seek, the chunks are authenticated, but the plaintext
+// as a whole isn't checked against the trailer.
+func (opn *Openable) OpenAtSync(key *Key, off int64) (*Reader, SyncPoint, error) {
+	c := opn.hdr.compression
+	if opn.ra == nil || opn.hdr.syncInterval == 0 || c != CompressionNone && c != CompressionZstdChunks {
+		return nil, SyncPoint{}, ErrNotSeekable
+	}
+	if off < 0 {
+		return nil, SyncPoint{}, fmt.Errorf("sealer: negative offset %d", off)
+	}
+	var fileKey [KeySize]byte
+	defer clear(fileKey[:])
+	err := opn.decapsulateKey(fileKey[:], key)
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+	err = opn.checkFileKey(fileKey[:])
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+	zopts, err := opn.zstdOptions()
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+	r, err := opn.newReader(nil, fileKey[:])
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+
+	p, err := opn.findSync(&r.dec, off)
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+	r.dec.in = io.NewSectionReader(opn.ra, opn.bodyStart+p.Offset, opn.bodyLen-p.Offset)
+	r.dec.chunkIndex = uint32(p.Chunk)
+	r.dec.pos = p.Offset
+	r.dec.merkle = nil
+	r.hash = nil
+	r.hasSize = false
+	r.bodyOff = -1
+	r.seeked = true
+	r.total = p.Plaintext
+
+	err = r.dec.read(nil)
+	if err != nil {
+		return nil, SyncPoint{}, withPlaintext(err, p.Plaintext)
+	}
+	r.decompr, err = c.newReader(&r.dec, zopts)
+	if err != nil {
+		return nil, SyncPoint{}, err
+	}
+	return r, p, nil
+}
+
+// findSync scans the body of the file from off for a valid sync marker.
+func (opn *Openable) findSync(dec *decryptor, off int64) (SyncPoint, error) {
+	in := io.NewSectionReader(opn.ra, opn.bodyStart+off, max(0, opn.bodyLen-off))
+	buf := make([]byte, 0, syncScanSize+SyncMarkerSize)
+	// start is the offset of buf from the first chunk
+	start := off
+	eof := false
+	for {
+		n, err := io.ReadFull(in, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+		} else if err != nil {
+			return SyncPoint{}, err
+		}
+
+		for i := 0; ; i++ {
+			k := bytes.Index(buf[i:], []byte(SyncMarker))
+			if k < 0 {
+				break
+			}
+			i += k
+			if len(buf)-i < SyncMarkerSize {
+				break
+			}
+			marker := buf[i : i+SyncMarkerSize]
+			index := binary.LittleEndian.Uint32(marker[len(SyncMarker):])
+			if hasSyncBefore(dec.syncInterval, index) && checkSyncMarker(dec.aead, dec.ad, marker, index) {
+				perChunk := int64(dec.chunkSize)
+				if dec.variableChunks {
+					perChunk--
+				}
+				return SyncPoint{Offset: start + int64(i), Chunk: int(index), Plaintext: int64(index) * perChunk}, nil
+			}
+		}
+		if eof {
+			return SyncPoint{}, ErrNoSyncMarker
+		}
+
+		// keep the bytes that can start a marker cut off at the end
+		keep := SyncMarkerSize - 1
+		start += int64(len(buf) - keep)
+		buf = buf[:copy(buf, buf[len(buf)-keep:])]
+	}
+}
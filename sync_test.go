@@ -0,0 +1,169 @@
+package sealer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSyncInterval(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 20000)
+	for i := 0; i < len(original); i += 1000 {
+		clear(original[i : i+500])
+	}
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, RandomNonces: true, PlaintextDigest: true, RecordSize: true},
+		{Compression: sealer.CompressionZstdChunks, MerkleRoot: true},
+		{Compression: sealer.CompressionZstd},
+	} {
+		opt.ChunkSize = 256
+		opt.SyncInterval = 4
+		t.Run(fmt.Sprintf("%v/%v", opt.Compression, opt.RandomNonces), func(t *testing.T) {
+			var m sealer.Manifest
+			opt.Manifest = &m
+			w, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimate := w.SealedSizeEstimate(int64(len(original)))
+			sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+			if opt.Compression == sealer.CompressionNone && estimate != int64(len(sealed)) {
+				t.Errorf("SealedSizeEstimate = %d, wanted %d", estimate, len(sealed))
+			}
+
+			actual, err := io.ReadAll(openReader(t, sealed, key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, original) {
+				t.Fatalf("got %d bytes, wanted %d", len(actual), len(original))
+			}
+			if err := m.Verify(bytes.NewReader(sealed)); err != nil {
+				t.Errorf("Manifest.Verify: %v", err)
+			}
+
+			opn, err := sealer.PrepareAt(bytes.NewReader(sealed), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opn.Header().SyncInterval != 4 {
+				t.Errorf("SyncInterval = %d", opn.Header().SyncInterval)
+			}
+			if opt.Compression == sealer.CompressionZstd {
+				if _, _, err := opn.OpenAtSync(key, 0); !errors.Is(err, sealer.ErrNotSeekable) {
+					t.Errorf("OpenAtSync with zstd: err = %v, wanted ErrNotSeekable", err)
+				}
+				return
+			}
+			prev := -1
+			for off := int64(0); ; off += 1000 {
+				r, p, err := opn.OpenAtSync(key, off)
+				if errors.Is(err, sealer.ErrNoSyncMarker) {
+					break
+				} else if err != nil {
+					t.Fatalf("OpenAtSync(%d): %v", off, err)
+				}
+				if p.Offset < off || p.Chunk%4 != 0 || p.Chunk < prev {
+					t.Fatalf("OpenAtSync(%d) = %+v", off, p)
+				}
+				prev = p.Chunk
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("reading from %+v: %v", p, err)
+				}
+				if !bytes.Equal(actual, original[min(p.Plaintext, int64(len(original))):]) {
+					t.Fatalf("got %d bytes from %+v", len(actual), p)
+				}
+			}
+			if prev < 0 {
+				t.Errorf("no sync markers found")
+			}
+		})
+	}
+}
+
+func TestSyncInterval_damaged(t *testing.T) {
+	key := generateKeyWithID("key")
+	opt := sealer.SealOptions{Compression: sealer.CompressionNone, ChunkSize: 64, SyncInterval: 2}
+	original := randomBytes(t, 1000)
+	sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+	// salvaging skips over markers without reporting gaps
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn.Salvage = true
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(actual, original) || len(r.Gaps()) != 0 {
+		t.Errorf("salvaged %d bytes with gaps %v: %v", len(actual), r.Gaps(), err)
+	}
+
+	i := bytes.Index(sealed, []byte(sealer.SyncMarker))
+	if i < 0 {
+		t.Fatal("no sync marker")
+	}
+
+	damaged := bytes.Clone(sealed)
+	damaged[i+sealer.SyncMarkerSize-1] ^= 1
+	if _, err := io.ReadAll(openReader(t, damaged, key)); err == nil {
+		t.Errorf("damaged sync marker read without an error")
+	}
+
+	// a damaged marker is skipped over when searching
+	opn, err = sealer.PrepareAt(bytes.NewReader(damaged), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p, err := opn.OpenAtSync(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Chunk != 4 {
+		t.Errorf("found a marker before chunk %d, wanted 4", p.Chunk)
+	}
+
+	if _, err := sealer.Seal(io.Discard, []*sealer.Key{key}, nil, sealer.SealOptions{Compression: sealer.CompressionNone, SeekIndex: true, SyncInterval: 2}); !errors.Is(err, sealer.ErrInvalidOptions) {
+		t.Errorf("SyncInterval with SeekIndex: err = %v, wanted ErrInvalidOptions", err)
+	}
+}
+
+func TestSyncInterval_reseal(t *testing.T) {
+	key := generateKeyWithID("key")
+	otherKey := generateKeyWithID("other")
+	newKey := generateKeyWithID("new")
+	original := randomBytes(t, 100000)
+	for _, opt := range []sealer.SealOptions{
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstdChunks, RandomNonces: true},
+	} {
+		opt.ChunkSize = 1024
+		opt.SyncInterval = 4
+		sealed := seal(t, []*sealer.Key{key, otherKey}, nil, opt, original)
+		// the header is rewritten alone, or the chunks are re-encrypted
+		for _, ropt := range []sealer.SealOptions{{}, {MasterKey: generateKeyWithID("master")}} {
+			opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := opn.Reseal(&buf, key, []*sealer.Key{newKey}, ropt); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := io.ReadAll(openReader(t, buf.Bytes(), newKey))
+			if err != nil || !bytes.Equal(actual, original) {
+				t.Errorf("%v, MasterKey %v: got %d bytes: %v", opt.Compression, ropt.MasterKey != nil, len(actual), err)
+			}
+		}
+	}
+}
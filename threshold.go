@@ -0,0 +1,303 @@
+package sealer
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/edwards25519"
+)
+
+// Threshold decapsulation lets a group of holders open files together
+// without any of them ever learning the group's private key or the key that
+// wraps the file key. Unlike Shamir slots, which reconstruct the file key
+// from shares unwrapped by individual holders, here the opener sends the
+// slot to the holders, each holder returns a partial decapsulation computed
+// with its share of the private key, and the opener combines any Threshold
+// of them to unwrap the file key.
+//
+// This is threshold ElGamal over the prime-order group of edwards25519:
+// the group key is s·B, and the private key s is Shamir-shared among the
+// holders. A slot holds E = r·B and the file key wrapped with a key derived
+// from 8·r·(s·B); each holder returns 8·sᵢ·E, and the opener interpolates
+// these in the exponent. The multiplication by the cofactor keeps a
+// malicious E from learning anything about the shares.
+
+// ErrInvalidThresholdKey is returned when parsing malformed threshold keys,
+// shares, or partial decapsulations.
+var ErrInvalidThresholdKey = errors.New("invalid threshold key")
+
+// ThresholdKey is the public key of a group of holders, any Threshold of
+// whom have to cooperate to open the files sealed to it. Use it in
+// SealOptions.Encapsulators.
+type ThresholdKey struct {
+	ID        [IDSize]byte
+	Threshold int
+	pub       *edwards25519.Point
+}
+
+// ThresholdShare is a holder's share of the private key of a ThresholdKey.
+type ThresholdShare struct {
+	// ID is the ID of the ThresholdKey.
+	ID    [IDSize]byte
+	Index int
+
+	threshold int
+	pub       *edwards25519.Point
+	s         *edwards25519.Scalar
+}
+
+// ThresholdPartials is a Decapsulator that combines partial decapsulations
+// returned by ThresholdShare.Partial. Any Threshold of them with distinct
+// indices can open a slot; extra ones are ignored.
+type ThresholdPartials [][]byte
+
+const (
+	thresholdKeySize     = 1 + 32
+	thresholdShareSize   = 1 + 1 + 32 + 32
+	thresholdPartialSize = 1 + 32
+	thresholdSlotSize    = 1 + 32 + encapsulatedSize
+)
+
+// Threshold slot data:
+//  - threshold       uint8
+//  - ephemeral       [32]byte (E = r·B)
+//  - encapsulatedKey [nonceSizeX + KeySize + overhead]byte
+//
+// Partial decapsulation:
+//  - index           uint8
+//  - partial         [32]byte (8·sᵢ·E)
+
+// GenerateThresholdKey generates a group key and n shares of its private
+// key, any threshold of which can open the files sealed to the key. Hand
+// each share to a different holder; the private key itself is never stored.
+func GenerateThresholdKey(threshold, n int, random io.Reader) (*ThresholdKey, []*ThresholdShare, error) {
+	if threshold < 1 || threshold > n || n > 255 {
+		return nil, nil, fmt.Errorf("invalid threshold %d of %d", threshold, n)
+	}
+	coeffs := make([]*edwards25519.Scalar, threshold)
+	for i := range coeffs {
+		var err error
+		coeffs[i], err = randomScalar(random)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pub := edwards25519.NewIdentityPoint().ScalarBaseMult(coeffs[0])
+	key := &ThresholdKey{ID: thresholdKeyID(pub), Threshold: threshold, pub: pub}
+	shares := make([]*ThresholdShare, n)
+	for i := range shares {
+		x := smallScalar(i + 1)
+		// Horner's method, highest coefficient first
+		s := edwards25519.NewScalar()
+		for c := threshold - 1; c >= 0; c-- {
+			s.MultiplyAdd(s, x, coeffs[c])
+		}
+		shares[i] = &ThresholdShare{ID: key.ID, Index: i + 1, threshold: threshold, pub: pub, s: s}
+	}
+	for _, c := range coeffs {
+		c.Set(edwards25519.NewScalar())
+	}
+	return key, shares, nil
+}
+
+// NewThresholdKey loads a key returned by ThresholdKey.Bytes.
+func NewThresholdKey(b []byte) (*ThresholdKey, error) {
+	if len(b) != thresholdKeySize || b[0] == 0 {
+		return nil, ErrInvalidThresholdKey
+	}
+	pub, err := edwards25519.NewIdentityPoint().SetBytes(b[1:])
+	if err != nil {
+		return nil, ErrInvalidThresholdKey
+	}
+	return &ThresholdKey{ID: thresholdKeyID(pub), Threshold: int(b[0]), pub: pub}, nil
+}
+
+// Bytes returns the serialized key.
+func (k *ThresholdKey) Bytes() []byte {
+	return append([]byte{byte(k.Threshold)}, k.pub.Bytes()...)
+}
+
+// NewThresholdShare loads a share returned by ThresholdShare.Bytes.
+func NewThresholdShare(b []byte) (*ThresholdShare, error) {
+	if len(b) != thresholdShareSize || b[0] == 0 || b[1] == 0 {
+		return nil, ErrInvalidThresholdKey
+	}
+	pub, err := edwards25519.NewIdentityPoint().SetBytes(b[2:34])
+	if err != nil {
+		return nil, ErrInvalidThresholdKey
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(b[34:])
+	if err != nil {
+		return nil, ErrInvalidThresholdKey
+	}
+	return &ThresholdShare{ID: thresholdKeyID(pub), Index: int(b[1]), threshold: int(b[0]), pub: pub, s: s}, nil
+}
+
+// Bytes returns the serialized share, which is secret.
+func (sh *ThresholdShare) Bytes() []byte {
+	b := []byte{byte(sh.threshold), byte(sh.Index)}
+	b = append(b, sh.pub.Bytes()...)
+	return append(b, sh.s.Bytes()...)
+}
+
+// Key returns the group key the share belongs to.
+func (sh *ThresholdShare) Key() *ThresholdKey {
+	return &ThresholdKey{ID: sh.ID, Threshold: sh.threshold, pub: sh.pub}
+}
+
+// Encapsulate implements Encapsulator.
+func (k *ThresholdKey) Encapsulate(fileKey []byte, random io.Reader) (Slot, error) {
+	r, err := randomScalar(random)
+	if err != nil {
+		return Slot{}, err
+	}
+	ephemeral := edwards25519.NewIdentityPoint().ScalarBaseMult(r)
+	shared := edwards25519.NewIdentityPoint().ScalarMult(r, k.pub)
+	shared.MultByCofactor(shared)
+
+	data := make([]byte, thresholdSlotSize)
+	data[0] = byte(k.Threshold)
+	copy(data[1:33], ephemeral.Bytes())
+	encapsulated := data[33:]
+	_, err = io.ReadFull(random, encapsulated[:nonceSizeX])
+	if err != nil {
+		return Slot{}, fmt.Errorf("generating nonce: %w", err)
+	}
+	copy(encapsulated[nonceSizeX:], fileKey)
+	kek := thresholdKEK(shared, data[1:33])
+	err = encapsulate(kek, encapsulated, nil)
+	clear(kek)
+	if err != nil {
+		return Slot{}, err
+	}
+	return Slot{Type: SlotTypeThreshold, KeyID: k.ID, Data: data}, nil
+}
+
+// SlotDataSize implements SlotSizer.
+func (k *ThresholdKey) SlotDataSize() int {
+	return thresholdSlotSize
+}
+
+// Partial returns the partial decapsulation of a slot sealed to the share's
+// group key, or ErrNoMatchingKey if the slot is not meant for this group.
+// The opener sends the slot (see Openable.Slots) to the holders and passes
+// the partials they return to Openable.OpenWith as ThresholdPartials.
+//
+// A partial does not reveal the share, but it does let the opener unwrap
+// this particular slot once enough partials are collected, so holders
+// should only return partials to openers they trust with the file.
+func (sh *ThresholdShare) Partial(s Slot) ([]byte, error) {
+	if s.Type != SlotTypeThreshold || s.KeyID != sh.ID {
+		return nil, ErrNoMatchingKey
+	}
+	if len(s.Data) != thresholdSlotSize {
+		return nil, ErrUnsupportedVersion
+	}
+	ephemeral, err := edwards25519.NewIdentityPoint().SetBytes(s.Data[1:33])
+	if err != nil {
+		return nil, ErrUnsupportedVersion
+	}
+	ephemeral.MultByCofactor(ephemeral)
+	partial := edwards25519.NewIdentityPoint().ScalarMult(sh.s, ephemeral)
+	return append([]byte{byte(sh.Index)}, partial.Bytes()...), nil
+}
+
+// Decapsulate implements Decapsulator, returning ErrNotEnoughShares if there
+// are fewer partials than the slot's threshold.
+func (p ThresholdPartials) Decapsulate(s Slot) ([]byte, error) {
+	if s.Type != SlotTypeThreshold {
+		return nil, ErrNoMatchingKey
+	}
+	if len(s.Data) != thresholdSlotSize || s.Data[0] == 0 {
+		return nil, ErrUnsupportedVersion
+	}
+	threshold := int(s.Data[0])
+
+	var xs []*edwards25519.Scalar
+	var points []*edwards25519.Point
+	var seen [256]bool
+	for _, partial := range p {
+		if len(partial) != thresholdPartialSize || partial[0] == 0 {
+			return nil, ErrInvalidThresholdKey
+		}
+		if seen[partial[0]] {
+			continue
+		}
+		seen[partial[0]] = true
+		point, err := edwards25519.NewIdentityPoint().SetBytes(partial[1:])
+		if err != nil {
+			return nil, ErrInvalidThresholdKey
+		}
+		xs = append(xs, smallScalar(int(partial[0])))
+		points = append(points, point)
+		if len(points) == threshold {
+			break
+		}
+	}
+	if len(points) < threshold {
+		return nil, ErrNotEnoughShares
+	}
+
+	// Lagrange basis polynomials at 0: prod xj / (xj - xi)
+	lambdas := make([]*edwards25519.Scalar, len(xs))
+	for i, xi := range xs {
+		num, den := smallScalar(1), smallScalar(1)
+		for j, xj := range xs {
+			if i != j {
+				num.Multiply(num, xj)
+				den.Multiply(den, edwards25519.NewScalar().Subtract(xj, xi))
+			}
+		}
+		lambdas[i] = num.Multiply(num, den.Invert(den))
+	}
+	shared := edwards25519.NewIdentityPoint().MultiScalarMult(lambdas, points)
+
+	kek := thresholdKEK(shared, s.Data[1:33])
+	defer clear(kek)
+	fileKey := make([]byte, KeySize)
+	err := decapsulate(fileKey, kek, s.Data[33:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("combining partial decapsulations: %w", err)
+	}
+	return fileKey, nil
+}
+
+func thresholdKEK(shared *edwards25519.Point, ephemeral []byte) []byte {
+	kek, err := hkdf.Key(sha256.New, shared.Bytes(), ephemeral, "github.com/andreyvit/sealer threshold", KeySize)
+	if err != nil {
+		panic(err)
+	}
+	return kek
+}
+
+func thresholdKeyID(pub *edwards25519.Point) [IDSize]byte {
+	h := sha256.New()
+	h.Write(binary.LittleEndian.AppendUint16(nil, SlotTypeThreshold))
+	h.Write(pub.Bytes())
+	return [IDSize]byte(h.Sum(nil))
+}
+
+func randomScalar(random io.Reader) (*edwards25519.Scalar, error) {
+	var b [64]byte
+	_, err := io.ReadFull(random, b[:])
+	if err != nil {
+		return nil, fmt.Errorf("generating scalar: %w", err)
+	}
+	defer clear(b[:])
+	return edwards25519.NewScalar().SetUniformBytes(b[:])
+}
+
+func smallScalar(x int) *edwards25519.Scalar {
+	var b [32]byte
+	b[0] = byte(x)
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(b[:])
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
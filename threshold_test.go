@@ -0,0 +1,111 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestThreshold(t *testing.T) {
+	key, shares, err := sealer.GenerateThresholdKey(3, 5, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = sealer.NewThresholdKey(key.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sh := range shares {
+		shares[i], err = sealer.NewThresholdShare(sh.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shares[i].ID != key.ID || shares[i].Index != i+1 {
+			t.Fatalf("share %d has ID %x, index %d", i, shares[i].ID, shares[i].Index)
+		}
+	}
+
+	original := []byte("hello, world")
+	other := generateKeyWithID("other")
+	sealed := seal(t, []*sealer.Key{other}, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{key},
+	}, original)
+
+	partials := func(indices ...int) sealer.ThresholdPartials {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result sealer.ThresholdPartials
+		for _, i := range indices {
+			for _, s := range opn.Slots {
+				p, err := shares[i].Partial(s)
+				if err == sealer.ErrNoMatchingKey {
+					continue
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				result = append(result, p)
+			}
+		}
+		return result
+	}
+
+	for _, tc := range []struct {
+		partials sealer.ThresholdPartials
+		err      error
+	}{
+		{partials(0, 1, 2), nil},
+		{partials(4, 2, 0), nil},
+		{partials(1, 3, 3, 4), nil},
+		{partials(0, 1, 2, 3, 4), nil},
+		{partials(0, 1), sealer.ErrNotEnoughShares},
+		{partials(2, 2, 2), sealer.ErrNotEnoughShares},
+	} {
+		opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := opn.OpenWith(tc.partials)
+		if tc.err != nil {
+			if err != tc.err {
+				t.Errorf("OpenWith(%d partials) = %v, wanted %v", len(tc.partials), err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, original) {
+			t.Fatalf("got %q, wanted %q", actual, original)
+		}
+	}
+
+	// a share of another group cannot help open the file
+	_, foreign, err := sealer.GenerateThresholdKey(1, 1, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range opn.Slots {
+		if _, err := foreign[0].Partial(s); err != sealer.ErrNoMatchingKey {
+			t.Errorf("Partial with a foreign share = %v, wanted ErrNoMatchingKey", err)
+		}
+	}
+	bad := partials(0, 1, 2)
+	bad[1][5] ^= 1
+	_, err = opn.OpenWith(bad)
+	if err == nil {
+		t.Errorf("OpenWith a corrupted partial succeeded")
+	}
+}
@@ -0,0 +1,169 @@
+// Package tpmseal seals sealer file keys to a TPM 2.0 under a PCR policy, so
+// that a file can only be opened on the machine that created it, and only
+// while that machine is in the same boot state.
+//
+// The package does not talk to the TPM device itself. You provide a TPM
+// backed by your TPM library of choice (e.g. github.com/google/go-tpm):
+// Seal should create a sealed data object under the storage root key with
+// an authPolicy of TPM2_PolicyPCR over the selected PCRs (TPM2_Create), and
+// Unseal should load it and call TPM2_Unseal in a policy session that
+// satisfies TPM2_PolicyPCR.
+package tpmseal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/andreyvit/sealer"
+)
+
+// TPM hash algorithm IDs (TPM_ALG_*) for PCR banks.
+const (
+	AlgSHA1   uint16 = 0x0004
+	AlgSHA256 uint16 = 0x000B
+	AlgSHA384 uint16 = 0x000C
+)
+
+// PCRSelection selects the PCRs whose values the file key is bound to.
+type PCRSelection struct {
+	Hash uint16
+	PCRs []int
+}
+
+// DefaultPCRs binds to the firmware, boot loader configuration and Secure
+// Boot state.
+var DefaultPCRs = PCRSelection{Hash: AlgSHA256, PCRs: []int{0, 2, 4, 7}}
+
+// TPM performs TPM 2.0 sealing operations.
+type TPM interface {
+	// SRKName returns the TPM name of the storage root key that objects are
+	// sealed under, which identifies the machine.
+	SRKName() ([]byte, error)
+
+	// Seal creates a sealed data object holding secret, with a PolicyPCR
+	// policy over the given PCRs, and returns its marshaled TPM2B_PUBLIC and
+	// TPM2B_PRIVATE.
+	Seal(pcrs PCRSelection, secret []byte) (public, private []byte, err error)
+
+	// Unseal loads the sealed data object and unseals it, which only
+	// succeeds if the PCRs still hold the values they had at Seal time.
+	Unseal(pcrs PCRSelection, public, private []byte) ([]byte, error)
+}
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// a TPM.
+type Wrapper struct {
+	tpm  TPM
+	pcrs PCRSelection
+}
+
+// New returns a Wrapper that seals file keys to the given PCRs.
+func New(tpm TPM, pcrs PCRSelection) *Wrapper {
+	return &Wrapper{tpm: tpm, pcrs: pcrs}
+}
+
+// TPM slot data:
+//  - hash       uint16
+//  - pcrCount   uint8
+//  - pcrs       [pcrCount]uint8
+//  - publicLen  uint16
+//  - public     [publicLen]byte
+//  - private    (rest)
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	id, err := w.keyID()
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	public, private, err := w.tpm.Seal(w.pcrs, fileKey)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("tpmseal: %w", err)
+	}
+	if len(w.pcrs.PCRs) > 0xff || len(public) > 0xffff {
+		return sealer.Slot{}, sealer.ErrHeaderTooLarge
+	}
+
+	data := binary.LittleEndian.AppendUint16(nil, w.pcrs.Hash)
+	data = append(data, byte(len(w.pcrs.PCRs)))
+	for _, pcr := range w.pcrs.PCRs {
+		data = append(data, byte(pcr))
+	}
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(public)))
+	data = append(data, public...)
+	data = append(data, private...)
+	return sealer.Slot{Type: sealer.SlotTypeTPM, KeyID: id, Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter. The sealed blob is
+// protected by the symmetric key of the TPM's storage hierarchy.
+func (w *Wrapper) PostQuantum() bool {
+	return true
+}
+
+// Decapsulate implements sealer.Decapsulator. It only handles slots sealed
+// by the same TPM, using the PCR selection stored in the slot.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	pcrs, public, private, ok := parseSlot(s)
+	if !ok {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	id, err := w.keyID()
+	if err != nil {
+		return nil, err
+	}
+	if s.KeyID != id {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.tpm.Unseal(pcrs, public, private)
+	if err != nil {
+		return nil, fmt.Errorf("tpmseal: %w", err)
+	}
+	return fileKey, nil
+}
+
+func (w *Wrapper) keyID() ([sealer.IDSize]byte, error) {
+	name, err := w.tpm.SRKName()
+	if err != nil {
+		return [sealer.IDSize]byte{}, fmt.Errorf("tpmseal: %w", err)
+	}
+	return KeyID(name), nil
+}
+
+// KeyID returns the sealer key ID used for slots sealed under the SRK with
+// the given name.
+func KeyID(srkName []byte) [sealer.IDSize]byte {
+	return sha256.Sum256(append([]byte("tpm:"), srkName...))
+}
+
+// PCRs returns the PCR selection the slot is bound to, or false if it's not
+// a TPM slot.
+func PCRs(s sealer.Slot) (PCRSelection, bool) {
+	pcrs, _, _, ok := parseSlot(s)
+	return pcrs, ok
+}
+
+func parseSlot(s sealer.Slot) (pcrs PCRSelection, public, private []byte, ok bool) {
+	data := s.Data
+	if s.Type != sealer.SlotTypeTPM || len(data) < 3 {
+		return PCRSelection{}, nil, nil, false
+	}
+	pcrs.Hash = binary.LittleEndian.Uint16(data)
+	n := int(data[2])
+	data = data[3:]
+	if len(data) < n+2 {
+		return PCRSelection{}, nil, nil, false
+	}
+	for _, pcr := range data[:n] {
+		pcrs.PCRs = append(pcrs.PCRs, int(pcr))
+	}
+	data = data[n:]
+	publicLen := int(binary.LittleEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < publicLen {
+		return PCRSelection{}, nil, nil, false
+	}
+	return pcrs, data[:publicLen], data[publicLen:], true
+}
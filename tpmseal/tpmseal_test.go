@@ -0,0 +1,113 @@
+package tpmseal_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/tpmseal"
+)
+
+// fakeTPM binds secrets to its simulated PCR values by XORing them with
+// a digest of the selected PCRs; public holds a checksum to detect changes.
+type fakeTPM struct {
+	srk  []byte
+	pcrs [24][32]byte
+}
+
+var errPolicy = errors.New("TPM_RC_POLICY_FAIL")
+
+func (t *fakeTPM) SRKName() ([]byte, error) {
+	return t.srk, nil
+}
+
+func (t *fakeTPM) digest(sel tpmseal.PCRSelection) [32]byte {
+	h := sha256.New()
+	h.Write(t.srk)
+	for _, pcr := range sel.PCRs {
+		h.Write(t.pcrs[pcr][:])
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+func (t *fakeTPM) Seal(sel tpmseal.PCRSelection, secret []byte) ([]byte, []byte, error) {
+	d := t.digest(sel)
+	check := sha256.Sum256(d[:])
+	private := bytes.Clone(secret)
+	for i := range private {
+		private[i] ^= d[i%len(d)]
+	}
+	return check[:], private, nil
+}
+
+func (t *fakeTPM) Unseal(sel tpmseal.PCRSelection, public, private []byte) ([]byte, error) {
+	d := t.digest(sel)
+	check := sha256.Sum256(d[:])
+	if !bytes.Equal(public, check[:]) {
+		return nil, errPolicy
+	}
+	secret := bytes.Clone(private)
+	for i := range secret {
+		secret[i] ^= d[i%len(d)]
+	}
+	return secret, nil
+}
+
+func TestWrapper(t *testing.T) {
+	tpm := &fakeTPM{srk: []byte("srk-1")}
+	w := tpmseal.New(tpm, tpmseal.DefaultPCRs)
+
+	var buf bytes.Buffer
+	sw, err := sealer.Seal(&buf, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{w}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(sw, "hello, world")
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.Bytes()
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := opn.KeyID, tpmseal.KeyID([]byte("srk-1")); a != e {
+		t.Errorf("KeyID = %x, wanted %x", a, e)
+	}
+	if sel, ok := tpmseal.PCRs(opn.Slots[0]); !ok || sel.Hash != tpmseal.AlgSHA256 || len(sel.PCRs) != 4 || sel.PCRs[3] != 7 {
+		t.Errorf("PCRs = %v, %v", sel, ok)
+	}
+	r, err := opn.OpenWith(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "hello, world"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+
+	// a different boot state cannot unseal
+	tpm.pcrs[7][0] = 1
+	opn, err = sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opn.OpenWith(w)
+	if !errors.Is(err, errPolicy) {
+		t.Errorf("OpenWith after PCR change = %v, wanted %v", err, errPolicy)
+	}
+
+	// another machine does not even try
+	other := tpmseal.New(&fakeTPM{srk: []byte("srk-2")}, tpmseal.DefaultPCRs)
+	_, err = opn.OpenWith(other)
+	if err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenWith on another machine = %v, wanted ErrNoMatchingKey", err)
+	}
+}
@@ -0,0 +1,507 @@
+package sealer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrDigestMismatch is returned by Reader at the end of a file whose
+// plaintext doesn't match the digest in its trailer.
+var ErrDigestMismatch = errors.New("sealed file plaintext digest mismatch")
+
+// ErrSizeUnknown is returned by Reader.Size when the file doesn't record its
+// size, or the size can't be read without reading the file to the end.
+var ErrSizeUnknown = errors.New("sealed file size unknown")
+
+// Files with a trailer field in the header end with a trailer chunk: the
+// final chunk holds the trailer rather than data, and the data chunk before
+// it can be shorter than chunkSize. The trailer is authenticated like any
+// other chunk, and lists fields in the same format as the header:
+//  - type            uint16 (high bit set if readers may skip it)
+//  - size            uint16
+//  - value           [size]byte
+//
+// The header lists the size of the trailer and the types of its fields, so
+// that readers know what to expect, and can find the trailer chunk from the
+// end of the file.
+//
+// Digest field value:
+//  - digest          [sha256.Size]byte, SHA-256 of the plaintext
+//
+// Size field value:
+//  - plaintextSize   uint64
+//  - ciphertextSize  uint64, size of the chunks, trailer chunk included
+//  - chunks          uint32, number of chunks, trailer chunk included
+//
+// Merkle root field value:
+//  - root            [sha256.Size]byte, see merkle.go
+//
+// Index field value: see seek.go
+//
+// Checkpoints field value: see checkpoint.go
+
+const (
+	trailerDigest uint16 = 1
+	trailerSize   uint16 = 2
+	trailerMerkle uint16 = 3
+	trailerIndex  uint16 = 4
+
+	trailerCheckpoints uint16 = 5
+
+	trailerSizeLen = 8 + 8 + 4
+)
+
+// hasher is hash.Hash, which can't be imported in this package because of
+// the debugging helper called hash.
+type hasher interface {
+	io.Writer
+	Sum(b []byte) []byte
+}
+
+// Size describes the size of a sealed file, as recorded in its trailer (see
+// SealOptions.RecordSize).
+type Size struct {
+	// Plaintext is the number of bytes of plaintext.
+	Plaintext int64
+
+	// Ciphertext is the number of bytes of the chunks following the header,
+	// so the size of the file is the size of the header plus Ciphertext. It
+	// doesn't change when the file is resealed.
+	Ciphertext int64
+
+	// Chunks is the number of chunks, including the one holding the trailer.
+	Chunks int
+}
+
+type trailer struct {
+	// digest is the SHA-256 of the plaintext, or nil if none.
+	digest []byte
+
+	// size is the size of the file, or nil if not recorded.
+	size *Size
+
+	// merkleRoot is the Merkle root of the chunks, or nil if none.
+	merkleRoot []byte
+
+	// index is the seek index, or nil if none.
+	index *seekIndex
+
+	// checkpoints are the checkpoints, or nil if the file has no room for
+	// them.
+	checkpoints *checkpoints
+}
+
+// trailerFields returns the types of the trailer fields the options call
+// for, or nil if they don't call for a trailer.
+func (opt *SealOptions) trailerFields() []uint16 {
+	var fields []uint16
+	if opt.PlaintextDigest {
+		fields = append(fields, trailerDigest)
+	}
+	if opt.RecordSize {
+		fields = append(fields, trailerSize)
+	}
+	if opt.MerkleRoot {
+		fields = append(fields, trailerMerkle)
+	}
+	if opt.SeekIndex {
+		fields = append(fields, trailerIndex)
+	}
+	if opt.Checkpoints > 0 {
+		fields = append(fields, trailerCheckpoints)
+	}
+	return fields
+}
+
+// hasTrailer returns whether the options call for a trailer.
+func (opt *SealOptions) hasTrailer() bool {
+	return opt.PlaintextDigest || opt.RecordSize || opt.MerkleRoot || opt.SeekIndex || opt.Checkpoints > 0
+}
+
+// trailerSize returns the size of the trailer the options call for.
+func (opt *SealOptions) trailerSize() int {
+	var size int
+	if opt.PlaintextDigest {
+		size += fieldHeaderSize + sha256.Size
+	}
+	if opt.RecordSize {
+		size += fieldHeaderSize + trailerSizeLen
+	}
+	if opt.MerkleRoot {
+		size += fieldHeaderSize + sha256.Size
+	}
+	if opt.SeekIndex {
+		size += fieldHeaderSize + seekIndexHeaderLen + seekIndexCapacity(opt.ChunkSize)*seekEntryLen
+	}
+	if opt.Checkpoints > 0 {
+		size += fieldHeaderSize + 4 + opt.Checkpoints*checkpointLen
+	}
+	return size
+}
+
+func (t *trailer) append(buf []byte) ([]byte, error) {
+	var err error
+	field := func(typ uint16, f func(buf []byte) []byte) {
+		if err == nil {
+			buf, err = appendField(buf, typ, f)
+		}
+	}
+	if t.digest != nil {
+		field(trailerDigest, func(buf []byte) []byte {
+			return append(buf, t.digest...)
+		})
+	}
+	if t.size != nil {
+		field(trailerSize, func(buf []byte) []byte {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(t.size.Plaintext))
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(t.size.Ciphertext))
+			return binary.LittleEndian.AppendUint32(buf, uint32(t.size.Chunks))
+		})
+	}
+	if t.merkleRoot != nil {
+		field(trailerMerkle, func(buf []byte) []byte {
+			return append(buf, t.merkleRoot...)
+		})
+	}
+	if t.index != nil {
+		field(trailerIndex, t.index.append)
+	}
+	if t.checkpoints != nil {
+		field(trailerCheckpoints, t.checkpoints.append)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trailer: %w", err)
+	}
+	return buf, nil
+}
+
+func (t *trailer) parse(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < fieldHeaderSize {
+			return errBadTrailer
+		}
+		typ := binary.LittleEndian.Uint16(data)
+		size := int(binary.LittleEndian.Uint16(data[2:]))
+		data = data[fieldHeaderSize:]
+		if size > len(data) {
+			return errBadTrailer
+		}
+		value := data[:size]
+		data = data[size:]
+
+		switch typ {
+		case trailerDigest:
+			if size != sha256.Size || t.digest != nil {
+				return errBadTrailer
+			}
+			t.digest = value
+		case trailerSize:
+			if size != trailerSizeLen || t.size != nil {
+				return errBadTrailer
+			}
+			t.size = &Size{
+				Plaintext:  int64(binary.LittleEndian.Uint64(value)),
+				Ciphertext: int64(binary.LittleEndian.Uint64(value[8:])),
+				Chunks:     int(binary.LittleEndian.Uint32(value[16:])),
+			}
+			if t.size.Plaintext < 0 || t.size.Ciphertext < 0 {
+				return errBadTrailer
+			}
+		case trailerMerkle:
+			if size != sha256.Size || t.merkleRoot != nil {
+				return errBadTrailer
+			}
+			t.merkleRoot = value
+		case trailerIndex:
+			if t.index != nil {
+				return errBadTrailer
+			}
+			t.index = new(seekIndex)
+			err := t.index.parse(value)
+			if err != nil {
+				return err
+			}
+		case trailerCheckpoints:
+			if t.checkpoints != nil {
+				return errBadTrailer
+			}
+			t.checkpoints = new(checkpoints)
+			err := t.checkpoints.parse(value)
+			if err != nil {
+				return err
+			}
+		default:
+			if typ&fieldOptional == 0 {
+				return errBadTrailer
+			}
+		}
+	}
+	return nil
+}
+
+var errBadTrailer = fmt.Errorf("%w: bad trailer", ErrCorrupted)
+
+// closeWithTrailer flushes the remaining data as the last data chunk, and
+// then the trailer as the final chunk, filling in the size of the file and
+// the Merkle root if the trailer records them.
+func (e *encryptor) closeWithTrailer(t *trailer) error {
+	if len(e.buf) > 0 {
+		err := e.flush(e.buf, false)
+		if err != nil {
+			return err
+		}
+		e.buf = e.buf[:0]
+	}
+	if e.par != nil {
+		// the Merkle tree needs the chunks written out
+		err := e.drain(0)
+		if err != nil {
+			return err
+		}
+	}
+	if e.merkle != nil {
+		root := e.merkle.root()
+		t.merkleRoot = root[:]
+	}
+	if e.index != nil {
+		if t.index == nil {
+			t.index = new(seekIndex)
+		}
+		t.index.chunks = int(e.chunkIndex) + 1
+		t.index.stride = e.index.stride
+		t.index.entries = e.index.entries
+		t.index.capacity = e.index.capacity
+	}
+	// off is the offset of the trailer chunk
+	off := e.sealed
+	if hasSyncBefore(e.syncInterval, e.chunkIndex) {
+		off += int64(SyncMarkerSize)
+	}
+	if e.checkpoints != nil {
+		// those at the very end are in the trailer chunk
+		e.placeCheckpoints(off)
+		t.checkpoints = e.checkpoints
+	}
+	if t.size != nil {
+		t.size.Chunks = int(e.chunkIndex) + 1
+		data, err := t.append(nil)
+		if err != nil {
+			return err
+		}
+		t.size.Ciphertext = off + int64(e.chunkLen(len(data)))
+	}
+	data, err := t.append(nil)
+	if err != nil {
+		return err
+	}
+	if len(data) > e.chunkSize {
+		return fmt.Errorf("trailer of %d bytes does not fit into a chunk", len(data))
+	}
+	return e.flush(data, true)
+}
+
+// chunkLen returns the size of a chunk holding size bytes.
+func (e *encryptor) chunkLen(size int) int {
+	n := chunkHeaderSize + size + overhead
+	if e.framed {
+		n += chunkSizeFieldSize
+	}
+	if e.random != nil {
+		n += nonceSizeX
+	}
+	return n
+}
+
+// finish is called when the plaintext has been read to the end. It checks
+// that nothing follows the compressed stream (and the final chunk, with
+// OpenOptions.StrictEOF), and verifies the trailer.
+func (r *Reader) finish() error {
+	if !r.finished {
+		r.finished = true
+		r.elapsed = time.Since(r.start)
+		r.finishErr = r.verify()
+	}
+	return r.finishErr
+}
+
+func (r *Reader) verify() error {
+	// some decompressors stop at the end of their stream without reading
+	// the rest of the chunks
+	var buf [1]byte
+	for {
+		n, err := r.dec.Read(buf[:])
+		if n > 0 {
+			return fmt.Errorf("%w: data after the end of the compressed stream", ErrCorrupted)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if r.strictEOF {
+		n, err := r.TrailingBytes()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: %d bytes", ErrTrailingData, n)
+		}
+	}
+
+	if r.dec.trailer == nil || r.dec.salvage != nil {
+		return nil
+	}
+	var t trailer
+	err := t.parse(r.dec.trailer)
+	if err != nil {
+		return err
+	}
+	r.trailer = t
+	if t.digest != nil && r.hash != nil {
+		if subtle.ConstantTimeCompare(r.hash.Sum(nil), t.digest) != 1 {
+			return ErrDigestMismatch
+		}
+	}
+	if t.index != nil && t.index.chunks != int(r.dec.chunkIndex) {
+		return fmt.Errorf("%w: chunk count mismatch", ErrCorrupted)
+	}
+	if r.seeked {
+		return nil
+	}
+	if t.index != nil && t.index.plaintext != r.total {
+		return fmt.Errorf("%w: size mismatch", ErrCorrupted)
+	}
+	if t.size != nil {
+		if t.size.Plaintext != r.total || t.size.Chunks != int(r.dec.chunkIndex) {
+			return fmt.Errorf("%w: size mismatch", ErrCorrupted)
+		}
+	}
+	if t.merkleRoot != nil && r.dec.merkle != nil {
+		if root := r.dec.merkle.root(); !bytes.Equal(root[:], t.merkleRoot) {
+			return fmt.Errorf("%w: Merkle root mismatch", ErrCorrupted)
+		}
+	}
+	return nil
+}
+
+// Digest returns the SHA-256 of the plaintext recorded in the trailer (see
+// SealOptions.PlaintextDigest), once Read has returned io.EOF, which means
+// the digest has been verified. It returns nil otherwise.
+func (r *Reader) Digest() []byte {
+	if !r.finished || r.finishErr != nil {
+		return nil
+	}
+	return r.trailer.digest
+}
+
+// Size returns the size of the file recorded in its trailer (see
+// SealOptions.RecordSize). Before the end of the file, the trailer is read
+// by seeking to the end of the input, which has to be an io.ReadSeeker that
+// ends with the file; otherwise, and if the file doesn't record its size,
+// Size returns ErrSizeUnknown.
+//
+// The size read before the end is authenticated, but the rest of the file
+// is only verified to match it once it has been read to the end.
+func (r *Reader) Size() (Size, error) {
+	if !r.hasSize {
+		return Size{}, ErrSizeUnknown
+	}
+	t, err := r.peekTrailer()
+	if err == errTrailerUnavailable {
+		return Size{}, ErrSizeUnknown
+	} else if err != nil {
+		return Size{}, err
+	}
+	if t.size == nil {
+		return Size{}, errBadTrailer
+	}
+	return *t.size, nil
+}
+
+var errTrailerUnavailable = errors.New("trailer cannot be read before the end of the file")
+
+// peekTrailer returns the trailer, reading it early if the file hasn't been
+// read to the end yet (see Size).
+func (r *Reader) peekTrailer() (*trailer, error) {
+	if r.peeked != nil {
+		return r.peeked, nil
+	}
+	data := r.dec.trailer
+	if data == nil {
+		in, ok := r.dec.in.(io.ReadSeeker)
+		if !ok || r.bodyOff < 0 || r.dec.variableChunks && !r.dec.detached || r.dec.syncInterval != 0 {
+			return nil, errTrailerUnavailable
+		}
+		var err error
+		data, err = r.readTrailer(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t := new(trailer)
+	err := t.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	r.peeked = t
+	return t, nil
+}
+
+// readTrailer reads and decrypts the trailer chunk at the end of in, then
+// seeks back to where the reading stopped. Since only the last data chunk
+// can be short, the position of the trailer chunk follows from the size of
+// the file; in files with a seek index, it doesn't matter.
+func (r *Reader) readTrailer(in io.ReadSeeker) ([]byte, error) {
+	pos, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	hdrLen := chunkHeaderSize + chunkSizeFieldSize + r.dec.nonceSize
+	trailerLen := int64(hdrLen + r.dec.trailerSize + overhead)
+	fullLen := int64(hdrLen + r.dec.chunkSize + overhead)
+	body := end - trailerLen - r.bodyOff
+	if body < 0 {
+		return nil, ErrTruncated
+	}
+	index := (body + fullLen - 1) / fullLen
+	if index >= int64(finalChunkIndex) {
+		return nil, ErrStreamTooLarge
+	}
+
+	chunk := make([]byte, trailerLen)
+	_, err = in.Seek(end-trailerLen, io.SeekStart)
+	if err == nil {
+		_, err = io.ReadFull(in, chunk)
+	}
+	if _, serr := in.Seek(pos, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ad := r.dec.ad
+	if index == 0 {
+		ad = r.firstAD
+	}
+	data, isFinal, err := r.dec.open(nil, chunk, uint32(index), ad)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the trailer: %w", err)
+	}
+	if !isFinal {
+		return nil, errBadTrailer
+	}
+	return data, nil
+}
@@ -0,0 +1,170 @@
+package sealer_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+)
+
+func TestSealer_plaintextDigest(t *testing.T) {
+	key, master := generateKeyWithID("key"), generateKeyWithID("master")
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionZstdChunks},
+		{Compression: sealer.CompressionBrotli},
+		{Compression: sealer.CompressionNone, Padding: sealer.PaddingPadme},
+		{MasterKey: master},
+	} {
+		opt.PlaintextDigest = true
+		opt.ChunkSize = 100
+		for _, size := range []int{0, 1, 99, 100, 101, 1000, 1234} {
+			t.Run(fmt.Sprintf("%v/%v/%d", opt.Compression, opt.Padding, size), func(t *testing.T) {
+				original := randomBytes(t, size)
+				expected := sha256.Sum256(original)
+				sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+
+				r := openReader(t, sealed, key)
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) {
+					t.Fatalf("got %d bytes, wanted %d", len(actual), len(original))
+				}
+				if !bytes.Equal(r.Digest(), expected[:]) {
+					t.Errorf("Digest = %x, wanted %x", r.Digest(), expected)
+				}
+
+				if opt.MasterKey == nil {
+					return
+				}
+				opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				err = opn.Reseal(&buf, key, nil, sealer.SealOptions{MasterKey: master})
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = openReader(t, buf.Bytes(), master)
+				actual, err = io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) || !bytes.Equal(r.Digest(), expected[:]) {
+					t.Errorf("resealed file doesn't match")
+				}
+			})
+		}
+	}
+}
+
+func TestSealer_plaintextDigestTruncated(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 1000)
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{PlaintextDigest: true, ChunkSize: 100, Compression: sealer.CompressionNone}, original)
+
+	// drop the trailer chunk
+	_, err := openSealed(sealed[:len(sealed)-50], nil, key)
+	if err == nil {
+		t.Fatalf("opened a file without the trailer")
+	}
+
+	r := openReader(t, sealed, key)
+	if _, err := io.ReadFull(r, make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if r.Digest() != nil {
+		t.Errorf("Digest is available before EOF")
+	}
+}
+
+func openReader(t testing.TB, sealed []byte, key *sealer.Key) *sealer.Reader {
+	t.Helper()
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReader_size(t *testing.T) {
+	key := generateKeyWithID("key")
+	for _, opt := range []sealer.SealOptions{
+		{},
+		{Compression: sealer.CompressionNone},
+		{Compression: sealer.CompressionNone, RandomNonces: true, PlaintextDigest: true},
+		{Compression: sealer.CompressionZstdChunks},
+	} {
+		opt.RecordSize = true
+		opt.ChunkSize = 100
+		for _, size := range []int{0, 1, 99, 100, 101, 1000, 1234} {
+			t.Run(fmt.Sprintf("%v/%v/%d", opt.Compression, opt.RandomNonces, size), func(t *testing.T) {
+				original := randomBytes(t, size)
+				sealed := seal(t, []*sealer.Key{key}, nil, opt, original)
+				body := bodySize(t, sealed, nil)
+
+				r := openReader(t, sealed, key)
+				sz, err := r.Size()
+				if opt.Compression == sealer.CompressionZstdChunks {
+					if err != sealer.ErrSizeUnknown {
+						t.Fatalf("Size = %v, %v, wanted ErrSizeUnknown for variable chunks", sz, err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				} else if sz.Plaintext != int64(size) || sz.Ciphertext != int64(body) {
+					t.Errorf("Size = %+v, wanted %d plaintext and %d ciphertext bytes", sz, size, body)
+				}
+
+				actual, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, original) {
+					t.Fatalf("got %d bytes, wanted %d", len(actual), len(original))
+				}
+				sz, err = r.Size()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sz.Plaintext != int64(size) || sz.Ciphertext != int64(body) || sz.Chunks < 1 {
+					t.Errorf("Size after EOF = %+v, wanted %d plaintext and %d ciphertext bytes", sz, size, body)
+				}
+			})
+		}
+	}
+}
+
+func TestReader_sizeUnknown(t *testing.T) {
+	key := generateKeyWithID("key")
+	original := randomBytes(t, 1000)
+
+	sealed := seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{PlaintextDigest: true}, original)
+	r := openReader(t, sealed, key)
+	if _, err := r.Size(); err != sealer.ErrSizeUnknown {
+		t.Errorf("Size = %v, wanted ErrSizeUnknown without RecordSize", err)
+	}
+
+	sealed = seal(t, []*sealer.Key{key}, nil, sealer.SealOptions{RecordSize: true}, original)
+	opn, err := sealer.Prepare(io.MultiReader(bytes.NewReader(sealed)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = opn.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Size(); err != sealer.ErrSizeUnknown {
+		t.Errorf("Size = %v, wanted ErrSizeUnknown for a non-seekable input", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Package vaulttransit wraps sealer file keys using the HashiCorp Vault
+// transit secrets engine, so that sealing and opening never need local key
+// material.
+//
+// Vault ciphertexts carry the key version ("vault:v3:..."), and Vault
+// decrypts using older versions as long as they are at or above the key's
+// min_decryption_version, so rotating the key on Vault's side keeps old
+// files readable. Rewrap upgrades a slot to the latest key version without
+// exposing the file key.
+package vaulttransit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/internal/slotdata"
+)
+
+// Transit is the subset of the transit engine API used by this package.
+// Client implements it.
+type Transit interface {
+	Encrypt(ctx context.Context, keyPath string, plaintext []byte) (ciphertext string, err error)
+	Decrypt(ctx context.Context, keyPath string, ciphertext string) ([]byte, error)
+	Rewrap(ctx context.Context, keyPath string, ciphertext string) (string, error)
+}
+
+// Wrapper implements sealer.Encapsulator and sealer.Decapsulator using
+// a transit key.
+type Wrapper struct {
+	transit Transit
+	keyPath string
+	keyType string
+	ctx     context.Context
+}
+
+// New returns a Wrapper that uses the given transit key, named by mount
+// path and key name, e.g. "transit/my-key".
+func New(transit Transit, keyPath string) *Wrapper {
+	return &Wrapper{
+		transit: transit,
+		keyPath: strings.Trim(keyPath, "/"),
+		ctx:     context.Background(),
+	}
+}
+
+// WithContext returns a copy of w that uses ctx for Vault requests.
+func (w *Wrapper) WithContext(ctx context.Context) *Wrapper {
+	w2 := *w
+	w2.ctx = ctx
+	return &w2
+}
+
+// WithKeyType returns a copy of w that knows the type of the transit key
+// (e.g. "aes256-gcm96"). Transit keys can be RSA, so only wrappers declaring
+// a symmetric type are accepted with sealer.SealOptions.PostQuantum.
+func (w *Wrapper) WithKeyType(keyType string) *Wrapper {
+	w2 := *w
+	w2.keyType = keyType
+	return &w2
+}
+
+// Transit slot data is the key path and the Vault ciphertext string,
+// packed by slotdata.Pack.
+
+// Encapsulate implements sealer.Encapsulator.
+func (w *Wrapper) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	ciphertext, err := w.transit.Encrypt(w.ctx, w.keyPath, fileKey)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("vaulttransit: %w", err)
+	}
+	return w.slot(ciphertext), nil
+}
+
+func (w *Wrapper) slot(ciphertext string) sealer.Slot {
+	data := slotdata.Pack(w.keyPath, []byte(ciphertext))
+	return sealer.Slot{Type: sealer.SlotTypeVaultTransit, KeyID: KeyID(w.keyPath), Data: data}
+}
+
+// PostQuantum implements sealer.PostQuantumReporter.
+func (w *Wrapper) PostQuantum() bool {
+	switch w.keyType {
+	case "aes128-gcm96", "aes256-gcm96", "chacha20-poly1305":
+		return true
+	default:
+		return false
+	}
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (w *Wrapper) Decapsulate(s sealer.Slot) ([]byte, error) {
+	keyPath, ciphertext, ok := parseSlot(s)
+	if !ok || keyPath != w.keyPath {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	fileKey, err := w.transit.Decrypt(w.ctx, w.keyPath, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("vaulttransit: %w", err)
+	}
+	return fileKey, nil
+}
+
+// Rewrap returns a copy of the slot re-encrypted under the latest version
+// of the key. Vault does this without revealing the file key.
+func (w *Wrapper) Rewrap(s sealer.Slot) (sealer.Slot, error) {
+	keyPath, ciphertext, ok := parseSlot(s)
+	if !ok || keyPath != w.keyPath {
+		return sealer.Slot{}, sealer.ErrNoMatchingKey
+	}
+	ciphertext, err := w.transit.Rewrap(w.ctx, w.keyPath, ciphertext)
+	if err != nil {
+		return sealer.Slot{}, fmt.Errorf("vaulttransit: %w", err)
+	}
+	return w.slot(ciphertext), nil
+}
+
+// KeyID returns the sealer key ID used for slots wrapped by the given key.
+// All versions of a key share the same ID.
+func KeyID(keyPath string) [sealer.IDSize]byte {
+	return sha256.Sum256([]byte("vaulttransit:" + strings.Trim(keyPath, "/")))
+}
+
+// KeyPath returns the transit key path and version required to open the
+// slot, or false if it's not a transit slot.
+func KeyPath(s sealer.Slot) (keyPath string, version int, ok bool) {
+	keyPath, ciphertext, ok := parseSlot(s)
+	if !ok {
+		return "", 0, false
+	}
+	return keyPath, KeyVersion(ciphertext), true
+}
+
+// KeyVersion returns the key version of a Vault ciphertext like
+// "vault:v3:...", or 0 if it cannot be determined.
+func KeyVersion(ciphertext string) int {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0
+	}
+	v, _ := strconv.Atoi(parts[1][1:])
+	return v
+}
+
+func parseSlot(s sealer.Slot) (keyPath, ciphertext string, ok bool) {
+	if s.Type != sealer.SlotTypeVaultTransit {
+		return "", "", false
+	}
+	keyPath, c, ok := slotdata.Unpack(s.Data)
+	return keyPath, string(c), ok
+}
+
+// Client is a minimal Vault client for the transit engine.
+type Client struct {
+	// Address is the Vault server address, e.g. https://vault:8200.
+	Address string
+
+	// Token is the Vault token used to authenticate.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClientFromEnv returns a client configured with the standard
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment variables.
+func NewClientFromEnv() *Client {
+	return &Client{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+}
+
+// Error is an error returned by the Vault API.
+type Error struct {
+	StatusCode int
+	Errors     []string `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Vault HTTP %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Encrypt calls transit/encrypt.
+func (c *Client) Encrypt(ctx context.Context, keyPath string, plaintext []byte) (string, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := c.call(ctx, "encrypt", keyPath, map[string]any{"plaintext": plaintext}, &resp)
+	return resp.Ciphertext, err
+}
+
+// Decrypt calls transit/decrypt.
+func (c *Client) Decrypt(ctx context.Context, keyPath string, ciphertext string) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := c.call(ctx, "decrypt", keyPath, map[string]any{"ciphertext": ciphertext}, &resp)
+	return resp.Plaintext, err
+}
+
+// Rewrap calls transit/rewrap.
+func (c *Client) Rewrap(ctx context.Context, keyPath string, ciphertext string) (string, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := c.call(ctx, "rewrap", keyPath, map[string]any{"ciphertext": ciphertext}, &resp)
+	return resp.Ciphertext, err
+}
+
+// call posts to /v1/<mount>/<op>/<name>, where keyPath is <mount>/<name>.
+func (c *Client) call(ctx context.Context, op, keyPath string, in, out any) error {
+	i := strings.LastIndexByte(keyPath, '/')
+	if i < 0 {
+		return fmt.Errorf("invalid transit key path %q, wanted <mount>/<name>", keyPath)
+	}
+	url := strings.TrimSuffix(c.Address, "/") + "/v1/" + keyPath[:i] + "/" + op + "/" + keyPath[i+1:]
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", c.Token)
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(respBody, e)
+		return e
+	}
+	var wrapper struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &wrapper); err != nil {
+		return err
+	}
+	return json.Unmarshal(wrapper.Data, out)
+}
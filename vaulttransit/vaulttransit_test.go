@@ -0,0 +1,131 @@
+package vaulttransit_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/vaulttransit"
+)
+
+// fakeVault "encrypts" by base64-encoding with a version prefix.
+type fakeVault struct {
+	t       *testing.T
+	version int
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "TOKEN" {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"errors": ["permission denied"]}`))
+		return
+	}
+	var req struct {
+		Plaintext  []byte `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var data any
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/k":
+		data = map[string]any{"ciphertext": fmt.Sprintf("vault:v%d:%s", v.version, base64.StdEncoding.EncodeToString(req.Plaintext))}
+	case "/v1/transit/decrypt/k":
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		plaintext, _ := base64.StdEncoding.DecodeString(parts[2])
+		data = map[string]any{"plaintext": plaintext}
+	case "/v1/transit/rewrap/k":
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		data = map[string]any{"ciphertext": fmt.Sprintf("vault:v%d:%s", v.version, parts[2])}
+	default:
+		w.WriteHeader(404)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func TestWrapper(t *testing.T) {
+	vault := &fakeVault{t: t, version: 1}
+	srv := httptest.NewServer(vault)
+	defer srv.Close()
+
+	client := &vaulttransit.Client{Address: srv.URL, Token: "TOKEN"}
+	wrapper := vaulttransit.New(client, "transit/k")
+
+	original := []byte("hello, world")
+	var sealed bytes.Buffer
+	w, err := sealer.Seal(&sealed, nil, nil, sealer.SealOptions{
+		Encapsulators: []sealer.Encapsulator{wrapper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(original)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	vault.version = 2 // rotate the key
+
+	opn, err := sealer.Prepare(bytes.NewReader(sealed.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, version, ok := vaulttransit.KeyPath(opn.Slots[0]); !ok || path != "transit/k" || version != 1 {
+		t.Fatalf("KeyPath = %q, %d, %v", path, version, ok)
+	}
+
+	rewrapped, err := wrapper.Rewrap(opn.Slots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, version, _ := vaulttransit.KeyPath(rewrapped); version != 2 {
+		t.Fatalf("rewrapped to version %d, wanted 2", version)
+	}
+	if _, err := wrapper.Decapsulate(rewrapped); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := opn.OpenWith(wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, original) {
+		t.Fatalf("got %q, wanted %q", actual, original)
+	}
+
+	client.Token = "WRONG"
+	_, err = wrapper.Decapsulate(opn.Slots[0])
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("got %v, wanted a permission error", err)
+	}
+}
+
+func TestWrapper_postQuantum(t *testing.T) {
+	srv := httptest.NewServer(&fakeVault{t: t, version: 1})
+	defer srv.Close()
+
+	wrapper := vaulttransit.New(&vaulttransit.Client{Address: srv.URL, Token: "TOKEN"}, "transit/k")
+	for _, tc := range []struct {
+		keyType string
+		err     error
+	}{{"", sealer.ErrNotPostQuantum}, {"rsa-4096", sealer.ErrNotPostQuantum}, {"aes256-gcm96", nil}} {
+		_, err := sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{
+			Encapsulators: []sealer.Encapsulator{wrapper.WithKeyType(tc.keyType)},
+			PostQuantum:   true,
+		})
+		if err != tc.err {
+			t.Errorf("Seal with key type %q and PostQuantum = %v, wanted %v", tc.keyType, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package yubikey seals sealer file keys to a YubiKey (or another hardware
+// token), so that opening a file requires the token to be present and,
+// depending on its policy, touched.
+//
+// Two mechanisms are supported:
+//
+//   - PIV: the file key is wrapped using ECDH with a P-256 key held in
+//     a PIV slot. Sealing only needs the public key; opening needs the
+//     card. PIVCard matches github.com/go-piv/piv-go's ECDSA private key.
+//
+//   - FIDO2 hmac-secret: the wrapping key is derived from the
+//     authenticator's hmac-secret output for a random salt, which requires
+//     the token both to seal and to open.
+//
+// The header records the device serial number (PIV) or relying party ID
+// and credential ID (FIDO2), which Device returns so that an app can prompt
+// the user for the right token.
+package yubikey
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andreyvit/sealer"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// PIV slots suitable for decryption keys. Retired key management slots
+// 0x82 to 0x95 work as well.
+const (
+	PIVSlotKeyManagement      uint8 = 0x9d
+	PIVSlotCardAuthentication uint8 = 0x9e
+)
+
+// Kind identifies the mechanism a slot was sealed with.
+type Kind uint8
+
+const (
+	KindPIV   Kind = 1
+	KindFIDO2 Kind = 2
+)
+
+// Device describes the token needed to open a slot.
+type Device struct {
+	Kind Kind
+
+	// Serial is the serial number of the PIV device.
+	Serial uint32
+
+	// PIVSlot is the PIV slot holding the private key.
+	PIVSlot uint8
+
+	// RPID is the FIDO2 relying party ID.
+	RPID string
+
+	// CredentialID is the FIDO2 credential ID.
+	CredentialID []byte
+}
+
+// PIVCard performs ECDH with the P-256 private key held in the given PIV
+// slot, returning the shared secret (the X coordinate).
+type PIVCard interface {
+	SharedKey(pivSlot uint8, peer *ecdsa.PublicKey) ([]byte, error)
+}
+
+// Authenticator evaluates the FIDO2 hmac-secret extension for the given
+// credential and 32-byte salt, returning the 32-byte output.
+type Authenticator interface {
+	HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error)
+}
+
+var errInvalidPublicKey = errors.New("yubikey: PIV key must be ECDSA P-256")
+
+const (
+	wrappingKeySize  = chacha20poly1305.KeySize
+	p256PointSize    = 65
+	hmacSaltSize     = 32
+	encapsulatedSize = chacha20poly1305.NonceSizeX + sealer.KeySize + chacha20poly1305.Overhead
+)
+
+const hkdfInfo = "github.com/andreyvit/sealer/yubikey"
+
+// YubiKey slot data, PIV:
+//  - kind          uint8 = KindPIV
+//  - serial        uint32
+//  - pivSlot       uint8
+//  - ephemeralPub  [65]byte (uncompressed P-256 point)
+//  - encapsulated  [nonce + key + tag]byte
+//
+// YubiKey slot data, FIDO2:
+//  - kind          uint8 = KindFIDO2
+//  - rpIDLen       uint8
+//  - rpID          [rpIDLen]byte
+//  - credIDLen     uint16
+//  - credID        [credIDLen]byte
+//  - salt          [32]byte
+//  - encapsulated  [nonce + key + tag]byte
+//
+// Everything before encapsulated is its additional data.
+
+// PIVRecipient seals file keys to the public key of a PIV slot.
+type PIVRecipient struct {
+	serial  uint32
+	pivSlot uint8
+	pub     *ecdh.PublicKey
+}
+
+// NewPIVRecipient returns a PIVRecipient for the P-256 public key in the
+// given slot of the device with the given serial number.
+func NewPIVRecipient(serial uint32, pivSlot uint8, pub *ecdsa.PublicKey) (*PIVRecipient, error) {
+	epub, err := pub.ECDH()
+	if err != nil || epub.Curve() != ecdh.P256() {
+		return nil, errInvalidPublicKey
+	}
+	return &PIVRecipient{serial: serial, pivSlot: pivSlot, pub: epub}, nil
+}
+
+// KeyID returns the sealer key ID used for slots sealed to the public key.
+func (r *PIVRecipient) KeyID() [sealer.IDSize]byte {
+	return sha256.Sum256(append([]byte("yubikey-piv:"), r.pub.Bytes()...))
+}
+
+// Encapsulate implements sealer.Encapsulator.
+func (r *PIVRecipient) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	eph, err := ecdh.P256().GenerateKey(random)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	shared, err := eph.ECDH(r.pub)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	data := []byte{byte(KindPIV)}
+	data = binary.LittleEndian.AppendUint32(data, r.serial)
+	data = append(data, r.pivSlot)
+	data = append(data, eph.PublicKey().Bytes()...)
+	wrappingKey, err := hkdf.Key(sha256.New, shared, append(eph.PublicKey().Bytes(), r.pub.Bytes()...), hkdfInfo, wrappingKeySize)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	data, err = wrap(data, wrappingKey, fileKey, random)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	return sealer.Slot{Type: sealer.SlotTypeYubiKey, KeyID: r.KeyID(), Data: data}, nil
+}
+
+// SlotDataSize implements sealer.SlotSizer.
+func (r *PIVRecipient) SlotDataSize() int {
+	return 1 + 4 + 1 + p256PointSize + encapsulatedSize
+}
+
+// PIVIdentity opens slots sealed to a PIVRecipient using the card.
+type PIVIdentity struct {
+	PIVRecipient
+	card PIVCard
+}
+
+// NewPIVIdentity returns a PIVIdentity for the key in the given PIV slot,
+// whose public key is pub.
+func NewPIVIdentity(card PIVCard, serial uint32, pivSlot uint8, pub *ecdsa.PublicKey) (*PIVIdentity, error) {
+	r, err := NewPIVRecipient(serial, pivSlot, pub)
+	if err != nil {
+		return nil, err
+	}
+	return &PIVIdentity{PIVRecipient: *r, card: card}, nil
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (id *PIVIdentity) Decapsulate(s sealer.Slot) ([]byte, error) {
+	dev, meta, encapsulated, ok := parseSlot(s)
+	if !ok || dev.Kind != KindPIV || s.KeyID != id.KeyID() {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	ephBytes := meta[len(meta)-p256PointSize:]
+	ephPub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), ephBytes)
+	if err != nil {
+		return nil, sealer.ErrUnsupportedVersion
+	}
+	shared, err := id.card.SharedKey(dev.PIVSlot, ephPub)
+	if err != nil {
+		return nil, fmt.Errorf("yubikey: %w", err)
+	}
+	wrappingKey, err := hkdf.Key(sha256.New, shared, append(ephBytes[:len(ephBytes):len(ephBytes)], id.pub.Bytes()...), hkdfInfo, wrappingKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(meta, wrappingKey, encapsulated)
+}
+
+// FIDO2 seals and opens file keys using an hmac-secret credential.
+type FIDO2 struct {
+	auth   Authenticator
+	rpID   string
+	credID []byte
+}
+
+// NewFIDO2 returns a FIDO2 wrapper for the given relying party ID and
+// credential ID, which must have been created with the hmac-secret
+// extension enabled.
+func NewFIDO2(auth Authenticator, rpID string, credentialID []byte) *FIDO2 {
+	return &FIDO2{auth: auth, rpID: rpID, credID: credentialID}
+}
+
+// KeyID returns the sealer key ID used for slots sealed with the credential.
+func (f *FIDO2) KeyID() [sealer.IDSize]byte {
+	h := sha256.New()
+	h.Write([]byte("yubikey-fido2:"))
+	h.Write([]byte(f.rpID))
+	h.Write([]byte{0})
+	h.Write(f.credID)
+	return [sealer.IDSize]byte(h.Sum(nil))
+}
+
+// Encapsulate implements sealer.Encapsulator.
+func (f *FIDO2) Encapsulate(fileKey []byte, random io.Reader) (sealer.Slot, error) {
+	if len(f.rpID) > 0xff || len(f.credID) > 0xffff {
+		return sealer.Slot{}, sealer.ErrHeaderTooLarge
+	}
+	data := []byte{byte(KindFIDO2), byte(len(f.rpID))}
+	data = append(data, f.rpID...)
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(f.credID)))
+	data = append(data, f.credID...)
+	data = append(data, make([]byte, hmacSaltSize)...)
+	salt := data[len(data)-hmacSaltSize:]
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return sealer.Slot{}, fmt.Errorf("generating salt: %w", err)
+	}
+	wrappingKey, err := f.deriveKey(salt)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	data, err = wrap(data, wrappingKey, fileKey, random)
+	if err != nil {
+		return sealer.Slot{}, err
+	}
+	return sealer.Slot{Type: sealer.SlotTypeYubiKey, KeyID: f.KeyID(), Data: data}, nil
+}
+
+// PostQuantum implements sealer.PostQuantumReporter. Unlike PIV, which uses
+// ECDH, the hmac-secret extension is symmetric.
+func (f *FIDO2) PostQuantum() bool {
+	return true
+}
+
+// SlotDataSize implements sealer.SlotSizer.
+func (f *FIDO2) SlotDataSize() int {
+	return 1 + 1 + len(f.rpID) + 2 + len(f.credID) + hmacSaltSize + encapsulatedSize
+}
+
+// Decapsulate implements sealer.Decapsulator.
+func (f *FIDO2) Decapsulate(s sealer.Slot) ([]byte, error) {
+	dev, meta, encapsulated, ok := parseSlot(s)
+	if !ok || dev.Kind != KindFIDO2 || s.KeyID != f.KeyID() {
+		return nil, sealer.ErrNoMatchingKey
+	}
+	wrappingKey, err := f.deriveKey(meta[len(meta)-hmacSaltSize:])
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(meta, wrappingKey, encapsulated)
+}
+
+func (f *FIDO2) deriveKey(salt []byte) ([]byte, error) {
+	secret, err := f.auth.HMACSecret(f.rpID, f.credID, salt)
+	if err != nil {
+		return nil, fmt.Errorf("yubikey: %w", err)
+	}
+	defer clear(secret)
+	return hkdf.Key(sha256.New, secret, salt, hkdfInfo, wrappingKeySize)
+}
+
+// SlotDevice returns the token needed to open the slot, or false if it's
+// not a YubiKey slot.
+func SlotDevice(s sealer.Slot) (Device, bool) {
+	dev, _, _, ok := parseSlot(s)
+	return dev, ok
+}
+
+func parseSlot(s sealer.Slot) (dev Device, meta, encapsulated []byte, ok bool) {
+	data := s.Data
+	if s.Type != sealer.SlotTypeYubiKey || len(data) < 1+encapsulatedSize {
+		return Device{}, nil, nil, false
+	}
+	meta, encapsulated = data[:len(data)-encapsulatedSize], data[len(data)-encapsulatedSize:]
+	dev.Kind = Kind(meta[0])
+	switch dev.Kind {
+	case KindPIV:
+		if len(meta) != 1+4+1+p256PointSize {
+			return Device{}, nil, nil, false
+		}
+		dev.Serial = binary.LittleEndian.Uint32(meta[1:])
+		dev.PIVSlot = meta[5]
+	case KindFIDO2:
+		rest := meta[1:]
+		if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+			return Device{}, nil, nil, false
+		}
+		dev.RPID = string(rest[1 : 1+rest[0]])
+		rest = rest[1+rest[0]:]
+		n := int(binary.LittleEndian.Uint16(rest))
+		rest = rest[2:]
+		if len(rest) != n+hmacSaltSize {
+			return Device{}, nil, nil, false
+		}
+		dev.CredentialID = rest[:n:n]
+	default:
+		return Device{}, nil, nil, false
+	}
+	return dev, meta, encapsulated, true
+}
+
+func wrap(meta, wrappingKey, fileKey []byte, random io.Reader) ([]byte, error) {
+	defer clear(wrappingKey)
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	start := len(meta)
+	data := append(meta, make([]byte, chacha20poly1305.NonceSizeX)...)
+	nonce := data[start:]
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(data, nonce, fileKey, data[:start]), nil
+}
+
+func unwrap(meta, wrappingKey, encapsulated []byte) ([]byte, error) {
+	defer clear(wrappingKey)
+	aead, err := chacha20poly1305.NewX(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := encapsulated[:chacha20poly1305.NonceSizeX]
+	return aead.Open(nil, nonce, encapsulated[chacha20poly1305.NonceSizeX:], meta)
+}
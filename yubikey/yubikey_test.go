@@ -0,0 +1,164 @@
+package yubikey_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/andreyvit/sealer"
+	"github.com/andreyvit/sealer/yubikey"
+)
+
+type fakeCard struct {
+	keys map[uint8]*ecdsa.PrivateKey
+}
+
+func (c *fakeCard) SharedKey(pivSlot uint8, peer *ecdsa.PublicKey) ([]byte, error) {
+	priv, err := c.keys[pivSlot].ECDH()
+	if err != nil {
+		return nil, err
+	}
+	pub, err := peer.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(pub)
+}
+
+type fakeAuthenticator struct {
+	secret []byte
+	calls  int
+}
+
+func (a *fakeAuthenticator) HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error) {
+	a.calls++
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(rpID))
+	m.Write(credentialID)
+	m.Write(salt)
+	return m.Sum(nil), nil
+}
+
+func TestPIV(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := &fakeCard{keys: map[uint8]*ecdsa.PrivateKey{yubikey.PIVSlotKeyManagement: priv}}
+
+	r, err := yubikey.NewPIVRecipient(12345678, yubikey.PIVSlotKeyManagement, &priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := sealWith(t, r)
+
+	opn := prepare(t, sealed)
+	dev, ok := yubikey.SlotDevice(opn.Slots[0])
+	if !ok || dev.Kind != yubikey.KindPIV || dev.Serial != 12345678 || dev.PIVSlot != yubikey.PIVSlotKeyManagement {
+		t.Errorf("SlotDevice = %+v, %v", dev, ok)
+	}
+	id, err := yubikey.NewPIVIdentity(card, 12345678, yubikey.PIVSlotKeyManagement, &priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, opn, id)
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	id2, _ := yubikey.NewPIVIdentity(card, 12345678, yubikey.PIVSlotKeyManagement, &other.PublicKey)
+	if _, err := prepare(t, sealed).OpenWith(id2); err != sealer.ErrNoMatchingKey {
+		t.Errorf("OpenWith(other) = %v, wanted ErrNoMatchingKey", err)
+	}
+}
+
+func TestFIDO2(t *testing.T) {
+	auth := &fakeAuthenticator{secret: []byte("device secret")}
+	f := yubikey.NewFIDO2(auth, "sealer.example.com", []byte("cred-1"))
+	sealed := sealWith(t, f)
+
+	opn := prepare(t, sealed)
+	dev, ok := yubikey.SlotDevice(opn.Slots[0])
+	if !ok || dev.Kind != yubikey.KindFIDO2 || dev.RPID != "sealer.example.com" || string(dev.CredentialID) != "cred-1" {
+		t.Errorf("SlotDevice = %+v, %v", dev, ok)
+	}
+	verify(t, opn, f)
+	if auth.calls != 2 {
+		t.Errorf("calls = %d, wanted 2", auth.calls)
+	}
+
+	wrong := yubikey.NewFIDO2(&fakeAuthenticator{secret: []byte("other device")}, "sealer.example.com", []byte("cred-1"))
+	if _, err := prepare(t, sealed).OpenWith(wrong); err == nil {
+		t.Errorf("OpenWith succeeded with another device")
+	}
+}
+
+func TestPostQuantum(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	piv, err := yubikey.NewPIVRecipient(12345678, yubikey.PIVSlotKeyManagement, &priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fido := yubikey.NewFIDO2(&fakeAuthenticator{secret: []byte("device secret")}, "sealer.example.com", []byte("cred-1"))
+	for _, tc := range []struct {
+		enc sealer.Encapsulator
+		err error
+	}{{piv, sealer.ErrNotPostQuantum}, {fido, nil}} {
+		_, err := sealer.Seal(io.Discard, nil, nil, sealer.SealOptions{Encapsulators: []sealer.Encapsulator{tc.enc}, PostQuantum: true})
+		if err != tc.err {
+			t.Errorf("Seal to %T with PostQuantum = %v, wanted %v", tc.enc, err, tc.err)
+		}
+	}
+}
+
+func sealWith(t *testing.T, enc sealer.Encapsulator) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	opt := sealer.SealOptions{Encapsulators: []sealer.Encapsulator{enc}}
+	w, err := sealer.Seal(&buf, nil, nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := sealer.SealedSizeEstimate(12, nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := w.SealedSizeEstimate(12); estimate != a {
+		t.Errorf("SealedSizeEstimate = %d, wanted %d", estimate, a)
+	}
+	io.WriteString(w, "hello, world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func prepare(t *testing.T, sealed []byte) *sealer.Openable {
+	t.Helper()
+	opn, err := sealer.Prepare(bytes.NewReader(sealed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opn
+}
+
+func verify(t *testing.T, opn *sealer.Openable, dec sealer.Decapsulator) {
+	t.Helper()
+	r, err := opn.OpenWith(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, e := string(data), "hello, world"; a != e {
+		t.Errorf("got %q, wanted %q", a, e)
+	}
+}